@@ -3,12 +3,58 @@ package book
 import (
 	"context"
 	"errors"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"strings"
+	"time"
+	"unicode"
 )
 
 var (
-	errBookNotFound = errors.New("book not found")
+	errBookNotFound   = errors.New("book not found")
+	errTitleEmpty     = errors.New("invalid title: cannot be empty")
+	errTitleLong      = errors.New("invalid title: exceed the max amount of 255 characters")
+	errAuthorEmpty    = errors.New("invalid author: cannot be empty")
+	errAuthorLong     = errors.New("invalid author: exceed the max amount of 255 characters")
+	errPriceInvalid   = errors.New("invalid price: needs to be greater than zero")
+	errPriceTooHigh   = errors.New("invalid price: exceed the max amount of 99999999.99")
+	errInvalidBookID  = errors.New("invalid book ID")
+	errInvalidLimit   = errors.New("invalid limit: needs to be between 1 and 100")
+	errInvalidSort    = errors.New("invalid sort: needs to be one of price, title or author")
+	errInvalidDir     = errors.New("invalid direction: needs to be asc or desc")
+	errInvalidCursor  = errors.New("invalid cursor")
+	errInvalidFilter  = errors.New("invalid price filter: min_price and max_price can't be negative")
+	errInvalidRange   = errors.New("invalid price filter: min_price can't be greater than max_price")
+	errSearchEmpty    = errors.New("invalid search: needs at least one letter or digit")
+	errSearchLong     = errors.New("invalid search: exceed the max amount of 100 characters")
+	errWeightInvalid  = errors.New("invalid weight: needs to be between 1 and 100000 grams")
+	errStockQuantity  = errors.New("invalid quantity: needs to be different from zero and at most 1000000 units")
+	errStockReason    = errors.New("invalid reason: needs to have between 1 and 255 characters")
+	errNotEnoughStock = errors.New("not enough stock to remove that quantity")
 )
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxSearchLength = 100
+	maxSearchTerms  = 10
+	maxStockChange  = 1_000_000
+	maxWeight       = 100_000
+)
+
+const (
+	SortID     = "id"
+	SortPrice  = "price"
+	SortTitle  = "title"
+	SortAuthor = "author"
+)
+
+// DefaultWeight is the weight in grams new books get, the one of an average paperback
+const DefaultWeight = 400
+
+// maxPrice is the biggest value that fits the DECIMAL(10, 2) price column
+const maxPrice = money.Amount(99999999_99)
+
 type Service struct {
 	r Repository
 }
@@ -17,42 +63,486 @@ func NewService(bookRepository Repository) *Service {
 	return &Service{bookRepository}
 }
 
+// Model is a book of the catalog, Weight is in grams and decides its shipping cost
 type Model struct {
-	ID     int64   `json:"id"`
-	Title  string  `json:"title"`
-	Author string  `json:"author"`
-	Price  float64 `json:"price"`
+	ID     int64        `json:"id"`
+	Title  string       `json:"title"`
+	Author string       `json:"author"`
+	Price  money.Amount `json:"price"`
+	Stock  int          `json:"stock"`
+	Weight int          `json:"weight"`
+}
+
+// StockMovement is a change in the stock of a book, positive quantities add units and negative ones remove them
+type StockMovement struct {
+	ID        int64     `json:"id"`
+	BookID    int64     `json:"book_id"`
+	Quantity  int       `json:"quantity"`
+	Reason    string    `json:"reason"`
+	OrderID   *int64    `json:"order_id,omitempty"`
+	CreatedBy *int64    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Information is the subset of a book needed when pricing and describing order items
+type Information struct {
+	Price  money.Amount
+	Title  string
+	Author string
+	Weight int
+}
+
+// Patch holds the fields of a partial book update, nil fields are left untouched
+type Patch struct {
+	Title  *string       `json:"title"`
+	Author *string       `json:"author"`
+	Price  *money.Amount `json:"price"`
+	Weight *int          `json:"weight"`
+}
+
+// ListParams are the options of a catalog listing as requested by the client
+type ListParams struct {
+	Limit     int
+	Cursor    string
+	Sort      string
+	Direction string
+	Author    string
+	MinPrice  *money.Amount
+	MaxPrice  *money.Amount
+	// WithTotal asks for the number of books matching the filters, counting them costs a scan so it's opt-in
+	WithTotal bool
+}
+
+// Cursor is the position right after the last book of a page
+type Cursor struct {
+	Value string `json:"v,omitempty"`
+	ID    int64  `json:"id"`
+}
+
+// Query is the validated form of ListParams that the repository executes
+type Query struct {
+	Limit    int
+	After    *Cursor
+	Sort     string
+	Desc     bool
+	Author   string
+	MinPrice *money.Amount
+	MaxPrice *money.Amount
+}
+
+// Page is a slice of the catalog, NextCursor is empty when there are no more books and Total is only set when asked for
+type Page struct {
+	Items      []*Model `json:"items"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	Total      *int64   `json:"total,omitempty"`
 }
 
 type Repository interface {
-	GetAllBooks(ctx context.Context) ([]*Model, error)
+	// ListBooks returns up to query.Limit books ordered by query.Sort, and then by id
+	ListBooks(ctx context.Context, query Query) ([]*Model, error)
+	// CountBooks returns how many books match the query filters, ignoring the limit and the cursor
+	CountBooks(ctx context.Context, query Query) (int64, error)
+	// SearchBooks returns up to limit books matching all the terms, each term also matches words it is a prefix of,
+	// the best matches come first
+	SearchBooks(ctx context.Context, terms []string, limit int) ([]*Model, error)
+	// GetBookByID returns nil if the book doesn't exist
+	GetBookByID(ctx context.Context, id int64) (*Model, error)
+	// GetBooksByIDs returns the books that exist among the given ids, in no particular order
+	GetBooksByIDs(ctx context.Context, ids []int64) ([]*Model, error)
+	SaveBook(ctx context.Context, title, author string, price money.Amount) (*int64, error)
+	// UpdateBook returns nil if the book doesn't exist
+	UpdateBook(ctx context.Context, id int64, title, author string, price money.Amount) (*Model, error)
+	// PatchBook returns nil if the book doesn't exist
+	PatchBook(ctx context.Context, id int64, patch Patch) (*Model, error)
+	// DeleteBook returns false if the book doesn't exist
+	DeleteBook(ctx context.Context, id int64) (bool, error)
+	// AdjustStock changes the stock and records the movement in a single transaction,
+	// it returns nil if the book doesn't exist or if the stock would become negative
+	AdjustStock(ctx context.Context, movement StockMovement) (*Model, error)
+	// GetStockMovements returns the latest movements of a book, newest first
+	GetStockMovements(ctx context.Context, bookID int64, limit int) ([]StockMovement, error)
 }
 
-func (s *Service) GetAllBooks(ctx context.Context) ([]*Model, error) {
-	return s.r.GetAllBooks(ctx)
+// IsNotEnoughStock reports whether err means that a stock adjustment would leave the book with negative stock
+func IsNotEnoughStock(err error) bool {
+	return errors.Is(err, errNotEnoughStock)
 }
 
-// GetBooksInformation returns a map of book price/name if all of them exists, and errBookNotFound if one of the books is not found
-func (s *Service) GetBooksInformation(ctx context.Context, bookIDs []int64) (map[int64]struct {
-	Price float64
-	Title string
-}, error) {
+// IsNotFound reports whether err means that the requested book doesn't exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, errBookNotFound)
+}
+
+func validateTitle(title string) error {
+	if title == "" {
+		return errTitleEmpty
+	}
+	if len(title) > 255 {
+		return errTitleLong
+	}
+	return nil
+}
+
+func validateAuthor(author string) error {
+	if author == "" {
+		return errAuthorEmpty
+	}
+	if len(author) > 255 {
+		return errAuthorLong
+	}
+	return nil
+}
+
+func validatePrice(price money.Amount) error {
+	if price <= 0 {
+		return errPriceInvalid
+	}
+	if price > maxPrice {
+		return errPriceTooHigh
+	}
+	return nil
+}
+
+func validateBook(title, author string, price money.Amount) error {
+	if err := validateTitle(title); err != nil {
+		return err
+	}
+	if err := validateAuthor(author); err != nil {
+		return err
+	}
+	return validatePrice(price)
+}
+
+// sortValue returns the value of the field the listing is sorted by, in the form stored in the cursor
+func sortValue(b *Model, sort string) string {
+	switch sort {
+	case SortPrice:
+		return b.Price.String()
+	case SortTitle:
+		return b.Title
+	case SortAuthor:
+		return b.Author
+	default:
+		return ""
+	}
+}
+
+func buildQuery(params ListParams) (*Query, error) {
+	q := Query{
+		Limit:    params.Limit,
+		Sort:     params.Sort,
+		Author:   params.Author,
+		MinPrice: params.MinPrice,
+		MaxPrice: params.MaxPrice,
+	}
+
+	if q.Limit == 0 {
+		q.Limit = defaultPageSize
+	}
+	if q.Limit < 0 || q.Limit > maxPageSize {
+		return nil, errInvalidLimit
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = SortID
+	case SortPrice, SortTitle, SortAuthor:
+	default:
+		return nil, errInvalidSort
+	}
+
+	switch params.Direction {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return nil, errInvalidDir
+	}
+
+	if (q.MinPrice != nil && *q.MinPrice < 0) || (q.MaxPrice != nil && *q.MaxPrice < 0) {
+		return nil, errInvalidFilter
+	}
+	if q.MinPrice != nil && q.MaxPrice != nil && *q.MinPrice > *q.MaxPrice {
+		return nil, errInvalidRange
+	}
+
+	if params.Cursor != "" {
+		var c Cursor
+		if err := utils.DecodeCursor(params.Cursor, &c); err != nil || c.ID <= 0 {
+			return nil, errInvalidCursor
+		}
+		if q.Sort == SortPrice {
+			if _, err := money.Parse(c.Value); err != nil {
+				return nil, errInvalidCursor
+			}
+		}
+		q.After = &c
+	}
+
+	return &q, nil
+}
+
+// ListBooks returns a page of the catalog, following the sorting, filters and cursor of the params
+func (s *Service) ListBooks(ctx context.Context, params ListParams) (*Page, error) {
+	q, err := buildQuery(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// ask for one extra book to know if there is a next page
+	pageQuery := *q
+	pageQuery.Limit++
+	books, err := s.r.ListBooks(ctx, pageQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Items: books}
+	if page.Items == nil {
+		page.Items = []*Model{}
+	}
+
+	if params.WithTotal {
+		total, err := s.r.CountBooks(ctx, *q)
+		if err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	if len(books) > q.Limit {
+		page.Items = books[:q.Limit]
+		last := page.Items[q.Limit-1]
+		page.NextCursor, err = utils.EncodeCursor(Cursor{Value: sortValue(last, q.Sort), ID: last.ID})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// searchTerms splits the search text in words, dropping anything that isn't a letter or a digit
+func searchTerms(text string) []string {
+	terms := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// SearchBooks returns the books whose title or author match the text, ordered by relevance
+func (s *Service) SearchBooks(ctx context.Context, text string, limit int) ([]*Model, error) {
+	if len(text) > maxSearchLength {
+		return nil, errSearchLong
+	}
+
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return nil, errSearchEmpty
+	}
+
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if limit < 0 || limit > maxPageSize {
+		return nil, errInvalidLimit
+	}
+
+	books, err := s.r.SearchBooks(ctx, terms, limit)
+	if err != nil {
+		return nil, err
+	}
+	if books == nil {
+		books = []*Model{}
+	}
+
+	return books, nil
+}
+
+// GetBookByID returns a single book, or errBookNotFound if it doesn't exist
+func (s *Service) GetBookByID(ctx context.Context, id int64) (*Model, error) {
+	if id <= 0 {
+		return nil, errInvalidBookID
+	}
+
+	b, err := s.r.GetBookByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, errBookNotFound
+	}
+
+	return b, nil
+}
+
+// CreateBook validates and adds a new book to the catalog
+func (s *Service) CreateBook(ctx context.Context, title, author string, price money.Amount) (*Model, error) {
+	if err := validateBook(title, author, price); err != nil {
+		return nil, err
+	}
+
+	id, err := s.r.SaveBook(ctx, title, author, price)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Model{ID: *id, Title: title, Author: author, Price: price, Weight: DefaultWeight}, nil
+}
+
+// UpdateBook replaces all the fields of an existing book
+func (s *Service) UpdateBook(ctx context.Context, id int64, title, author string, price money.Amount) (*Model, error) {
+	if id <= 0 {
+		return nil, errInvalidBookID
+	}
+
+	if err := validateBook(title, author, price); err != nil {
+		return nil, err
+	}
+
+	b, err := s.r.UpdateBook(ctx, id, title, author, price)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, errBookNotFound
+	}
+
+	return b, nil
+}
+
+// PatchBook updates only the fields that are present in the patch
+func (s *Service) PatchBook(ctx context.Context, id int64, patch Patch) (*Model, error) {
+	if id <= 0 {
+		return nil, errInvalidBookID
+	}
+
+	if patch.Title != nil {
+		if err := validateTitle(*patch.Title); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Author != nil {
+		if err := validateAuthor(*patch.Author); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Price != nil {
+		if err := validatePrice(*patch.Price); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Weight != nil && (*patch.Weight <= 0 || *patch.Weight > maxWeight) {
+		return nil, errWeightInvalid
+	}
+
+	b, err := s.r.PatchBook(ctx, id, patch)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, errBookNotFound
+	}
+
+	return b, nil
+}
 
-	books, err := s.GetAllBooks(ctx)
+// DeleteBook removes a book from the catalog
+func (s *Service) DeleteBook(ctx context.Context, id int64) error {
+	if id <= 0 {
+		return errInvalidBookID
+	}
+
+	deleted, err := s.r.DeleteBook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errBookNotFound
+	}
+
+	return nil
+}
+
+// AdjustStock adds (positive quantity) or removes (negative quantity) units of a book, keeping track of who did it and why
+func (s *Service) AdjustStock(ctx context.Context, bookID int64, quantity int, reason string, adminID int64) (*Model, error) {
+	if bookID <= 0 {
+		return nil, errInvalidBookID
+	}
+	if quantity == 0 || quantity > maxStockChange || quantity < -maxStockChange {
+		return nil, errStockQuantity
+	}
+	if reason == "" || len(reason) > 255 {
+		return nil, errStockReason
+	}
+
+	b, err := s.GetBookByID(ctx, bookID)
 	if err != nil {
 		return nil, err
 	}
+	if b.Stock+quantity < 0 {
+		return nil, errNotEnoughStock
+	}
 
-	m := map[int64]struct {
-		Price float64
-		Title string
-	}{}
+	updated, err := s.r.AdjustStock(ctx, StockMovement{
+		BookID:    bookID,
+		Quantity:  quantity,
+		Reason:    reason,
+		CreatedBy: &adminID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		// the stock changed between the check above and the update
+		return nil, errNotEnoughStock
+	}
+
+	return updated, nil
+}
+
+// GetStockMovements returns the stock history of a book, newest first
+func (s *Service) GetStockMovements(ctx context.Context, bookID int64, limit int) ([]StockMovement, error) {
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if limit < 0 || limit > maxPageSize {
+		return nil, errInvalidLimit
+	}
+
+	if _, err := s.GetBookByID(ctx, bookID); err != nil {
+		return nil, err
+	}
+
+	movements, err := s.r.GetStockMovements(ctx, bookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if movements == nil {
+		movements = []StockMovement{}
+	}
+
+	return movements, nil
+}
+
+// GetBooksInformation returns a map of book price/name if all of them exists, and errBookNotFound if one of the books is not found
+func (s *Service) GetBooksInformation(ctx context.Context, bookIDs []int64) (map[int64]Information, error) {
+	m := make(map[int64]Information, len(bookIDs))
+	if len(bookIDs) == 0 {
+		return m, nil
+	}
+
+	books, err := s.r.GetBooksByIDs(ctx, bookIDs)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, v := range books {
-		m[v.ID] = struct {
-			Price float64
-			Title string
-		}{Price: v.Price, Title: v.Title}
+		m[v.ID] = Information{Price: v.Price, Title: v.Title, Author: v.Author, Weight: v.Weight}
 	}
 
 	for _, v := range bookIDs {
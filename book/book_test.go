@@ -3,77 +3,255 @@ package book
 import (
 	"context"
 	"errors"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/utils"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 // Define a mock repository for testing purposes.
 type MockRepository struct {
-	Books []*Model
-	Err   error
+	Books     []*Model
+	Movements []StockMovement
+	Err       error
+	LastQuery Query
+	LastTerms []string
 }
 
-func (m *MockRepository) GetAllBooks(ctx context.Context) ([]*Model, error) {
-	return m.Books, m.Err
+func (m *MockRepository) ListBooks(ctx context.Context, query Query) ([]*Model, error) {
+	m.LastQuery = query
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if len(m.Books) > query.Limit {
+		return m.Books[:query.Limit], nil
+	}
+	return m.Books, nil
+}
+
+func (m *MockRepository) CountBooks(ctx context.Context, query Query) (int64, error) {
+	return int64(len(m.Books)), m.Err
+}
+
+func (m *MockRepository) SaveBook(ctx context.Context, title, author string, price money.Amount) (*int64, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	id := int64(len(m.Books) + 1)
+	m.Books = append(m.Books, &Model{ID: id, Title: title, Author: author, Price: price})
+	return &id, nil
+}
+
+func (m *MockRepository) find(id int64) *Model {
+	for _, b := range m.Books {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+func (m *MockRepository) SearchBooks(ctx context.Context, terms []string, limit int) ([]*Model, error) {
+	m.LastQuery = Query{Limit: limit}
+	m.LastTerms = terms
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return m.Books, nil
+}
+
+func (m *MockRepository) GetBookByID(ctx context.Context, id int64) (*Model, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return m.find(id), nil
+}
+
+func (m *MockRepository) GetBooksByIDs(ctx context.Context, ids []int64) ([]*Model, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	var books []*Model
+	for _, id := range ids {
+		if b := m.find(id); b != nil {
+			books = append(books, b)
+		}
+	}
+	return books, nil
+}
+
+func (m *MockRepository) AdjustStock(ctx context.Context, movement StockMovement) (*Model, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	b := m.find(movement.BookID)
+	if b == nil || b.Stock+movement.Quantity < 0 {
+		return nil, nil
+	}
+	b.Stock += movement.Quantity
+	m.Movements = append(m.Movements, movement)
+	return b, nil
+}
+
+func (m *MockRepository) GetStockMovements(ctx context.Context, bookID int64, limit int) ([]StockMovement, error) {
+	m.LastQuery = Query{Limit: limit}
+	return m.Movements, m.Err
+}
+
+func (m *MockRepository) UpdateBook(ctx context.Context, id int64, title, author string, price money.Amount) (*Model, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	b := m.find(id)
+	if b == nil {
+		return nil, nil
+	}
+	b.Title, b.Author, b.Price = title, author, price
+	return b, nil
+}
+
+func (m *MockRepository) PatchBook(ctx context.Context, id int64, patch Patch) (*Model, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	b := m.find(id)
+	if b == nil {
+		return nil, nil
+	}
+	if patch.Title != nil {
+		b.Title = *patch.Title
+	}
+	if patch.Author != nil {
+		b.Author = *patch.Author
+	}
+	if patch.Price != nil {
+		b.Price = *patch.Price
+	}
+	if patch.Weight != nil {
+		b.Weight = *patch.Weight
+	}
+	return b, nil
 }
 
-func TestService_GetAllBooks(t *testing.T) {
+func (m *MockRepository) DeleteBook(ctx context.Context, id int64) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	for i, b := range m.Books {
+		if b.ID == id {
+			m.Books = append(m.Books[:i], m.Books[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestService_ListBooks(t *testing.T) {
 	errRepo := errors.New("mock repository error")
+	books := []*Model{
+		{ID: 1, Title: "book1", Author: "author1", Price: 100},
+		{ID: 2, Title: "book2", Author: "author2", Price: 200},
+		{ID: 3, Title: "book3", Author: "author3", Price: 3},
+	}
+	cursor, _ := utils.EncodeCursor(Cursor{Value: "2.00", ID: 2})
+	negative := money.Amount(-100)
+	low := money.Amount(100)
+	high := money.Amount(500)
+
 	testCases := []struct {
-		name          string
-		mockBooks     []*Model
-		expectedBooks []*Model
-		mockError     error
-		expectedError error
+		name           string
+		params         ListParams
+		mockBooks      []*Model
+		mockError      error
+		expectedItems  int
+		expectedCursor *Cursor
+		expectedQuery  *Query
+		expectedError  error
 	}{
 		{
-			name: "Valid list of books",
-			mockBooks: []*Model{
-				{
-					ID:     1,
-					Title:  "harry potter",
-					Author: "jk rowling",
-					Price:  10,
-				},
-			},
-			expectedBooks: []*Model{
-				{
-					ID:     1,
-					Title:  "harry potter",
-					Author: "jk rowling",
-					Price:  10,
-				},
-			},
-			mockError:     nil,
-			expectedError: nil,
+			name:          "Defaults",
+			params:        ListParams{},
+			mockBooks:     books,
+			expectedItems: 3,
+			expectedQuery: &Query{Limit: defaultPageSize + 1, Sort: SortID},
 		},
 		{
-			name:          "Error from repository",
-			mockBooks:     nil,
-			mockError:     errRepo,
-			expectedError: errRepo,
+			name:           "More books than the limit",
+			params:         ListParams{Limit: 2, WithTotal: true},
+			mockBooks:      books,
+			expectedItems:  2,
+			expectedCursor: &Cursor{ID: 2},
+		},
+		{
+			name:           "Sorted by price descending",
+			params:         ListParams{Limit: 2, Sort: SortPrice, Direction: "desc"},
+			mockBooks:      books,
+			expectedItems:  2,
+			expectedCursor: &Cursor{Value: "2.00", ID: 2},
+			expectedQuery:  &Query{Limit: 3, Sort: SortPrice, Desc: true},
+		},
+		{
+			name:          "Following a cursor with filters",
+			params:        ListParams{Limit: 5, Sort: SortPrice, Cursor: cursor, Author: "author3", MinPrice: &low, MaxPrice: &high, WithTotal: true},
+			mockBooks:     books[2:],
+			expectedItems: 1,
+			expectedQuery: &Query{Limit: 6, Sort: SortPrice, After: &Cursor{Value: "2.00", ID: 2}, Author: "author3", MinPrice: &low, MaxPrice: &high},
 		},
+		{
+			name:          "Empty catalog",
+			params:        ListParams{WithTotal: true},
+			expectedItems: 0,
+		},
+		{name: "Limit too big", params: ListParams{Limit: maxPageSize + 1}, expectedError: errInvalidLimit},
+		{name: "Negative limit", params: ListParams{Limit: -1}, expectedError: errInvalidLimit},
+		{name: "Invalid sort", params: ListParams{Sort: "isbn"}, expectedError: errInvalidSort},
+		{name: "Invalid direction", params: ListParams{Direction: "up"}, expectedError: errInvalidDir},
+		{name: "Invalid cursor", params: ListParams{Cursor: "not-a-cursor"}, expectedError: errInvalidCursor},
+		{name: "Negative price filter", params: ListParams{MinPrice: &negative}, expectedError: errInvalidFilter},
+		{name: "Inverted price range", params: ListParams{MinPrice: &high, MaxPrice: &low}, expectedError: errInvalidRange},
+		{name: "Error from repository", params: ListParams{}, mockError: errRepo, expectedError: errRepo},
 	}
 
-	// Iterate through the test cases and run the tests.
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			// Create a Service with a mock repository.
-			service := NewService(&MockRepository{
-				Books: tc.mockBooks,
-				Err:   tc.mockError,
-			})
+			repo := &MockRepository{Books: tc.mockBooks, Err: tc.mockError}
+			service := NewService(repo)
 
-			// Perform the actual test.
-			books, err := service.GetAllBooks(context.Background())
+			page, err := service.ListBooks(context.Background(), tc.params)
+			if err != tc.expectedError {
+				t.Fatalf("Expected error: %v, but got: %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
 
-			if !reflect.DeepEqual(books, tc.expectedBooks) {
-				t.Fatalf("Expected error: %v, but got: %v", tc.expectedBooks, books)
+			if len(page.Items) != tc.expectedItems {
+				t.Fatalf("Expected %d books, but got: %d", tc.expectedItems, len(page.Items))
+			}
 
+			if !tc.params.WithTotal && page.Total != nil {
+				t.Fatalf("Expected no total, but got: %d", *page.Total)
 			}
-			// Check the error.
-			if err != tc.expectedError {
-				t.Fatalf("Expected error: %v, but got: %v", tc.expectedError, err)
+			if tc.params.WithTotal && (page.Total == nil || *page.Total != int64(len(tc.mockBooks))) {
+				t.Fatalf("Expected total: %d, but got: %v", len(tc.mockBooks), page.Total)
+			}
+
+			if tc.expectedCursor == nil && page.NextCursor != "" {
+				t.Fatalf("Expected no next cursor, but got: %s", page.NextCursor)
+			}
+			if tc.expectedCursor != nil {
+				var c Cursor
+				if err := utils.DecodeCursor(page.NextCursor, &c); err != nil {
+					t.Fatalf("next cursor should be valid: %v", err)
+				}
+				if c != *tc.expectedCursor {
+					t.Fatalf("Expected cursor: %+v, but got: %+v", *tc.expectedCursor, c)
+				}
+			}
+
+			if tc.expectedQuery != nil && !reflect.DeepEqual(repo.LastQuery, *tc.expectedQuery) {
+				t.Fatalf("Expected query: %+v, but got: %+v", *tc.expectedQuery, repo.LastQuery)
 			}
 		})
 	}
@@ -85,7 +263,7 @@ func TestService_GetBookPrices(t *testing.T) {
 		name          string
 		bookIDs       []int64
 		bookOptions   []*Model
-		expected      map[int64]float64
+		expected      map[int64]money.Amount
 		repoError     error
 		expectedError error
 	}{
@@ -97,28 +275,28 @@ func TestService_GetBookPrices(t *testing.T) {
 					ID:     1,
 					Title:  "book1",
 					Author: "author1",
-					Price:  1.0,
+					Price:  100,
 				},
 				{
 					ID:     2,
 					Title:  "book2",
 					Author: "author2",
-					Price:  2.0,
+					Price:  200,
 				},
 				{
 					ID:     3,
 					Title:  "book3",
 					Author: "author3",
-					Price:  3.0,
+					Price:  300,
 				},
 				{
 					ID:     4,
 					Title:  "book4",
 					Author: "author4",
-					Price:  4.0,
+					Price:  400,
 				},
 			},
-			expected:      map[int64]float64{1: 1.0, 2: 2.0, 3: 3.0},
+			expected:      map[int64]money.Amount{1: 100, 2: 200, 3: 300},
 			repoError:     nil,
 			expectedError: nil,
 		},
@@ -130,19 +308,19 @@ func TestService_GetBookPrices(t *testing.T) {
 					ID:     1,
 					Title:  "book1",
 					Author: "author1",
-					Price:  1.0,
+					Price:  100,
 				},
 				{
 					ID:     2,
 					Title:  "book2",
 					Author: "author2",
-					Price:  2.0,
+					Price:  200,
 				},
 				{
 					ID:     3,
 					Title:  "book3",
 					Author: "author3",
-					Price:  3.0,
+					Price:  300,
 				},
 			},
 			expected:      nil,
@@ -179,10 +357,363 @@ func TestService_GetBookPrices(t *testing.T) {
 			if err == nil {
 				for id, expectedPrice := range tc.expected {
 					if prices[id].Price != expectedPrice {
-						t.Errorf("Expected price for book %d: %f, but got: %f", id, expectedPrice, prices[id].Price)
+						t.Errorf("Expected price for book %d: %s, but got: %s", id, expectedPrice, prices[id].Price)
 					}
 				}
 			}
 		})
 	}
 }
+
+func TestService_SearchBooks(t *testing.T) {
+	errRepo := errors.New("mock repository error")
+	testCases := []struct {
+		name          string
+		text          string
+		limit         int
+		mockBooks     []*Model
+		repoError     error
+		expectedTerms string
+		expectedLimit int
+		expectedError error
+	}{
+		{
+			name:          "Terms are normalized",
+			text:          "  Harry-Potter's  STONE ",
+			mockBooks:     []*Model{{ID: 1, Title: "Harry Potter and the Sorcerer's Stone"}},
+			expectedTerms: "harry potter s stone",
+			expectedLimit: defaultPageSize,
+		},
+		{
+			name:          "Custom limit",
+			text:          "potter",
+			limit:         5,
+			expectedTerms: "potter",
+			expectedLimit: 5,
+		},
+		{name: "Only symbols", text: "!!! ---", expectedError: errSearchEmpty},
+		{name: "Empty text", text: "", expectedError: errSearchEmpty},
+		{name: "Text too long", text: strings.Repeat("a", maxSearchLength+1), expectedError: errSearchLong},
+		{name: "Invalid limit", text: "potter", limit: maxPageSize + 1, expectedError: errInvalidLimit},
+		{name: "Error from repository", text: "potter", repoError: errRepo, expectedError: errRepo},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepository{Books: tc.mockBooks, Err: tc.repoError}
+			service := NewService(repo)
+
+			books, err := service.SearchBooks(context.Background(), tc.text, tc.limit)
+			if err != tc.expectedError {
+				t.Fatalf("Expected error: %v, but got: %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if books == nil || len(books) != len(tc.mockBooks) {
+				t.Fatalf("Expected %d books, but got: %v", len(tc.mockBooks), books)
+			}
+			terms := strings.Join(repo.LastTerms, " ")
+			if terms != tc.expectedTerms || repo.LastQuery.Limit != tc.expectedLimit {
+				t.Fatalf("Expected terms %q with limit %d, but got: %q with limit %d", tc.expectedTerms, tc.expectedLimit, terms, repo.LastQuery.Limit)
+			}
+		})
+	}
+}
+
+func TestService_GetBookByID(t *testing.T) {
+	errRepo := errors.New("mock repository error")
+	existing := &Model{ID: 1, Title: "title", Author: "author", Price: 1}
+	testCases := []struct {
+		name          string
+		id            int64
+		repoError     error
+		expectedBook  *Model
+		expectedError error
+	}{
+		{name: "Existing book", id: 1, expectedBook: existing},
+		{name: "Invalid id", id: 0, expectedError: errInvalidBookID},
+		{name: "Book not found", id: 2, expectedError: errBookNotFound},
+		{name: "Error from repository", id: 1, repoError: errRepo, expectedError: errRepo},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := NewService(&MockRepository{
+				Books: []*Model{existing},
+				Err:   tc.repoError,
+			})
+
+			b, err := service.GetBookByID(context.Background(), tc.id)
+			if err != tc.expectedError {
+				t.Fatalf("Expected error: %v, but got: %v", tc.expectedError, err)
+			}
+
+			if !reflect.DeepEqual(b, tc.expectedBook) {
+				t.Fatalf("Expected book: %+v, but got: %+v", tc.expectedBook, b)
+			}
+		})
+	}
+}
+
+func TestService_CreateBook(t *testing.T) {
+	errRepo := errors.New("mock repository error")
+	testCases := []struct {
+		name          string
+		title         string
+		author        string
+		price         money.Amount
+		repoError     error
+		expectedError error
+	}{
+		{name: "Valid book", title: "the hobbit", author: "tolkien", price: 999},
+		{name: "Empty title", title: "", author: "tolkien", price: 999, expectedError: errTitleEmpty},
+		{name: "Long title", title: strings.Repeat("a", 256), author: "tolkien", price: 999, expectedError: errTitleLong},
+		{name: "Empty author", title: "the hobbit", author: "", price: 999, expectedError: errAuthorEmpty},
+		{name: "Long author", title: "the hobbit", author: strings.Repeat("a", 256), price: 999, expectedError: errAuthorLong},
+		{name: "Zero price", title: "the hobbit", author: "tolkien", price: 0, expectedError: errPriceInvalid},
+		{name: "Negative price", title: "the hobbit", author: "tolkien", price: -1, expectedError: errPriceInvalid},
+		{name: "Price too high", title: "the hobbit", author: "tolkien", price: 100000000_00, expectedError: errPriceTooHigh},
+		{name: "Error from repository", title: "the hobbit", author: "tolkien", price: 999, repoError: errRepo, expectedError: errRepo},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := NewService(&MockRepository{Err: tc.repoError})
+
+			b, err := service.CreateBook(context.Background(), tc.title, tc.author, tc.price)
+			if err != tc.expectedError {
+				t.Fatalf("Expected error: %v, but got: %v", tc.expectedError, err)
+			}
+
+			if err == nil && (b.ID == 0 || b.Title != tc.title || b.Author != tc.author || b.Price != tc.price) {
+				t.Fatalf("unexpected book returned: %+v", b)
+			}
+		})
+	}
+}
+
+func TestService_UpdateBook(t *testing.T) {
+	errRepo := errors.New("mock repository error")
+	testCases := []struct {
+		name          string
+		id            int64
+		title         string
+		repoError     error
+		expectedError error
+	}{
+		{name: "Valid update", id: 1, title: "new title"},
+		{name: "Invalid id", id: 0, title: "new title", expectedError: errInvalidBookID},
+		{name: "Invalid title", id: 1, title: "", expectedError: errTitleEmpty},
+		{name: "Book not found", id: 2, title: "new title", expectedError: errBookNotFound},
+		{name: "Error from repository", id: 1, title: "new title", repoError: errRepo, expectedError: errRepo},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := NewService(&MockRepository{
+				Books: []*Model{{ID: 1, Title: "old title", Author: "author", Price: 1}},
+				Err:   tc.repoError,
+			})
+
+			b, err := service.UpdateBook(context.Background(), tc.id, tc.title, "author", 2)
+			if err != tc.expectedError {
+				t.Fatalf("Expected error: %v, but got: %v", tc.expectedError, err)
+			}
+
+			if err == nil && (b.Title != tc.title || b.Price != 2) {
+				t.Fatalf("book was not updated: %+v", b)
+			}
+		})
+	}
+}
+
+func TestService_PatchBook(t *testing.T) {
+	errRepo := errors.New("mock repository error")
+	title := "new title"
+	empty := ""
+	price := money.Amount(550)
+	badPrice := money.Amount(-500)
+	weight := 850
+	badWeight := 0
+	testCases := []struct {
+		name          string
+		id            int64
+		patch         Patch
+		repoError     error
+		expectedBook  *Model
+		expectedError error
+	}{
+		{
+			name:         "Patch title only",
+			id:           1,
+			patch:        Patch{Title: &title},
+			expectedBook: &Model{ID: 1, Title: title, Author: "author", Price: 1},
+		},
+		{
+			name:         "Patch price only",
+			id:           1,
+			patch:        Patch{Price: &price},
+			expectedBook: &Model{ID: 1, Title: "old title", Author: "author", Price: price},
+		},
+		{
+			name:         "Patch weight only",
+			id:           1,
+			patch:        Patch{Weight: &weight},
+			expectedBook: &Model{ID: 1, Title: "old title", Author: "author", Price: 1, Weight: weight},
+		},
+		{name: "Invalid id", id: -1, patch: Patch{Title: &title}, expectedError: errInvalidBookID},
+		{name: "Invalid title", id: 1, patch: Patch{Title: &empty}, expectedError: errTitleEmpty},
+		{name: "Invalid author", id: 1, patch: Patch{Author: &empty}, expectedError: errAuthorEmpty},
+		{name: "Invalid price", id: 1, patch: Patch{Price: &badPrice}, expectedError: errPriceInvalid},
+		{name: "Invalid weight", id: 1, patch: Patch{Weight: &badWeight}, expectedError: errWeightInvalid},
+		{name: "Book not found", id: 2, patch: Patch{Title: &title}, expectedError: errBookNotFound},
+		{name: "Error from repository", id: 1, patch: Patch{Title: &title}, repoError: errRepo, expectedError: errRepo},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := NewService(&MockRepository{
+				Books: []*Model{{ID: 1, Title: "old title", Author: "author", Price: 1}},
+				Err:   tc.repoError,
+			})
+
+			b, err := service.PatchBook(context.Background(), tc.id, tc.patch)
+			if err != tc.expectedError {
+				t.Fatalf("Expected error: %v, but got: %v", tc.expectedError, err)
+			}
+
+			if tc.expectedBook != nil && !reflect.DeepEqual(b, tc.expectedBook) {
+				t.Fatalf("Expected book: %+v, but got: %+v", tc.expectedBook, b)
+			}
+		})
+	}
+}
+
+func TestService_DeleteBook(t *testing.T) {
+	errRepo := errors.New("mock repository error")
+	testCases := []struct {
+		name          string
+		id            int64
+		repoError     error
+		expectedError error
+	}{
+		{name: "Valid delete", id: 1},
+		{name: "Invalid id", id: 0, expectedError: errInvalidBookID},
+		{name: "Book not found", id: 2, expectedError: errBookNotFound},
+		{name: "Error from repository", id: 1, repoError: errRepo, expectedError: errRepo},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := NewService(&MockRepository{
+				Books: []*Model{{ID: 1, Title: "title", Author: "author", Price: 1}},
+				Err:   tc.repoError,
+			})
+
+			err := service.DeleteBook(context.Background(), tc.id)
+			if err != tc.expectedError {
+				t.Fatalf("Expected error: %v, but got: %v", tc.expectedError, err)
+			}
+
+			if IsNotFound(err) != (tc.expectedError == errBookNotFound) {
+				t.Fatalf("IsNotFound mismatch for error: %v", err)
+			}
+		})
+	}
+}
+
+func TestService_AdjustStock(t *testing.T) {
+	errRepo := errors.New("mock repository error")
+	testCases := []struct {
+		name          string
+		id            int64
+		quantity      int
+		reason        string
+		repoError     error
+		expectedStock int
+		expectedError error
+	}{
+		{name: "Add units", id: 1, quantity: 10, reason: "restock", expectedStock: 15},
+		{name: "Remove units", id: 1, quantity: -5, reason: "damaged", expectedStock: 0},
+		{name: "Remove more units than available", id: 1, quantity: -6, reason: "damaged", expectedError: errNotEnoughStock},
+		{name: "Zero quantity", id: 1, quantity: 0, reason: "restock", expectedError: errStockQuantity},
+		{name: "Quantity too big", id: 1, quantity: maxStockChange + 1, reason: "restock", expectedError: errStockQuantity},
+		{name: "Missing reason", id: 1, quantity: 1, reason: "", expectedError: errStockReason},
+		{name: "Invalid id", id: 0, quantity: 1, reason: "restock", expectedError: errInvalidBookID},
+		{name: "Book not found", id: 2, quantity: 1, reason: "restock", expectedError: errBookNotFound},
+		{name: "Error from repository", id: 1, quantity: 1, reason: "restock", repoError: errRepo, expectedError: errRepo},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepository{
+				Books: []*Model{{ID: 1, Title: "title", Author: "author", Price: 1, Stock: 5}},
+				Err:   tc.repoError,
+			}
+			service := NewService(repo)
+
+			b, err := service.AdjustStock(context.Background(), tc.id, tc.quantity, tc.reason, 42)
+			if err != tc.expectedError {
+				t.Fatalf("Expected error: %v, but got: %v", tc.expectedError, err)
+			}
+			if err != nil {
+				if IsNotEnoughStock(err) != (tc.expectedError == errNotEnoughStock) {
+					t.Fatalf("IsNotEnoughStock mismatch for error: %v", err)
+				}
+				return
+			}
+
+			if b.Stock != tc.expectedStock {
+				t.Fatalf("Expected stock: %d, but got: %d", tc.expectedStock, b.Stock)
+			}
+			if len(repo.Movements) != 1 || repo.Movements[0].Quantity != tc.quantity || *repo.Movements[0].CreatedBy != 42 {
+				t.Fatalf("the movement should have been recorded, got: %+v", repo.Movements)
+			}
+		})
+	}
+}
+
+func TestService_GetStockMovements(t *testing.T) {
+	errRepo := errors.New("mock repository error")
+	testCases := []struct {
+		name          string
+		id            int64
+		limit         int
+		repoError     error
+		expectedLimit int
+		expectedError error
+	}{
+		{name: "Default limit", id: 1, expectedLimit: defaultPageSize},
+		{name: "Custom limit", id: 1, limit: 5, expectedLimit: 5},
+		{name: "Invalid limit", id: 1, limit: maxPageSize + 1, expectedError: errInvalidLimit},
+		{name: "Book not found", id: 2, expectedError: errBookNotFound},
+		{name: "Error from repository", id: 1, repoError: errRepo, expectedError: errRepo},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepository{
+				Books: []*Model{{ID: 1, Title: "title", Author: "author", Price: 1}},
+				Err:   tc.repoError,
+			}
+			service := NewService(repo)
+
+			movements, err := service.GetStockMovements(context.Background(), tc.id, tc.limit)
+			if err != tc.expectedError {
+				t.Fatalf("Expected error: %v, but got: %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if movements == nil {
+				t.Fatalf("movements should be an empty slice instead of nil")
+			}
+			if repo.LastQuery.Limit != tc.expectedLimit {
+				t.Fatalf("Expected limit: %d, but got: %d", tc.expectedLimit, repo.LastQuery.Limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package cart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/book"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"time"
+)
+
+var (
+	errInvalidBookID   = errors.New("invalid book ID")
+	errInvalidQuantity = errors.New("invalid quantity: needs to be between 1 and 100")
+	errCartFull        = errors.New("the cart can't have more than 100 different books")
+	errItemNotFound    = errors.New("book not found in the cart")
+	errEmptyCart       = errors.New("the cart is empty")
+)
+
+const (
+	maxQuantity = 100
+	maxItems    = 100
+)
+
+// Item is a book in the cart, Price and BookTitle always come from the catalog at the time the cart is read
+type Item struct {
+	BookID    int64        `json:"book_id"`
+	Quantity  int          `json:"quantity"`
+	Price     money.Amount `json:"price"`
+	BookTitle string       `json:"book_title"`
+	AddedAt   time.Time    `json:"added_at"`
+}
+
+type Cart struct {
+	Items    []Item       `json:"items"`
+	Total    money.Amount `json:"total"`
+	Currency string       `json:"currency"`
+}
+
+type Repository interface {
+	// GetCartItems returns the items of the customer cart, oldest first
+	GetCartItems(ctx context.Context, customerID int64) ([]Item, error)
+	// AddCartItem puts the book in the cart, adding to the quantity if it's already there, and returns the new quantity
+	AddCartItem(ctx context.Context, customerID, bookID int64, quantity int, addedAt time.Time) (int, error)
+	// SetCartItemQuantity returns false if the book isn't in the cart
+	SetCartItemQuantity(ctx context.Context, customerID, bookID int64, quantity int) (bool, error)
+	// RemoveCartItem returns false if the book isn't in the cart
+	RemoveCartItem(ctx context.Context, customerID, bookID int64) (bool, error)
+}
+
+type BookService interface {
+	GetBooksInformation(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error)
+}
+
+type OrderService interface {
+	MakeOrder(ctx context.Context, customerID int64, request order.OrderRequest) (*order.Order, error)
+}
+
+type Service struct {
+	r            Repository
+	bookService  BookService
+	orderService OrderService
+}
+
+func NewService(r Repository, bookService BookService, orderService OrderService) *Service {
+	return &Service{r, bookService, orderService}
+}
+
+// IsItemNotFound reports whether err means that the book isn't in the cart
+func IsItemNotFound(err error) bool {
+	return errors.Is(err, errItemNotFound)
+}
+
+// GetCart returns the customer cart with the current catalog prices
+func (s *Service) GetCart(ctx context.Context, customerID int64) (*Cart, error) {
+	items, err := s.r.GetCartItems(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cart{Items: []Item{}, Currency: money.Currency}
+	if len(items) == 0 {
+		return c, nil
+	}
+
+	bookIDs := make([]int64, len(items))
+	for i := range items {
+		bookIDs[i] = items[i].BookID
+	}
+	m, err := s.bookService.GetBooksInformation(ctx, bookIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		items[i].Price = m[items[i].BookID].Price
+		items[i].BookTitle = m[items[i].BookID].Title
+		c.Total += items[i].Price.Mul(items[i].Quantity)
+	}
+	c.Items = items
+
+	return c, nil
+}
+
+// AddItem puts quantity units of the book in the customer cart
+func (s *Service) AddItem(ctx context.Context, customerID, bookID int64, quantity int) (*Cart, error) {
+	if bookID <= 0 {
+		return nil, errInvalidBookID
+	}
+	if quantity <= 0 || quantity > maxQuantity {
+		return nil, errInvalidQuantity
+	}
+
+	// make sure the book is in the catalog
+	if _, err := s.bookService.GetBooksInformation(ctx, []int64{bookID}); err != nil {
+		return nil, err
+	}
+
+	items, err := s.r.GetCartItems(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	inCart := false
+	for _, v := range items {
+		if v.BookID == bookID {
+			inCart = true
+			if v.Quantity+quantity > maxQuantity {
+				return nil, errInvalidQuantity
+			}
+		}
+	}
+	if !inCart && len(items) >= maxItems {
+		return nil, errCartFull
+	}
+
+	if _, err := s.r.AddCartItem(ctx, customerID, bookID, quantity, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return s.GetCart(ctx, customerID)
+}
+
+// UpdateItem changes the quantity of a book that is already in the customer cart
+func (s *Service) UpdateItem(ctx context.Context, customerID, bookID int64, quantity int) (*Cart, error) {
+	if bookID <= 0 {
+		return nil, errInvalidBookID
+	}
+	if quantity <= 0 || quantity > maxQuantity {
+		return nil, errInvalidQuantity
+	}
+
+	ok, err := s.r.SetCartItemQuantity(ctx, customerID, bookID, quantity)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errItemNotFound
+	}
+
+	return s.GetCart(ctx, customerID)
+}
+
+// RemoveItem takes a book out of the customer cart
+func (s *Service) RemoveItem(ctx context.Context, customerID, bookID int64) (*Cart, error) {
+	if bookID <= 0 {
+		return nil, errInvalidBookID
+	}
+
+	ok, err := s.r.RemoveCartItem(ctx, customerID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errItemNotFound
+	}
+
+	return s.GetCart(ctx, customerID)
+}
+
+// Checkout turns the customer cart into an order and empties it, the cart is kept if the order can't be made. The
+// items of the request are replaced by the ones in the cart, and the order fails if the cart changes meanwhile
+func (s *Service) Checkout(ctx context.Context, customerID int64, request order.OrderRequest) (*order.Order, error) {
+	items, err := s.r.GetCartItems(ctx, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("checkout failed: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("checkout failed: %w", errEmptyCart)
+	}
+
+	requestItems := make([]order.OrderRequestItem, len(items))
+	for i := range items {
+		requestItems[i] = order.OrderRequestItem{BookID: items[i].BookID, Quantity: items[i].Quantity}
+	}
+	request.Items = requestItems
+	// the cart is emptied in the same transaction that stores the order
+	request.FromCart = true
+
+	newOrder, err := s.orderService.MakeOrder(ctx, customerID, request)
+	if err != nil {
+		return nil, fmt.Errorf("checkout failed: %w", err)
+	}
+
+	return newOrder, nil
+}
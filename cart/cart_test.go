@@ -0,0 +1,332 @@
+package cart
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/book"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/tax"
+	"testing"
+	"time"
+)
+
+// MockRepository keeps the carts in memory, mimicking the database behavior
+type MockRepository struct {
+	Items map[int64][]Item
+	Err   error
+}
+
+func newMockRepository() *MockRepository {
+	return &MockRepository{Items: map[int64][]Item{}}
+}
+
+func (m *MockRepository) GetCartItems(ctx context.Context, customerID int64) ([]Item, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return append([]Item(nil), m.Items[customerID]...), nil
+}
+
+func (m *MockRepository) AddCartItem(ctx context.Context, customerID, bookID int64, quantity int, addedAt time.Time) (int, error) {
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	for i, v := range m.Items[customerID] {
+		if v.BookID == bookID {
+			m.Items[customerID][i].Quantity += quantity
+			return m.Items[customerID][i].Quantity, nil
+		}
+	}
+	m.Items[customerID] = append(m.Items[customerID], Item{BookID: bookID, Quantity: quantity, AddedAt: addedAt})
+	return quantity, nil
+}
+
+func (m *MockRepository) SetCartItemQuantity(ctx context.Context, customerID, bookID int64, quantity int) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	for i, v := range m.Items[customerID] {
+		if v.BookID == bookID {
+			m.Items[customerID][i].Quantity = quantity
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockRepository) RemoveCartItem(ctx context.Context, customerID, bookID int64) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	for i, v := range m.Items[customerID] {
+		if v.BookID == bookID {
+			m.Items[customerID] = append(m.Items[customerID][:i], m.Items[customerID][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type MockBookService struct {
+	Books map[int64]book.Information
+	Err   error
+}
+
+func (m *MockBookService) GetBooksInformation(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	r := map[int64]book.Information{}
+	for _, id := range bookIDs {
+		info, ok := m.Books[id]
+		if !ok {
+			return nil, errors.New("book not found")
+		}
+		r[id] = info
+	}
+	return r, nil
+}
+
+type MockOrderService struct {
+	LastRequest order.OrderRequest
+	Err         error
+}
+
+func (m *MockOrderService) MakeOrder(ctx context.Context, customerID int64, request order.OrderRequest) (*order.Order, error) {
+	m.LastRequest = request
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &order.Order{ID: 1, Status: order.StatusPaid}, nil
+}
+
+func newTestService() (*Service, *MockRepository, *MockBookService, *MockOrderService) {
+	repo := newMockRepository()
+	books := &MockBookService{Books: map[int64]book.Information{
+		1: {Price: 1099, Title: "Book1"},
+		2: {Price: 500, Title: "Book2"},
+	}}
+	orders := &MockOrderService{}
+	return NewService(repo, books, orders), repo, books, orders
+}
+
+func TestService_GetCart(t *testing.T) {
+	s, repo, books, _ := newTestService()
+
+	c, err := s.GetCart(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if c.Items == nil || len(c.Items) != 0 || c.Total != 0 || c.Currency != money.Currency {
+		t.Fatalf("Expected an empty cart, got: %+v", c)
+	}
+
+	repo.Items[1] = []Item{{BookID: 1, Quantity: 3, Price: 1}, {BookID: 2, Quantity: 1}}
+	c, err = s.GetCart(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if c.Items[0].Price != 1099 || c.Items[0].BookTitle != "Book1" || c.Total != 3797 {
+		t.Fatalf("Expected the prices to be refreshed from the catalog, got: %+v", c)
+	}
+
+	books.Books[1] = book.Information{Price: 999, Title: "Book1"}
+	c, err = s.GetCart(context.Background(), 1)
+	if err != nil || c.Total != 3497 {
+		t.Fatalf("Expected the new price to be used, got: %+v, %v", c, err)
+	}
+
+	repoErr := errors.New("repo err")
+	repo.Err = repoErr
+	if _, err := s.GetCart(context.Background(), 1); !errors.Is(err, repoErr) {
+		t.Fatalf("Expected error: %v, got: %v", repoErr, err)
+	}
+}
+
+func TestService_AddItem(t *testing.T) {
+	tests := []struct {
+		name          string
+		bookID        int64
+		quantity      int
+		setup         func(repo *MockRepository)
+		expectedQty   int
+		expectedError error
+	}{
+		{
+			name:        "NewBook",
+			bookID:      1,
+			quantity:    2,
+			expectedQty: 2,
+		},
+		{
+			name:     "BookAlreadyInCart",
+			bookID:   1,
+			quantity: 2,
+			setup: func(repo *MockRepository) {
+				repo.Items[1] = []Item{{BookID: 1, Quantity: 3}}
+			},
+			expectedQty: 5,
+		},
+		{
+			name:          "InvalidBookID",
+			bookID:        0,
+			quantity:      1,
+			expectedError: errInvalidBookID,
+		},
+		{
+			name:          "InvalidQuantity",
+			bookID:        1,
+			quantity:      0,
+			expectedError: errInvalidQuantity,
+		},
+		{
+			name:     "QuantityAboveLimit",
+			bookID:   1,
+			quantity: 2,
+			setup: func(repo *MockRepository) {
+				repo.Items[1] = []Item{{BookID: 1, Quantity: maxQuantity - 1}}
+			},
+			expectedError: errInvalidQuantity,
+		},
+		{
+			name:     "CartFull",
+			bookID:   1,
+			quantity: 1,
+			setup: func(repo *MockRepository) {
+				for i := 0; i < maxItems; i++ {
+					repo.Items[1] = append(repo.Items[1], Item{BookID: int64(100 + i), Quantity: 1})
+				}
+			},
+			expectedError: errCartFull,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, repo, _, _ := newTestService()
+			if tt.setup != nil {
+				tt.setup(repo)
+			}
+
+			c, err := s.AddItem(context.Background(), 1, tt.bookID, tt.quantity)
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if len(c.Items) != 1 || c.Items[0].Quantity != tt.expectedQty {
+				t.Fatalf("Expected %d units in the cart, got: %+v", tt.expectedQty, c)
+			}
+		})
+	}
+
+	t.Run("UnknownBook", func(t *testing.T) {
+		s, repo, _, _ := newTestService()
+		if _, err := s.AddItem(context.Background(), 1, 99, 1); err == nil {
+			t.Fatalf("Expected an error for a book that isn't in the catalog")
+		}
+		if len(repo.Items[1]) != 0 {
+			t.Fatalf("Expected the cart to stay empty, got: %+v", repo.Items[1])
+		}
+	})
+}
+
+func TestService_UpdateAndRemoveItem(t *testing.T) {
+	s, repo, _, _ := newTestService()
+	repo.Items[1] = []Item{{BookID: 1, Quantity: 3}, {BookID: 2, Quantity: 1}}
+
+	c, err := s.UpdateItem(context.Background(), 1, 2, 4)
+	if err != nil || c.Items[1].Quantity != 4 {
+		t.Fatalf("Expected the quantity to be updated, got: %+v, %v", c, err)
+	}
+
+	if _, err := s.UpdateItem(context.Background(), 1, 2, 0); !errors.Is(err, errInvalidQuantity) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidQuantity, err)
+	}
+	if _, err := s.UpdateItem(context.Background(), 1, 0, 1); !errors.Is(err, errInvalidBookID) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidBookID, err)
+	}
+	if _, err := s.UpdateItem(context.Background(), 1, 5, 1); !IsItemNotFound(err) {
+		t.Fatalf("Expected error: %v, got: %v", errItemNotFound, err)
+	}
+
+	c, err = s.RemoveItem(context.Background(), 1, 1)
+	if err != nil || len(c.Items) != 1 || c.Items[0].BookID != 2 {
+		t.Fatalf("Expected the book to be removed, got: %+v, %v", c, err)
+	}
+
+	if _, err := s.RemoveItem(context.Background(), 1, 1); !IsItemNotFound(err) {
+		t.Fatalf("Expected error: %v, got: %v", errItemNotFound, err)
+	}
+	if _, err := s.RemoveItem(context.Background(), 1, -1); !errors.Is(err, errInvalidBookID) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidBookID, err)
+	}
+}
+
+func TestService_Checkout(t *testing.T) {
+	orderErr := errors.New("out of stock")
+
+	tests := []struct {
+		name          string
+		items         []Item
+		orderErr      error
+		expectedError error
+	}{
+		{
+			name:  "Valid",
+			items: []Item{{BookID: 1, Quantity: 3}, {BookID: 2, Quantity: 1}},
+		},
+		{
+			name:          "EmptyCart",
+			expectedError: errEmptyCart,
+		},
+		{
+			name:          "OrderFails",
+			items:         []Item{{BookID: 1, Quantity: 3}},
+			orderErr:      orderErr,
+			expectedError: orderErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, repo, _, orders := newTestService()
+			repo.Items[1] = tt.items
+			orders.Err = tt.orderErr
+
+			o, err := s.Checkout(context.Background(), 1, order.OrderRequest{
+				Items:        []order.OrderRequestItem{{BookID: 9, Quantity: 1}},
+				PaymentToken: "tok_visa",
+				CouponCode:   "SAVE10",
+				Destination:  tax.Destination{Country: "US", Region: "CA"},
+			})
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+				}
+				if len(repo.Items[1]) != len(tt.items) {
+					t.Fatalf("Expected the cart to be kept, got: %+v", repo.Items[1])
+				}
+				return
+			}
+			if err != nil || o == nil {
+				t.Fatalf("Expected an order, got: %+v, %v", o, err)
+			}
+			items := orders.LastRequest.Items
+			if len(items) != len(tt.items) || items[0].BookID != 1 || items[0].Quantity != 3 {
+				t.Fatalf("Expected the cart items to be ordered, got: %+v", items)
+			}
+			if r := orders.LastRequest; r.PaymentToken != "tok_visa" || r.CouponCode != "SAVE10" || r.Destination.Region != "CA" {
+				t.Fatalf("Expected the rest of the request to be passed on, got: %+v", r)
+			}
+			if !orders.LastRequest.FromCart {
+				t.Fatal("Expected the order to empty the cart when it's stored")
+			}
+		})
+	}
+}
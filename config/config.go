@@ -1,6 +1,36 @@
 package config
 
+import "time"
+
 type GlobalConfig struct {
-	ServerPort         int    `env:"SERVER_PORT,required"`
-	PostgresConnection string `env:"POSTGRES_CONNECTION,required"`
+	ServerPort                  int           `env:"SERVER_PORT,required"`
+	PostgresConnection          string        `env:"POSTGRES_CONNECTION,required"`
+	OrderCancellationWindow     time.Duration `env:"ORDER_CANCELLATION_WINDOW,default=30m"`
+	IdempotencyKeyTTL           time.Duration `env:"IDEMPOTENCY_KEY_TTL,default=24h"`
+	PaymentProvider             string        `env:"PAYMENT_PROVIDER,default=fake"`
+	ReturnWindow                time.Duration `env:"RETURN_WINDOW,default=720h"`
+	TaxRatesFile                string        `env:"TAX_RATES_FILE"`
+	ShippingRatesFile           string        `env:"SHIPPING_RATES_FILE"`
+	JwtSigningKeyFile           string        `env:"JWT_SIGNING_KEY_FILE"`
+	JwtSecret                   string        `env:"JWT_SECRET"`
+	JwtVerificationKeyFiles     []string      `env:"JWT_VERIFICATION_KEY_FILES"`
+	AccessTokenTTL              time.Duration `env:"ACCESS_TOKEN_TTL,default=15m"`
+	RefreshTokenTTL             time.Duration `env:"REFRESH_TOKEN_TTL,default=720h"`
+	Mailer                      string        `env:"MAILER,default=file"`
+	MailFrom                    string        `env:"MAIL_FROM,default=bookstore@localhost"`
+	MailDir                     string        `env:"MAIL_DIR,default=mail"`
+	SMTPHost                    string        `env:"SMTP_HOST"`
+	SMTPPort                    int           `env:"SMTP_PORT,default=587"`
+	SMTPUsername                string        `env:"SMTP_USERNAME"`
+	SMTPPassword                string        `env:"SMTP_PASSWORD"`
+	SMTPTimeout                 time.Duration `env:"SMTP_TIMEOUT,default=30s"`
+	MailMaxPending              int           `env:"MAIL_MAX_PENDING,default=100"`
+	ShutdownTimeout             time.Duration `env:"SHUTDOWN_TIMEOUT,default=30s"`
+	PasswordResetTTL            time.Duration `env:"PASSWORD_RESET_TTL,default=1h"`
+	PasswordResetURL            string        `env:"PASSWORD_RESET_URL,default=http://localhost:8081/reset-password"`
+	PasswordResetResendInterval time.Duration `env:"PASSWORD_RESET_RESEND_INTERVAL,default=1m"`
+	EmailVerificationTTL        time.Duration `env:"EMAIL_VERIFICATION_TTL,default=48h"`
+	EmailVerificationURL        string        `env:"EMAIL_VERIFICATION_URL,default=http://localhost:8081/verify-email"`
+	VerificationResendInterval  time.Duration `env:"VERIFICATION_RESEND_INTERVAL,default=1m"`
+	RequireVerifiedEmail        bool          `env:"REQUIRE_VERIFIED_EMAIL,default=false"`
 }
@@ -0,0 +1,196 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/tax"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidRecipient  = errors.New("invalid recipient: needs to have between 1 and 100 characters")
+	errInvalidLine1      = errors.New("invalid line1: needs to have between 1 and 200 characters")
+	errInvalidLine2      = errors.New("invalid line2: exceed the max amount of 200 characters")
+	errInvalidCity       = errors.New("invalid city: needs to have between 1 and 100 characters")
+	errInvalidPostalCode = errors.New("invalid postal_code: exceed the max amount of 20 characters")
+	errInvalidAddressID  = errors.New("invalid address ID")
+	errTooManyAddresses  = errors.New("the address book can't have more than 20 addresses")
+	errAddressNotFound   = errors.New("address not found")
+)
+
+const maxAddresses = 20
+
+// Address is an entry of the customer address book, the Default address is used when an order doesn't pick one
+type Address struct {
+	ID         int64     `json:"id"`
+	Recipient  string    `json:"recipient"`
+	Line1      string    `json:"line1"`
+	Line2      string    `json:"line2,omitempty"`
+	City       string    `json:"city"`
+	Region     string    `json:"region,omitempty"`
+	PostalCode string    `json:"postal_code,omitempty"`
+	Country    string    `json:"country"`
+	Default    bool      `json:"default"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Destination returns where the address is for tax purposes
+func (a *Address) Destination() tax.Destination {
+	return tax.Destination{Country: a.Country, Region: a.Region}
+}
+
+// normalize trims every field and uppercases the country and region codes
+func (a *Address) normalize() {
+	a.Recipient = strings.TrimSpace(a.Recipient)
+	a.Line1 = strings.TrimSpace(a.Line1)
+	a.Line2 = strings.TrimSpace(a.Line2)
+	a.City = strings.TrimSpace(a.City)
+	a.PostalCode = strings.TrimSpace(a.PostalCode)
+	d := a.Destination().Normalize()
+	a.Country, a.Region = d.Country, d.Region
+}
+
+func (a *Address) validate() error {
+	if a.Recipient == "" || len(a.Recipient) > 100 {
+		return errInvalidRecipient
+	}
+	if a.Line1 == "" || len(a.Line1) > 200 {
+		return errInvalidLine1
+	}
+	if len(a.Line2) > 200 {
+		return errInvalidLine2
+	}
+	if a.City == "" || len(a.City) > 100 {
+		return errInvalidCity
+	}
+	if len(a.PostalCode) > 20 {
+		return errInvalidPostalCode
+	}
+	return a.Destination().Validate()
+}
+
+type AddressRepository interface {
+	// ListAddresses returns the addresses of the customer, the default one first and then the oldest first
+	ListAddresses(ctx context.Context, customerID int64) ([]Address, error)
+	// GetAddress returns nil if the customer doesn't have the address
+	GetAddress(ctx context.Context, customerID, addressID int64) (*Address, error)
+	// GetDefaultAddress returns nil if the customer doesn't have a default address
+	GetDefaultAddress(ctx context.Context, customerID int64) (*Address, error)
+	// SaveAddress stores a new address, making it the default one when it's the first address of the customer, and
+	// unsetting the previous default when it is the new default
+	SaveAddress(ctx context.Context, customerID int64, a Address) (*Address, error)
+	// UpdateAddress replaces an address, unsetting the previous default when it is the new default, it returns nil if
+	// the customer doesn't have the address
+	UpdateAddress(ctx context.Context, customerID int64, a Address) (*Address, error)
+	// DeleteAddress returns false if the customer doesn't have the address, deleting the default address makes the
+	// oldest remaining one the default
+	DeleteAddress(ctx context.Context, customerID, addressID int64) (bool, error)
+}
+
+// AddressService manages the address books of the customers
+type AddressService struct {
+	repository AddressRepository
+}
+
+func NewAddressService(r AddressRepository) *AddressService {
+	return &AddressService{r}
+}
+
+// IsAddressNotFound reports whether err means that the customer doesn't have the address
+func IsAddressNotFound(err error) bool {
+	return errors.Is(err, errAddressNotFound)
+}
+
+// ListAddresses returns the address book of the customer
+func (s *AddressService) ListAddresses(ctx context.Context, customerID int64) ([]Address, error) {
+	addresses, err := s.repository.ListAddresses(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if addresses == nil {
+		addresses = []Address{}
+	}
+	return addresses, nil
+}
+
+// GetAddress returns one of the customer addresses
+func (s *AddressService) GetAddress(ctx context.Context, customerID, addressID int64) (*Address, error) {
+	if addressID <= 0 {
+		return nil, errInvalidAddressID
+	}
+
+	a, err := s.repository.GetAddress(ctx, customerID, addressID)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, errAddressNotFound
+	}
+
+	return a, nil
+}
+
+// GetDefaultAddress returns the default address of the customer, or nil when there isn't one
+func (s *AddressService) GetDefaultAddress(ctx context.Context, customerID int64) (*Address, error) {
+	return s.repository.GetDefaultAddress(ctx, customerID)
+}
+
+// AddAddress validates the address and adds it to the customer address book
+func (s *AddressService) AddAddress(ctx context.Context, customerID int64, a Address) (*Address, error) {
+	a.normalize()
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+
+	addresses, err := s.repository.ListAddresses(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(addresses) >= maxAddresses {
+		return nil, errTooManyAddresses
+	}
+
+	a.CreatedAt = time.Now()
+	return s.repository.SaveAddress(ctx, customerID, a)
+}
+
+// UpdateAddress validates the address and replaces the customer address with its ID, orders made with the address
+// keep the copy they were made with
+func (s *AddressService) UpdateAddress(ctx context.Context, customerID int64, a Address) (*Address, error) {
+	if a.ID <= 0 {
+		return nil, errInvalidAddressID
+	}
+
+	a.normalize()
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.repository.UpdateAddress(ctx, customerID, a)
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		return nil, errAddressNotFound
+	}
+
+	return updated, nil
+}
+
+// DeleteAddress removes an address from the customer address book
+func (s *AddressService) DeleteAddress(ctx context.Context, customerID, addressID int64) error {
+	if addressID <= 0 {
+		return errInvalidAddressID
+	}
+
+	deleted, err := s.repository.DeleteAddress(ctx, customerID, addressID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errAddressNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/tax"
+	"strings"
+	"testing"
+)
+
+// MockAddressRepository keeps the addresses of a single customer in memory
+type MockAddressRepository struct {
+	Addresses []Address
+	Err       error
+}
+
+func (m *MockAddressRepository) ListAddresses(ctx context.Context, customerID int64) ([]Address, error) {
+	return m.Addresses, m.Err
+}
+
+func (m *MockAddressRepository) GetAddress(ctx context.Context, customerID, addressID int64) (*Address, error) {
+	for _, v := range m.Addresses {
+		if v.ID == addressID {
+			return &v, m.Err
+		}
+	}
+	return nil, m.Err
+}
+
+func (m *MockAddressRepository) GetDefaultAddress(ctx context.Context, customerID int64) (*Address, error) {
+	for _, v := range m.Addresses {
+		if v.Default {
+			return &v, m.Err
+		}
+	}
+	return nil, m.Err
+}
+
+func (m *MockAddressRepository) SaveAddress(ctx context.Context, customerID int64, a Address) (*Address, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	a.ID = int64(len(m.Addresses) + 1)
+	m.Addresses = append(m.Addresses, a)
+	return &a, nil
+}
+
+func (m *MockAddressRepository) UpdateAddress(ctx context.Context, customerID int64, a Address) (*Address, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	for i, v := range m.Addresses {
+		if v.ID == a.ID {
+			m.Addresses[i] = a
+			return &a, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockAddressRepository) DeleteAddress(ctx context.Context, customerID, addressID int64) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	for i, v := range m.Addresses {
+		if v.ID == addressID {
+			m.Addresses = append(m.Addresses[:i], m.Addresses[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func validAddress() Address {
+	return Address{Recipient: " John Doe ", Line1: "1 Main St", City: "Los Angeles", Region: "ca", PostalCode: "90001", Country: "us"}
+}
+
+func TestAddressService_AddAddress(t *testing.T) {
+	repoErr := errors.New("repo err")
+
+	tests := []struct {
+		name          string
+		change        func(a *Address)
+		existing      int
+		repoErr       error
+		expectedError error
+	}{
+		{name: "Valid", change: func(a *Address) {}},
+		{name: "WithoutRegionAndPostalCode", change: func(a *Address) { a.Region, a.PostalCode = "", "" }},
+		{name: "MissingRecipient", change: func(a *Address) { a.Recipient = "  " }, expectedError: errInvalidRecipient},
+		{name: "MissingLine1", change: func(a *Address) { a.Line1 = "" }, expectedError: errInvalidLine1},
+		{name: "LongLine2", change: func(a *Address) { a.Line2 = strings.Repeat("a", 201) }, expectedError: errInvalidLine2},
+		{name: "MissingCity", change: func(a *Address) { a.City = "" }, expectedError: errInvalidCity},
+		{name: "LongPostalCode", change: func(a *Address) { a.PostalCode = strings.Repeat("1", 21) }, expectedError: errInvalidPostalCode},
+		{name: "InvalidCountry", change: func(a *Address) { a.Country = "USA" }, expectedError: tax.Destination{}.Validate()},
+		{name: "TooManyAddresses", change: func(a *Address) {}, existing: maxAddresses, expectedError: errTooManyAddresses},
+		{name: "RepositoryError", change: func(a *Address) {}, repoErr: repoErr, expectedError: repoErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &MockAddressRepository{Addresses: make([]Address, tt.existing), Err: tt.repoErr}
+			s := NewAddressService(repo)
+
+			a := validAddress()
+			tt.change(&a)
+			saved, err := s.AddAddress(context.Background(), 1, a)
+			if tt.expectedError != nil {
+				if err == nil || err.Error() != tt.expectedError.Error() {
+					t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			if saved.ID == 0 || saved.Recipient != "John Doe" || saved.Country != "US" || saved.CreatedAt.IsZero() {
+				t.Fatalf("Expected the address to be stored trimmed with uppercase codes, got: %+v", saved)
+			}
+			if saved.Region != "" && saved.Region != "CA" {
+				t.Fatalf("Expected an uppercase region, got: %s", saved.Region)
+			}
+		})
+	}
+}
+
+func TestAddressService_UpdateAddress(t *testing.T) {
+	repo := &MockAddressRepository{Addresses: []Address{{ID: 1, Recipient: "John", Line1: "1 Main St", City: "Portland", Country: "US", Default: true}}}
+	s := NewAddressService(repo)
+
+	a := validAddress()
+	a.ID = 1
+	updated, err := s.UpdateAddress(context.Background(), 1, a)
+	if err != nil || updated.City != "Los Angeles" || repo.Addresses[0].City != "Los Angeles" {
+		t.Fatalf("Expected the address to be replaced, got: %+v, %v", updated, err)
+	}
+
+	a.ID = 2
+	if _, err := s.UpdateAddress(context.Background(), 1, a); !IsAddressNotFound(err) {
+		t.Fatalf("Expected error: %v, got: %v", errAddressNotFound, err)
+	}
+
+	a.ID = 1
+	a.City = ""
+	if _, err := s.UpdateAddress(context.Background(), 1, a); !errors.Is(err, errInvalidCity) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidCity, err)
+	}
+
+	a.ID = 0
+	if _, err := s.UpdateAddress(context.Background(), 1, a); !errors.Is(err, errInvalidAddressID) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidAddressID, err)
+	}
+}
+
+func TestAddressService_GetAndDeleteAddress(t *testing.T) {
+	repo := &MockAddressRepository{Addresses: []Address{{ID: 1, Recipient: "John", Default: true}, {ID: 2, Recipient: "Jane"}}}
+	s := NewAddressService(repo)
+
+	a, err := s.GetAddress(context.Background(), 1, 2)
+	if err != nil || a.Recipient != "Jane" {
+		t.Fatalf("Expected the address, got: %+v, %v", a, err)
+	}
+
+	d, err := s.GetDefaultAddress(context.Background(), 1)
+	if err != nil || d == nil || d.ID != 1 {
+		t.Fatalf("Expected the default address, got: %+v, %v", d, err)
+	}
+
+	if err := s.DeleteAddress(context.Background(), 1, 2); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if _, err := s.GetAddress(context.Background(), 1, 2); !IsAddressNotFound(err) {
+		t.Fatalf("Expected error: %v, got: %v", errAddressNotFound, err)
+	}
+	if err := s.DeleteAddress(context.Background(), 1, 2); !IsAddressNotFound(err) {
+		t.Fatalf("Expected error: %v, got: %v", errAddressNotFound, err)
+	}
+
+	addresses, err := s.ListAddresses(context.Background(), 2)
+	if err != nil || len(addresses) != 1 {
+		t.Fatalf("Expected the remaining address, got: %+v, %v", addresses, err)
+	}
+}
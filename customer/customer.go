@@ -3,6 +3,7 @@ package customer
 import (
 	"context"
 	"errors"
+	"github.com/ap-pauloafonso/bookstore/utils"
 	"net/mail"
 	"time"
 )
@@ -16,6 +17,8 @@ var (
 	errEmailAlreadyTaken  = errors.New("email already registered")
 	errStoringcustomer    = errors.New("error storing customer")
 	errcustomerNotFound   = errors.New("error customer not found")
+	errWrongPassword      = errors.New("the current password is incorrect")
+	errEmailUnchanged     = errors.New("the new email is the same as the current one")
 )
 
 type Service struct {
@@ -28,14 +31,38 @@ func NewService(customerRepository Repository, securityService SecurityService)
 }
 
 type Model struct {
-	Id       int64  `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Id            int64    `json:"id"`
+	Email         string   `json:"email"`
+	Password      string   `json:"password"`
+	Roles         []string `json:"roles"`
+	EmailVerified bool     `json:"email_verified"`
 }
 
 type Repository interface {
 	SaveCustomer(ctx context.Context, email, password string, createdAt time.Time) (*int64, error)
 	GetCustomer(ctx context.Context, email string) (*Model, error)
+	// GetCustomerByID returns nil if there is no customer with the id
+	GetCustomerByID(ctx context.Context, id int64) (*Model, error)
+	// UpdatePassword changes the password of the customer, the password reset links sent before stop working
+	UpdatePassword(ctx context.Context, id int64, password string) error
+	// UpdateEmail changes the email of the customer, which needs to be verified again. The password reset links sent to
+	// the old email stop working
+	UpdateEmail(ctx context.Context, id int64, email string) error
+}
+
+// IsWrongPassword reports whether err means that the current password given to change the account is incorrect
+func IsWrongPassword(err error) bool {
+	return errors.Is(err, errWrongPassword)
+}
+
+// IsEmailAlreadyTaken reports whether err means that another customer has the email
+func IsEmailAlreadyTaken(err error) bool {
+	return errors.Is(err, errEmailAlreadyTaken)
+}
+
+// IsNotFound reports whether err means that the customer doesn't exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, errcustomerNotFound)
 }
 
 type SecurityService interface {
@@ -48,21 +75,37 @@ func isValidEmail(email string) bool {
 	return err == nil
 }
 
-func (s *Service) Register(ctx context.Context, email, password string) (*int64, error) {
+func validateEmail(email string) error {
+	if len(email) > 255 {
+		return errEmailLong
+	}
+
+	if !isValidEmail(email) {
+		return errEmailInvalid
+	}
+
+	return nil
+}
+
+func validatePassword(password string) error {
 	if len(password) < 3 {
-		return nil, errPasswordShort
+		return errPasswordShort
 	}
 
 	if len(password) > 50 {
-		return nil, errPasswordLong
+		return errPasswordLong
 	}
 
-	if len(email) > 255 {
-		return nil, errEmailLong
+	return nil
+}
+
+func (s *Service) Register(ctx context.Context, email, password string) (*int64, error) {
+	if err := validatePassword(password); err != nil {
+		return nil, err
 	}
 
-	if !isValidEmail(email) {
-		return nil, errEmailInvalid
+	if err := validateEmail(email); err != nil {
+		return nil, err
 	}
 
 	_, err := s.repository.GetCustomer(ctx, email)
@@ -104,3 +147,80 @@ func (s *Service) Getcustomer(ctx context.Context, email string) (*Model, error)
 
 	return customer, nil
 }
+
+func (s *Service) GetcustomerByID(ctx context.Context, id int64) (*Model, error) {
+	customer, err := s.repository.GetCustomerByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if customer == nil {
+		return nil, errcustomerNotFound
+	}
+
+	return customer, nil
+}
+
+// checkPassword returns the customer with the id if password is their current password
+func (s *Service) checkPassword(ctx context.Context, id int64, password string) (*Model, error) {
+	customer, err := s.GetcustomerByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.security.CheckPasswordHash(password, customer.Password) {
+		return nil, errWrongPassword
+	}
+
+	return customer, nil
+}
+
+// ChangePassword sets the new password of the customer, who needs to know the current one
+func (s *Service) ChangePassword(ctx context.Context, id int64, currentPassword, newPassword string) error {
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	if _, err := s.checkPassword(ctx, id, currentPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.security.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.UpdatePassword(ctx, id, hashedPassword)
+}
+
+// ChangeEmail sets the new email of the customer, who needs to know their password. The new email isn't verified
+func (s *Service) ChangeEmail(ctx context.Context, id int64, password, email string) error {
+	if err := validateEmail(email); err != nil {
+		return err
+	}
+
+	customer, err := s.checkPassword(ctx, id, password)
+	if err != nil {
+		return err
+	}
+	if customer.Email == email {
+		return errEmailUnchanged
+	}
+
+	_, err = s.repository.GetCustomer(ctx, email)
+	if err == nil {
+		return errEmailAlreadyTaken
+	}
+	if utils.IsStorageRelatedError(err) {
+		return err
+	}
+
+	if err := s.repository.UpdateEmail(ctx, id, email); err != nil {
+		// another customer took the email in the meantime
+		if utils.IsUniqueViolation(err) {
+			return errEmailAlreadyTaken
+		}
+		return err
+	}
+
+	return nil
+}
@@ -34,6 +34,44 @@ func (m *MockRepository) GetCustomer(ctx context.Context, email string) (*Model,
 	return customer, nil
 }
 
+func (m *MockRepository) GetCustomerByID(ctx context.Context, id int64) (*Model, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	for _, customer := range m.customers {
+		if customer.Id == id {
+			return customer, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockRepository) UpdatePassword(ctx context.Context, id int64, password string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, customer := range m.customers {
+		if customer.Id == id {
+			customer.Password = password
+		}
+	}
+	return nil
+}
+
+func (m *MockRepository) UpdateEmail(ctx context.Context, id int64, email string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	for current, customer := range m.customers {
+		if customer.Id == id {
+			delete(m.customers, current)
+			customer.Email, customer.EmailVerified = email, false
+			m.customers[email] = customer
+		}
+	}
+	return nil
+}
+
 // Define a mock repository for testing purposes.
 type MockSecurity struct {
 	errorHash   error
@@ -250,3 +288,108 @@ func TestService_Getcustomer(t *testing.T) {
 	})
 
 }
+
+func TestService_GetcustomerByID(t *testing.T) {
+	repo := &MockRepository{customers: map[string]*Model{"user@gmail.com": {Id: 1, Email: "user@gmail.com"}}}
+	service := NewService(repo, nil)
+
+	customer, err := service.GetcustomerByID(context.Background(), 1)
+	if err != nil || customer.Email != "user@gmail.com" {
+		t.Fatalf("expected the customer and got %v, %v", customer, err)
+	}
+
+	if _, err := service.GetcustomerByID(context.Background(), 2); !IsNotFound(err) {
+		t.Fatalf("expected error %v and got %v", errcustomerNotFound, err)
+	}
+
+	repo.Err = errors.New("db down")
+	if _, err := service.GetcustomerByID(context.Background(), 1); !errors.Is(err, repo.Err) {
+		t.Fatalf("expected error %v and got %v", repo.Err, err)
+	}
+}
+
+func TestService_ChangePassword(t *testing.T) {
+	newService := func(resultCheck bool) (*Service, *MockRepository) {
+		repo := &MockRepository{customers: map[string]*Model{"user@gmail.com": {Id: 1, Email: "user@gmail.com", Password: "hash"}}}
+		return NewService(repo, &MockSecurity{resultCheck: resultCheck}), repo
+	}
+
+	t.Run("changes the password", func(t *testing.T) {
+		service, repo := newService(true)
+		if err := service.ChangePassword(context.Background(), 1, "current", "new password"); err != nil {
+			t.Fatalf("expected no error and got %v", err)
+		}
+		// MockSecurity hashes every password to ""
+		if repo.customers["user@gmail.com"].Password != "" {
+			t.Fatal("expected the password to be re-hashed")
+		}
+	})
+
+	t.Run("requires the current password", func(t *testing.T) {
+		service, repo := newService(false)
+		if err := service.ChangePassword(context.Background(), 1, "wrong", "new password"); !IsWrongPassword(err) {
+			t.Fatalf("expected error %v and got %v", errWrongPassword, err)
+		}
+		if repo.customers["user@gmail.com"].Password != "hash" {
+			t.Fatal("expected the password to not change")
+		}
+	})
+
+	t.Run("validates the new password", func(t *testing.T) {
+		service, _ := newService(true)
+		if err := service.ChangePassword(context.Background(), 1, "current", "pw"); err != errPasswordShort {
+			t.Fatalf("expected error %v and got %v", errPasswordShort, err)
+		}
+	})
+
+	t.Run("unknown customer", func(t *testing.T) {
+		service, _ := newService(true)
+		if err := service.ChangePassword(context.Background(), 2, "current", "new password"); !IsNotFound(err) {
+			t.Fatalf("expected error %v and got %v", errcustomerNotFound, err)
+		}
+	})
+}
+
+func TestService_ChangeEmail(t *testing.T) {
+	newService := func(resultCheck bool) (*Service, *MockRepository) {
+		repo := &MockRepository{customers: map[string]*Model{
+			"user@gmail.com":  {Id: 1, Email: "user@gmail.com", EmailVerified: true},
+			"other@gmail.com": {Id: 2, Email: "other@gmail.com"},
+		}}
+		return NewService(repo, &MockSecurity{resultCheck: resultCheck}), repo
+	}
+
+	t.Run("changes the email, which isn't verified", func(t *testing.T) {
+		service, repo := newService(true)
+		if err := service.ChangeEmail(context.Background(), 1, "password", "new@gmail.com"); err != nil {
+			t.Fatalf("expected no error and got %v", err)
+		}
+		c, ok := repo.customers["new@gmail.com"]
+		if !ok || c.Id != 1 || c.EmailVerified {
+			t.Fatalf("expected the customer to have the new unverified email, got %+v", c)
+		}
+	})
+
+	tests := []struct {
+		name        string
+		email       string
+		resultCheck bool
+		expectedErr error
+	}{
+		{name: "wrong password", email: "new@gmail.com", expectedErr: errWrongPassword},
+		{name: "invalid email", email: "invalid_email", resultCheck: true, expectedErr: errEmailInvalid},
+		{name: "same email", email: "user@gmail.com", resultCheck: true, expectedErr: errEmailUnchanged},
+		{name: "email of another customer", email: "other@gmail.com", resultCheck: true, expectedErr: errEmailAlreadyTaken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, repo := newService(tt.resultCheck)
+			if err := service.ChangeEmail(context.Background(), 1, "password", tt.email); err != tt.expectedErr {
+				t.Fatalf("expected error %v and got %v", tt.expectedErr, err)
+			}
+			if c := repo.customers["user@gmail.com"]; c == nil || c.Id != 1 {
+				t.Fatal("expected the email to not change")
+			}
+		})
+	}
+}
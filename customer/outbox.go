@@ -0,0 +1,56 @@
+package customer
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Outbox sends the emails of the customers in the background, once the requests that asked for them were answered.
+// At most maxPending emails are being sent at once, the ones over it are dropped since customers can ask again
+type Outbox struct {
+	// slots has an entry for each email being sent
+	slots chan struct{}
+}
+
+func NewOutbox(maxPending int) *Outbox {
+	return &Outbox{slots: make(chan struct{}, maxPending)}
+}
+
+// Send calls send in the background with a context that outlives the request, what describes the email in the logs
+func (o *Outbox) Send(ctx context.Context, what string, send func(ctx context.Context) error) {
+	select {
+	case o.slots <- struct{}{}:
+	default:
+		slog.Warn("too many emails being sent, dropping one", "email", what)
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() { <-o.slots }()
+		if err := send(ctx); err != nil {
+			slog.Error("error sending "+what+" email", "error", err)
+		}
+	}()
+}
+
+// Wait blocks until the emails being sent are done, or until ctx is done. The emails are done once every slot is
+// free, so the ones sent while waiting are dropped
+func (o *Outbox) Wait(ctx context.Context) error {
+	taken := 0
+	defer func() {
+		for ; taken > 0; taken-- {
+			<-o.slots
+		}
+	}()
+
+	for taken < cap(o.slots) {
+		select {
+		case o.slots <- struct{}{}:
+			taken++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	outbox := NewOutbox(1)
+	release := make(chan struct{})
+	sent := make(chan string, 2)
+
+	outbox.Send(context.Background(), "first", func(ctx context.Context) error {
+		<-release
+		sent <- "first"
+		return nil
+	})
+	// the only slot is taken, so this one is dropped
+	outbox.Send(context.Background(), "second", func(ctx context.Context) error {
+		sent <- "second"
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := outbox.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the wait to give up on the stuck email, got: %v", err)
+	}
+
+	close(release)
+	if err := outbox.Wait(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(sent) != 1 || <-sent != "first" {
+		t.Fatal("Expected only the first email to be sent")
+	}
+
+	// the slot is free again once the email was sent
+	outbox.Send(context.Background(), "third", func(ctx context.Context) error {
+		sent <- "third"
+		return nil
+	})
+	if err := outbox.Wait(context.Background()); err != nil || len(sent) != 1 {
+		t.Fatalf("Expected the third email to be sent, got: %v", err)
+	}
+}
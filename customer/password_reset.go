@@ -0,0 +1,167 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/mailer"
+	"github.com/ap-pauloafonso/bookstore/security"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+var (
+	errInvalidResetToken = errors.New("invalid or expired password reset token")
+)
+
+// PasswordReset is a request to reset the password of a customer, only the hash of its token is stored
+type PasswordReset struct {
+	TokenHash  string
+	CustomerID int64
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+type PasswordResetRepository interface {
+	// SaveReset stores the reset, replacing the resets of the customer that weren't used yet, unless the customer was
+	// sent one after notSentSince. It reports false when it wasn't stored
+	SaveReset(ctx context.Context, reset PasswordReset, notSentSince time.Time) (bool, error)
+	// DeleteReset removes the reset with the token hash unless it was used, so a reset that couldn't be sent doesn't
+	// throttle the next one
+	DeleteReset(ctx context.Context, tokenHash string) error
+	// ResetPassword uses the reset with the token hash, unless it was already used or expired before now, and sets
+	// the password of its customer. It returns the id of the customer, or nil if there was no such reset
+	ResetPassword(ctx context.Context, tokenHash, password string, now time.Time) (*int64, error)
+}
+
+type Mailer interface {
+	Send(ctx context.Context, m mailer.Message) error
+}
+
+// SessionRevoker logs a customer out of every device
+type SessionRevoker interface {
+	LogoutAll(ctx context.Context, customerID int64) error
+}
+
+type PasswordResetService struct {
+	repository     PasswordResetRepository
+	customers      Repository
+	security       SecurityService
+	mailer         Mailer
+	outbox         *Outbox
+	sessions       SessionRevoker
+	ttl            time.Duration
+	resendInterval time.Duration
+	resetURL       string
+}
+
+// NewPasswordResetService creates the password reset service, the emailed links point to resetURL with the token in
+// the query string and can be used for ttl. A customer is sent at most one link every resendInterval, through outbox
+func NewPasswordResetService(repository PasswordResetRepository, customers Repository, securityService SecurityService, m Mailer,
+	outbox *Outbox, sessions SessionRevoker, ttl, resendInterval time.Duration, resetURL string) *PasswordResetService {
+	return &PasswordResetService{
+		repository:     repository,
+		customers:      customers,
+		security:       securityService,
+		mailer:         m,
+		outbox:         outbox,
+		sessions:       sessions,
+		ttl:            ttl,
+		resendInterval: resendInterval,
+		resetURL:       resetURL,
+	}
+}
+
+// IsInvalidResetToken reports whether err means that the reset token is unknown, expired or was already used
+func IsInvalidResetToken(err error) bool {
+	return errors.Is(err, errInvalidResetToken)
+}
+
+// linkWithToken adds the token to the query string of link
+func linkWithToken(link, token string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("invalid link: %w", err)
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Forgot emails a link to reset the password to the customer with the email, at most once every resend interval.
+// The email is looked up and sent in the background, so registered emails can't be told apart by how long the
+// answer takes nor by an error
+func (s *PasswordResetService) Forgot(ctx context.Context, email string) {
+	s.outbox.Send(ctx, "password reset", func(ctx context.Context) error {
+		return s.sendReset(ctx, email)
+	})
+}
+
+// sendReset emails a reset link to the customer with the email, doing nothing if there is no such customer or if
+// the last link was sent less than the resend interval ago. The reset is removed when the email can't be sent
+func (s *PasswordResetService) sendReset(ctx context.Context, email string) error {
+	c, err := s.customers.GetCustomer(ctx, email)
+	if err != nil {
+		if utils.IsStorageRelatedError(err) {
+			return err
+		}
+		return nil
+	}
+
+	token, err := security.RandomToken(32)
+	if err != nil {
+		return err
+	}
+	link, err := linkWithToken(s.resetURL, token)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	reset := PasswordReset{TokenHash: security.HashToken(token), CustomerID: c.Id, ExpiresAt: now.Add(s.ttl), CreatedAt: now}
+	saved, err := s.repository.SaveReset(ctx, reset, now.Add(-s.resendInterval))
+	if err != nil || !saved {
+		return err
+	}
+
+	body := fmt.Sprintf("Someone asked to reset the password of your bookstore account.\n\n"+
+		"Follow this link in the next %s to choose a new one:\n%s\n\n"+
+		"If it wasn't you, ignore this email and your password won't change.\n", s.ttl, link)
+	if err := s.mailer.Send(ctx, mailer.Message{To: c.Email, Subject: "Reset your bookstore password", Body: body}); err != nil {
+		if deleteErr := s.repository.DeleteReset(ctx, reset.TokenHash); deleteErr != nil {
+			slog.Error("error deleting a password reset that wasn't sent", "customer", c.Id, "error", deleteErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Reset sets the password of the customer the token was sent to, the token can't be used again and the customer is
+// logged out of every device
+func (s *PasswordResetService) Reset(ctx context.Context, token, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	if token == "" {
+		return errInvalidResetToken
+	}
+
+	hashedPassword, err := s.security.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	customerID, err := s.repository.ResetPassword(ctx, security.HashToken(token), hashedPassword, time.Now())
+	if err != nil {
+		return err
+	}
+	if customerID == nil {
+		return errInvalidResetToken
+	}
+
+	return s.sessions.LogoutAll(ctx, *customerID)
+}
@@ -0,0 +1,232 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/mailer"
+	"github.com/ap-pauloafonso/bookstore/security"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// MockPasswordResetRepository keeps the resets in memory, mimicking the database behavior
+type MockPasswordResetRepository struct {
+	resets    map[string]*PasswordReset
+	used      map[string]bool
+	passwords map[int64]string
+	Err       error
+}
+
+func newMockPasswordResetRepository() *MockPasswordResetRepository {
+	return &MockPasswordResetRepository{resets: map[string]*PasswordReset{}, used: map[string]bool{}, passwords: map[int64]string{}}
+}
+
+func (m *MockPasswordResetRepository) SaveReset(ctx context.Context, reset PasswordReset, notSentSince time.Time) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	for _, r := range m.resets {
+		if r.CustomerID == reset.CustomerID && r.CreatedAt.After(notSentSince) {
+			return false, nil
+		}
+	}
+	for hash, r := range m.resets {
+		if r.CustomerID == reset.CustomerID && !m.used[hash] {
+			delete(m.resets, hash)
+		}
+	}
+	m.resets[reset.TokenHash] = &reset
+	return true, nil
+}
+
+func (m *MockPasswordResetRepository) DeleteReset(ctx context.Context, tokenHash string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	if !m.used[tokenHash] {
+		delete(m.resets, tokenHash)
+	}
+	return nil
+}
+
+// sentBefore makes the resets of the customer look like they were sent long ago
+func (m *MockPasswordResetRepository) sentBefore(customerID int64, createdAt time.Time) {
+	for _, r := range m.resets {
+		if r.CustomerID == customerID {
+			r.CreatedAt = createdAt
+		}
+	}
+}
+
+func (m *MockPasswordResetRepository) ResetPassword(ctx context.Context, tokenHash, password string, now time.Time) (*int64, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	r, ok := m.resets[tokenHash]
+	if !ok || m.used[tokenHash] || !now.Before(r.ExpiresAt) {
+		return nil, nil
+	}
+	m.used[tokenHash] = true
+	m.passwords[r.CustomerID] = password
+	id := r.CustomerID
+	return &id, nil
+}
+
+// failingMailer fails to send every message
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, m mailer.Message) error {
+	return errors.New("smtp down")
+}
+
+type mockSessions struct {
+	loggedOut []int64
+}
+
+func (m *mockSessions) LogoutAll(ctx context.Context, customerID int64) error {
+	m.loggedOut = append(m.loggedOut, customerID)
+	return nil
+}
+
+func newTestPasswordResetService() (*PasswordResetService, *MockPasswordResetRepository, *mailer.MemorySink, *mockSessions) {
+	repo := newMockPasswordResetRepository()
+	customers := &MockRepository{customers: map[string]*Model{"user@gmail.com": {Id: 1, Email: "user@gmail.com"}}}
+	sink := mailer.NewMemorySink()
+	sessions := &mockSessions{}
+	service := NewPasswordResetService(repo, customers, &MockSecurity{}, sink, NewOutbox(10), sessions, time.Hour, time.Minute, "http://localhost/reset-password?lang=en")
+	return service, repo, sink, sessions
+}
+
+// tokenOf reads the token of the link in the last email sent to the address
+func tokenOf(t *testing.T, sink *mailer.MemorySink, to string) string {
+	t.Helper()
+	m, ok := sink.Last(to)
+	if !ok {
+		t.Fatalf("Expected an email to %s", to)
+	}
+	start := strings.Index(m.Body, "http://")
+	if start < 0 {
+		t.Fatalf("Expected a link in the email: %s", m.Body)
+	}
+	link, err := url.Parse(strings.Fields(m.Body[start:])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Query().Get("lang") != "en" {
+		t.Fatalf("Expected the query string of the reset url to be kept: %s", link)
+	}
+	return link.Query().Get("token")
+}
+
+func TestPasswordResetService_Forgot(t *testing.T) {
+	service, repo, sink, _ := newTestPasswordResetService()
+
+	service.Forgot(context.Background(), "user@gmail.com")
+	service.outbox.Wait(context.Background())
+	token := tokenOf(t, sink, "user@gmail.com")
+	if _, ok := repo.resets[security.HashToken(token)]; !ok || len(repo.resets) != 1 {
+		t.Fatalf("Expected only the hash of the token to be stored, got: %v", repo.resets)
+	}
+
+	// asking again right away is throttled
+	service.Forgot(context.Background(), "user@gmail.com")
+	service.outbox.Wait(context.Background())
+	if len(sink.Messages()) != 1 {
+		t.Fatalf("Expected only one email, got: %+v", sink.Messages())
+	}
+
+	// and replaces the previous token once the interval passed
+	repo.sentBefore(1, time.Now().Add(-2*time.Minute))
+	service.Forgot(context.Background(), "user@gmail.com")
+	service.outbox.Wait(context.Background())
+	if len(sink.Messages()) != 2 || len(repo.resets) != 1 {
+		t.Fatalf("Expected the previous token to be replaced, got: %v", repo.resets)
+	}
+
+	// unknown emails get no email
+	service.Forgot(context.Background(), "unknown@gmail.com")
+	service.outbox.Wait(context.Background())
+	if len(sink.Messages()) != 2 {
+		t.Fatalf("Expected no email for an unknown address, got: %+v", sink.Messages())
+	}
+
+	// neither do registered ones when the reset can't be stored
+	repo.sentBefore(1, time.Now().Add(-2*time.Minute))
+	repo.Err = errors.New("db down")
+	service.Forgot(context.Background(), "user@gmail.com")
+	service.outbox.Wait(context.Background())
+	if len(sink.Messages()) != 2 {
+		t.Fatalf("Expected no email without a stored reset, got: %+v", sink.Messages())
+	}
+}
+
+func TestPasswordResetService_Forgot_Failed(t *testing.T) {
+	service, repo, sink, _ := newTestPasswordResetService()
+
+	// a reset that couldn't be sent is removed, so it doesn't throttle the next one
+	service.mailer = failingMailer{}
+	service.Forgot(context.Background(), "user@gmail.com")
+	service.outbox.Wait(context.Background())
+	if len(repo.resets) != 0 {
+		t.Fatalf("Expected the unsent reset to be removed, got: %v", repo.resets)
+	}
+
+	service.mailer = sink
+	service.Forgot(context.Background(), "user@gmail.com")
+	service.outbox.Wait(context.Background())
+	if _, ok := repo.resets[security.HashToken(tokenOf(t, sink, "user@gmail.com"))]; !ok {
+		t.Fatalf("Expected the reset to be sent right away, got: %v", repo.resets)
+	}
+}
+
+func TestPasswordResetService_Reset(t *testing.T) {
+	service, repo, sink, sessions := newTestPasswordResetService()
+	service.Forgot(context.Background(), "user@gmail.com")
+	service.outbox.Wait(context.Background())
+	token := tokenOf(t, sink, "user@gmail.com")
+
+	if err := service.Reset(context.Background(), token, "pw"); !errors.Is(err, errPasswordShort) {
+		t.Fatalf("Expected error: %v, got: %v", errPasswordShort, err)
+	}
+	if err := service.Reset(context.Background(), "", "new password"); !IsInvalidResetToken(err) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidResetToken, err)
+	}
+	if err := service.Reset(context.Background(), "unknown", "new password"); !IsInvalidResetToken(err) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidResetToken, err)
+	}
+
+	if err := service.Reset(context.Background(), token, "new password"); err != nil {
+		t.Fatalf("Expected the password to be reset, got: %v", err)
+	}
+	if _, ok := repo.passwords[1]; !ok {
+		t.Fatal("Expected the password of the customer to be changed")
+	}
+	if len(sessions.loggedOut) != 1 || sessions.loggedOut[0] != 1 {
+		t.Fatalf("Expected the customer to be logged out of every device, got: %v", sessions.loggedOut)
+	}
+
+	// the token can only be used once
+	if err := service.Reset(context.Background(), token, "other password"); !IsInvalidResetToken(err) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidResetToken, err)
+	}
+}
+
+func TestPasswordResetService_Reset_Expired(t *testing.T) {
+	service, repo, sink, _ := newTestPasswordResetService()
+	service.Forgot(context.Background(), "user@gmail.com")
+	service.outbox.Wait(context.Background())
+	token := tokenOf(t, sink, "user@gmail.com")
+	repo.resets[security.HashToken(token)].ExpiresAt = time.Now().Add(-time.Second)
+
+	if err := service.Reset(context.Background(), token, "new password"); !IsInvalidResetToken(err) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidResetToken, err)
+	}
+
+	errHash := errors.New("error hashing")
+	service.security = &MockSecurity{errorHash: errHash}
+	if err := service.Reset(context.Background(), token, "new password"); !errors.Is(err, errHash) {
+		t.Fatalf("Expected error: %v, got: %v", errHash, err)
+	}
+}
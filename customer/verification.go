@@ -0,0 +1,173 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/mailer"
+	"log/slog"
+	"time"
+)
+
+// verifyEmailPurpose is the purpose of the tokens of the verification links, so no other emailed token verifies an email
+const verifyEmailPurpose = "verify_email"
+
+var (
+	errInvalidVerificationToken = errors.New("invalid or expired email verification token")
+	errEmailAlreadyVerified     = errors.New("email already verified")
+	errVerificationThrottled    = errors.New("a verification email was sent recently, try again later")
+	errEmailNotVerified         = errors.New("the email of the account needs to be verified first")
+)
+
+type VerificationRepository interface {
+	// MarkVerificationSent records that a verification email is being sent to the customer at sentAt, unless the
+	// last one was sent after notSentSince. It reports false when it wasn't recorded
+	MarkVerificationSent(ctx context.Context, customerID int64, sentAt, notSentSince time.Time) (bool, error)
+	// UnmarkVerificationSent forgets the verification email marked as sent at sentAt, which couldn't be sent, unless
+	// another one was marked since
+	UnmarkVerificationSent(ctx context.Context, customerID int64, sentAt time.Time) error
+	// VerifyEmail marks the email of the customer as verified if it's still email, reporting false when it isn't
+	VerifyEmail(ctx context.Context, customerID int64, email string) (bool, error)
+}
+
+// EmailTokens signs the tokens of the emailed links
+type EmailTokens interface {
+	GenerateEmailToken(purpose string, customerID int64, email string, expiresAt time.Time) (string, error)
+	ParseEmailToken(purpose, token string) (int64, string, error)
+}
+
+type VerificationService struct {
+	repository     VerificationRepository
+	customers      Repository
+	tokens         EmailTokens
+	mailer         Mailer
+	outbox         *Outbox
+	ttl            time.Duration
+	resendInterval time.Duration
+	verifyURL      string
+	required       bool
+}
+
+// NewVerificationService creates the email verification service, the emailed links point to verifyURL with the
+// token in the query string and can be used for ttl. Only one email is sent every resendInterval, and customers can
+// only order once their email is verified if required is set. The emails that don't need an answer go through outbox
+func NewVerificationService(repository VerificationRepository, customers Repository, tokens EmailTokens, m Mailer, outbox *Outbox,
+	ttl, resendInterval time.Duration, verifyURL string, required bool) *VerificationService {
+	return &VerificationService{repository, customers, tokens, m, outbox, ttl, resendInterval, verifyURL, required}
+}
+
+// IsInvalidVerificationToken reports whether err means that the verification token is invalid, expired, or was sent
+// to an email the customer doesn't have anymore
+func IsInvalidVerificationToken(err error) bool {
+	return errors.Is(err, errInvalidVerificationToken)
+}
+
+// IsEmailAlreadyVerified reports whether err means that there is nothing to verify
+func IsEmailAlreadyVerified(err error) bool {
+	return errors.Is(err, errEmailAlreadyVerified)
+}
+
+// IsVerificationThrottled reports whether err means that the last verification email was sent too recently
+func IsVerificationThrottled(err error) bool {
+	return errors.Is(err, errVerificationThrottled)
+}
+
+// IsEmailNotVerified reports whether err means that the customer needs to verify their email first
+func IsEmailNotVerified(err error) bool {
+	return errors.Is(err, errEmailNotVerified)
+}
+
+// SendVerification emails a link to verify the email to the customer, at most once every resend interval. An email
+// that couldn't be sent doesn't count, so the customer can ask again right away
+func (s *VerificationService) SendVerification(ctx context.Context, customerID int64) error {
+	c, err := s.customers.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return errcustomerNotFound
+	}
+	if c.EmailVerified {
+		return errEmailAlreadyVerified
+	}
+
+	now := time.Now()
+	token, err := s.tokens.GenerateEmailToken(verifyEmailPurpose, c.Id, c.Email, now.Add(s.ttl))
+	if err != nil {
+		return err
+	}
+	link, err := linkWithToken(s.verifyURL, token)
+	if err != nil {
+		return err
+	}
+
+	marked, err := s.repository.MarkVerificationSent(ctx, c.Id, now, now.Add(-s.resendInterval))
+	if err != nil {
+		return err
+	}
+	if !marked {
+		return errVerificationThrottled
+	}
+
+	body := fmt.Sprintf("Welcome to the bookstore!\n\n"+
+		"Follow this link in the next %s to verify your email:\n%s\n\n"+
+		"If you didn't create an account, ignore this email.\n", s.ttl, link)
+	if err := s.mailer.Send(ctx, mailer.Message{To: c.Email, Subject: "Verify your bookstore email", Body: body}); err != nil {
+		if unmarkErr := s.repository.UnmarkVerificationSent(ctx, c.Id, now); unmarkErr != nil {
+			slog.Error("error unmarking a verification email that wasn't sent", "customer", c.Id, "error", unmarkErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// SendVerificationLater does what SendVerification does in the background, for the requests that don't wait for the
+// email, the errors are only logged
+func (s *VerificationService) SendVerificationLater(ctx context.Context, customerID int64) {
+	s.outbox.Send(ctx, "verification", func(ctx context.Context) error {
+		return s.SendVerification(ctx, customerID)
+	})
+}
+
+// Verify marks the email the token was sent to as verified, as long as the customer still has that email
+func (s *VerificationService) Verify(ctx context.Context, token string) error {
+	if token == "" {
+		return errInvalidVerificationToken
+	}
+
+	customerID, email, err := s.tokens.ParseEmailToken(verifyEmailPurpose, token)
+	if err != nil {
+		return errInvalidVerificationToken
+	}
+
+	verified, err := s.repository.VerifyEmail(ctx, customerID, email)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return errInvalidVerificationToken
+	}
+
+	return nil
+}
+
+// CheckCanOrder returns an error when the verification is required and the customer didn't verify their email yet
+func (s *VerificationService) CheckCanOrder(ctx context.Context, customerID int64) error {
+	if !s.required {
+		return nil
+	}
+
+	c, err := s.customers.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return errcustomerNotFound
+	}
+	if !c.EmailVerified {
+		return errEmailNotVerified
+	}
+
+	return nil
+}
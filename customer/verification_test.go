@@ -0,0 +1,181 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/mailer"
+	"github.com/ap-pauloafonso/bookstore/security"
+	"testing"
+	"time"
+)
+
+// MockVerificationRepository keeps the verification state in the customers of MockRepository
+type MockVerificationRepository struct {
+	customers *MockRepository
+	sentAt    map[int64]time.Time
+	Err       error
+}
+
+func (m *MockVerificationRepository) MarkVerificationSent(ctx context.Context, customerID int64, sentAt, notSentSince time.Time) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	if last, ok := m.sentAt[customerID]; ok && last.After(notSentSince) {
+		return false, nil
+	}
+	m.sentAt[customerID] = sentAt
+	return true, nil
+}
+
+func (m *MockVerificationRepository) UnmarkVerificationSent(ctx context.Context, customerID int64, sentAt time.Time) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	if last, ok := m.sentAt[customerID]; ok && last.Equal(sentAt) {
+		delete(m.sentAt, customerID)
+	}
+	return nil
+}
+
+func (m *MockVerificationRepository) VerifyEmail(ctx context.Context, customerID int64, email string) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	c, ok := m.customers.customers[email]
+	if !ok || c.Id != customerID {
+		return false, nil
+	}
+	c.EmailVerified = true
+	return true, nil
+}
+
+func newTestVerificationService(t *testing.T, required bool) (*VerificationService, *MockVerificationRepository, *mailer.MemorySink) {
+	key, err := security.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := security.NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customers := &MockRepository{customers: map[string]*Model{
+		"user@gmail.com":     {Id: 1, Email: "user@gmail.com"},
+		"verified@gmail.com": {Id: 2, Email: "verified@gmail.com", EmailVerified: true},
+	}}
+	repo := &MockVerificationRepository{customers: customers, sentAt: map[int64]time.Time{}}
+	sink := mailer.NewMemorySink()
+	service := NewVerificationService(repo, customers, keys, sink, NewOutbox(10), time.Hour, time.Minute, "http://localhost/verify-email?lang=en", required)
+	return service, repo, sink
+}
+
+func TestVerificationService_SendVerification(t *testing.T) {
+	service, repo, sink := newTestVerificationService(t, false)
+
+	if err := service.SendVerification(context.Background(), 1); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if tokenOf(t, sink, "user@gmail.com") == "" {
+		t.Fatal("Expected a token in the verification link")
+	}
+
+	// resending right away is throttled
+	if err := service.SendVerification(context.Background(), 1); !IsVerificationThrottled(err) {
+		t.Fatalf("Expected error: %v, got: %v", errVerificationThrottled, err)
+	}
+	if len(sink.Messages()) != 1 {
+		t.Fatalf("Expected only one email, got: %+v", sink.Messages())
+	}
+
+	// and works again once the interval passed
+	repo.sentAt[1] = time.Now().Add(-2 * time.Minute)
+	if err := service.SendVerification(context.Background(), 1); err != nil {
+		t.Fatalf("Expected the email to be resent, got: %v", err)
+	}
+
+	if err := service.SendVerification(context.Background(), 2); !IsEmailAlreadyVerified(err) {
+		t.Fatalf("Expected error: %v, got: %v", errEmailAlreadyVerified, err)
+	}
+	if err := service.SendVerification(context.Background(), 3); !IsNotFound(err) {
+		t.Fatalf("Expected error: %v, got: %v", errcustomerNotFound, err)
+	}
+}
+
+func TestVerificationService_SendVerification_Failed(t *testing.T) {
+	service, repo, sink := newTestVerificationService(t, false)
+
+	// an email that couldn't be sent doesn't throttle the next one
+	service.mailer = failingMailer{}
+	if err := service.SendVerification(context.Background(), 1); err == nil {
+		t.Fatal("Expected the mailer error")
+	}
+	if _, ok := repo.sentAt[1]; ok {
+		t.Fatal("Expected the failed email not to be marked as sent")
+	}
+
+	service.mailer = sink
+	if err := service.SendVerification(context.Background(), 1); err != nil {
+		t.Fatalf("Expected the email to be resent, got: %v", err)
+	}
+}
+
+func TestVerificationService_SendVerificationLater(t *testing.T) {
+	service, _, sink := newTestVerificationService(t, false)
+
+	service.SendVerificationLater(context.Background(), 1)
+	service.outbox.Wait(context.Background())
+	if tokenOf(t, sink, "user@gmail.com") == "" {
+		t.Fatal("Expected a token in the verification link")
+	}
+}
+
+func TestVerificationService_Verify(t *testing.T) {
+	service, repo, sink := newTestVerificationService(t, false)
+	if err := service.SendVerification(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	token := tokenOf(t, sink, "user@gmail.com")
+
+	if err := service.Verify(context.Background(), ""); !IsInvalidVerificationToken(err) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidVerificationToken, err)
+	}
+	if err := service.Verify(context.Background(), "not a token"); !IsInvalidVerificationToken(err) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidVerificationToken, err)
+	}
+
+	if err := service.Verify(context.Background(), token); err != nil {
+		t.Fatalf("Expected the email to be verified, got: %v", err)
+	}
+	if !repo.customers.customers["user@gmail.com"].EmailVerified {
+		t.Fatal("Expected the email of the customer to be verified")
+	}
+
+	// a link sent to an email the customer doesn't have anymore doesn't verify the new one
+	c := repo.customers.customers["user@gmail.com"]
+	delete(repo.customers.customers, "user@gmail.com")
+	c.Email, c.EmailVerified = "new@gmail.com", false
+	repo.customers.customers["new@gmail.com"] = c
+	if err := service.Verify(context.Background(), token); !IsInvalidVerificationToken(err) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidVerificationToken, err)
+	}
+
+	repo.Err = errors.New("db down")
+	if err := service.Verify(context.Background(), token); !errors.Is(err, repo.Err) {
+		t.Fatalf("Expected error: %v, got: %v", repo.Err, err)
+	}
+}
+
+func TestVerificationService_CheckCanOrder(t *testing.T) {
+	optional, _, _ := newTestVerificationService(t, false)
+	if err := optional.CheckCanOrder(context.Background(), 1); err != nil {
+		t.Fatalf("Expected unverified customers to order when the verification isn't required, got: %v", err)
+	}
+
+	required, _, _ := newTestVerificationService(t, true)
+	if err := required.CheckCanOrder(context.Background(), 1); !IsEmailNotVerified(err) {
+		t.Fatalf("Expected error: %v, got: %v", errEmailNotVerified, err)
+	}
+	if err := required.CheckCanOrder(context.Background(), 2); err != nil {
+		t.Fatalf("Expected verified customers to order, got: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	"unicode"
+)
+
+var (
+	errInvalidKey  = errors.New("invalid idempotency key: needs to have between 1 and 255 printable characters")
+	errKeyMismatch = errors.New("idempotency key already used with a different request")
+	errInProgress  = errors.New("a request with this idempotency key is still in progress")
+)
+
+const maxKeyLength = 255
+
+// Record is a request made with an idempotency key, Response is nil while the request is still being processed.
+// Keys are scoped to the customer and to the Route they were sent to, so different endpoints never share them
+type Record struct {
+	Key         string
+	CustomerID  int64
+	Route       string
+	RequestHash string
+	StatusCode  int
+	Response    []byte
+	CreatedAt   time.Time
+}
+
+type Repository interface {
+	// ClaimKey stores the record unless the customer already used the key on the same route after expiredBefore,
+	// reporting whether it was stored
+	ClaimKey(ctx context.Context, record Record, expiredBefore time.Time) (bool, error)
+	// GetKey returns nil if the customer never used the key on the route
+	GetKey(ctx context.Context, customerID int64, route, key string) (*Record, error)
+	// CompleteKey stores the response of the request made with the key
+	CompleteKey(ctx context.Context, customerID int64, route, key string, statusCode int, response []byte) error
+	// ReleaseKey forgets the key so the request can be made again
+	ReleaseKey(ctx context.Context, customerID int64, route, key string) error
+	// DeleteExpiredKeys removes the keys used before expiredBefore, returning how many were removed
+	DeleteExpiredKeys(ctx context.Context, expiredBefore time.Time) (int64, error)
+}
+
+type Service struct {
+	r   Repository
+	ttl time.Duration
+}
+
+// NewService creates the idempotency service, keys can be reused for a different request once ttl is over
+func NewService(r Repository, ttl time.Duration) *Service {
+	return &Service{r, ttl}
+}
+
+// IsKeyMismatch reports whether err means that the key was already used with a different request
+func IsKeyMismatch(err error) bool {
+	return errors.Is(err, errKeyMismatch)
+}
+
+// IsInProgress reports whether err means that the first request made with the key didn't finish yet
+func IsInProgress(err error) bool {
+	return errors.Is(err, errInProgress)
+}
+
+func validKey(key string) bool {
+	if key == "" || len(key) > maxKeyLength {
+		return false
+	}
+	for _, r := range key {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// hashRequest fingerprints the decoded request, so the same request sent with a different formatting still matches
+func hashRequest(request any) (string, error) {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("error encoding request: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Begin claims the key for the request. It returns nil when the request is new and has to be processed,
+// followed by a call to Complete or Release, or the record of the first request so its response can be replayed
+func (s *Service) Begin(ctx context.Context, customerID int64, route, key string, request any) (*Record, error) {
+	if !validKey(key) {
+		return nil, errInvalidKey
+	}
+
+	hash, err := hashRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claimed, err := s.r.ClaimKey(ctx, Record{Key: key, CustomerID: customerID, Route: route, RequestHash: hash, CreatedAt: now}, now.Add(-s.ttl))
+	if err != nil {
+		return nil, err
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	record, err := s.r.GetKey(ctx, customerID, route, key)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		// the first request was released in the meantime
+		return nil, errInProgress
+	}
+	if record.RequestHash != hash {
+		return nil, errKeyMismatch
+	}
+	if record.Response == nil {
+		return nil, errInProgress
+	}
+
+	return record, nil
+}
+
+// Complete stores the encoded response of a request so it can be replayed exactly as it was sent
+func (s *Service) Complete(ctx context.Context, customerID int64, route, key string, statusCode int, body []byte) error {
+	return s.r.CompleteKey(ctx, customerID, route, key, statusCode, body)
+}
+
+// Release forgets a key whose request failed, so it can be retried with the same key
+func (s *Service) Release(ctx context.Context, customerID int64, route, key string) error {
+	return s.r.ReleaseKey(ctx, customerID, route, key)
+}
+
+// PurgeExpired removes the keys whose ttl is over
+func (s *Service) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.r.DeleteExpiredKeys(ctx, time.Now().Add(-s.ttl))
+}
+
+// PurgePeriodically calls PurgeExpired every interval until ctx is done
+func (s *Service) PurgePeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.PurgeExpired(ctx)
+			if err != nil {
+				slog.Error("error purging expired idempotency keys", "error", err)
+				continue
+			}
+			if n > 0 {
+				slog.Info("purged expired idempotency keys", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockKey struct {
+	customerID int64
+	route      string
+	key        string
+}
+
+// MockRepository keeps the records in memory, mimicking the database behavior
+type MockRepository struct {
+	Records map[mockKey]*Record
+	Err     error
+}
+
+func newMockRepository() *MockRepository {
+	return &MockRepository{Records: map[mockKey]*Record{}}
+}
+
+func (m *MockRepository) ClaimKey(ctx context.Context, record Record, expiredBefore time.Time) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	k := mockKey{record.CustomerID, record.Route, record.Key}
+	if existing, ok := m.Records[k]; ok && !existing.CreatedAt.Before(expiredBefore) {
+		return false, nil
+	}
+	m.Records[k] = &record
+	return true, nil
+}
+
+func (m *MockRepository) GetKey(ctx context.Context, customerID int64, route, key string) (*Record, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	r, ok := m.Records[mockKey{customerID, route, key}]
+	if !ok {
+		return nil, nil
+	}
+	c := *r
+	return &c, nil
+}
+
+func (m *MockRepository) CompleteKey(ctx context.Context, customerID int64, route, key string, statusCode int, response []byte) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	if r, ok := m.Records[mockKey{customerID, route, key}]; ok {
+		r.StatusCode = statusCode
+		r.Response = response
+	}
+	return nil
+}
+
+func (m *MockRepository) ReleaseKey(ctx context.Context, customerID int64, route, key string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	delete(m.Records, mockKey{customerID, route, key})
+	return nil
+}
+
+func (m *MockRepository) DeleteExpiredKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	var n int64
+	for k, r := range m.Records {
+		if r.CreatedAt.Before(expiredBefore) {
+			delete(m.Records, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+const (
+	ordersRoute   = "POST /api/orders"
+	checkoutRoute = "POST /api/cart/checkout"
+)
+
+type request struct {
+	BookID   int64 `json:"book_id"`
+	Quantity int   `json:"quantity"`
+}
+
+func TestService_Begin(t *testing.T) {
+	repoErr := errors.New("repo err")
+	first := []request{{BookID: 1, Quantity: 2}}
+
+	tests := []struct {
+		name          string
+		customerID    int64
+		route         string
+		key           string
+		request       any
+		setup         func(s *Service, m *MockRepository)
+		expectReplay  bool
+		expectedError error
+	}{
+		{
+			name:       "NewKey",
+			customerID: 1,
+			key:        "key-1",
+			request:    first,
+		},
+		{
+			name:       "ReplayCompletedRequest",
+			customerID: 1,
+			key:        "key-1",
+			request:    []request{{BookID: 1, Quantity: 2}},
+			setup: func(s *Service, m *MockRepository) {
+				s.Begin(context.Background(), 1, ordersRoute, "key-1", first)
+				s.Complete(context.Background(), 1, ordersRoute, "key-1", 200, []byte(`{"id":7}`))
+			},
+			expectReplay: true,
+		},
+		{
+			name:       "DifferentRequestSameKey",
+			customerID: 1,
+			key:        "key-1",
+			request:    []request{{BookID: 1, Quantity: 3}},
+			setup: func(s *Service, m *MockRepository) {
+				s.Begin(context.Background(), 1, ordersRoute, "key-1", first)
+				s.Complete(context.Background(), 1, ordersRoute, "key-1", 200, []byte(`{"id":7}`))
+			},
+			expectedError: errKeyMismatch,
+		},
+		{
+			name:       "StillInProgress",
+			customerID: 1,
+			key:        "key-1",
+			request:    first,
+			setup: func(s *Service, m *MockRepository) {
+				s.Begin(context.Background(), 1, ordersRoute, "key-1", first)
+			},
+			expectedError: errInProgress,
+		},
+		{
+			name:       "SameKeyAnotherCustomer",
+			customerID: 2,
+			key:        "key-1",
+			request:    []request{{BookID: 5, Quantity: 1}},
+			setup: func(s *Service, m *MockRepository) {
+				s.Begin(context.Background(), 1, ordersRoute, "key-1", first)
+				s.Complete(context.Background(), 1, ordersRoute, "key-1", 200, []byte(`{"id":7}`))
+			},
+		},
+		{
+			name:       "SameKeyAnotherRoute",
+			customerID: 1,
+			route:      checkoutRoute,
+			key:        "key-1",
+			request:    []request{{BookID: 5, Quantity: 1}},
+			setup: func(s *Service, m *MockRepository) {
+				s.Begin(context.Background(), 1, ordersRoute, "key-1", first)
+				s.Complete(context.Background(), 1, ordersRoute, "key-1", 200, []byte(`{"id":7}`))
+			},
+		},
+		{
+			name:       "ReleasedKeyCanBeRetried",
+			customerID: 1,
+			key:        "key-1",
+			request:    first,
+			setup: func(s *Service, m *MockRepository) {
+				s.Begin(context.Background(), 1, ordersRoute, "key-1", first)
+				s.Release(context.Background(), 1, ordersRoute, "key-1")
+			},
+		},
+		{
+			name:       "ExpiredKeyIsNew",
+			customerID: 1,
+			key:        "key-1",
+			request:    []request{{BookID: 1, Quantity: 3}},
+			setup: func(s *Service, m *MockRepository) {
+				m.Records[mockKey{1, ordersRoute, "key-1"}] = &Record{Key: "key-1", CustomerID: 1, Route: ordersRoute, RequestHash: "old", StatusCode: 200,
+					Response: []byte(`{"id":7}`), CreatedAt: time.Now().Add(-2 * time.Hour)}
+			},
+		},
+		{
+			name:          "EmptyKey",
+			customerID:    1,
+			key:           "",
+			request:       first,
+			expectedError: errInvalidKey,
+		},
+		{
+			name:          "KeyTooLong",
+			customerID:    1,
+			key:           strings.Repeat("a", maxKeyLength+1),
+			request:       first,
+			expectedError: errInvalidKey,
+		},
+		{
+			name:          "KeyWithControlCharacters",
+			customerID:    1,
+			key:           "key\n1",
+			request:       first,
+			expectedError: errInvalidKey,
+		},
+		{
+			name:       "RepositoryError",
+			customerID: 1,
+			key:        "key-1",
+			request:    first,
+			setup: func(s *Service, m *MockRepository) {
+				m.Err = repoErr
+			},
+			expectedError: repoErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockRepository()
+			s := NewService(m, time.Hour)
+			if tt.setup != nil {
+				tt.setup(s, m)
+			}
+
+			route := tt.route
+			if route == "" {
+				route = ordersRoute
+			}
+			record, err := s.Begin(context.Background(), tt.customerID, route, tt.key, tt.request)
+
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+				}
+				if IsKeyMismatch(err) != (tt.expectedError == errKeyMismatch) {
+					t.Errorf("IsKeyMismatch mismatch for error: %v", err)
+				}
+				if IsInProgress(err) != (tt.expectedError == errInProgress) {
+					t.Errorf("IsInProgress mismatch for error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			if !tt.expectReplay {
+				if record != nil {
+					t.Fatalf("Expected the request to be processed, got a replay of: %+v", record)
+				}
+				return
+			}
+			if record == nil || record.StatusCode != 200 || string(record.Response) != `{"id":7}` {
+				t.Fatalf("Expected the original response to be replayed, got: %+v", record)
+			}
+		})
+	}
+}
+
+func TestService_PurgeExpired(t *testing.T) {
+	m := newMockRepository()
+	m.Records[mockKey{1, ordersRoute, "old"}] = &Record{Key: "old", CustomerID: 1, CreatedAt: time.Now().Add(-25 * time.Hour)}
+	m.Records[mockKey{1, ordersRoute, "new"}] = &Record{Key: "new", CustomerID: 1, CreatedAt: time.Now().Add(-time.Hour)}
+	s := NewService(m, 24*time.Hour)
+
+	n, err := s.PurgeExpired(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if n != 1 || len(m.Records) != 1 || m.Records[mockKey{1, ordersRoute, "new"}] == nil {
+		t.Fatalf("Expected only the expired key to be removed, removed %d, left: %+v", n, m.Records)
+	}
+}
@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessage_encode(t *testing.T) {
+	m := Message{To: "a@a.com", Subject: "Reset your password", Body: "line 1\nline 2"}
+	data, err := m.encode("store@bookstore.com", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "From: store@bookstore.com\r\nTo: a@a.com\r\nSubject: Reset your password\r\nDate: Tue, 02 Jan 2024 03:04:05 +0000\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nline 1\r\nline 2"
+	if string(data) != expected {
+		t.Fatalf("Expected:\n%q\ngot:\n%q", expected, string(data))
+	}
+
+	for _, m := range []Message{{To: "a@a.com\r\nBcc: b@b.com"}, {To: "a@a.com", Subject: "hi\nBcc: b@b.com"}} {
+		if _, err := m.encode("store@bookstore.com", time.Now()); !errors.Is(err, errInvalidHeader) {
+			t.Fatalf("Expected error: %v, got: %v", errInvalidHeader, err)
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir() + "/mail"
+	sink, err := NewFileSink(dir, "store@bookstore.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := sink.Send(context.Background(), Message{To: "a@a.com", Subject: "Hi", Body: "hello"}); err != nil {
+			t.Fatalf("Expected the message to be written, got: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected a file for each message, got: %v, %v", entries, err)
+	}
+	data, err := os.ReadFile(dir + "/" + entries[0].Name())
+	if err != nil || !strings.Contains(string(data), "To: a@a.com") || !strings.HasSuffix(string(data), "hello") {
+		t.Fatalf("Unexpected message: %q, %v", data, err)
+	}
+}
+
+func TestMemorySink(t *testing.T) {
+	sink := NewMemorySink()
+	_ = sink.Send(context.Background(), Message{To: "a@a.com", Body: "first"})
+	_ = sink.Send(context.Background(), Message{To: "b@b.com", Body: "other"})
+	_ = sink.Send(context.Background(), Message{To: "a@a.com", Body: "second"})
+
+	if len(sink.Messages()) != 3 {
+		t.Fatalf("Expected 3 messages, got: %+v", sink.Messages())
+	}
+	if m, ok := sink.Last("a@a.com"); !ok || m.Body != "second" {
+		t.Fatalf("Expected the last message of a@a.com, got: %+v", m)
+	}
+	if _, ok := sink.Last("c@c.com"); ok {
+		t.Fatal("Expected no message for c@c.com")
+	}
+	if err := sink.Send(context.Background(), Message{To: "a@a.com\nBcc: c@c.com"}); !errors.Is(err, errInvalidHeader) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidHeader, err)
+	}
+}
+
+func TestSMTP_Timeout(t *testing.T) {
+	// a server that accepts the connection and never greets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	s := NewSMTP("127.0.0.1", addr.Port, "", "", "store@bookstore.com", 50*time.Millisecond)
+
+	start := time.Now()
+	if err := s.Send(context.Background(), Message{To: "a@a.com", Subject: "Hi", Body: "hello"}); err == nil {
+		t.Fatal("Expected the send to time out")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	s.timeout = time.Minute
+	if err := s.Send(ctx, Message{To: "a@a.com", Subject: "Hi", Body: "hello"}); err == nil {
+		t.Fatal("Expected the send to stop with its context")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the sends to give up quickly, they took: %s", elapsed)
+	}
+}
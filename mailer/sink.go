@@ -0,0 +1,84 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileSink writes every message to its own .eml file in a directory instead of sending it, for local development
+type FileSink struct {
+	mu   sync.Mutex
+	dir  string
+	from string
+	seq  int64
+}
+
+// NewFileSink creates the directory if it doesn't exist yet
+func NewFileSink(dir, from string) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating the mail directory: %w", err)
+	}
+	return &FileSink{dir: dir, from: from}, nil
+}
+
+func (f *FileSink) Send(ctx context.Context, m Message) error {
+	now := time.Now()
+	data, err := m.encode(f.from, now)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+
+	path := filepath.Join(f.dir, fmt.Sprintf("%s-%d.eml", now.UTC().Format("20060102T150405"), f.seq))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("error writing email: %w", err)
+	}
+	return nil
+}
+
+// MemorySink keeps the messages in memory, so tests can read what would have been sent
+type MemorySink struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (m *MemorySink) Send(ctx context.Context, msg Message) error {
+	if _, err := msg.encode("", time.Now()); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first
+func (m *MemorySink) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Last returns the last message sent to the address, or false if none was
+func (m *MemorySink) Last(to string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+	return Message{}, false
+}
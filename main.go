@@ -4,12 +4,21 @@ import (
 	"context"
 	"fmt"
 	"github.com/ap-pauloafonso/bookstore/book"
+	"github.com/ap-pauloafonso/bookstore/cart"
 	"github.com/ap-pauloafonso/bookstore/config"
 	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/idempotency"
+	"github.com/ap-pauloafonso/bookstore/mailer"
 	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/payment"
+	"github.com/ap-pauloafonso/bookstore/promotion"
+	"github.com/ap-pauloafonso/bookstore/returns"
 	"github.com/ap-pauloafonso/bookstore/security"
 	"github.com/ap-pauloafonso/bookstore/server"
+	"github.com/ap-pauloafonso/bookstore/session"
+	"github.com/ap-pauloafonso/bookstore/shipping"
 	"github.com/ap-pauloafonso/bookstore/storage"
+	"github.com/ap-pauloafonso/bookstore/tax"
 	"github.com/ap-pauloafonso/bookstore/utils"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/joho/godotenv"
@@ -21,6 +30,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -63,17 +73,91 @@ func main() {
 	customerRepository := storage.NewCustomerRepository(db)
 	bookRepository := storage.NewBookRepository(db)
 	orderRepository := storage.NewOrderRepository(db)
+	idempotencyRepository := storage.NewIdempotencyRepository(db)
+	cartRepository := storage.NewCartRepository(db)
+	paymentRepository := storage.NewPaymentRepository(db)
+	returnRepository := storage.NewReturnRepository(db)
+	promotionRepository := storage.NewPromotionRepository(db)
+	addressRepository := storage.NewAddressRepository(db)
+	sessionRepository := storage.NewSessionRepository(db)
+	passwordResetRepository := storage.NewPasswordResetRepository(db)
+	verificationRepository := storage.NewVerificationRepository(db)
+
+	// pick the payment gateway
+	var paymentProvider payment.Provider
+	switch cfg.PaymentProvider {
+	case "fake":
+		paymentProvider = payment.NewFakeProvider()
+	default:
+		utils.LogErrorFatal(fmt.Errorf("unknown payment provider: %s", cfg.PaymentProvider))
+	}
+
+	// pick where the emails go
+	var mail mailer.Mailer
+	switch cfg.Mailer {
+	case "smtp":
+		mail = mailer.NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom, cfg.SMTPTimeout)
+	case "file":
+		if mail, err = mailer.NewFileSink(cfg.MailDir, cfg.MailFrom); err != nil {
+			utils.LogErrorFatal(err)
+		}
+	default:
+		utils.LogErrorFatal(fmt.Errorf("unknown mailer: %s", cfg.Mailer))
+	}
+
+	// load the tax rates, the ones shipped with the store are used unless a file is given
+	taxCalculator := tax.DefaultTable()
+	if cfg.TaxRatesFile != "" {
+		if taxCalculator, err = tax.LoadTable(cfg.TaxRatesFile); err != nil {
+			utils.LogErrorFatal(err)
+		}
+	}
+
+	// load the shipping rates the same way
+	shippingCalculator := shipping.DefaultTable()
+	if cfg.ShippingRatesFile != "" {
+		if shippingCalculator, err = shipping.LoadTable(cfg.ShippingRatesFile); err != nil {
+			utils.LogErrorFatal(err)
+		}
+	}
 
 	// create security service
 	securityService := &security.Service{}
 
+	// load the keys the tokens are signed and verified with
+	keys, err := security.LoadKeySet(cfg.JwtSigningKeyFile, cfg.JwtSecret, cfg.JwtVerificationKeyFiles)
+	if err != nil {
+		utils.LogErrorFatal(err)
+	}
+
+	// the emails that don't have to be sent before answering go out in the background
+	outbox := customer.NewOutbox(cfg.MailMaxPending)
+
 	// create service instances
 	customerService := customer.NewService(customerRepository, securityService)
 	bookService := book.NewService(bookRepository)
-	orderService := order.NewService(orderRepository, bookService)
+	paymentService := payment.NewService(paymentProvider, paymentRepository)
+	promotionService := promotion.NewService(promotionRepository)
+	addressService := customer.NewAddressService(addressRepository)
+	verificationService := customer.NewVerificationService(verificationRepository, customerRepository, keys, mail, outbox, cfg.EmailVerificationTTL,
+		cfg.VerificationResendInterval, cfg.EmailVerificationURL, cfg.RequireVerifiedEmail)
+	orderService := order.NewService(orderRepository, bookService, paymentService, promotionService, addressService, verificationService,
+		taxCalculator, shippingCalculator, cfg.OrderCancellationWindow)
+	idempotencyService := idempotency.NewService(idempotencyRepository, cfg.IdempotencyKeyTTL)
+	cartService := cart.NewService(cartRepository, bookService, orderService)
+	returnService := returns.NewService(returnRepository, paymentService, cfg.ReturnWindow)
+	sessionService := session.NewService(sessionRepository, customerService, keys, cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	passwordResetService := customer.NewPasswordResetService(passwordResetRepository, customerRepository, securityService, mail,
+		outbox, sessionService, cfg.PasswordResetTTL, cfg.PasswordResetResendInterval, cfg.PasswordResetURL)
+
+	// remove the expired idempotency keys and sessions in the background
+	purgeCtx, stopPurge := context.WithCancel(ctx)
+	defer stopPurge()
+	go idempotencyService.PurgePeriodically(purgeCtx, time.Hour)
+	go sessionService.PurgePeriodically(purgeCtx, time.Hour)
 
 	// Create the server instance
-	server := server.New(customerService, bookService, orderService)
+	server := server.New(customerService, bookService, orderService, idempotencyService, cartService, returnService, promotionService, addressService, keys, sessionService, passwordResetService, verificationService)
 
 	// Start the server
 	go func() {
@@ -87,9 +171,15 @@ func main() {
 	sig := <-c
 
 	// Shutdown the server gracefully
-	if err := server.E.Shutdown(ctx); err != nil {
+	shutdownCtx, cancel := context.WithTimeout(ctx, cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.E.Shutdown(shutdownCtx); err != nil {
 		utils.LogErrorFatal(err)
 	}
+	// let the emails being sent go out, unless the server is taking too long
+	if err := outbox.Wait(shutdownCtx); err != nil {
+		slog.Error("gave up on the emails still being sent", "error", err)
+	}
 
-	slog.Info("Received signal, Server shut down gracefully", sig)
+	slog.Info("Received signal, Server shut down gracefully", "signal", sig)
 }
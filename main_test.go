@@ -5,9 +5,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/ap-pauloafonso/bookstore/security"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"io"
+	"math"
 	"net/http"
 	"testing"
 	"time"
@@ -52,6 +55,22 @@ func Test_E2E(t *testing.T) {
 	defer postgresC.Terminate(context.Background())
 
 	time.Sleep(3 * time.Second)
+
+	// the emails stay in the server container, so the tests that need a password reset link store one themselves
+	dbHost, err := postgresC.Host(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPort, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := pgxpool.Connect(context.Background(), fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", dbHost, dbPort.Port()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
 	reqServerContainer := testcontainers.ContainerRequest{
 		Networks: []string{networkName},
 		FromDockerfile: testcontainers.FromDockerfile{
@@ -97,6 +116,30 @@ func Test_E2E(t *testing.T) {
 		}
 	})
 
+	t.Run("api jwks", func(t *testing.T) {
+		resp, err := http.Get(url + "/.well-known/jwks.json")
+		if err != nil {
+			t.Fatal("jwks endpoint shouldn't fail", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("jwks should return 200, it returned: ", resp.StatusCode)
+		}
+
+		var set struct {
+			Keys []struct {
+				Kid string `json:"kid"`
+				Alg string `json:"alg"`
+			} `json:"keys"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+			t.Fatal("Failed to unmarshal response JSON", err)
+		}
+		if len(set.Keys) != 1 || set.Keys[0].Kid == "" || set.Keys[0].Alg != "EdDSA" {
+			t.Fatal("jwks should have the generated signing key, it has: ", set.Keys)
+		}
+	})
+
 	type Model struct {
 		ID     int64   `json:"id"`
 		Title  string  `json:"title"`
@@ -106,7 +149,7 @@ func Test_E2E(t *testing.T) {
 
 	var booksResult []Model
 	t.Run("api get books", func(t *testing.T) {
-		resp, err := http.Get(url + "/api/books")
+		resp, err := http.Get(url + "/api/books?with_total=true")
 		if err != nil {
 			t.Fatal("books endpoints shouldn't fail", err)
 		}
@@ -120,18 +163,22 @@ func Test_E2E(t *testing.T) {
 			t.Fatal("Failed to read response body", err)
 		}
 
-		var responseSlice []Model
+		var page struct {
+			Items      []Model `json:"items"`
+			NextCursor string  `json:"next_cursor"`
+			Total      *int64  `json:"total"`
+		}
 
-		// Unmarshal the response JSON into the slice of Model
-		if err := json.Unmarshal(responseBody, &responseSlice); err != nil {
+		// Unmarshal the response JSON into the page of Model
+		if err := json.Unmarshal(responseBody, &page); err != nil {
 			t.Fatal("Failed to unmarshal response JSON", err)
 		}
 
-		if len(responseSlice) < 2 {
+		if len(page.Items) < 2 || page.Total == nil || *page.Total < 2 {
 			t.Fatal("should have more than 2, because there is a migration")
 		}
 
-		booksResult = responseSlice
+		booksResult = page.Items
 
 	})
 
@@ -168,7 +215,7 @@ func Test_E2E(t *testing.T) {
 
 	})
 
-	var token string
+	var token, refreshToken string
 	t.Run("api login", func(t *testing.T) {
 		jsonData, err := json.Marshal(map[string]string{"email": "paulo@gmail.com", "password": "123"})
 		if err != nil {
@@ -190,7 +237,8 @@ func Test_E2E(t *testing.T) {
 		}
 
 		var responseStruct struct {
-			Token string `json:"token"`
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
 		}
 
 		if err := json.Unmarshal(responseBody, &responseStruct); err != nil {
@@ -199,6 +247,7 @@ func Test_E2E(t *testing.T) {
 		}
 
 		token = responseStruct.Token
+		refreshToken = responseStruct.RefreshToken
 	})
 
 	type OrderRequestItem struct {
@@ -206,36 +255,73 @@ func Test_E2E(t *testing.T) {
 		Quantity int   `json:"quantity"`
 	}
 
+	type Destination struct {
+		Country string `json:"country"`
+		Region  string `json:"region"`
+	}
+
 	b1 := booksResult[0]
 	b2 := booksResult[1]
 
 	t.Run("api create order", func(t *testing.T) {
 
-		jsonData, err := json.Marshal([]OrderRequestItem{
-			{
-				BookID:   b1.ID,
-				Quantity: 1,
-			},
-			{
-				BookID:   b2.ID,
-				Quantity: 3,
+		type OrderRequest struct {
+			Items        []OrderRequestItem `json:"items"`
+			PaymentToken string             `json:"payment_token"`
+			Destination  Destination        `json:"destination"`
+		}
+
+		jsonData, err := json.Marshal(OrderRequest{
+			Items: []OrderRequestItem{
+				{
+					BookID:   b1.ID,
+					Quantity: 1,
+				},
+				{
+					BookID:   b2.ID,
+					Quantity: 3,
+				},
 			},
+			PaymentToken: "tok_visa",
+			Destination:  Destination{Country: "US", Region: "OR"},
 		})
 		if err != nil {
 			t.Fatal("JSON serialization error", err)
 		}
 
-		req, err := http.NewRequest("POST", url+"/api/orders", bytes.NewBuffer(jsonData))
-		if err != nil {
-			t.Fatal("Failed to create create order request", err)
+		// post sends the body with the access token
+		post := func(path string, body []byte) *http.Response {
+			req, err := http.NewRequest("POST", url+path, bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatal("Failed to create the request", err)
+			}
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal("post shouldn't fail", err)
+			}
+			return resp
 		}
 
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-		req.Header.Set("Content-Type", "application/json")
-		resp, err := http.DefaultClient.Do(req)
+		// a destination only says where the order is taxed, it can't be delivered there
+		resp := post("/api/orders", jsonData)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatal("an order without a shipping address should return 400, it returned: ", resp.StatusCode)
+		}
+
+		address, err := json.Marshal(map[string]string{"recipient": "Paulo", "line1": "1 Main St", "city": "Portland", "region": "OR", "country": "US"})
 		if err != nil {
-			return
+			t.Fatal("JSON serialization error", err)
 		}
+		resp = post("/api/me/addresses", address)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatal("post address should return 201, it returned: ", resp.StatusCode)
+		}
+
+		resp = post("/api/orders", jsonData)
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
 			t.Fatal("post order should return 200, it returned: ", resp.StatusCode)
@@ -248,10 +334,12 @@ func Test_E2E(t *testing.T) {
 		}
 
 		var order struct {
-			ID        int64       `json:"id"`
-			Total     float64     `json:"total"`
-			OrderDate time.Time   `json:"order_date"`
-			Items     []OrderItem `json:"items"`
+			ID           int64       `json:"id"`
+			Total        float64     `json:"total"`
+			ShippingCost float64     `json:"shipping_cost"`
+			Status       string      `json:"status"`
+			OrderDate    time.Time   `json:"order_date"`
+			Items        []OrderItem `json:"items"`
 		}
 
 		responseBody, err := io.ReadAll(resp.Body)
@@ -271,10 +359,18 @@ func Test_E2E(t *testing.T) {
 			t.Fatal("order should have 2 item", err)
 		}
 
-		expectedTotal := (b1.Price * 1) + (b2.Price * 3)
+		if order.ShippingCost <= 0 {
+			t.Fatal("the order should be shipped with the standard method, got a shipping cost of: ", order.ShippingCost)
+		}
+
+		expectedTotal := math.Round(b1.Price*100) + math.Round(b2.Price*100)*3 + math.Round(order.ShippingCost*100)
+
+		if math.Round(order.Total*100) != expectedTotal {
+			t.Fatal("expected total in cents: ", expectedTotal, " got: ", order.Total)
+		}
 
-		if order.Total != expectedTotal {
-			t.Fatal("expected total: ", expectedTotal, " got: ", order.Total)
+		if order.Status != "paid" {
+			t.Fatal("the order should be paid, got: ", order.Status)
 		}
 
 	})
@@ -304,11 +400,15 @@ func Test_E2E(t *testing.T) {
 			Price    float64 `json:"price"`
 		}
 
-		var orders []struct {
-			ID        int64       `json:"id"`
-			Total     float64     `json:"total"`
-			OrderDate time.Time   `json:"order_date"`
-			Items     []OrderItem `json:"items"`
+		var page struct {
+			Items []struct {
+				ID           int64       `json:"id"`
+				Total        float64     `json:"total"`
+				ShippingCost float64     `json:"shipping_cost"`
+				OrderDate    time.Time   `json:"order_date"`
+				Items        []OrderItem `json:"items"`
+			} `json:"items"`
+			NextCursor string `json:"next_cursor"`
 		}
 
 		responseBody, err := io.ReadAll(resp.Body)
@@ -316,15 +416,19 @@ func Test_E2E(t *testing.T) {
 			t.Fatal("Failed to read response body", err)
 		}
 
-		if err := json.Unmarshal(responseBody, &orders); err != nil {
+		if err := json.Unmarshal(responseBody, &page); err != nil {
 			t.Fatal("Failed to unmarshal response JSON", err)
 		}
 
-		if len(orders) == 0 {
+		if len(page.Items) == 0 {
 			t.Fatal("expected 1 order but got zero")
 		}
 
-		order := orders[0] // get the first order
+		if page.NextCursor != "" {
+			t.Fatal("there should be no more orders after the first page")
+		}
+
+		order := page.Items[0] // get the first order
 
 		if order.ID == 0 {
 			t.Fatal("order should have a valid id", err)
@@ -334,11 +438,311 @@ func Test_E2E(t *testing.T) {
 			t.Fatal("order should have 2 item", err)
 		}
 
-		expectedTotal := (b1.Price * 1) + (b2.Price * 3)
+		expectedTotal := math.Round(b1.Price*100) + math.Round(b2.Price*100)*3 + math.Round(order.ShippingCost*100)
+
+		if math.Round(order.Total*100) != expectedTotal {
+			t.Fatal("expected total in cents: ", expectedTotal, " got: ", order.Total)
+		}
+
+	})
+
+	type tokenResponse struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// getOrders returns the status of listing the orders with the access token
+	getOrders := func(t *testing.T, accessToken string) int {
+		req, err := http.NewRequest(http.MethodGet, url+"/api/orders", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("orders endpoint shouldn't fail", err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	refresh := func(t *testing.T, token string) (int, tokenResponse) {
+		jsonData, err := json.Marshal(map[string]string{"refresh_token": token})
+		if err != nil {
+			t.Fatal("JSON serialization error", err)
+		}
+		resp, err := http.Post(url+"/api/token/refresh", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatal("refresh endpoint shouldn't fail", err)
+		}
+		defer resp.Body.Close()
 
-		if order.Total != expectedTotal {
-			t.Fatal("expected total: ", expectedTotal, " got: ", order.Total)
+		var tokens tokenResponse
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+				t.Fatal("Failed to unmarshal response JSON", err)
+			}
+		}
+		return resp.StatusCode, tokens
+	}
+
+	t.Run("api token refresh", func(t *testing.T) {
+		status, tokens := refresh(t, refreshToken)
+		if status != http.StatusOK || tokens.Token == "" || tokens.RefreshToken == refreshToken {
+			t.Fatal("refresh should return new tokens, it returned: ", status)
+		}
+		if status := getOrders(t, tokens.Token); status != http.StatusOK {
+			t.Fatal("the refreshed access token should work, it returned: ", status)
 		}
 
+		// using the first refresh token again revokes the session
+		if status, _ := refresh(t, refreshToken); status != http.StatusUnauthorized {
+			t.Fatal("a reused refresh token should return 401, it returned: ", status)
+		}
+		if status := getOrders(t, tokens.Token); status != http.StatusUnauthorized {
+			t.Fatal("the access tokens of a revoked session should return 401, it returned: ", status)
+		}
+	})
+
+	t.Run("api logout", func(t *testing.T) {
+		jsonData, err := json.Marshal(map[string]string{"email": "paulo@gmail.com", "password": "123"})
+		if err != nil {
+			t.Fatal("JSON serialization error", err)
+		}
+		respLogin, err := http.Post(url+"/api/login", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatal("login shouldn't fail", err)
+		}
+		defer respLogin.Body.Close()
+		var tokens tokenResponse
+		if err := json.NewDecoder(respLogin.Body).Decode(&tokens); err != nil {
+			t.Fatal("Failed to unmarshal response JSON", err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url+"/api/logout", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokens.Token))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("logout shouldn't fail", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("logout should return 200, it returned: ", resp.StatusCode)
+		}
+
+		if status := getOrders(t, tokens.Token); status != http.StatusUnauthorized {
+			t.Fatal("the access token should be revoked, it returned: ", status)
+		}
+		if status, _ := refresh(t, tokens.RefreshToken); status != http.StatusUnauthorized {
+			t.Fatal("the refresh token should be revoked, it returned: ", status)
+		}
+	})
+
+	t.Run("api password forgot", func(t *testing.T) {
+		// registered and unknown emails get the same answer
+		for _, email := range []string{"paulo@gmail.com", "nobody@gmail.com"} {
+			jsonData, err := json.Marshal(map[string]string{"email": email})
+			if err != nil {
+				t.Fatal("JSON serialization error", err)
+			}
+			resp, err := http.Post(url+"/api/password/forgot", "application/json", bytes.NewBuffer(jsonData))
+			if err != nil {
+				t.Fatal("forgot password shouldn't fail", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatal("forgot password should return 200, it returned: ", resp.StatusCode)
+			}
+		}
+
+		jsonData, err := json.Marshal(map[string]string{"token": "unknown", "password": "new password"})
+		if err != nil {
+			t.Fatal("JSON serialization error", err)
+		}
+		resp, err := http.Post(url+"/api/password/reset", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatal("reset password shouldn't fail", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatal("an unknown reset token should return 400, it returned: ", resp.StatusCode)
+		}
+	})
+
+	t.Run("api verify email", func(t *testing.T) {
+		jsonData, err := json.Marshal(map[string]string{"token": "unknown"})
+		if err != nil {
+			t.Fatal("JSON serialization error", err)
+		}
+		resp, err := http.Post(url+"/api/verify-email", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatal("verify email shouldn't fail", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatal("an unknown verification token should return 400, it returned: ", resp.StatusCode)
+		}
+
+		jsonData, err = json.Marshal(map[string]string{"email": "paulo@gmail.com", "password": "123"})
+		if err != nil {
+			t.Fatal("JSON serialization error", err)
+		}
+		respLogin, err := http.Post(url+"/api/login", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatal("login shouldn't fail", err)
+		}
+		defer respLogin.Body.Close()
+		var tokens tokenResponse
+		if err := json.NewDecoder(respLogin.Body).Decode(&tokens); err != nil {
+			t.Fatal("Failed to unmarshal response JSON", err)
+		}
+
+		// the verification email was just sent by the registration
+		req, err := http.NewRequest(http.MethodPost, url+"/api/verify-email/resend", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokens.Token))
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("resend verification shouldn't fail", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Fatal("resending right after registering should return 429, it returned: ", resp.StatusCode)
+		}
+	})
+
+	login := func(t *testing.T, email, password string) tokenResponse {
+		jsonData, err := json.Marshal(map[string]string{"email": email, "password": password})
+		if err != nil {
+			t.Fatal("JSON serialization error", err)
+		}
+		resp, err := http.Post(url+"/api/login", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatal("login shouldn't fail", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("login should return 200, it returned: ", resp.StatusCode)
+		}
+		var tokens tokenResponse
+		if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+			t.Fatal("Failed to unmarshal response JSON", err)
+		}
+		return tokens
+	}
+
+	put := func(t *testing.T, path, accessToken string, body map[string]string) int {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal("JSON serialization error", err)
+		}
+		req, err := http.NewRequest(http.MethodPut, url+path, bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("put shouldn't fail", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// sendReset stores a password reset link for the customer as if it was emailed, returning its token
+	sendReset := func(t *testing.T, email string) string {
+		token, err := security.RandomToken(32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := `
+			INSERT INTO password_resets (token_hash, customer_id, expires_at, created_at)
+			SELECT $1, id, NOW() + INTERVAL '1 hour', NOW() FROM customers WHERE email = $2
+		`
+		if _, err := db.Exec(context.Background(), query, security.HashToken(token), email); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	resetPassword := func(t *testing.T, token, password string) int {
+		jsonData, err := json.Marshal(map[string]string{"token": token, "password": password})
+		if err != nil {
+			t.Fatal("JSON serialization error", err)
+		}
+		resp, err := http.Post(url+"/api/password/reset", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatal("reset password shouldn't fail", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("api change password", func(t *testing.T) {
+		other := login(t, "paulo@gmail.com", "123")
+		current := login(t, "paulo@gmail.com", "123")
+		token := sendReset(t, "paulo@gmail.com")
+
+		if status := put(t, "/api/me/password", current.Token, map[string]string{"current_password": "wrong", "new_password": "456"}); status != http.StatusForbidden {
+			t.Fatal("a wrong current password should return 403, it returned: ", status)
+		}
+		if status := put(t, "/api/me/password", current.Token, map[string]string{"current_password": "123", "new_password": "456"}); status != http.StatusOK {
+			t.Fatal("change password should return 200, it returned: ", status)
+		}
+
+		if status := getOrders(t, other.Token); status != http.StatusUnauthorized {
+			t.Fatal("the other sessions should be revoked, it returned: ", status)
+		}
+		if status := getOrders(t, current.Token); status != http.StatusOK {
+			t.Fatal("the session that changed the password should keep working, it returned: ", status)
+		}
+		if status := resetPassword(t, token, "789"); status != http.StatusBadRequest {
+			t.Fatal("a reset link sent before the password change should return 400, it returned: ", status)
+		}
+		login(t, "paulo@gmail.com", "456")
+	})
+
+	t.Run("api change email", func(t *testing.T) {
+		current := login(t, "paulo@gmail.com", "456")
+		token := sendReset(t, "paulo@gmail.com")
+
+		if status := put(t, "/api/me/email", current.Token, map[string]string{"email": "paulo@gmail.com", "password": "456"}); status != http.StatusBadRequest {
+			t.Fatal("the same email should return 400, it returned: ", status)
+		}
+		if status := put(t, "/api/me/email", current.Token, map[string]string{"email": "paulo2@gmail.com", "password": "456"}); status != http.StatusOK {
+			t.Fatal("change email should return 200, it returned: ", status)
+		}
+
+		if status := getOrders(t, current.Token); status != http.StatusOK {
+			t.Fatal("the access token with the old email should keep working, it returned: ", status)
+		}
+		if status := resetPassword(t, token, "789"); status != http.StatusBadRequest {
+			t.Fatal("a reset link sent to the old email should return 400, it returned: ", status)
+		}
+		login(t, "paulo2@gmail.com", "456")
+	})
+
+	t.Run("api admin requires the admin role", func(t *testing.T) {
+		current := login(t, "paulo2@gmail.com", "456")
+
+		req, err := http.NewRequest(http.MethodGet, url+"/api/admin/returns", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", current.Token))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("admin request shouldn't fail", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatal("a customer token should return 403 on admin routes, it returned: ", resp.StatusCode)
+		}
 	})
 }
@@ -0,0 +1,172 @@
+// Package money represents prices and totals exactly, as an integer amount of cents, so that arithmetic on them
+// never drifts the way float64 does.
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgtype"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Currency is the ISO 4217 code of the currency every amount of the store is in
+const Currency = "USD"
+
+// MinorUnits is how many cents make one unit of Currency
+const MinorUnits = 100
+
+var (
+	errInvalidAmount = errors.New("invalid amount")
+	errTooPrecise    = errors.New("invalid amount: only two decimal places are allowed")
+	errOutOfRange    = errors.New("invalid amount: out of range")
+)
+
+// Amount is an exact amount of money in cents, 10.99 is Amount(1099)
+type Amount int64
+
+// FromCents returns the amount with the given number of cents
+func FromCents(cents int64) Amount {
+	return Amount(cents)
+}
+
+// Cents returns the amount as an integer number of cents
+func (a Amount) Cents() int64 {
+	return int64(a)
+}
+
+// Mul multiplies the amount by a quantity, which never needs rounding
+func (a Amount) Mul(quantity int) Amount {
+	return a * Amount(quantity)
+}
+
+// MulRate multiplies the amount by numerator/denominator, rounding half away from zero to the nearest cent.
+// This is the rounding rule used for every amount that is derived from a rate, like taxes and discounts.
+func (a Amount) MulRate(numerator, denominator int64) Amount {
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(numerator))
+	return Amount(divRound(product, big.NewInt(denominator)).Int64())
+}
+
+// divRound divides x by y rounding half away from zero
+func divRound(x, y *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(x, y, new(big.Int))
+	// |2r| >= |y| means the remainder is at least half of the divisor
+	twiceR := new(big.Int).Abs(r)
+	twiceR.Lsh(twiceR, 1)
+	if twiceR.CmpAbs(y) >= 0 {
+		if (x.Sign() < 0) != (y.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// String formats the amount as a plain decimal number with two decimal places, like 10.99
+func (a Amount) String() string {
+	sign := ""
+	cents := int64(a)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/MinorUnits, cents%MinorUnits)
+}
+
+// Parse reads a decimal number like 10.99 or 10, it fails if the number has more than two decimal places
+func Parse(s string) (Amount, error) {
+	return parse(s, false)
+}
+
+// parse reads a decimal number, in plain or exponent notation, rounding it to cents when round is true
+func parse(s string, round bool) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errInvalidAmount
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, errInvalidAmount
+	}
+
+	r.Mul(r, big.NewRat(MinorUnits, 1))
+	var cents *big.Int
+	if r.IsInt() {
+		cents = r.Num()
+	} else if round {
+		cents = divRound(r.Num(), r.Denom())
+	} else {
+		return 0, errTooPrecise
+	}
+
+	if !cents.IsInt64() {
+		return 0, errOutOfRange
+	}
+
+	return Amount(cents.Int64()), nil
+}
+
+// MarshalJSON encodes the amount as a JSON number with two decimal places
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding a number
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*a = v
+	return nil
+}
+
+// Scan reads a NUMERIC column, rounding it to cents if it has more decimal places
+func (a *Amount) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case int64:
+		*a = Amount(v * MinorUnits)
+		return nil
+	case nil:
+		return fmt.Errorf("cannot scan NULL into money.Amount")
+	default:
+		return fmt.Errorf("cannot scan %T into money.Amount", src)
+	}
+
+	v, err := parse(s, true)
+	if err != nil {
+		return err
+	}
+
+	*a = v
+	return nil
+}
+
+// Value lets database/sql drivers store the amount in a NUMERIC column
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// EncodeText makes pgx send the amount as decimal text, without it pgx would store the raw number of cents
+// because Amount is an integer type
+func (a Amount) EncodeText(ci *pgtype.ConnInfo, buf []byte) ([]byte, error) {
+	return append(buf, a.String()...), nil
+}
@@ -0,0 +1,161 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expected      Amount
+		expectedError error
+	}{
+		{name: "Two decimals", input: "10.99", expected: 1099},
+		{name: "One decimal", input: "10.5", expected: 1050},
+		{name: "Integer", input: "10", expected: 1000},
+		{name: "Negative", input: "-0.05", expected: -5},
+		{name: "Trailing zeros", input: "1.2300", expected: 123},
+		{name: "Too precise", input: "10.999", expectedError: errTooPrecise},
+		{name: "Empty", input: "", expectedError: errInvalidAmount},
+		{name: "Not a number", input: "ten", expectedError: errInvalidAmount},
+		{name: "Out of range", input: "999999999999999999999", expectedError: errOutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := Parse(tt.input)
+			if err != tt.expectedError {
+				t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+			if a != tt.expected {
+				t.Errorf("Expected: %d, got: %d", tt.expected, a)
+			}
+		})
+	}
+}
+
+func TestAmount_String(t *testing.T) {
+	tests := map[Amount]string{
+		0:     "0.00",
+		5:     "0.05",
+		1099:  "10.99",
+		-1099: "-10.99",
+		-5:    "-0.05",
+		100:   "1.00",
+	}
+
+	for amount, expected := range tests {
+		if amount.String() != expected {
+			t.Errorf("Expected %s, got: %s", expected, amount.String())
+		}
+	}
+}
+
+func TestAmount_Mul(t *testing.T) {
+	// 3 x 10.99 is the classic example of a float64 total that drifts
+	price, _ := Parse("10.99")
+	if price.Mul(3) != 3297 {
+		t.Errorf("Expected 32.97, got: %s", price.Mul(3))
+	}
+
+	price, _ = Parse("0.1")
+	if price.Mul(1000) != 10000 {
+		t.Errorf("Expected 100.00, got: %s", price.Mul(1000))
+	}
+}
+
+func TestAmount_MulRate(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      Amount
+		numerator   int64
+		denominator int64
+		expected    Amount
+	}{
+		{name: "Exact", amount: 1000, numerator: 10, denominator: 100, expected: 100},
+		{name: "Rounds down below half", amount: 1099, numerator: 7, denominator: 100, expected: 77},
+		{name: "Rounds half up", amount: 50, numerator: 1, denominator: 100, expected: 1},
+		{name: "Rounds half away from zero when negative", amount: -50, numerator: 1, denominator: 100, expected: -1},
+		{name: "Basis points", amount: 1999, numerator: 825, denominator: 10000, expected: 165},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.amount.MulRate(tt.numerator, tt.denominator)
+			if r != tt.expected {
+				t.Errorf("Expected: %s, got: %s", tt.expected, r)
+			}
+		})
+	}
+}
+
+func TestAmount_JSON(t *testing.T) {
+	var v struct {
+		Price Amount `json:"price"`
+	}
+
+	if err := json.Unmarshal([]byte(`{"price": 10.99}`), &v); err != nil || v.Price != 1099 {
+		t.Fatalf("should read a number, got: %d, %v", v.Price, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"price": "7.5"}`), &v); err != nil || v.Price != 750 {
+		t.Fatalf("should read a string, got: %d, %v", v.Price, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"price": 10.999}`), &v); err == nil {
+		t.Fatalf("should not accept more than two decimal places")
+	}
+
+	v.Price = 3297
+	b, err := json.Marshal(v)
+	if err != nil || string(b) != `{"price":32.97}` {
+		t.Fatalf("should write a number with two decimal places, got: %s, %v", b, err)
+	}
+}
+
+func TestAmount_Scan(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      interface{}
+		expected Amount
+	}{
+		// pgx hands NUMERIC values over in exponent notation
+		{name: "Exponent notation", src: "1099e-2", expected: 1099},
+		{name: "Positive exponent", src: "1e3", expected: 100000},
+		{name: "Plain decimal", src: "10.99", expected: 1099},
+		{name: "Bytes", src: []byte("32.97"), expected: 3297},
+		{name: "Integer", src: int64(7), expected: 700},
+		{name: "Rounds extra decimal places", src: "10.995", expected: 1100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a Amount
+			if err := a.Scan(tt.src); err != nil {
+				t.Fatalf("should not fail: %v", err)
+			}
+			if a != tt.expected {
+				t.Errorf("Expected: %d, got: %d", tt.expected, a)
+			}
+		})
+	}
+
+	var a Amount
+	if err := a.Scan(nil); err == nil {
+		t.Errorf("should not scan NULL")
+	}
+}
+
+func TestAmount_Value(t *testing.T) {
+	v, err := Amount(1099).Value()
+	if err != nil || v != "10.99" {
+		t.Errorf("Expected 10.99, got: %v, %v", v, err)
+	}
+
+	b, err := Amount(-5).EncodeText(nil, nil)
+	if err != nil || string(b) != "-0.05" {
+		t.Errorf("Expected -0.05, got: %s, %v", b, err)
+	}
+}
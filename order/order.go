@@ -4,7 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"golang.org/x/exp/maps"
+	"github.com/ap-pauloafonso/bookstore/book"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/payment"
+	"github.com/ap-pauloafonso/bookstore/promotion"
+	"github.com/ap-pauloafonso/bookstore/shipping"
+	"github.com/ap-pauloafonso/bookstore/tax"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"log/slog"
 	"time"
 )
 
@@ -13,83 +21,363 @@ var (
 	errInvalidBookID        = errors.New("invalid book ID")
 	errInvalidBookQuantity  = errors.New("invalid book quantity")
 	errDuplicateOrderItemID = errors.New("duplicate bookID, use quantity instead")
+	errOutOfStock           = errors.New("not enough stock")
+	errCartChanged          = errors.New("the cart changed during the checkout, review it and check out again")
+	errInvalidLimit         = errors.New("invalid limit: needs to be between 1 and 100")
+	errInvalidCursor        = errors.New("invalid cursor")
+	errInvalidRange         = errors.New("invalid date range: from needs to be before to")
+	errMissingAddress       = errors.New("missing shipping address: send an address_id or a destination, or add a default address")
+	errMissingDelivery      = errors.New("missing shipping address: send an address_id or add a default address, orders without one can only be picked up")
 )
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// OutOfStockError is returned by the Repository when a book doesn't have enough units left for an order
+type OutOfStockError struct {
+	BookID int64
+}
+
+func (e *OutOfStockError) Error() string {
+	return fmt.Sprintf("book %d doesn't have enough stock", e.BookID)
+}
+
+// IsOutOfStock reports whether err means that the order asked for more units than there are in stock
+func IsOutOfStock(err error) bool {
+	return errors.Is(err, errOutOfStock)
+}
+
+// CartChangedError is returned by the Repository when the cart an order is made from doesn't have the same items
+// anymore
+type CartChangedError struct {
+	BookID int64
+}
+
+func (e *CartChangedError) Error() string {
+	return fmt.Sprintf("book %d changed in the cart", e.BookID)
+}
+
+// IsCartChanged reports whether err means that the cart was changed while it was being checked out
+func IsCartChanged(err error) bool {
+	return errors.Is(err, errCartChanged)
+}
+
 type Service struct {
-	repository  Repository
-	bookService BookService
+	repository         Repository
+	bookService        BookService
+	paymentService     PaymentService
+	promotionService   PromotionService
+	addressService     AddressService
+	verification       VerificationService
+	taxCalculator      tax.Calculator
+	shippingCalculator shipping.Calculator
+	cancellationWindow time.Duration
 }
 
-func NewService(orderRepository Repository, bookService BookService) *Service {
-	return &Service{orderRepository, bookService}
+// NewService creates the order service, customers can cancel their orders up to cancellationWindow after making them
+func NewService(orderRepository Repository, bookService BookService, paymentService PaymentService, promotionService PromotionService,
+	addressService AddressService, verification VerificationService, taxCalculator tax.Calculator, shippingCalculator shipping.Calculator,
+	cancellationWindow time.Duration) *Service {
+	return &Service{orderRepository, bookService, paymentService, promotionService, addressService, verification, taxCalculator,
+		shippingCalculator, cancellationWindow}
 }
 
+// OrderItem is a book of an order, Tax is the tax of all its units at TaxRate
 type OrderItem struct {
-	BookID    int64   `json:"book_id"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
-	BookTitle string  `json:"book_title"`
+	BookID    int64        `json:"book_id"`
+	Quantity  int          `json:"quantity"`
+	Price     money.Amount `json:"price"`
+	BookTitle string       `json:"book_title"`
+	TaxRate   tax.Rate     `json:"tax_rate"`
+	Tax       money.Amount `json:"tax"`
 }
 
+// Order is a purchase of a customer, Total is what was charged: the Subtotal minus the Discount plus the ShippingCost
+// and the Tax. Destination is nil for orders made before taxes existed. ShippingAddress is a copy of the address the order was made
+// with, so editing or deleting the address doesn't change it
 type Order struct {
-	ID        int64       `json:"id"`
-	Total     float64     `json:"total"`
-	OrderDate time.Time   `json:"order_date"`
-	Items     []OrderItem `json:"items"`
+	ID              int64            `json:"id"`
+	Subtotal        money.Amount     `json:"subtotal"`
+	Discount        money.Amount     `json:"discount"`
+	Tax             money.Amount     `json:"tax"`
+	Total           money.Amount     `json:"total"`
+	CouponCode      string           `json:"coupon_code,omitempty"`
+	Destination     *tax.Destination `json:"destination,omitempty"`
+	ShippingAddress *ShippingAddress `json:"shipping_address,omitempty"`
+	ShippingMethod  shipping.Method  `json:"shipping_method,omitempty"`
+	ShippingCost    money.Amount     `json:"shipping_cost"`
+	Currency        string           `json:"currency"`
+	Status          Status           `json:"status"`
+	OrderDate       time.Time        `json:"order_date"`
+	Items           []OrderItem      `json:"items"`
+	Payment         *payment.Payment `json:"payment,omitempty"`
+	// FromCart is set when the items come from the cart of the customer, which is emptied when the order is stored
+	FromCart bool `json:"-"`
 }
 
-func CalculateTotal(items []OrderItem) float64 {
-	var r float64
+// CalculateSubtotal sums the unit price times the quantity of every item, exactly to the cent
+func CalculateSubtotal(items []OrderItem) money.Amount {
+	var r money.Amount
 
 	for _, v := range items {
-		r += v.Price * float64(v.Quantity)
+		r += v.Price.Mul(v.Quantity)
 	}
 
 	return r
 }
 
+// CalculateTax sums the tax of every item
+func CalculateTax(items []OrderItem) money.Amount {
+	var r money.Amount
+
+	for _, v := range items {
+		r += v.Tax
+	}
+
+	return r
+}
+
+// CalculateTotal takes the discount off the subtotal of the items, never going below zero, and adds their tax
+func CalculateTotal(items []OrderItem, discount money.Amount) money.Amount {
+	r := CalculateSubtotal(items) - discount
+	if r < 0 {
+		r = 0
+	}
+
+	return r + CalculateTax(items)
+}
+
+// fillTotals sets the subtotal, tax and total of a new order from its items and its shipping cost, stored orders
+// keep the ones they were made with
+func (o *Order) fillTotals() {
+	o.Subtotal = CalculateSubtotal(o.Items)
+	o.Tax = CalculateTax(o.Items)
+	o.Total = CalculateTotal(o.Items, o.Discount) + o.ShippingCost
+}
+
+// taxableAmounts splits the discount among the items proportionally to their value and returns what is left of each
+// of them. The shares are rounded on the running total, so they add up to the discount exactly
+func taxableAmounts(items []OrderItem, discount money.Amount) []money.Amount {
+	subtotal := CalculateSubtotal(items)
+	if discount > subtotal {
+		discount = subtotal
+	}
+
+	amounts := make([]money.Amount, len(items))
+	var running, given money.Amount
+	for i, v := range items {
+		value := v.Price.Mul(v.Quantity)
+		running += value
+		share := value
+		if subtotal > 0 {
+			share = running.MulRate(int64(discount), int64(subtotal)) - given
+		}
+		given += share
+		amounts[i] = value - share
+	}
+
+	return amounts
+}
+
+// ListParams are the options of an order history listing as requested by the client,
+// From is inclusive and To is exclusive
+type ListParams struct {
+	Limit  int
+	Cursor string
+	From   *time.Time
+	To     *time.Time
+	Status Status
+}
+
+// Cursor is the position right after the last order of a page
+type Cursor struct {
+	ID int64 `json:"id"`
+}
+
+// Query is the validated form of ListParams that the repository executes
+type Query struct {
+	CustomerID int64
+	Limit      int
+	After      *Cursor
+	From       *time.Time
+	To         *time.Time
+	Status     Status
+}
+
+// Page is a slice of the order history, newest orders first, NextCursor is empty when there are no more orders
+type Page struct {
+	Items      []Order `json:"items"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
 type Repository interface {
-	SaveOrder(ctx context.Context, customerId int64, orderDate time.Time, items []OrderItem) (*int64, error)
-	GetOrdersByCustomer(ctx context.Context, customerID int64) ([]Order, error)
+	// SaveOrder stores the order in its initial status together with its payment and takes the items out of stock
+	// atomically, failing with *OutOfStockError if one of the books doesn't have enough units. Orders made FromCart
+	// empty the cart in the same transaction, failing with *CartChangedError if it doesn't have exactly their items
+	SaveOrder(ctx context.Context, customerId int64, o Order) (*int64, error)
+	// ListOrders returns up to query.Limit orders of query.CustomerID with their items and book titles, newest first
+	ListOrders(ctx context.Context, query Query) ([]Order, error)
+	// GetOrderByID returns nil if the order doesn't exist or belongs to another customer
+	GetOrderByID(ctx context.Context, customerID, orderID int64) (*Order, error)
+	// GetOrderState returns nil if the order doesn't exist
+	GetOrderState(ctx context.Context, orderID int64) (*State, error)
+	// ClaimStatusChange reserves the move of the order from `from` to `to`, so no other transition can start until it
+	// is stored by UpdateOrderStatus or given up by ReleaseStatusChange. Claiming the same move again succeeds, so an
+	// interrupted transition can be retried. It reports false if the order is no longer in `from` or is being moved
+	// to another status
+	ClaimStatusChange(ctx context.Context, orderID int64, from, to Status) (bool, error)
+	// ReleaseStatusChange gives up the claimed move of the order to `to`
+	ReleaseStatusChange(ctx context.Context, orderID int64, to Status) error
+	// UpdateOrderStatus moves the order from change.From to change.To, releasing its claim, and records it in the
+	// status history in a single transaction, giving the items back to the stock when change.Restocks(). It returns
+	// nil if the order is no longer in change.From or is being moved to another status
+	UpdateOrderStatus(ctx context.Context, change StatusChange) (*StatusChange, error)
+	// GetStatusHistory returns the status changes of an order, oldest first
+	GetStatusHistory(ctx context.Context, orderID int64) ([]StatusChange, error)
 }
 
 type BookService interface {
-	GetBooksInformation(ctx context.Context, bookIDs []int64) (map[int64]struct {
-		Price float64
-		Title string
-	}, error)
+	GetBooksInformation(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error)
 }
 
-func (s *Service) GetOrdersByCustomer(ctx context.Context, customerID int64) ([]Order, error) {
-	orders, err := s.repository.GetOrdersByCustomer(ctx, customerID)
+type PaymentService interface {
+	Authorize(ctx context.Context, amount money.Amount, currency, token string) (*payment.Payment, error)
+	Release(ctx context.Context, p payment.Payment) error
+	GetPayment(ctx context.Context, orderID int64) (*payment.Payment, error)
+	Capture(ctx context.Context, orderID int64) error
+	Void(ctx context.Context, orderID int64) error
+	RefundAll(ctx context.Context, orderID int64) error
+}
+
+type PromotionService interface {
+	Apply(ctx context.Context, customerID int64, code string, lines []promotion.Line) (*promotion.Redemption, error)
+}
+
+type AddressService interface {
+	GetAddress(ctx context.Context, customerID, addressID int64) (*customer.Address, error)
+	// GetDefaultAddress returns nil when the customer doesn't have a default address
+	GetDefaultAddress(ctx context.Context, customerID int64) (*customer.Address, error)
+}
+
+type VerificationService interface {
+	// CheckCanOrder returns an error when the customer can't order until they verify their email
+	CheckCanOrder(ctx context.Context, customerID int64) error
+}
+
+// ShippingAddress is where an order is shipped to, as the address was when the order was made
+type ShippingAddress struct {
+	AddressID  int64  `json:"address_id"`
+	Recipient  string `json:"recipient"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postal_code,omitempty"`
+	Country    string `json:"country"`
+}
+
+func newShippingAddress(a *customer.Address) *ShippingAddress {
+	return &ShippingAddress{
+		AddressID:  a.ID,
+		Recipient:  a.Recipient,
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		Region:     a.Region,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+	}
+}
+
+func buildQuery(customerID int64, params ListParams) (*Query, error) {
+	q := Query{
+		CustomerID: customerID,
+		Limit:      params.Limit,
+		From:       params.From,
+		To:         params.To,
+		Status:     params.Status,
+	}
+
+	if q.Limit == 0 {
+		q.Limit = defaultPageSize
+	}
+	if q.Limit < 0 || q.Limit > maxPageSize {
+		return nil, errInvalidLimit
+	}
+
+	if q.Status != "" && !q.Status.Valid() {
+		return nil, errInvalidStatus
+	}
+
+	if q.From != nil && q.To != nil && !q.From.Before(*q.To) {
+		return nil, errInvalidRange
+	}
+
+	if params.Cursor != "" {
+		var c Cursor
+		if err := utils.DecodeCursor(params.Cursor, &c); err != nil || c.ID <= 0 {
+			return nil, errInvalidCursor
+		}
+		q.After = &c
+	}
+
+	return &q, nil
+}
+
+// ListOrders returns a page of the customer order history, newest orders first
+func (s *Service) ListOrders(ctx context.Context, customerID int64, params ListParams) (*Page, error) {
+	q, err := buildQuery(customerID, params)
 	if err != nil {
 		return nil, err
 	}
 
-	// calculate total
-	distinctBooks := map[int64]struct{}{}
-	for i := range orders {
-		orders[i].Total = CalculateTotal(orders[i].Items)
-		for _, v := range orders[i].Items {
-			if _, ok := distinctBooks[v.BookID]; !ok {
-				distinctBooks[v.BookID] = struct{}{}
-			}
+	// ask for one extra order to know if there is a next page
+	pageQuery := *q
+	pageQuery.Limit++
+	orders, err := s.repository.ListOrders(ctx, pageQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Items: orders}
+	if page.Items == nil {
+		page.Items = []Order{}
+	}
+
+	if len(orders) > q.Limit {
+		page.Items = orders[:q.Limit]
+		page.NextCursor, err = utils.EncodeCursor(Cursor{ID: page.Items[q.Limit-1].ID})
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	// fill books name for good user experience
-	booksMap, err := s.bookService.GetBooksInformation(ctx, maps.Keys(distinctBooks))
+	return page, nil
+}
+
+// GetOrderByID returns one of the customer orders, orders of other customers are reported as not found
+// so their IDs can't be discovered
+func (s *Service) GetOrderByID(ctx context.Context, customerID, orderID int64) (*Order, error) {
+	if orderID <= 0 {
+		return nil, errInvalidOrderID
+	}
+
+	o, err := s.repository.GetOrderByID(ctx, customerID, orderID)
 	if err != nil {
 		return nil, err
 	}
-	for i := range orders {
-		for bookIdx := range orders[i].Items {
-			orders[i].Items[bookIdx].BookTitle = booksMap[orders[i].Items[bookIdx].BookID].Title
-		}
+	if o == nil {
+		return nil, errOrderNotFound
 	}
 
-	return orders, nil
+	o.Payment, err = s.paymentService.GetPayment(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
 
+	return o, nil
 }
 
 type OrderRequestItem struct {
@@ -97,8 +385,71 @@ type OrderRequestItem struct {
 	Quantity int   `json:"quantity"`
 }
 
-func (s *Service) MakeOrder(ctx context.Context, customerID int64, items []OrderRequestItem) (*Order, error) {
+// OrderRequest is what the customer asks for, PaymentToken represents the payment method at the payment provider,
+// CouponCode is an optional discount code and AddressID picks the address book entry the order is shipped to,
+// the default address is used without it. Only orders that are picked up can do without an address, Destination
+// decides their tax then. ShippingMethod defaults to the standard one
+type OrderRequest struct {
+	Items          []OrderRequestItem `json:"items"`
+	PaymentToken   string             `json:"payment_token"`
+	CouponCode     string             `json:"coupon_code,omitempty"`
+	AddressID      *int64             `json:"address_id,omitempty"`
+	Destination    tax.Destination    `json:"destination"`
+	ShippingMethod shipping.Method    `json:"shipping_method,omitempty"`
+	// FromCart is set by the checkout of the cart, never by the clients
+	FromCart bool `json:"-"`
+}
 
+// shippingAddress returns the address the order asks for, or the default address of the customer. Orders that are
+// delivered can't do without one, the others return nil when they have a destination and don't pick an address
+func (s *Service) shippingAddress(ctx context.Context, customerID int64, addressID *int64, destination tax.Destination, delivered bool) (*ShippingAddress, error) {
+	if addressID != nil {
+		a, err := s.addressService.GetAddress(ctx, customerID, *addressID)
+		if err != nil {
+			return nil, err
+		}
+		return newShippingAddress(a), nil
+	}
+
+	if !delivered && destination != (tax.Destination{}) {
+		return nil, nil
+	}
+
+	a, err := s.addressService.GetDefaultAddress(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		if delivered {
+			return nil, errMissingDelivery
+		}
+		return nil, errMissingAddress
+	}
+	return newShippingAddress(a), nil
+}
+
+// resolveDestination returns the shipping address of shippingAddress and the validated destination the order is taxed
+// and shipped by, which comes from the address when there is one
+func (s *Service) resolveDestination(ctx context.Context, customerID int64, addressID *int64, requested tax.Destination, delivered bool) (*ShippingAddress, tax.Destination, error) {
+	address, err := s.shippingAddress(ctx, customerID, addressID, requested, delivered)
+	if err != nil {
+		return nil, tax.Destination{}, err
+	}
+
+	destination := requested
+	if address != nil {
+		destination = tax.Destination{Country: address.Country, Region: address.Region}
+	}
+	destination = destination.Normalize()
+	if err := destination.Validate(); err != nil {
+		return nil, tax.Destination{}, err
+	}
+
+	return address, destination, nil
+}
+
+// validateItems checks the requested items and returns their book IDs
+func validateItems(items []OrderRequestItem) ([]int64, error) {
 	if len(items) == 0 {
 		return nil, errEmptyBooksArr
 	}
@@ -124,12 +475,81 @@ func (s *Service) MakeOrder(ctx context.Context, customerID int64, items []Order
 		bookIDs = append(bookIDs, item.BookID)
 	}
 
+	return bookIDs, nil
+}
+
+// parcel returns the number of units and the weight of the items
+func parcel(items []OrderRequestItem, books map[int64]book.Information) shipping.Parcel {
+	var p shipping.Parcel
+	for _, v := range items {
+		p.Items += v.Quantity
+		p.Weight += books[v.BookID].Weight * v.Quantity
+	}
+	return p
+}
+
+// ShippingQuoteRequest is what a quote is asked for, the items are shipped like in an OrderRequest
+type ShippingQuoteRequest struct {
+	Items       []OrderRequestItem `json:"items"`
+	AddressID   *int64             `json:"address_id,omitempty"`
+	Destination tax.Destination    `json:"destination"`
+}
+
+// QuoteShipping returns how much shipping the items costs with every method available for their destination
+func (s *Service) QuoteShipping(ctx context.Context, customerID int64, request ShippingQuoteRequest) ([]shipping.Option, error) {
+	bookIDs, err := validateItems(request.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	// quotes only need to know where the books would go, a destination is enough
+	_, destination, err := s.resolveDestination(ctx, customerID, request.AddressID, request.Destination, false)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.bookService.GetBooksInformation(ctx, bookIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.shippingCalculator.Quote(ctx, destination, parcel(request.Items, m))
+}
+
+// MakeOrder applies the coupon of the request, prices its shipping, taxes the order, authorizes its payment and stores it
+// as paid, the authorization is voided if the order can't be stored. Orders that end up free don't need a payment
+func (s *Service) MakeOrder(ctx context.Context, customerID int64, request OrderRequest) (*Order, error) {
+	if err := s.verification.CheckCanOrder(ctx, customerID); err != nil {
+		return nil, err
+	}
+
+	items := request.Items
+	bookIDs, err := validateItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	delivered := request.ShippingMethod != shipping.MethodPickup
+	address, destination, err := s.resolveDestination(ctx, customerID, request.AddressID, request.Destination, delivered)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check if all books exist, get their prices
 	m, err := s.bookService.GetBooksInformation(ctx, bookIDs)
 	if err != nil {
 		return nil, err
 	}
 
+	options, err := s.shippingCalculator.Quote(ctx, destination, parcel(items, m))
+	if err != nil {
+		return nil, err
+	}
+	option, err := shipping.Select(options, request.ShippingMethod)
+	if err != nil {
+		return nil, err
+	}
+
 	// fill up the unit prices of each item
 	orderItems := make([]OrderItem, len(items))
 	for i := range items {
@@ -141,17 +561,79 @@ func (s *Service) MakeOrder(ctx context.Context, customerID int64, items []Order
 		}
 	}
 
-	t := time.Now()
+	o := Order{
+		Currency:        money.Currency,
+		Status:          StatusPaid,
+		OrderDate:       time.Now(),
+		Items:           orderItems,
+		ShippingAddress: address,
+		ShippingMethod:  option.Method,
+		ShippingCost:    option.Cost,
+		FromCart:        request.FromCart,
+	}
+
+	if request.CouponCode != "" {
+		lines := make([]promotion.Line, len(orderItems))
+		for i, v := range orderItems {
+			lines[i] = promotion.Line{BookID: v.BookID, Author: m[v.BookID].Author, Price: v.Price, Quantity: v.Quantity}
+		}
+		redemption, err := s.promotionService.Apply(ctx, customerID, request.CouponCode, lines)
+		if err != nil {
+			return nil, err
+		}
+		o.Discount = redemption.Discount
+		o.CouponCode = redemption.Code
+	}
+
+	// tax what is left of every item after the discount
+	amounts := taxableAmounts(orderItems, o.Discount)
+	lines := make([]tax.Line, len(orderItems))
+	for i, v := range orderItems {
+		lines[i] = tax.Line{BookID: v.BookID, Amount: amounts[i]}
+	}
+	taxes, err := s.taxCalculator.Calculate(ctx, destination, lines)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orderItems {
+		orderItems[i].TaxRate = taxes[i].Rate
+		orderItems[i].Tax = taxes[i].Tax
+	}
+	o.Destination = &destination
+
+	o.fillTotals()
+
+	if o.Total > 0 {
+		o.Payment, err = s.paymentService.Authorize(ctx, o.Total, o.Currency, request.PaymentToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// store the order
-	orderID, err := s.repository.SaveOrder(ctx, customerID, t, orderItems)
+	orderID, err := s.repository.SaveOrder(ctx, customerID, o)
 	if err != nil {
+		if o.Payment != nil {
+			if releaseErr := s.paymentService.Release(ctx, *o.Payment); releaseErr != nil {
+				slog.Error("error voiding the payment of an order that wasn't stored", "authorization", o.Payment.AuthorizationID, "error", releaseErr)
+			}
+		}
+
+		var stockErr *OutOfStockError
+		if errors.As(err, &stockErr) {
+			return nil, fmt.Errorf("%w: book %d", errOutOfStock, stockErr.BookID)
+		}
+		var cartErr *CartChangedError
+		if errors.As(err, &cartErr) {
+			return nil, fmt.Errorf("%w: book %d", errCartChanged, cartErr.BookID)
+		}
 		return nil, fmt.Errorf("order creation failed: %w", err)
 	}
 
-	return &Order{
-		ID:        *orderID,
-		Total:     CalculateTotal(orderItems),
-		OrderDate: t,
-		Items:     orderItems,
-	}, nil
+	o.ID = *orderID
+	if o.Payment != nil {
+		o.Payment.OrderID = *orderID
+	}
+
+	return &o, nil
 }
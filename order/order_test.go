@@ -3,75 +3,239 @@ package order
 import (
 	"context"
 	"errors"
+	"github.com/ap-pauloafonso/bookstore/book"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/payment"
+	"github.com/ap-pauloafonso/bookstore/promotion"
+	"github.com/ap-pauloafonso/bookstore/shipping"
+	"github.com/ap-pauloafonso/bookstore/tax"
+	"github.com/ap-pauloafonso/bookstore/utils"
 	"testing"
 	"time"
 )
 
 type MockRepository struct {
-	SaveOrderFunc           func(ctx context.Context, customerID int64, orderDate time.Time, items []OrderItem) (*int64, error)
-	GetOrdersByCustomerFunc func(ctx context.Context, customerID int64) ([]Order, error)
+	SaveOrderFunc         func(ctx context.Context, customerID int64, o Order) (*int64, error)
+	ListOrdersFunc        func(ctx context.Context, query Query) ([]Order, error)
+	GetOrderByIDFunc      func(ctx context.Context, customerID, orderID int64) (*Order, error)
+	GetOrderStateFunc     func(ctx context.Context, orderID int64) (*State, error)
+	UpdateOrderStatusFunc func(ctx context.Context, change StatusChange) (*StatusChange, error)
+	GetStatusHistoryFunc  func(ctx context.Context, orderID int64) ([]StatusChange, error)
+	// ClaimedBy is the status another transition claimed, claims of other statuses fail while it's set
+	ClaimedBy Status
+	Released  bool
 }
 
-func (m *MockRepository) SaveOrder(ctx context.Context, customerID int64, orderDate time.Time, items []OrderItem) (*int64, error) {
-	return m.SaveOrderFunc(ctx, customerID, orderDate, items)
+func (m *MockRepository) SaveOrder(ctx context.Context, customerID int64, o Order) (*int64, error) {
+	return m.SaveOrderFunc(ctx, customerID, o)
 }
 
-func (m *MockRepository) GetOrdersByCustomer(ctx context.Context, customerID int64) ([]Order, error) {
-	return m.GetOrdersByCustomerFunc(ctx, customerID)
+func (m *MockRepository) ListOrders(ctx context.Context, query Query) ([]Order, error) {
+	return m.ListOrdersFunc(ctx, query)
+}
+
+func (m *MockRepository) GetOrderByID(ctx context.Context, customerID, orderID int64) (*Order, error) {
+	return m.GetOrderByIDFunc(ctx, customerID, orderID)
+}
+
+func (m *MockRepository) GetOrderState(ctx context.Context, orderID int64) (*State, error) {
+	return m.GetOrderStateFunc(ctx, orderID)
+}
+
+func (m *MockRepository) ClaimStatusChange(ctx context.Context, orderID int64, from, to Status) (bool, error) {
+	if m.ClaimedBy != "" && m.ClaimedBy != to {
+		return false, nil
+	}
+	m.ClaimedBy = to
+	return true, nil
+}
+
+func (m *MockRepository) ReleaseStatusChange(ctx context.Context, orderID int64, to Status) error {
+	m.ClaimedBy = ""
+	m.Released = true
+	return nil
+}
+
+func (m *MockRepository) UpdateOrderStatus(ctx context.Context, change StatusChange) (*StatusChange, error) {
+	return m.UpdateOrderStatusFunc(ctx, change)
+}
+
+func (m *MockRepository) GetStatusHistory(ctx context.Context, orderID int64) ([]StatusChange, error) {
+	return m.GetStatusHistoryFunc(ctx, orderID)
 }
 
 type MockBookService struct {
-	GetBookPricesFunc func(ctx context.Context, bookIDs []int64) (map[int64]struct {
-		Price float64
-		Title string
-	}, error)
+	GetBookPricesFunc func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error)
 }
 
-func (m *MockBookService) GetBooksInformation(ctx context.Context, bookIDs []int64) (map[int64]struct {
-	Price float64
-	Title string
-}, error) {
+func (m *MockBookService) GetBooksInformation(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
 	return m.GetBookPricesFunc(ctx, bookIDs)
 }
 
+// MockPaymentService approves every payment unless AuthorizeErr is set, and keeps track of what happened to them
+type MockPaymentService struct {
+	AuthorizeErr error
+	SettleErr    error
+	Released     bool
+	Settled      []string
+}
+
+func (m *MockPaymentService) Authorize(ctx context.Context, amount money.Amount, currency, token string) (*payment.Payment, error) {
+	if m.AuthorizeErr != nil {
+		return nil, m.AuthorizeErr
+	}
+	return &payment.Payment{AuthorizationID: "auth_" + token, Amount: amount, Currency: currency, Status: payment.StatusAuthorized}, nil
+}
+
+func (m *MockPaymentService) Release(ctx context.Context, p payment.Payment) error {
+	m.Released = true
+	return nil
+}
+
+func (m *MockPaymentService) GetPayment(ctx context.Context, orderID int64) (*payment.Payment, error) {
+	return nil, nil
+}
+
+func (m *MockPaymentService) settle(operation string) error {
+	if m.SettleErr != nil {
+		return m.SettleErr
+	}
+	m.Settled = append(m.Settled, operation)
+	return nil
+}
+
+func (m *MockPaymentService) Capture(ctx context.Context, orderID int64) error {
+	return m.settle("capture")
+}
+
+func (m *MockPaymentService) Void(ctx context.Context, orderID int64) error {
+	return m.settle("void")
+}
+
+func (m *MockPaymentService) RefundAll(ctx context.Context, orderID int64) error {
+	return m.settle("refund")
+}
+
+// testTaxes doesn't tax the US but California, at 10% with a reduced 5% for book 2
+var testTaxes, _ = tax.NewTable([]tax.Rule{
+	{Country: "US"},
+	{Country: "US", Region: "CA", Rate: 1000, BookRates: map[int64]tax.Rate{2: 500}},
+})
+
+// testShipping ships to the US and to France, where testTaxes doesn't, for free with the standard method so it doesn't
+// change the totals of the other tests. Orders can only be picked up in the US
+var testShipping, _ = shipping.NewTable(shipping.Rules{
+	Zones: []shipping.Zone{{Name: "domestic", Countries: []string{"US"}}, {Name: "europe", Countries: []string{"FR"}}},
+	Rates: []shipping.Rate{
+		{Method: shipping.MethodStandard, Zone: "domestic", MinDays: 3, MaxDays: 5},
+		{Method: shipping.MethodPickup, Zone: "domestic", MinDays: 0, MaxDays: 1},
+		{Method: shipping.MethodStandard, Zone: "europe", MinDays: 7, MaxDays: 14},
+		{Method: shipping.MethodExpress, Zone: "domestic", Base: 1000, PerItem: 100, PerKg: 200, MinDays: 1, MaxDays: 2},
+	},
+})
+
+// MockPromotionService takes Discount off every order unless Err is set, and keeps the lines it was asked to discount
+type MockPromotionService struct {
+	Discount money.Amount
+	Err      error
+	Lines    []promotion.Line
+}
+
+func (m *MockPromotionService) Apply(ctx context.Context, customerID int64, code string, lines []promotion.Line) (*promotion.Redemption, error) {
+	m.Lines = lines
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &promotion.Redemption{Code: promotion.NormalizeCode(code), Discount: m.Discount}, nil
+}
+
+var errMockAddressNotFound = errors.New("address not found")
+
+// addressesAt returns an address book whose default address is at the destination, so the orders can be delivered
+func addressesAt(destination tax.Destination) *MockAddressService {
+	return &MockAddressService{Default: &customer.Address{ID: 1, Recipient: "John", Line1: "1 Main St", City: "Springfield",
+		Region: destination.Region, Country: destination.Country, Default: true}}
+}
+
+// MockAddressService has the addresses of customer 1, the Default one is returned when no address is picked
+type MockAddressService struct {
+	Addresses map[int64]customer.Address
+	Default   *customer.Address
+}
+
+func (m *MockAddressService) GetAddress(ctx context.Context, customerID, addressID int64) (*customer.Address, error) {
+	a, ok := m.Addresses[addressID]
+	if !ok || customerID != 1 {
+		return nil, errMockAddressNotFound
+	}
+	return &a, nil
+}
+
+func (m *MockAddressService) GetDefaultAddress(ctx context.Context, customerID int64) (*customer.Address, error) {
+	return m.Default, nil
+}
+
+// MockVerificationService lets every customer order unless Err is set
+type MockVerificationService struct {
+	Err error
+}
+
+func (m *MockVerificationService) CheckCanOrder(ctx context.Context, customerID int64) error {
+	return m.Err
+}
+
 func TestCalculateTotal(t *testing.T) {
 	tests := []struct {
 		name          string
 		items         []OrderItem
-		expectedTotal float64
+		discount      money.Amount
+		expectedTotal money.Amount
 	}{
 		{
 			name:          "SingleItem",
-			items:         []OrderItem{{BookID: 1, Quantity: 2, Price: 10.0}},
-			expectedTotal: 20.0,
+			items:         []OrderItem{{BookID: 1, Quantity: 2, Price: 1000}},
+			expectedTotal: 2000,
 		},
 		{
 			name:          "MultipleItems",
-			items:         []OrderItem{{BookID: 1, Quantity: 2, Price: 10.0}, {BookID: 2, Quantity: 1, Price: 20.0}},
-			expectedTotal: 40.0,
+			items:         []OrderItem{{BookID: 1, Quantity: 2, Price: 1000}, {BookID: 2, Quantity: 1, Price: 2000}},
+			expectedTotal: 4000,
 		},
 		{
 			name:          "EmptyItems",
 			items:         []OrderItem{},
-			expectedTotal: 0.0,
+			expectedTotal: 0,
 		},
 		{
 			name:          "MixedItems",
-			items:         []OrderItem{{BookID: 1, Quantity: 2, Price: 10.0}, {BookID: 2, Quantity: 1, Price: 20.0}},
-			expectedTotal: 40.0,
+			items:         []OrderItem{{BookID: 1, Quantity: 2, Price: 1000}, {BookID: 2, Quantity: 1, Price: 2000}},
+			expectedTotal: 4000,
 		},
 		{
 			name:          "LargeQuantity",
-			items:         []OrderItem{{BookID: 1, Quantity: 1000, Price: 0.1}},
-			expectedTotal: 100.0,
+			items:         []OrderItem{{BookID: 1, Quantity: 1000, Price: 10}},
+			expectedTotal: 10000,
+		},
+		{
+			name:          "Discount",
+			items:         []OrderItem{{BookID: 1, Quantity: 2, Price: 1000}},
+			discount:      350,
+			expectedTotal: 1650,
+		},
+		{
+			name:          "DiscountOverTotal",
+			items:         []OrderItem{{BookID: 1, Quantity: 1, Price: 1000}},
+			discount:      1500,
+			expectedTotal: 0,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			total := CalculateTotal(tt.items)
+			total := CalculateTotal(tt.items, tt.discount)
 			if total != tt.expectedTotal {
-				t.Errorf("Expected total: %f, got: %f", tt.expectedTotal, total)
+				t.Errorf("Expected total: %s, got %s", tt.expectedTotal, total)
 			}
 		})
 	}
@@ -82,19 +246,18 @@ func TestService_MakeOrder(t *testing.T) {
 	bookServiceErr := errors.New("BookService error")
 
 	saveOrdeErr := errors.New("failed to save")
+	declinedErr := &payment.DeclinedError{Reason: "card declined"}
 	tests := []struct {
-		name          string
-		items         []OrderRequestItem
-		expectedOrder *Order
-		expectedError error
+		name           string
+		items          []OrderRequestItem
+		authorizeErr   error
+		expectedOrder  *Order
+		expectedError  error
+		expectReleased bool
 
-		SaveOrderFunc           func(ctx context.Context, customerID int64, orderDate time.Time, items []OrderItem) (*int64, error)
-		GetOrdersByCustomerFunc func(ctx context.Context, customerID int64) ([]Order, error)
+		SaveOrderFunc func(ctx context.Context, customerID int64, o Order) (*int64, error)
 
-		getBooksInformation func(ctx context.Context, bookIDs []int64) (map[int64]struct {
-			Price float64
-			Title string
-		}, error)
+		getBooksInformation func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error)
 	}{
 		{
 			name: "ValidOrder",
@@ -102,25 +265,22 @@ func TestService_MakeOrder(t *testing.T) {
 				{BookID: 1, Quantity: 2},
 				{BookID: 2, Quantity: 1},
 			},
-			SaveOrderFunc: func(ctx context.Context, customerID int64, orderDate time.Time, items []OrderItem) (*int64, error) {
+			SaveOrderFunc: func(ctx context.Context, customerID int64, o Order) (*int64, error) {
+				if o.Status != StatusPaid || o.Payment == nil || o.Payment.Amount != o.Total {
+					return nil, errors.New("expected a paid order with its payment")
+				}
 				return new(int64), nil
 			},
-			getBooksInformation: func(ctx context.Context, bookIDs []int64) (map[int64]struct {
-				Price float64
-				Title string
-			}, error) {
-				return map[int64]struct {
-					Price float64
-					Title string
-				}{1: {10.0, "Book1"}, 2: {20.0, "Book2"}}, nil
+			getBooksInformation: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+				return map[int64]book.Information{1: {Price: 1000, Title: "Book1"}, 2: {Price: 2000, Title: "Book2"}}, nil
 			},
 			expectedOrder: &Order{
 				ID:        0,
-				Total:     40.0,
+				Total:     4000,
 				OrderDate: time.Time{},
 				Items: []OrderItem{
-					{BookID: 1, Quantity: 2, Price: 10.0},
-					{BookID: 2, Quantity: 1, Price: 20.0},
+					{BookID: 1, Quantity: 2, Price: 1000},
+					{BookID: 2, Quantity: 1, Price: 2000},
 				},
 			},
 			expectedError: nil,
@@ -131,28 +291,54 @@ func TestService_MakeOrder(t *testing.T) {
 				{BookID: 1, Quantity: 2},
 				{BookID: 2, Quantity: 1},
 			},
-			SaveOrderFunc: func(ctx context.Context, customerID int64, orderDate time.Time, items []OrderItem) (*int64, error) {
+			SaveOrderFunc: func(ctx context.Context, customerID int64, o Order) (*int64, error) {
 				return new(int64), saveOrdeErr
 			},
-			getBooksInformation: func(ctx context.Context, bookIDs []int64) (map[int64]struct {
-				Price float64
-				Title string
-			}, error) {
-				return map[int64]struct {
-					Price float64
-					Title string
-				}{1: {10.0, "Book1"}, 2: {20.0, "book2"}}, nil
+			getBooksInformation: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+				return map[int64]book.Information{1: {Price: 1000, Title: "Book1"}, 2: {Price: 2000, Title: "book2"}}, nil
 			},
 			expectedOrder: &Order{
 				ID:        0,
-				Total:     40.0,
+				Total:     4000,
 				OrderDate: time.Time{},
 				Items: []OrderItem{
-					{BookID: 1, Quantity: 2, Price: 10.0},
-					{BookID: 2, Quantity: 1, Price: 20.0},
+					{BookID: 1, Quantity: 2, Price: 1000},
+					{BookID: 2, Quantity: 1, Price: 2000},
 				},
 			},
-			expectedError: saveOrdeErr,
+			expectedError:  saveOrdeErr,
+			expectReleased: true,
+		},
+		{
+			name: "OutOfStock",
+			items: []OrderRequestItem{
+				{BookID: 1, Quantity: 2},
+				{BookID: 2, Quantity: 1},
+			},
+			SaveOrderFunc: func(ctx context.Context, customerID int64, o Order) (*int64, error) {
+				return nil, &OutOfStockError{BookID: 2}
+			},
+			getBooksInformation: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+				return map[int64]book.Information{1: {Price: 1000, Title: "Book1"}, 2: {Price: 2000, Title: "book2"}}, nil
+			},
+			expectedOrder:  nil,
+			expectedError:  errOutOfStock,
+			expectReleased: true,
+		},
+		{
+			name: "PaymentDeclined",
+			items: []OrderRequestItem{
+				{BookID: 1, Quantity: 2},
+			},
+			authorizeErr: declinedErr,
+			SaveOrderFunc: func(ctx context.Context, customerID int64, o Order) (*int64, error) {
+				return nil, errors.New("the order shouldn't be stored")
+			},
+			getBooksInformation: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+				return map[int64]book.Information{1: {Price: 1000, Title: "Book1"}}, nil
+			},
+			expectedOrder: nil,
+			expectedError: declinedErr,
 		},
 		{
 			name:  "EmptyItems",
@@ -194,10 +380,7 @@ func TestService_MakeOrder(t *testing.T) {
 				{BookID: 1, Quantity: 2},
 				{BookID: 2, Quantity: 1},
 			},
-			getBooksInformation: func(ctx context.Context, bookIDs []int64) (map[int64]struct {
-				Price float64
-				Title string
-			}, error) {
+			getBooksInformation: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
 				return nil, bookServiceErr
 			},
 			expectedOrder: nil,
@@ -210,17 +393,18 @@ func TestService_MakeOrder(t *testing.T) {
 
 			// Create a mock repository and book service.
 			mockRepo := &MockRepository{
-				SaveOrderFunc:           tt.SaveOrderFunc,
-				GetOrdersByCustomerFunc: tt.GetOrdersByCustomerFunc,
+				SaveOrderFunc: tt.SaveOrderFunc,
 			}
 			mockBookService := &MockBookService{
 				GetBookPricesFunc: tt.getBooksInformation,
 			}
 
+			mockPaymentService := &MockPaymentService{AuthorizeErr: tt.authorizeErr}
+
 			// Create the service with the mock repository and book service.
-			service := NewService(mockRepo, mockBookService)
+			service := NewService(mockRepo, mockBookService, mockPaymentService, &MockPromotionService{}, addressesAt(tax.Destination{Country: "US"}), &MockVerificationService{}, testTaxes, testShipping, time.Hour)
 
-			order, err := service.MakeOrder(context.Background(), 1, tt.items)
+			order, err := service.MakeOrder(context.Background(), 1, OrderRequest{Items: tt.items, PaymentToken: "tok_visa"})
 
 			if err != nil {
 				if tt.expectedError == nil || !errors.Is(err, tt.expectedError) {
@@ -230,6 +414,16 @@ func TestService_MakeOrder(t *testing.T) {
 				t.Errorf("Expected error: %v, got nil", tt.expectedError)
 			}
 
+			if IsOutOfStock(err) != (tt.expectedError == errOutOfStock) {
+				t.Errorf("IsOutOfStock mismatch for error: %v", err)
+			}
+			if payment.IsDeclined(err) != (tt.authorizeErr != nil) {
+				t.Errorf("IsDeclined mismatch for error: %v", err)
+			}
+			if mockPaymentService.Released != tt.expectReleased {
+				t.Errorf("Expected the authorization to be voided: %v, got: %v", tt.expectReleased, mockPaymentService.Released)
+			}
+
 			if order != nil {
 				if tt.expectedOrder == nil {
 					t.Errorf("Expected nil order, got: %v", order)
@@ -238,7 +432,13 @@ func TestService_MakeOrder(t *testing.T) {
 						t.Errorf("Expected ID: %v, got: %v", tt.expectedOrder.ID, order.ID)
 					}
 					if order.Total != tt.expectedOrder.Total {
-						t.Errorf("Expected total: %f, got: %f", tt.expectedOrder.Total, order.Total)
+						t.Errorf("Expected total: %s, got %s", tt.expectedOrder.Total, order.Total)
+					}
+					if order.Status != StatusPaid {
+						t.Errorf("Expected a new order to be paid, got: %s", order.Status)
+					}
+					if order.Payment == nil || order.Payment.Amount != order.Total || order.Payment.AuthorizationID != "auth_tok_visa" {
+						t.Errorf("Expected the order to carry its payment, got: %+v", order.Payment)
 					}
 				}
 			}
@@ -246,80 +446,514 @@ func TestService_MakeOrder(t *testing.T) {
 	}
 }
 
-func TestService_GetOrdersByCustomer(t *testing.T) {
-	repoErr := errors.New("repo err")
-	booksInfoErr := errors.New("error retrieving books info")
-	// Prepare some sample data for testing
-	orders := []Order{
-		{
-			ID:        1,
-			OrderDate: time.Now(),
-			Items: []OrderItem{
-				{BookID: 1, Quantity: 2, Price: 10.0},
-				{BookID: 2, Quantity: 1, Price: 20.0},
-			},
+func TestService_MakeOrder_Coupon(t *testing.T) {
+	notApplicableErr := &promotion.UsageLimitError{Code: "SAVE10"}
+	books := &MockBookService{
+		GetBookPricesFunc: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+			return map[int64]book.Information{1: {Price: 1000, Title: "Book1", Author: "Author1"}, 2: {Price: 2000, Title: "Book2", Author: "Author2"}}, nil
 		},
+	}
+	items := []OrderRequestItem{{BookID: 1, Quantity: 2}, {BookID: 2, Quantity: 1}}
+
+	tests := []struct {
+		name           string
+		promotions     *MockPromotionService
+		saveErr        error
+		expectedError  error
+		expectedTotal  money.Amount
+		expectPayment  bool
+		expectReleased bool
+	}{
 		{
-			ID:        2,
-			OrderDate: time.Now(),
-			Items: []OrderItem{
-				{BookID: 3, Quantity: 3, Price: 30.0},
-				{BookID: 4, Quantity: 2, Price: 40.0},
-			},
+			name:          "Discounted",
+			promotions:    &MockPromotionService{Discount: 400},
+			expectedTotal: 3600,
+			expectPayment: true,
+		},
+		{
+			name:          "NotApplicable",
+			promotions:    &MockPromotionService{Err: notApplicableErr},
+			expectedError: notApplicableErr,
+		},
+		{
+			name:          "FreeOrder",
+			promotions:    &MockPromotionService{Discount: 4000},
+			expectedTotal: 0,
+		},
+		{
+			name:           "UsedUpConcurrently",
+			promotions:     &MockPromotionService{Discount: 400},
+			saveErr:        notApplicableErr,
+			expectedError:  notApplicableErr,
+			expectReleased: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved Order
+			repo := &MockRepository{
+				SaveOrderFunc: func(ctx context.Context, customerID int64, o Order) (*int64, error) {
+					if tt.saveErr != nil {
+						return nil, tt.saveErr
+					}
+					saved = o
+					id := int64(7)
+					return &id, nil
+				},
+			}
+			payments := &MockPaymentService{}
+			service := NewService(repo, books, payments, tt.promotions, addressesAt(tax.Destination{Country: "US"}), &MockVerificationService{}, testTaxes, testShipping, time.Hour)
+
+			o, err := service.MakeOrder(context.Background(), 1, OrderRequest{Items: items, PaymentToken: "tok_visa", CouponCode: "save10"})
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+			if tt.expectedError != nil && !promotion.IsNotApplicable(err) {
+				t.Errorf("Expected a not applicable coupon error, got: %v", err)
+			}
+			if payments.Released != tt.expectReleased {
+				t.Errorf("Expected the authorization to be voided: %v, got: %v", tt.expectReleased, payments.Released)
+			}
+			if len(tt.promotions.Lines) != 2 || tt.promotions.Lines[1].Author != "Author2" || tt.promotions.Lines[1].Price != 2000 {
+				t.Errorf("Expected the coupon to see the order lines, got: %+v", tt.promotions.Lines)
+			}
+			if err != nil {
+				return
+			}
+
+			if o.Total != tt.expectedTotal || saved.Total != tt.expectedTotal {
+				t.Errorf("Expected total: %s, got %s", tt.expectedTotal, o.Total)
+			}
+			if saved.Discount != tt.promotions.Discount || saved.CouponCode != "SAVE10" {
+				t.Errorf("Expected the discount to be stored with the order, got: %s %q", saved.Discount, saved.CouponCode)
+			}
+			if (o.Payment != nil) != tt.expectPayment {
+				t.Fatalf("Expected a payment: %v, got: %+v", tt.expectPayment, o.Payment)
+			}
+			if o.Payment != nil && (o.Payment.Amount != tt.expectedTotal || o.Payment.OrderID != 7) {
+				t.Errorf("Expected the discounted total to be authorized, got: %+v", o.Payment)
+			}
+		})
+	}
+}
+
+func TestService_MakeOrder_Tax(t *testing.T) {
+	books := &MockBookService{
+		GetBookPricesFunc: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+			return map[int64]book.Information{1: {Price: 1000, Title: "Book1"}, 2: {Price: 2000, Title: "Book2"}}, nil
+		},
+	}
+	items := []OrderRequestItem{{BookID: 1, Quantity: 2}, {BookID: 2, Quantity: 1}}
+
+	tests := []struct {
+		name             string
+		destination      tax.Destination
+		discount         money.Amount
+		expectedError    error
+		expectedTaxes    []money.Amount
+		expectedSubtotal money.Amount
+		expectedTotal    money.Amount
+	}{
+		{
+			name:             "Untaxed",
+			destination:      tax.Destination{Country: "us", Region: "OR"},
+			expectedTaxes:    []money.Amount{0, 0},
+			expectedSubtotal: 4000,
+			expectedTotal:    4000,
+		},
+		{
+			name:             "ReducedRate",
+			destination:      tax.Destination{Country: "US", Region: "ca"},
+			expectedTaxes:    []money.Amount{200, 100},
+			expectedSubtotal: 4000,
+			expectedTotal:    4300,
+		},
+		{
+			// 10.00 off 40.00 leaves 15.00 of book 1 and 15.00 of book 2
+			name:             "DiscountIsNotTaxed",
+			destination:      tax.Destination{Country: "US", Region: "CA"},
+			discount:         1000,
+			expectedTaxes:    []money.Amount{150, 75},
+			expectedSubtotal: 4000,
+			expectedTotal:    3225,
+		},
+		{
+			name:          "UnsupportedDestination",
+			destination:   tax.Destination{Country: "FR"},
+			expectedError: tax.Destination{}.Validate(),
+		},
+		{
+			name:          "InvalidDestination",
+			destination:   tax.Destination{Country: "USA"},
+			expectedError: tax.Destination{}.Validate(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved Order
+			repo := &MockRepository{
+				SaveOrderFunc: func(ctx context.Context, customerID int64, o Order) (*int64, error) {
+					saved = o
+					return new(int64), nil
+				},
+			}
+			payments := &MockPaymentService{}
+			service := NewService(repo, books, payments, &MockPromotionService{Discount: tt.discount}, addressesAt(tt.destination), &MockVerificationService{}, testTaxes, testShipping, time.Hour)
+
+			request := OrderRequest{Items: items, PaymentToken: "tok_visa"}
+			if tt.discount > 0 {
+				request.CouponCode = "SAVE10"
+			}
+			o, err := service.MakeOrder(context.Background(), 1, request)
+			if tt.expectedError != nil {
+				if err == nil {
+					t.Fatalf("Expected an error, got: %+v", o)
+				}
+				if tax.IsUnsupportedDestination(err) != (tt.name == "UnsupportedDestination") {
+					t.Errorf("IsUnsupportedDestination mismatch for error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			for i, v := range tt.expectedTaxes {
+				if saved.Items[i].Tax != v {
+					t.Errorf("Item %d: Expected tax %s, got %s", i, v, saved.Items[i].Tax)
+				}
+			}
+			if saved.Subtotal != tt.expectedSubtotal || saved.Total != tt.expectedTotal || o.Payment.Amount != tt.expectedTotal {
+				t.Errorf("Expected subtotal %s and total %s, got: %s, %s", tt.expectedSubtotal, tt.expectedTotal, saved.Subtotal, saved.Total)
+			}
+			if saved.Destination == nil || saved.Destination.Country != "US" {
+				t.Errorf("Expected the normalized destination to be stored, got: %+v", saved.Destination)
+			}
+		})
+	}
+}
+
+func TestService_MakeOrder_Address(t *testing.T) {
+	books := &MockBookService{
+		GetBookPricesFunc: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+			return map[int64]book.Information{1: {Price: 1000, Title: "Book1"}}, nil
+		},
+	}
+	home := customer.Address{ID: 1, Recipient: "John", Line1: "1 Main St", City: "Los Angeles", Region: "CA", PostalCode: "90001", Country: "US", Default: true}
+	work := customer.Address{ID: 2, Recipient: "John", Line1: "2 Market St", City: "Portland", Region: "OR", Country: "US"}
+	addressID := func(id int64) *int64 { return &id }
+
+	tests := []struct {
+		name            string
+		addressID       *int64
+		destination     tax.Destination
+		method          shipping.Method
+		defaultAddress  *customer.Address
+		expectedError   error
+		expectedAddress *customer.Address
+		expectedTax     money.Amount
+	}{
+		{name: "PickedAddress", addressID: addressID(2), defaultAddress: &home, expectedAddress: &work},
+		{name: "PickedAddressWinsOverDestination", addressID: addressID(1), destination: tax.Destination{Country: "US"}, expectedAddress: &home, expectedTax: 100},
+		{name: "DefaultAddress", defaultAddress: &home, expectedAddress: &home, expectedTax: 100},
+		{name: "DeliveredToTheDefaultAddressOverDestination", destination: tax.Destination{Country: "US", Region: "CA"}, defaultAddress: &work, expectedAddress: &work},
+		{name: "DestinationIsNotDelivered", destination: tax.Destination{Country: "US", Region: "CA"}, expectedError: errMissingDelivery},
+		{name: "PickupTaxedByDestination", destination: tax.Destination{Country: "US", Region: "CA"}, method: shipping.MethodPickup, defaultAddress: &work, expectedTax: 100},
+		{name: "PickupWithDefaultAddress", method: shipping.MethodPickup, defaultAddress: &home, expectedAddress: &home, expectedTax: 100},
+		{name: "UnknownAddress", addressID: addressID(3), expectedError: errMockAddressNotFound},
+		{name: "MissingAddress", expectedError: errMissingDelivery},
+		{name: "MissingAddressForPickup", method: shipping.MethodPickup, expectedError: errMissingAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved Order
+			repo := &MockRepository{
+				SaveOrderFunc: func(ctx context.Context, customerID int64, o Order) (*int64, error) {
+					saved = o
+					return new(int64), nil
+				},
+			}
+			addresses := &MockAddressService{Addresses: map[int64]customer.Address{1: home, 2: work}, Default: tt.defaultAddress}
+			service := NewService(repo, books, &MockPaymentService{}, &MockPromotionService{}, addresses, &MockVerificationService{}, testTaxes, testShipping, time.Hour)
+
+			request := OrderRequest{Items: []OrderRequestItem{{BookID: 1, Quantity: 1}}, PaymentToken: "tok_visa", AddressID: tt.addressID, Destination: tt.destination,
+				ShippingMethod: tt.method}
+			_, err := service.MakeOrder(context.Background(), 1, request)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if tt.expectedAddress == nil {
+				if saved.ShippingAddress != nil {
+					t.Fatalf("Expected no shipping address, got: %+v", saved.ShippingAddress)
+				}
+			} else if saved.ShippingAddress == nil || *saved.ShippingAddress != *newShippingAddress(tt.expectedAddress) {
+				t.Fatalf("Expected a copy of %+v, got: %+v", tt.expectedAddress, saved.ShippingAddress)
+			}
+			if saved.Tax != tt.expectedTax {
+				t.Errorf("Expected the tax of the address, %s, got: %s", tt.expectedTax, saved.Tax)
+			}
+		})
+	}
+}
+
+func TestService_MakeOrder_Shipping(t *testing.T) {
+	books := &MockBookService{
+		GetBookPricesFunc: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+			return map[int64]book.Information{1: {Price: 1000, Title: "Book1", Weight: 400}, 2: {Price: 2000, Title: "Book2", Weight: 900}}, nil
+		},
+	}
+	items := []OrderRequestItem{{BookID: 1, Quantity: 2}, {BookID: 2, Quantity: 1}}
+
+	tests := []struct {
+		name         string
+		method       shipping.Method
+		destination  tax.Destination
+		invalid      bool
+		unavailable  bool
+		expectedCost money.Amount
+	}{
+		{name: "DefaultsToStandard", destination: tax.Destination{Country: "US"}},
+		// 10.00 plus 1.00 for each of the 3 units and 2.00 for each of the 2 started kilograms
+		{name: "Express", method: shipping.MethodExpress, destination: tax.Destination{Country: "US"}, expectedCost: 1700},
+		{name: "ShippingIsNotTaxed", method: shipping.MethodExpress, destination: tax.Destination{Country: "US", Region: "CA"}, expectedCost: 1700},
+		{name: "UnavailableMethod", method: shipping.MethodPickup, destination: tax.Destination{Country: "FR"}, unavailable: true},
+		{name: "InvalidMethod", method: "drone", destination: tax.Destination{Country: "US"}, invalid: true},
+		{name: "UnsupportedDestination", destination: tax.Destination{Country: "DE"}, unavailable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved Order
+			repo := &MockRepository{
+				SaveOrderFunc: func(ctx context.Context, customerID int64, o Order) (*int64, error) {
+					saved = o
+					return new(int64), nil
+				},
+			}
+			service := NewService(repo, books, &MockPaymentService{}, &MockPromotionService{}, addressesAt(tt.destination), &MockVerificationService{}, testTaxes, testShipping, time.Hour)
+
+			o, err := service.MakeOrder(context.Background(), 1, OrderRequest{Items: items, PaymentToken: "tok_visa", ShippingMethod: tt.method})
+			if tt.invalid || tt.unavailable {
+				if err == nil {
+					t.Fatalf("Expected an error, got: %+v", o)
+				}
+				if shipping.IsUnavailable(err) != tt.unavailable {
+					t.Errorf("IsUnavailable mismatch for error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			expectedMethod := tt.method
+			if expectedMethod == "" {
+				expectedMethod = shipping.MethodStandard
+			}
+			if saved.ShippingMethod != expectedMethod || saved.ShippingCost != tt.expectedCost {
+				t.Fatalf("Expected %s shipping for %s, got: %s for %s", expectedMethod, tt.expectedCost, saved.ShippingMethod, saved.ShippingCost)
+			}
+			expectedTotal := 4000 + tt.expectedCost + saved.Tax
+			if saved.Total != expectedTotal || o.Payment.Amount != expectedTotal {
+				t.Errorf("Expected the shipping cost in the total %s, got: %s", expectedTotal, saved.Total)
+			}
+		})
+	}
+}
+
+func TestService_MakeOrder_UnverifiedEmail(t *testing.T) {
+	errNotVerified := errors.New("email not verified")
+	repo := &MockRepository{
+		SaveOrderFunc: func(ctx context.Context, customerID int64, o Order) (*int64, error) {
+			t.Fatal("Expected the order to not be saved")
+			return nil, nil
+		},
+	}
+	service := NewService(repo, &MockBookService{}, &MockPaymentService{}, &MockPromotionService{}, &MockAddressService{},
+		&MockVerificationService{Err: errNotVerified}, testTaxes, testShipping, time.Hour)
+
+	_, err := service.MakeOrder(context.Background(), 1, OrderRequest{Items: []OrderRequestItem{{BookID: 1, Quantity: 1}}, PaymentToken: "tok_visa",
+		Destination: tax.Destination{Country: "US"}})
+	if !errors.Is(err, errNotVerified) {
+		t.Fatalf("Expected error: %v, got: %v", errNotVerified, err)
+	}
+}
+
+func TestService_QuoteShipping(t *testing.T) {
+	books := &MockBookService{
+		GetBookPricesFunc: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+			return map[int64]book.Information{1: {Price: 1000, Title: "Book1", Weight: 1000}}, nil
 		},
 	}
+	home := customer.Address{ID: 1, Recipient: "John", Line1: "1 Main St", City: "Los Angeles", Region: "CA", Country: "US", Default: true}
+	service := NewService(&MockRepository{}, books, &MockPaymentService{}, &MockPromotionService{}, &MockAddressService{Default: &home}, &MockVerificationService{},
+		testTaxes, testShipping, time.Hour)
+
+	options, err := service.QuoteShipping(context.Background(), 1, ShippingQuoteRequest{Items: []OrderRequestItem{{BookID: 1, Quantity: 2}}})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := []shipping.Option{
+		{Method: shipping.MethodStandard, MinDays: 3, MaxDays: 5},
+		{Method: shipping.MethodPickup, MinDays: 0, MaxDays: 1},
+		{Method: shipping.MethodExpress, Cost: 1600, MinDays: 1, MaxDays: 2},
+	}
+	if len(options) != len(expected) || options[0] != expected[0] || options[1] != expected[1] || options[2] != expected[2] {
+		t.Fatalf("Expected options: %+v, got: %+v", expected, options)
+	}
+
+	_, err = service.QuoteShipping(context.Background(), 1, ShippingQuoteRequest{Items: []OrderRequestItem{{BookID: 1, Quantity: 0}}})
+	if !errors.Is(err, errInvalidBookQuantity) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidBookQuantity, err)
+	}
+
+	_, err = service.QuoteShipping(context.Background(), 1, ShippingQuoteRequest{Items: []OrderRequestItem{{BookID: 1, Quantity: 1}}, Destination: tax.Destination{Country: "BR"}})
+	if !shipping.IsUnavailable(err) {
+		t.Fatalf("Expected the destination to be unavailable, got: %v", err)
+	}
+}
+
+func TestTaxableAmounts(t *testing.T) {
+	items := []OrderItem{{BookID: 1, Quantity: 1, Price: 1000}, {BookID: 2, Quantity: 1, Price: 1000}, {BookID: 3, Quantity: 1, Price: 1000}}
+
+	amounts := taxableAmounts(items, 100)
+	var left money.Amount
+	for _, v := range amounts {
+		left += v
+	}
+	if left != 2900 || amounts[0] != 967 || amounts[1] != 966 || amounts[2] != 967 {
+		t.Fatalf("Expected the discount to be split exactly, got: %v", amounts)
+	}
+
+	for i, v := range taxableAmounts(items, 5000) {
+		if v != 0 {
+			t.Fatalf("Item %d: Expected nothing left to tax, got: %s", i, v)
+		}
+	}
+}
+
+func TestService_ListOrders(t *testing.T) {
+	repoErr := errors.New("repo err")
+	// Prepare some sample data for testing, newest first
+	newOrders := func() []Order {
+		return []Order{
+			{
+				ID:        3,
+				OrderDate: time.Now(),
+				Subtotal:  4000,
+				Total:     4000,
+				Items: []OrderItem{
+					{BookID: 1, BookTitle: "book1", Quantity: 2, Price: 1000},
+					{BookID: 2, BookTitle: "book2", Quantity: 1, Price: 2000},
+				},
+			},
+			{
+				ID:        2,
+				OrderDate: time.Now(),
+				Subtotal:  17000,
+				Total:     17000,
+				Items: []OrderItem{
+					{BookID: 3, Quantity: 3, Price: 3000},
+					{BookID: 4, Quantity: 2, Price: 4000},
+				},
+			},
+			{
+				ID:        1,
+				OrderDate: time.Now(),
+				Subtotal:  1000,
+				Total:     1000,
+				Items: []OrderItem{
+					{BookID: 1, Quantity: 1, Price: 1000},
+				},
+			},
+		}
+	}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	cursor, _ := utils.EncodeCursor(Cursor{ID: 3})
 
 	tests := []struct {
-		name                string
-		customerID          int64
-		mockRepositoryFunc  func(ctx context.Context, customerID int64) ([]Order, error)
-		expectedOrders      []Order
-		expectedError       error
-		getBooksInformation func(ctx context.Context, bookIDs []int64) (map[int64]struct {
-			Price float64
-			Title string
-		}, error)
+		name               string
+		params             ListParams
+		mockRepositoryFunc func(ctx context.Context, query Query) ([]Order, error)
+		expectedIDs        []int64
+		expectedTotals     []money.Amount
+		expectedNextCursor bool
+		expectedError      error
 	}{
 		{
-			name:       "ValidCustomer",
-			customerID: 1,
-			mockRepositoryFunc: func(ctx context.Context, customerID int64) ([]Order, error) {
-				return orders, nil
+			name: "ValidCustomer",
+			mockRepositoryFunc: func(ctx context.Context, query Query) ([]Order, error) {
+				if query.CustomerID != 1 || query.Limit != defaultPageSize+1 || query.After != nil {
+					t.Errorf("unexpected query: %+v", query)
+				}
+				return newOrders(), nil
 			},
-			expectedOrders: orders, // Orders should be the same as provided.
-			expectedError:  nil,
-			getBooksInformation: func(ctx context.Context, bookIDs []int64) (map[int64]struct {
-				Price float64
-				Title string
-			}, error) {
-				return map[int64]struct {
-					Price float64
-					Title string
-				}{1: {10.0, "book1"}, 2: {20.0, "book2"}}, nil
+			expectedIDs:    []int64{3, 2, 1},
+			expectedTotals: []money.Amount{4000, 17000, 1000},
+		},
+		{
+			name:   "FirstPageHasNextCursor",
+			params: ListParams{Limit: 2},
+			mockRepositoryFunc: func(ctx context.Context, query Query) ([]Order, error) {
+				return newOrders()[:query.Limit], nil
 			},
+			expectedIDs:        []int64{3, 2},
+			expectedTotals:     []money.Amount{4000, 17000},
+			expectedNextCursor: true,
 		},
 		{
-			name:       "InvalidCustomer",
-			customerID: 999,
-			mockRepositoryFunc: func(ctx context.Context, customerID int64) ([]Order, error) {
-				return nil, repoErr
+			name:   "FiltersAndCursorArePassedOn",
+			params: ListParams{Limit: 2, Cursor: cursor, From: &from, To: &to, Status: StatusPaid},
+			mockRepositoryFunc: func(ctx context.Context, query Query) ([]Order, error) {
+				if query.After == nil || query.After.ID != 3 || query.From != &from || query.To != &to || query.Status != StatusPaid {
+					t.Errorf("unexpected query: %+v", query)
+				}
+				return newOrders()[1:], nil
 			},
-			expectedOrders: nil,
-			expectedError:  repoErr,
+			expectedIDs:    []int64{2, 1},
+			expectedTotals: []money.Amount{17000, 1000},
 		},
 		{
-			name:       "failed to fill books name",
-			customerID: 1,
-			mockRepositoryFunc: func(ctx context.Context, customerID int64) ([]Order, error) {
-				return orders, nil
+			name: "NoOrders",
+			mockRepositoryFunc: func(ctx context.Context, query Query) ([]Order, error) {
+				return nil, nil
 			},
-			expectedError: booksInfoErr,
-			getBooksInformation: func(ctx context.Context, bookIDs []int64) (map[int64]struct {
-				Price float64
-				Title string
-			}, error) {
-				return nil, booksInfoErr
+			expectedIDs: []int64{},
+		},
+		{
+			name:          "InvalidLimit",
+			params:        ListParams{Limit: maxPageSize + 1},
+			expectedError: errInvalidLimit,
+		},
+		{
+			name:          "InvalidCursor",
+			params:        ListParams{Cursor: "not a cursor"},
+			expectedError: errInvalidCursor,
+		},
+		{
+			name:          "InvalidStatus",
+			params:        ListParams{Status: Status("lost")},
+			expectedError: errInvalidStatus,
+		},
+		{
+			name:          "InvalidRange",
+			params:        ListParams{From: &to, To: &from},
+			expectedError: errInvalidRange,
+		},
+		{
+			name: "RepositoryError",
+			mockRepositoryFunc: func(ctx context.Context, query Query) ([]Order, error) {
+				return nil, repoErr
 			},
+			expectedError: repoErr,
 		},
 	}
 
@@ -327,40 +961,129 @@ func TestService_GetOrdersByCustomer(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create a mock repository.
 			mockRepo := &MockRepository{
-				GetOrdersByCustomerFunc: tt.mockRepositoryFunc,
+				ListOrdersFunc: tt.mockRepositoryFunc,
 			}
 
+			// the titles come along with the orders, the catalog shouldn't be asked for them again
 			mockBookService := &MockBookService{
-				GetBookPricesFunc: tt.getBooksInformation,
+				GetBookPricesFunc: func(ctx context.Context, bookIDs []int64) (map[int64]book.Information, error) {
+					t.Error("ListOrders shouldn't fetch the books again")
+					return nil, nil
+				},
 			}
 
 			// Create the service with the mock repository.
-			service := NewService(mockRepo, mockBookService)
+			service := NewService(mockRepo, mockBookService, &MockPaymentService{}, &MockPromotionService{}, &MockAddressService{}, &MockVerificationService{}, testTaxes, testShipping, time.Hour)
 
-			orders, err := service.GetOrdersByCustomer(context.Background(), tt.customerID)
+			page, err := service.ListOrders(context.Background(), 1, tt.params)
 
-			if err != nil {
-				if tt.expectedError == nil || !errors.Is(err, tt.expectedError) {
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
 					t.Errorf("Expected error: %v, got: %v", tt.expectedError, err)
 				}
-			} else if tt.expectedError != nil {
-				t.Errorf("Expected error: %v, got nil", tt.expectedError)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
 			}
 
-			if len(orders) != len(tt.expectedOrders) {
-				t.Errorf("Expected %d orders, got %d", len(tt.expectedOrders), len(orders))
-			} else {
-				// Check individual orders if needed.
-				for i := range orders {
-					if orders[i].ID != tt.expectedOrders[i].ID {
-						t.Errorf("Order %d: Expected ID %d, got %d", i, tt.expectedOrders[i].ID, orders[i].ID)
-					}
-					if orders[i].Total != tt.expectedOrders[i].Total {
-						t.Errorf("Order %d: Expected Total %f, got %f", i, tt.expectedOrders[i].Total, orders[i].Total)
-					}
-					// Check other fields and items as needed.
+			if page.Items == nil || len(page.Items) != len(tt.expectedIDs) {
+				t.Fatalf("Expected %d orders, got %+v", len(tt.expectedIDs), page.Items)
+			}
+			for i := range page.Items {
+				if page.Items[i].ID != tt.expectedIDs[i] {
+					t.Errorf("Order %d: Expected ID %d, got %d", i, tt.expectedIDs[i], page.Items[i].ID)
+				}
+				if page.Items[i].Total != tt.expectedTotals[i] {
+					t.Errorf("Order %d: Expected Total %s, got %s", i, tt.expectedTotals[i], page.Items[i].Total)
+				}
+				if page.Items[i].ID == 3 && page.Items[i].Items[0].BookTitle != "book1" {
+					t.Errorf("Order %d: the book titles from the repository should be kept, got %+v", i, page.Items[i].Items)
 				}
 			}
+
+			if (page.NextCursor != "") != tt.expectedNextCursor {
+				t.Errorf("Expected next cursor: %v, got: %q", tt.expectedNextCursor, page.NextCursor)
+			}
+			if page.NextCursor != "" {
+				var c Cursor
+				if err := utils.DecodeCursor(page.NextCursor, &c); err != nil || c.ID != tt.expectedIDs[len(tt.expectedIDs)-1] {
+					t.Errorf("the cursor should point to the last order of the page, got: %+v, %v", c, err)
+				}
+			}
+		})
+	}
+}
+
+func TestService_GetOrderByID(t *testing.T) {
+	repoErr := errors.New("repo err")
+
+	tests := []struct {
+		name             string
+		orderID          int64
+		GetOrderByIDFunc func(ctx context.Context, customerID, orderID int64) (*Order, error)
+		expectedTotal    money.Amount
+		expectedError    error
+	}{
+		{
+			name:    "Valid",
+			orderID: 1,
+			GetOrderByIDFunc: func(ctx context.Context, customerID, orderID int64) (*Order, error) {
+				if customerID != 3 {
+					t.Errorf("the order should be looked up for the customer, got: %d", customerID)
+				}
+				// the stored totals are returned as they are, even the tax the items alone don't add up to
+				return &Order{ID: 1, Status: StatusPaid, Subtotal: 5297, Tax: 424, Total: 5721, Items: []OrderItem{
+					{BookID: 1, Quantity: 3, Price: 1099, BookTitle: "Book1"},
+					{BookID: 2, Quantity: 1, Price: 2000, BookTitle: "Book2"},
+				}}, nil
+			},
+			expectedTotal: 5721,
+		},
+		{
+			name:          "InvalidOrderID",
+			orderID:       0,
+			expectedError: errInvalidOrderID,
+		},
+		{
+			name:    "NotFoundOrAnotherCustomerOrder",
+			orderID: 1,
+			GetOrderByIDFunc: func(ctx context.Context, customerID, orderID int64) (*Order, error) {
+				return nil, nil
+			},
+			expectedError: errOrderNotFound,
+		},
+		{
+			name:    "RepositoryError",
+			orderID: 1,
+			GetOrderByIDFunc: func(ctx context.Context, customerID, orderID int64) (*Order, error) {
+				return nil, repoErr
+			},
+			expectedError: repoErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(&MockRepository{GetOrderByIDFunc: tt.GetOrderByIDFunc}, &MockBookService{}, &MockPaymentService{}, &MockPromotionService{}, &MockAddressService{}, &MockVerificationService{}, testTaxes, testShipping, time.Hour)
+
+			o, err := service.GetOrderByID(context.Background(), 3, tt.orderID)
+
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Errorf("Expected error: %v, got: %v", tt.expectedError, err)
+				}
+				if IsNotFound(err) != (tt.expectedError == errOrderNotFound) {
+					t.Errorf("IsNotFound mismatch for error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if o.Total != tt.expectedTotal {
+				t.Errorf("Expected total: %s, got %s", tt.expectedTotal, o.Total)
+			}
 		})
 	}
 }
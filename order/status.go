@@ -0,0 +1,226 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var (
+	errInvalidOrderID    = errors.New("invalid order ID")
+	errOrderNotFound     = errors.New("order not found")
+	errInvalidStatus     = errors.New("invalid status: needs to be one of pending, paid, shipped, delivered, cancelled or refunded")
+	errInvalidTransition = errors.New("invalid status transition")
+	errStatusReason      = errors.New("invalid reason: needs to have at most 255 characters")
+	errCancelReason      = errors.New("invalid reason: needs to have between 1 and 255 characters")
+	errWindowOver        = errors.New("the order can no longer be cancelled")
+)
+
+// Status is the stage of its lifecycle an order is in
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusPaid      Status = "paid"
+	StatusShipped   Status = "shipped"
+	StatusDelivered Status = "delivered"
+	StatusCancelled Status = "cancelled"
+	StatusRefunded  Status = "refunded"
+)
+
+// transitions lists, for every status, the statuses an order can move to next,
+// cancelled and refunded are final
+var transitions = map[Status][]Status{
+	StatusPending:   {StatusPaid, StatusCancelled},
+	StatusPaid:      {StatusShipped, StatusCancelled, StatusRefunded},
+	StatusShipped:   {StatusDelivered},
+	StatusDelivered: {StatusRefunded},
+	StatusCancelled: nil,
+	StatusRefunded:  nil,
+}
+
+// Valid reports whether s is one of the known statuses
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether an order in status s can be moved to next
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, v := range transitions[s] {
+		if v == next {
+			return true
+		}
+	}
+	return false
+}
+
+// State is what's needed to decide whether an order can move to another status
+type State struct {
+	CustomerID int64
+	Status     Status
+	OrderDate  time.Time
+}
+
+// StatusChange is an entry of the status history of an order, From is empty for the order creation
+type StatusChange struct {
+	ID        int64     `json:"id"`
+	OrderID   int64     `json:"order_id"`
+	From      Status    `json:"from,omitempty"`
+	To        Status    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedBy *int64    `json:"changed_by,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Restocks reports whether the change gives the items of the order back to the stock,
+// which happens when the order is called off before being shipped
+func (c StatusChange) Restocks() bool {
+	return (c.To == StatusCancelled || c.To == StatusRefunded) && (c.From == StatusPending || c.From == StatusPaid)
+}
+
+// IsNotFound reports whether err means that the order doesn't exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, errOrderNotFound)
+}
+
+// IsInvalidTransition reports whether err means that the order can't move to the requested status from its current one
+func IsInvalidTransition(err error) bool {
+	return errors.Is(err, errInvalidTransition)
+}
+
+// IsCancellationWindowOver reports whether err means that the order is too old to be cancelled by the customer
+func IsCancellationWindowOver(err error) bool {
+	return errors.Is(err, errWindowOver)
+}
+
+// UpdateStatus moves an order to the next status of its lifecycle, keeping track of who did it and why
+func (s *Service) UpdateStatus(ctx context.Context, orderID int64, next Status, reason string, changedBy int64) (*StatusChange, error) {
+	if orderID <= 0 {
+		return nil, errInvalidOrderID
+	}
+	if !next.Valid() {
+		return nil, errInvalidStatus
+	}
+	if len(reason) > 255 {
+		return nil, errStatusReason
+	}
+
+	state, err := s.repository.GetOrderState(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, errOrderNotFound
+	}
+
+	return s.changeStatus(ctx, orderID, state.Status, next, reason, changedBy)
+}
+
+// CancelOrder cancels an order on behalf of the customer who made it, which is only possible for a while after
+// it was made. Orders of other customers are reported as not found
+func (s *Service) CancelOrder(ctx context.Context, customerID, orderID int64, reason string) (*StatusChange, error) {
+	if orderID <= 0 {
+		return nil, errInvalidOrderID
+	}
+	if reason == "" || len(reason) > 255 {
+		return nil, errCancelReason
+	}
+
+	state, err := s.repository.GetOrderState(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil || state.CustomerID != customerID {
+		return nil, errOrderNotFound
+	}
+	if time.Since(state.OrderDate) > s.cancellationWindow {
+		return nil, fmt.Errorf("%w: it was made more than %s ago", errWindowOver, s.cancellationWindow)
+	}
+
+	return s.changeStatus(ctx, orderID, state.Status, StatusCancelled, reason, customerID)
+}
+
+// changeStatus checks the transition and claims it before settling the payment, so concurrent transitions can't both
+// move the money, then stores it. The claim is given up if the payment can't be settled
+func (s *Service) changeStatus(ctx context.Context, orderID int64, current, next Status, reason string, changedBy int64) (*StatusChange, error) {
+	if !current.CanTransitionTo(next) {
+		return nil, fmt.Errorf("%w: from %s to %s", errInvalidTransition, current, next)
+	}
+
+	claimed, err := s.repository.ClaimStatusChange(ctx, orderID, current, next)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		// the status changed since we read it, or another transition is in progress
+		return nil, fmt.Errorf("%w: the order is no longer %s or is being moved to another status", errInvalidTransition, current)
+	}
+
+	if err := s.settlePayment(ctx, orderID, next); err != nil {
+		if releaseErr := s.repository.ReleaseStatusChange(ctx, orderID, next); releaseErr != nil {
+			slog.Error("error releasing the status change of an order whose payment wasn't settled", "order", orderID, "status", next, "error", releaseErr)
+		}
+		return nil, err
+	}
+
+	change, err := s.repository.UpdateOrderStatus(ctx, StatusChange{
+		OrderID:   orderID,
+		From:      current,
+		To:        next,
+		Reason:    reason,
+		ChangedBy: &changedBy,
+		ChangedAt: time.Now(),
+	})
+	if err != nil {
+		// the claim is kept, the payment is settled and retrying the transition stores it
+		return nil, err
+	}
+	if change == nil {
+		return nil, fmt.Errorf("%w: the order is no longer %s", errInvalidTransition, current)
+	}
+
+	return change, nil
+}
+
+// settlePayment moves the money according to the next status of the order: it is charged when the order ships,
+// released when it's cancelled and given back when it's refunded. It runs once the transition is claimed and does
+// nothing if the payment is already settled, so a claimed transition that fails to be stored can simply be retried
+func (s *Service) settlePayment(ctx context.Context, orderID int64, next Status) error {
+	switch next {
+	case StatusShipped:
+		return s.paymentService.Capture(ctx, orderID)
+	case StatusCancelled:
+		return s.paymentService.Void(ctx, orderID)
+	case StatusRefunded:
+		return s.paymentService.RefundAll(ctx, orderID)
+	}
+	return nil
+}
+
+// GetStatusHistory returns every status an order went through, oldest first
+func (s *Service) GetStatusHistory(ctx context.Context, orderID int64) ([]StatusChange, error) {
+	if orderID <= 0 {
+		return nil, errInvalidOrderID
+	}
+
+	state, err := s.repository.GetOrderState(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, errOrderNotFound
+	}
+
+	history, err := s.repository.GetStatusHistory(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if history == nil {
+		history = []StatusChange{}
+	}
+
+	return history, nil
+}
@@ -0,0 +1,484 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatus_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from     Status
+		to       Status
+		expected bool
+	}{
+		{StatusPending, StatusPaid, true},
+		{StatusPending, StatusCancelled, true},
+		{StatusPending, StatusShipped, false},
+		{StatusPending, StatusRefunded, false},
+		{StatusPaid, StatusShipped, true},
+		{StatusPaid, StatusCancelled, true},
+		{StatusPaid, StatusRefunded, true},
+		{StatusPaid, StatusPending, false},
+		{StatusShipped, StatusDelivered, true},
+		{StatusShipped, StatusCancelled, false},
+		{StatusDelivered, StatusRefunded, true},
+		{StatusDelivered, StatusShipped, false},
+		{StatusCancelled, StatusPending, false},
+		{StatusRefunded, StatusPaid, false},
+		{StatusPending, StatusPending, false},
+		{Status("created"), StatusPaid, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			if got := tt.from.CanTransitionTo(tt.to); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStatusChange_Restocks(t *testing.T) {
+	tests := []struct {
+		from     Status
+		to       Status
+		expected bool
+	}{
+		{StatusPending, StatusCancelled, true},
+		{StatusPaid, StatusCancelled, true},
+		{StatusPaid, StatusRefunded, true},
+		{StatusDelivered, StatusRefunded, false},
+		{StatusPending, StatusPaid, false},
+		{StatusShipped, StatusDelivered, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			if got := (StatusChange{From: tt.from, To: tt.to}).Restocks(); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestService_UpdateStatus(t *testing.T) {
+	repoErr := errors.New("repo err")
+
+	tests := []struct {
+		name                  string
+		orderID               int64
+		next                  Status
+		reason                string
+		GetOrderStateFunc     func(ctx context.Context, orderID int64) (*State, error)
+		UpdateOrderStatusFunc func(ctx context.Context, change StatusChange) (*StatusChange, error)
+		expectedError         error
+	}{
+		{
+			name:    "Valid",
+			orderID: 1,
+			next:    StatusPaid,
+			reason:  "payment received",
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusPending, OrderDate: time.Now()}, nil
+			},
+			UpdateOrderStatusFunc: func(ctx context.Context, change StatusChange) (*StatusChange, error) {
+				if change.From != StatusPending || change.To != StatusPaid || change.ChangedBy == nil || *change.ChangedBy != 42 {
+					t.Errorf("unexpected change: %+v", change)
+				}
+				change.ID = 7
+				return &change, nil
+			},
+		},
+		{
+			name:          "InvalidOrderID",
+			orderID:       0,
+			next:          StatusPaid,
+			expectedError: errInvalidOrderID,
+		},
+		{
+			name:          "InvalidStatus",
+			orderID:       1,
+			next:          Status("lost"),
+			expectedError: errInvalidStatus,
+		},
+		{
+			name:          "ReasonTooLong",
+			orderID:       1,
+			next:          StatusPaid,
+			reason:        strings.Repeat("a", 256),
+			expectedError: errStatusReason,
+		},
+		{
+			name:    "NotFound",
+			orderID: 1,
+			next:    StatusPaid,
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return nil, nil
+			},
+			expectedError: errOrderNotFound,
+		},
+		{
+			name:    "InvalidTransition",
+			orderID: 1,
+			next:    StatusDelivered,
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusPending, OrderDate: time.Now()}, nil
+			},
+			expectedError: errInvalidTransition,
+		},
+		{
+			name:    "ChangedConcurrently",
+			orderID: 1,
+			next:    StatusPaid,
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusPending, OrderDate: time.Now()}, nil
+			},
+			UpdateOrderStatusFunc: func(ctx context.Context, change StatusChange) (*StatusChange, error) {
+				return nil, nil
+			},
+			expectedError: errInvalidTransition,
+		},
+		{
+			name:    "GetStatusError",
+			orderID: 1,
+			next:    StatusPaid,
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return nil, repoErr
+			},
+			expectedError: repoErr,
+		},
+		{
+			name:    "UpdateError",
+			orderID: 1,
+			next:    StatusPaid,
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusPending, OrderDate: time.Now()}, nil
+			},
+			UpdateOrderStatusFunc: func(ctx context.Context, change StatusChange) (*StatusChange, error) {
+				return nil, repoErr
+			},
+			expectedError: repoErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(&MockRepository{
+				GetOrderStateFunc:     tt.GetOrderStateFunc,
+				UpdateOrderStatusFunc: tt.UpdateOrderStatusFunc,
+			}, &MockBookService{}, &MockPaymentService{}, &MockPromotionService{}, &MockAddressService{}, &MockVerificationService{}, testTaxes, testShipping, time.Hour)
+
+			change, err := service.UpdateStatus(context.Background(), tt.orderID, tt.next, tt.reason, 42)
+
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Errorf("Expected error: %v, got: %v", tt.expectedError, err)
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			} else if change == nil || change.ID != 7 {
+				t.Errorf("Expected the stored change, got: %+v", change)
+			}
+
+			if IsNotFound(err) != (tt.expectedError == errOrderNotFound) {
+				t.Errorf("IsNotFound mismatch for error: %v", err)
+			}
+			if IsInvalidTransition(err) != (tt.expectedError == errInvalidTransition) {
+				t.Errorf("IsInvalidTransition mismatch for error: %v", err)
+			}
+		})
+	}
+}
+
+func TestService_GetStatusHistory(t *testing.T) {
+	repoErr := errors.New("repo err")
+
+	tests := []struct {
+		name                 string
+		orderID              int64
+		GetOrderStateFunc    func(ctx context.Context, orderID int64) (*State, error)
+		GetStatusHistoryFunc func(ctx context.Context, orderID int64) ([]StatusChange, error)
+		expectedLen          int
+		expectedError        error
+	}{
+		{
+			name:    "Valid",
+			orderID: 1,
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusPaid, OrderDate: time.Now()}, nil
+			},
+			GetStatusHistoryFunc: func(ctx context.Context, orderID int64) ([]StatusChange, error) {
+				return []StatusChange{{ID: 1, To: StatusPending}, {ID: 2, From: StatusPending, To: StatusPaid}}, nil
+			},
+			expectedLen: 2,
+		},
+		{
+			name:    "EmptyHistory",
+			orderID: 1,
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusPending, OrderDate: time.Now()}, nil
+			},
+			GetStatusHistoryFunc: func(ctx context.Context, orderID int64) ([]StatusChange, error) {
+				return nil, nil
+			},
+		},
+		{
+			name:          "InvalidOrderID",
+			orderID:       -1,
+			expectedError: errInvalidOrderID,
+		},
+		{
+			name:    "NotFound",
+			orderID: 1,
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return nil, nil
+			},
+			expectedError: errOrderNotFound,
+		},
+		{
+			name:    "GetStatusError",
+			orderID: 1,
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return nil, repoErr
+			},
+			expectedError: repoErr,
+		},
+		{
+			name:    "HistoryError",
+			orderID: 1,
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusPending, OrderDate: time.Now()}, nil
+			},
+			GetStatusHistoryFunc: func(ctx context.Context, orderID int64) ([]StatusChange, error) {
+				return nil, repoErr
+			},
+			expectedError: repoErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(&MockRepository{
+				GetOrderStateFunc:    tt.GetOrderStateFunc,
+				GetStatusHistoryFunc: tt.GetStatusHistoryFunc,
+			}, &MockBookService{}, &MockPaymentService{}, &MockPromotionService{}, &MockAddressService{}, &MockVerificationService{}, testTaxes, testShipping, time.Hour)
+
+			history, err := service.GetStatusHistory(context.Background(), tt.orderID)
+
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Errorf("Expected error: %v, got: %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+			if history == nil || len(history) != tt.expectedLen {
+				t.Errorf("Expected %d changes, got: %+v", tt.expectedLen, history)
+			}
+		})
+	}
+}
+
+func TestService_CancelOrder(t *testing.T) {
+	repoErr := errors.New("repo err")
+
+	tests := []struct {
+		name                  string
+		customerID            int64
+		orderID               int64
+		reason                string
+		GetOrderStateFunc     func(ctx context.Context, orderID int64) (*State, error)
+		UpdateOrderStatusFunc func(ctx context.Context, change StatusChange) (*StatusChange, error)
+		expectedError         error
+	}{
+		{
+			name:       "Valid",
+			customerID: 1,
+			orderID:    1,
+			reason:     "ordered the wrong book",
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusPaid, OrderDate: time.Now().Add(-time.Minute)}, nil
+			},
+			UpdateOrderStatusFunc: func(ctx context.Context, change StatusChange) (*StatusChange, error) {
+				if change.From != StatusPaid || change.To != StatusCancelled || *change.ChangedBy != 1 || change.Reason != "ordered the wrong book" {
+					t.Errorf("unexpected change: %+v", change)
+				}
+				change.ID = 7
+				return &change, nil
+			},
+		},
+		{
+			name:          "InvalidOrderID",
+			customerID:    1,
+			orderID:       0,
+			reason:        "ordered the wrong book",
+			expectedError: errInvalidOrderID,
+		},
+		{
+			name:          "EmptyReason",
+			customerID:    1,
+			orderID:       1,
+			expectedError: errCancelReason,
+		},
+		{
+			name:          "ReasonTooLong",
+			customerID:    1,
+			orderID:       1,
+			reason:        strings.Repeat("a", 256),
+			expectedError: errCancelReason,
+		},
+		{
+			name:       "NotFound",
+			customerID: 1,
+			orderID:    1,
+			reason:     "ordered the wrong book",
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return nil, nil
+			},
+			expectedError: errOrderNotFound,
+		},
+		{
+			name:       "AnotherCustomerOrder",
+			customerID: 2,
+			orderID:    1,
+			reason:     "ordered the wrong book",
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusPending, OrderDate: time.Now()}, nil
+			},
+			expectedError: errOrderNotFound,
+		},
+		{
+			name:       "WindowOver",
+			customerID: 1,
+			orderID:    1,
+			reason:     "ordered the wrong book",
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusPending, OrderDate: time.Now().Add(-2 * time.Hour)}, nil
+			},
+			expectedError: errWindowOver,
+		},
+		{
+			name:       "AlreadyShipped",
+			customerID: 1,
+			orderID:    1,
+			reason:     "ordered the wrong book",
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return &State{CustomerID: 1, Status: StatusShipped, OrderDate: time.Now()}, nil
+			},
+			expectedError: errInvalidTransition,
+		},
+		{
+			name:       "GetStateError",
+			customerID: 1,
+			orderID:    1,
+			reason:     "ordered the wrong book",
+			GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+				return nil, repoErr
+			},
+			expectedError: repoErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(&MockRepository{
+				GetOrderStateFunc:     tt.GetOrderStateFunc,
+				UpdateOrderStatusFunc: tt.UpdateOrderStatusFunc,
+			}, &MockBookService{}, &MockPaymentService{}, &MockPromotionService{}, &MockAddressService{}, &MockVerificationService{}, testTaxes, testShipping, time.Hour)
+
+			change, err := service.CancelOrder(context.Background(), tt.customerID, tt.orderID, tt.reason)
+
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Errorf("Expected error: %v, got: %v", tt.expectedError, err)
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			} else if change == nil || change.ID != 7 {
+				t.Errorf("Expected the stored change, got: %+v", change)
+			}
+
+			if IsCancellationWindowOver(err) != (tt.expectedError == errWindowOver) {
+				t.Errorf("IsCancellationWindowOver mismatch for error: %v", err)
+			}
+		})
+	}
+}
+
+func TestService_UpdateStatus_SettlesPayment(t *testing.T) {
+	settleErr := errors.New("provider err")
+
+	tests := []struct {
+		name            string
+		current         Status
+		next            Status
+		settleErr       error
+		expectedSettled []string
+		expectedError   error
+	}{
+		{name: "ShippedCaptures", current: StatusPaid, next: StatusShipped, expectedSettled: []string{"capture"}},
+		{name: "CancelledVoids", current: StatusPaid, next: StatusCancelled, expectedSettled: []string{"void"}},
+		{name: "RefundedRefunds", current: StatusDelivered, next: StatusRefunded, expectedSettled: []string{"refund"}},
+		{name: "DeliveredDoesNothing", current: StatusShipped, next: StatusDelivered},
+		{name: "SettleErrorKeepsStatus", current: StatusPaid, next: StatusShipped, settleErr: settleErr, expectedError: settleErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := false
+			payments := &MockPaymentService{SettleErr: tt.settleErr}
+			repo := &MockRepository{
+				GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+					return &State{CustomerID: 1, Status: tt.current, OrderDate: time.Now()}, nil
+				},
+				UpdateOrderStatusFunc: func(ctx context.Context, change StatusChange) (*StatusChange, error) {
+					updated = true
+					return &change, nil
+				},
+			}
+			service := NewService(repo, &MockBookService{}, payments, &MockPromotionService{}, &MockAddressService{}, &MockVerificationService{}, testTaxes, testShipping, time.Hour)
+
+			_, err := service.UpdateStatus(context.Background(), 1, tt.next, "", 42)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+			if repo.Released != (tt.expectedError != nil) {
+				t.Errorf("Expected the claim to be released only when the payment fails, got: %v", repo.Released)
+			}
+			if updated != (tt.expectedError == nil) {
+				t.Errorf("Expected the status to be stored: %v, got: %v", tt.expectedError == nil, updated)
+			}
+			if len(payments.Settled) != len(tt.expectedSettled) || (len(tt.expectedSettled) > 0 && payments.Settled[0] != tt.expectedSettled[0]) {
+				t.Errorf("Expected the payment operations: %v, got: %v", tt.expectedSettled, payments.Settled)
+			}
+		})
+	}
+}
+
+func TestService_UpdateStatus_ClaimedTransition(t *testing.T) {
+	payments := &MockPaymentService{}
+	repo := &MockRepository{
+		GetOrderStateFunc: func(ctx context.Context, orderID int64) (*State, error) {
+			return &State{CustomerID: 1, Status: StatusPaid, OrderDate: time.Now()}, nil
+		},
+		UpdateOrderStatusFunc: func(ctx context.Context, change StatusChange) (*StatusChange, error) {
+			t.Fatal("Expected the status to not be stored")
+			return nil, nil
+		},
+		// the order is being shipped by another request
+		ClaimedBy: StatusShipped,
+	}
+	service := NewService(repo, &MockBookService{}, payments, &MockPromotionService{}, &MockAddressService{}, &MockVerificationService{}, testTaxes, testShipping, time.Hour)
+
+	if _, err := service.CancelOrder(context.Background(), 1, 1, "changed my mind"); !IsInvalidTransition(err) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidTransition, err)
+	}
+	if len(payments.Settled) != 0 {
+		t.Fatalf("Expected the payment to not be touched, got: %v", payments.Settled)
+	}
+}
@@ -0,0 +1,119 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"strings"
+	"sync"
+)
+
+// Tokens understood by FakeProvider, any other token starting with "tok_" is approved
+const (
+	FakeTokenDeclined          = "tok_declined"
+	FakeTokenInsufficientFunds = "tok_insufficient_funds"
+	FakeTokenUnavailable       = "tok_unavailable"
+)
+
+var (
+	errFakeUnavailable          = errors.New("fake provider unavailable")
+	errAuthorizationNotCaptured = errors.New("authorization not captured")
+	errAuthorizationClosed      = errors.New("authorization already captured or voided")
+)
+
+type fakeAuthorization struct {
+	amount   money.Amount
+	captured money.Amount
+	refunded money.Amount
+	voided   bool
+}
+
+// FakeProvider is an in-process provider whose outcome only depends on the token, used for development and tests.
+// Authorizations it doesn't know about, like the ones made before a restart, are trusted as they come
+type FakeProvider struct {
+	mu             sync.Mutex
+	seq            int64
+	authorizations map[string]*fakeAuthorization
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{authorizations: map[string]*fakeAuthorization{}}
+}
+
+func (f *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *FakeProvider) Authorize(ctx context.Context, request AuthorizationRequest) (string, error) {
+	switch {
+	case request.Token == FakeTokenDeclined:
+		return "", &DeclinedError{Reason: "card declined"}
+	case request.Token == FakeTokenInsufficientFunds:
+		return "", &DeclinedError{Reason: "insufficient funds"}
+	case request.Token == FakeTokenUnavailable:
+		return "", errFakeUnavailable
+	case !strings.HasPrefix(request.Token, "tok_"):
+		return "", &DeclinedError{Reason: "unknown payment token"}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	id := fmt.Sprintf("fake_auth_%d", f.seq)
+	f.authorizations[id] = &fakeAuthorization{amount: request.Amount}
+	return id, nil
+}
+
+// authorization must be called with the lock held
+func (f *FakeProvider) authorization(id string) *fakeAuthorization {
+	a, ok := f.authorizations[id]
+	if !ok {
+		a = &fakeAuthorization{}
+		f.authorizations[id] = a
+	}
+	return a
+}
+
+func (f *FakeProvider) Capture(ctx context.Context, authorizationID string, amount money.Amount) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a := f.authorization(authorizationID)
+	if a.voided || a.captured > 0 {
+		return errAuthorizationClosed
+	}
+	if a.amount > 0 && amount > a.amount {
+		return &DeclinedError{Reason: "capture higher than the authorized amount"}
+	}
+	a.captured = amount
+	return nil
+}
+
+func (f *FakeProvider) Refund(ctx context.Context, authorizationID string, amount money.Amount) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a := f.authorization(authorizationID)
+	if a.captured == 0 && a.amount > 0 {
+		return errAuthorizationNotCaptured
+	}
+	if a.captured > 0 && a.refunded+amount > a.captured {
+		return &DeclinedError{Reason: "refund higher than the captured amount"}
+	}
+	a.refunded += amount
+	return nil
+}
+
+func (f *FakeProvider) Void(ctx context.Context, authorizationID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a := f.authorization(authorizationID)
+	if a.voided || a.captured > 0 {
+		return errAuthorizationClosed
+	}
+	a.voided = true
+	return nil
+}
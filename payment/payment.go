@@ -0,0 +1,253 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"log/slog"
+	"time"
+)
+
+var (
+	errInvalidToken  = errors.New("invalid payment token: needs to have between 1 and 255 characters")
+	errInvalidAmount = errors.New("invalid payment amount")
+	errNotCaptured   = errors.New("the payment wasn't captured yet")
+	errNoPayment     = errors.New("the order doesn't have a payment to refund")
+	errRefundTooHigh = errors.New("the refund is higher than what is left of the payment")
+	errProvider      = errors.New("payment provider error")
+)
+
+// Status is the stage of its lifecycle a payment is in
+type Status string
+
+const (
+	StatusAuthorized Status = "authorized"
+	StatusCaptured   Status = "captured"
+	StatusVoided     Status = "voided"
+	StatusRefunded   Status = "refunded"
+)
+
+// Payment is the payment of an order, RefundedAmount is what was already given back to the customer
+type Payment struct {
+	ID              int64        `json:"id"`
+	OrderID         int64        `json:"order_id"`
+	Provider        string       `json:"provider"`
+	AuthorizationID string       `json:"authorization_id"`
+	Amount          money.Amount `json:"amount"`
+	RefundedAmount  money.Amount `json:"refunded_amount"`
+	Currency        string       `json:"currency"`
+	Status          Status       `json:"status"`
+	CreatedAt       time.Time    `json:"created_at"`
+}
+
+// AuthorizationRequest asks the provider to hold Amount on the payment method represented by Token
+type AuthorizationRequest struct {
+	Amount   money.Amount
+	Currency string
+	Token    string
+}
+
+// Provider is a payment gateway. Authorize holds the amount, Capture charges what was held, Void releases it
+// and Refund gives back (part of) a captured amount. A payment method refusing the operation is reported
+// with a *DeclinedError
+type Provider interface {
+	Name() string
+	Authorize(ctx context.Context, request AuthorizationRequest) (authorizationID string, err error)
+	Capture(ctx context.Context, authorizationID string, amount money.Amount) error
+	Refund(ctx context.Context, authorizationID string, amount money.Amount) error
+	Void(ctx context.Context, authorizationID string) error
+}
+
+// DeclinedError is returned by providers when the payment method refuses the operation
+type DeclinedError struct {
+	Reason string
+}
+
+func (e *DeclinedError) Error() string {
+	return fmt.Sprintf("payment declined: %s", e.Reason)
+}
+
+// IsDeclined reports whether err means that the payment method refused the operation
+func IsDeclined(err error) bool {
+	var declined *DeclinedError
+	return errors.As(err, &declined)
+}
+
+// IsProviderError reports whether err means that the provider failed to process the operation
+func IsProviderError(err error) bool {
+	return errors.Is(err, errProvider)
+}
+
+type Repository interface {
+	// GetPaymentByOrder returns nil if the order doesn't have a payment
+	GetPaymentByOrder(ctx context.Context, orderID int64) (*Payment, error)
+	// UpdatePaymentStatus moves the payment from `from` to `to`, reporting false if it isn't in `from` anymore
+	UpdatePaymentStatus(ctx context.Context, paymentID int64, from, to Status) (bool, error)
+	// ReserveRefund adds amount to the refunded amount of a captured payment, marking it refunded once everything
+	// was given back. It reports false if the payment isn't captured anymore or amount is more than what is left
+	ReserveRefund(ctx context.Context, paymentID int64, amount money.Amount) (bool, error)
+	// ReleaseRefund takes back a reserved amount whose refund failed, so the payment is captured again
+	ReleaseRefund(ctx context.Context, paymentID int64, amount money.Amount) error
+}
+
+type Service struct {
+	provider Provider
+	r        Repository
+}
+
+func NewService(provider Provider, r Repository) *Service {
+	return &Service{provider, r}
+}
+
+// providerError keeps declines as they are and flags everything else as a provider failure
+func providerError(err error) error {
+	if IsDeclined(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", errProvider, err)
+}
+
+// Authorize holds the amount on the payment method, the returned payment still has to be stored with its order
+func (s *Service) Authorize(ctx context.Context, amount money.Amount, currency, token string) (*Payment, error) {
+	if token == "" || len(token) > 255 {
+		return nil, errInvalidToken
+	}
+	if amount <= 0 {
+		return nil, errInvalidAmount
+	}
+
+	authorizationID, err := s.provider.Authorize(ctx, AuthorizationRequest{Amount: amount, Currency: currency, Token: token})
+	if err != nil {
+		return nil, providerError(err)
+	}
+
+	return &Payment{
+		Provider:        s.provider.Name(),
+		AuthorizationID: authorizationID,
+		Amount:          amount,
+		Currency:        currency,
+		Status:          StatusAuthorized,
+		CreatedAt:       time.Now(),
+	}, nil
+}
+
+// Release voids an authorization whose order couldn't be stored
+func (s *Service) Release(ctx context.Context, p Payment) error {
+	if err := s.provider.Void(ctx, p.AuthorizationID); err != nil {
+		return providerError(err)
+	}
+	return nil
+}
+
+// GetPayment returns the payment of an order, or nil if the order was made before payments existed
+func (s *Service) GetPayment(ctx context.Context, orderID int64) (*Payment, error) {
+	return s.r.GetPaymentByOrder(ctx, orderID)
+}
+
+// moveStatus moves the payment to `to` before calling the provider with op, so concurrent calls can't both call it,
+// and moves it back if the provider fails. It does nothing if the payment isn't authorized anymore
+func (s *Service) moveStatus(ctx context.Context, p *Payment, to Status, op func(authorizationID string) error) error {
+	moved, err := s.r.UpdatePaymentStatus(ctx, p.ID, StatusAuthorized, to)
+	if err != nil || !moved {
+		return err
+	}
+
+	if err := op(p.AuthorizationID); err != nil {
+		if _, releaseErr := s.r.UpdatePaymentStatus(ctx, p.ID, to, StatusAuthorized); releaseErr != nil {
+			slog.Error("error moving a payment back to authorized", "payment", p.ID, "error", releaseErr)
+		}
+		return providerError(err)
+	}
+
+	p.Status = to
+	return nil
+}
+
+// Capture charges the amount held for the order, doing nothing if it was already captured
+func (s *Service) Capture(ctx context.Context, orderID int64) error {
+	p, err := s.r.GetPaymentByOrder(ctx, orderID)
+	if err != nil || p == nil || p.Status != StatusAuthorized {
+		return err
+	}
+
+	return s.moveStatus(ctx, p, StatusCaptured, func(authorizationID string) error {
+		return s.provider.Capture(ctx, authorizationID, p.Amount)
+	})
+}
+
+// Void releases the amount held for the order, doing nothing if it isn't held anymore
+func (s *Service) Void(ctx context.Context, orderID int64) error {
+	p, err := s.r.GetPaymentByOrder(ctx, orderID)
+	if err != nil || p == nil || p.Status != StatusAuthorized {
+		return err
+	}
+
+	return s.moveStatus(ctx, p, StatusVoided, func(authorizationID string) error {
+		return s.provider.Void(ctx, authorizationID)
+	})
+}
+
+// Refund gives back part of the amount captured for the order. The amount is reserved before calling the provider,
+// so concurrent refunds can never give back more than what was paid. Refunding nothing always succeeds
+func (s *Service) Refund(ctx context.Context, orderID int64, amount money.Amount) error {
+	if amount < 0 {
+		return errInvalidAmount
+	}
+	if amount == 0 {
+		return nil
+	}
+
+	p, err := s.r.GetPaymentByOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return errNoPayment
+	}
+	if p.Status != StatusCaptured {
+		return errNotCaptured
+	}
+	if amount > p.Amount-p.RefundedAmount {
+		return errRefundTooHigh
+	}
+
+	reserved, err := s.r.ReserveRefund(ctx, p.ID, amount)
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		// another refund took what was left meanwhile
+		return errRefundTooHigh
+	}
+
+	if err := s.provider.Refund(ctx, p.AuthorizationID, amount); err != nil {
+		if releaseErr := s.r.ReleaseRefund(ctx, p.ID, amount); releaseErr != nil {
+			slog.Error("error releasing the amount of a failed refund", "payment", p.ID, "amount", amount, "error", releaseErr)
+		}
+		return providerError(err)
+	}
+
+	return nil
+}
+
+// RefundAll gives back everything that is left of the order payment, voiding it if it was never captured.
+// Orders without a payment, or whose payment was already given back by their returns, have nothing left to refund
+func (s *Service) RefundAll(ctx context.Context, orderID int64) error {
+	p, err := s.r.GetPaymentByOrder(ctx, orderID)
+	if err != nil || p == nil {
+		return err
+	}
+
+	switch p.Status {
+	case StatusAuthorized:
+		return s.Void(ctx, orderID)
+	case StatusCaptured:
+		if p.Amount == p.RefundedAmount {
+			return nil
+		}
+		return s.Refund(ctx, orderID, p.Amount-p.RefundedAmount)
+	}
+
+	return nil
+}
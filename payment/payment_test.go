@@ -0,0 +1,284 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"testing"
+)
+
+// MockRepository keeps the payments in memory, by order
+type MockRepository struct {
+	Payments map[int64]Payment
+	Err      error
+}
+
+func (m *MockRepository) GetPaymentByOrder(ctx context.Context, orderID int64) (*Payment, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	p, ok := m.Payments[orderID]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+// byID returns the order of the payment
+func (m *MockRepository) byID(paymentID int64) (int64, bool) {
+	for orderID, p := range m.Payments {
+		if p.ID == paymentID {
+			return orderID, true
+		}
+	}
+	return 0, false
+}
+
+func (m *MockRepository) UpdatePaymentStatus(ctx context.Context, paymentID int64, from, to Status) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	orderID, ok := m.byID(paymentID)
+	if !ok || m.Payments[orderID].Status != from {
+		return false, nil
+	}
+	p := m.Payments[orderID]
+	p.Status = to
+	m.Payments[orderID] = p
+	return true, nil
+}
+
+func (m *MockRepository) ReserveRefund(ctx context.Context, paymentID int64, amount money.Amount) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	orderID, ok := m.byID(paymentID)
+	p := m.Payments[orderID]
+	if !ok || p.Status != StatusCaptured || p.RefundedAmount+amount > p.Amount {
+		return false, nil
+	}
+	p.RefundedAmount += amount
+	if p.RefundedAmount == p.Amount {
+		p.Status = StatusRefunded
+	}
+	m.Payments[orderID] = p
+	return true, nil
+}
+
+func (m *MockRepository) ReleaseRefund(ctx context.Context, paymentID int64, amount money.Amount) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	orderID, ok := m.byID(paymentID)
+	if !ok {
+		return nil
+	}
+	p := m.Payments[orderID]
+	p.RefundedAmount -= amount
+	p.Status = StatusCaptured
+	m.Payments[orderID] = p
+	return nil
+}
+
+// newAuthorizedService returns a service whose order 1 has an authorized payment of amount
+func newAuthorizedService(t *testing.T, amount money.Amount) (*Service, *MockRepository) {
+	repo := &MockRepository{Payments: map[int64]Payment{}}
+	s := NewService(NewFakeProvider(), repo)
+
+	p, err := s.Authorize(context.Background(), amount, money.Currency, "tok_visa")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	p.ID, p.OrderID = 1, 1
+	repo.Payments[1] = *p
+
+	return s, repo
+}
+
+func TestService_Authorize(t *testing.T) {
+	tests := []struct {
+		name              string
+		amount            money.Amount
+		token             string
+		expectedError     error
+		expectDeclined    bool
+		expectUnavailable bool
+	}{
+		{name: "Approved", amount: 1099, token: "tok_visa"},
+		{name: "Declined", amount: 1099, token: FakeTokenDeclined, expectDeclined: true},
+		{name: "InsufficientFunds", amount: 1099, token: FakeTokenInsufficientFunds, expectDeclined: true},
+		{name: "UnknownToken", amount: 1099, token: "4242424242424242", expectDeclined: true},
+		{name: "ProviderUnavailable", amount: 1099, token: FakeTokenUnavailable, expectUnavailable: true},
+		{name: "EmptyToken", amount: 1099, token: "", expectedError: errInvalidToken},
+		{name: "ZeroAmount", amount: 0, token: "tok_visa", expectedError: errInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService(NewFakeProvider(), &MockRepository{})
+
+			p, err := s.Authorize(context.Background(), tt.amount, money.Currency, tt.token)
+
+			if IsDeclined(err) != tt.expectDeclined {
+				t.Errorf("IsDeclined mismatch for error: %v", err)
+			}
+			if IsProviderError(err) != tt.expectUnavailable {
+				t.Errorf("IsProviderError mismatch for error: %v", err)
+			}
+			if tt.expectedError != nil && !errors.Is(err, tt.expectedError) {
+				t.Errorf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+			if tt.expectedError != nil || tt.expectDeclined || tt.expectUnavailable {
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if p.Provider != "fake" || p.AuthorizationID == "" || p.Amount != tt.amount || p.Status != StatusAuthorized {
+				t.Fatalf("Unexpected payment: %+v", p)
+			}
+		})
+	}
+}
+
+func TestService_Settle(t *testing.T) {
+	t.Run("CaptureThenRefund", func(t *testing.T) {
+		s, repo := newAuthorizedService(t, 2000)
+
+		if err := s.Capture(context.Background(), 1); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if repo.Payments[1].Status != StatusCaptured {
+			t.Fatalf("Expected the payment to be captured, got: %+v", repo.Payments[1])
+		}
+		// capturing again does nothing
+		if err := s.Capture(context.Background(), 1); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if err := s.Refund(context.Background(), 1, 500); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if p := repo.Payments[1]; p.Status != StatusCaptured || p.RefundedAmount != 500 {
+			t.Fatalf("Expected a partial refund, got: %+v", p)
+		}
+		if err := s.Refund(context.Background(), 1, 1501); !errors.Is(err, errRefundTooHigh) {
+			t.Fatalf("Expected error: %v, got: %v", errRefundTooHigh, err)
+		}
+
+		if err := s.RefundAll(context.Background(), 1); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if p := repo.Payments[1]; p.Status != StatusRefunded || p.RefundedAmount != 2000 {
+			t.Fatalf("Expected the payment to be fully refunded, got: %+v", p)
+		}
+	})
+
+	t.Run("FullyReturnedThenRefunded", func(t *testing.T) {
+		s, repo := newAuthorizedService(t, 2000)
+
+		// the order is delivered, and then every item is returned
+		if err := s.Capture(context.Background(), 1); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		for _, amount := range []money.Amount{1200, 800} {
+			if err := s.Refund(context.Background(), 1, amount); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+		}
+		if p := repo.Payments[1]; p.Status != StatusRefunded || p.RefundedAmount != 2000 {
+			t.Fatalf("Expected the returns to refund the whole payment, got: %+v", p)
+		}
+
+		// refunding the order has nothing left to give back
+		if err := s.RefundAll(context.Background(), 1); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		p := repo.Payments[1]
+		p.Status = StatusCaptured
+		repo.Payments[1] = p
+		if err := s.RefundAll(context.Background(), 1); err != nil {
+			t.Fatalf("Expected no error for a captured payment with nothing left, got: %v", err)
+		}
+		if p := repo.Payments[1]; p.RefundedAmount != 2000 {
+			t.Fatalf("Expected nothing else to be refunded, got: %+v", p)
+		}
+	})
+
+	t.Run("VoidBeforeCapture", func(t *testing.T) {
+		s, repo := newAuthorizedService(t, 2000)
+
+		if err := s.Refund(context.Background(), 1, 500); !errors.Is(err, errNotCaptured) {
+			t.Fatalf("Expected error: %v, got: %v", errNotCaptured, err)
+		}
+		if err := s.RefundAll(context.Background(), 1); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if repo.Payments[1].Status != StatusVoided {
+			t.Fatalf("Expected the payment to be voided, got: %+v", repo.Payments[1])
+		}
+		// there is nothing left to capture
+		if err := s.Capture(context.Background(), 1); err != nil || repo.Payments[1].Status != StatusVoided {
+			t.Fatalf("Expected the payment to stay voided, got: %+v, %v", repo.Payments[1], err)
+		}
+	})
+
+	t.Run("ProviderFailureIsUndone", func(t *testing.T) {
+		s, repo := newAuthorizedService(t, 2000)
+
+		// the provider refuses to refund what it never captured
+		p := repo.Payments[1]
+		p.Status = StatusCaptured
+		repo.Payments[1] = p
+		if err := s.Refund(context.Background(), 1, 500); !IsProviderError(err) {
+			t.Fatalf("Expected a provider error, got: %v", err)
+		}
+		if p := repo.Payments[1]; p.Status != StatusCaptured || p.RefundedAmount != 0 {
+			t.Fatalf("Expected the reserved amount to be released, got: %+v", p)
+		}
+
+		// and to capture what was already voided
+		p.Status = StatusAuthorized
+		repo.Payments[1] = p
+		if err := s.provider.Void(context.Background(), p.AuthorizationID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Capture(context.Background(), 1); !IsProviderError(err) {
+			t.Fatalf("Expected a provider error, got: %v", err)
+		}
+		if repo.Payments[1].Status != StatusAuthorized {
+			t.Fatalf("Expected the payment to be authorized again, got: %+v", repo.Payments[1])
+		}
+	})
+
+	t.Run("OrderWithoutPayment", func(t *testing.T) {
+		s := NewService(NewFakeProvider(), &MockRepository{Payments: map[int64]Payment{}})
+
+		for _, settle := range []func(ctx context.Context, orderID int64) error{s.Capture, s.Void, s.RefundAll} {
+			if err := settle(context.Background(), 1); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+		}
+
+		// there is nothing to give back from, unless nothing is asked for
+		if err := s.Refund(context.Background(), 1, 500); !errors.Is(err, errNoPayment) {
+			t.Fatalf("Expected error: %v, got: %v", errNoPayment, err)
+		}
+		if err := s.Refund(context.Background(), 1, 0); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if err := s.Refund(context.Background(), 1, -1); !errors.Is(err, errInvalidAmount) {
+			t.Fatalf("Expected error: %v, got: %v", errInvalidAmount, err)
+		}
+	})
+
+	t.Run("RepositoryError", func(t *testing.T) {
+		repoErr := errors.New("repo err")
+		s := NewService(NewFakeProvider(), &MockRepository{Err: repoErr})
+
+		if err := s.Capture(context.Background(), 1); !errors.Is(err, repoErr) {
+			t.Fatalf("Expected error: %v, got: %v", repoErr, err)
+		}
+	})
+}
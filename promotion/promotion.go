@@ -0,0 +1,290 @@
+package promotion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidCode     = errors.New("invalid code: needs to have between 3 and 64 letters, digits, - or _")
+	errInvalidType     = errors.New("invalid type: needs to be percentage or fixed")
+	errInvalidPercent  = errors.New("invalid percent: needs to be between 1 and 100")
+	errInvalidAmount   = errors.New("invalid amount: needs to be positive")
+	errInvalidMinimum  = errors.New("invalid min_order_value: can't be negative")
+	errInvalidLimit    = errors.New("invalid usage limit: can't be negative, 0 means unlimited")
+	errInvalidWindow   = errors.New("invalid validity window: starts_at needs to be before ends_at")
+	errInvalidBookID   = errors.New("invalid book ID")
+	errInvalidAuthor   = errors.New("invalid author: needs to have between 1 and 255 characters")
+	errCodeTaken       = errors.New("coupon code already exists")
+	errCouponNotFound  = errors.New("coupon not found")
+	errNotApplicable   = errors.New("coupon can't be applied")
+	errNotStarted      = fmt.Errorf("%w: it isn't valid yet", errNotApplicable)
+	errExpired         = fmt.Errorf("%w: it expired", errNotApplicable)
+	errBelowMinimum    = fmt.Errorf("%w: the order is below the minimum value", errNotApplicable)
+	errNoEligibleBooks = fmt.Errorf("%w: it doesn't cover any book of the order", errNotApplicable)
+	errUsedUp          = fmt.Errorf("%w: it reached its usage limit", errNotApplicable)
+)
+
+var codeRegex = regexp.MustCompile(`^[A-Z0-9_-]{3,64}$`)
+
+// Type is how a coupon computes its discount
+type Type string
+
+const (
+	// TypePercentage takes Percent percent off the eligible books
+	TypePercentage Type = "percentage"
+	// TypeFixed takes Amount off the eligible books, never more than what they cost
+	TypeFixed Type = "fixed"
+)
+
+// Coupon is a discount code. Zero limits mean unlimited, nil dates leave the window open and empty BookIDs and
+// Authors make every book eligible
+type Coupon struct {
+	ID                 int64        `json:"id"`
+	Code               string       `json:"code"`
+	Type               Type         `json:"type"`
+	Percent            int          `json:"percent,omitempty"`
+	Amount             money.Amount `json:"amount,omitempty"`
+	MinOrderValue      money.Amount `json:"min_order_value"`
+	MaxUses            int          `json:"max_uses"`
+	MaxUsesPerCustomer int          `json:"max_uses_per_customer"`
+	StartsAt           *time.Time   `json:"starts_at,omitempty"`
+	EndsAt             *time.Time   `json:"ends_at,omitempty"`
+	BookIDs            []int64      `json:"book_ids"`
+	Authors            []string     `json:"authors"`
+	CreatedAt          time.Time    `json:"created_at"`
+}
+
+// Line is a book of the order the coupon is applied to
+type Line struct {
+	BookID   int64
+	Author   string
+	Price    money.Amount
+	Quantity int
+}
+
+// Redemption is the result of applying a coupon to an order
+type Redemption struct {
+	Code     string
+	Discount money.Amount
+}
+
+// UsageLimitError is returned by repositories when the coupon was used up by concurrent orders
+type UsageLimitError struct {
+	Code string
+}
+
+func (e *UsageLimitError) Error() string {
+	return fmt.Sprintf("coupon %s reached its usage limit", e.Code)
+}
+
+type Repository interface {
+	SaveCoupon(ctx context.Context, c Coupon) (*int64, error)
+	// GetCouponByCode returns nil if there isn't a coupon with the code
+	GetCouponByCode(ctx context.Context, code string) (*Coupon, error)
+	// CountRedemptions returns how many orders used the coupon, in total and by the customer
+	CountRedemptions(ctx context.Context, couponID, customerID int64) (total int, byCustomer int, err error)
+}
+
+type Service struct {
+	r Repository
+}
+
+func NewService(r Repository) *Service {
+	return &Service{r}
+}
+
+// IsNotApplicable reports whether err means that the coupon can't be used for the order
+func IsNotApplicable(err error) bool {
+	var limitErr *UsageLimitError
+	return errors.Is(err, errNotApplicable) || errors.As(err, &limitErr)
+}
+
+// IsNotFound reports whether err means that the coupon doesn't exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, errCouponNotFound)
+}
+
+// IsCodeTaken reports whether err means that another coupon already has the code
+func IsCodeTaken(err error) bool {
+	return errors.Is(err, errCodeTaken)
+}
+
+// NormalizeCode returns the code the way it's stored, codes are case insensitive
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func validateCoupon(c *Coupon) error {
+	if !codeRegex.MatchString(c.Code) {
+		return errInvalidCode
+	}
+	switch c.Type {
+	case TypePercentage:
+		if c.Percent < 1 || c.Percent > 100 {
+			return errInvalidPercent
+		}
+		c.Amount = 0
+	case TypeFixed:
+		if c.Amount <= 0 {
+			return errInvalidAmount
+		}
+		c.Percent = 0
+	default:
+		return errInvalidType
+	}
+	if c.MinOrderValue < 0 {
+		return errInvalidMinimum
+	}
+	if c.MaxUses < 0 || c.MaxUsesPerCustomer < 0 {
+		return errInvalidLimit
+	}
+	if c.StartsAt != nil && c.EndsAt != nil && !c.StartsAt.Before(*c.EndsAt) {
+		return errInvalidWindow
+	}
+	for _, v := range c.BookIDs {
+		if v <= 0 {
+			return errInvalidBookID
+		}
+	}
+	for _, v := range c.Authors {
+		if v == "" || len(v) > 255 {
+			return errInvalidAuthor
+		}
+	}
+	return nil
+}
+
+// CreateCoupon validates and stores a new coupon
+func (s *Service) CreateCoupon(ctx context.Context, c Coupon) (*Coupon, error) {
+	c.Code = NormalizeCode(c.Code)
+	if err := validateCoupon(&c); err != nil {
+		return nil, err
+	}
+	if c.BookIDs == nil {
+		c.BookIDs = []int64{}
+	}
+	if c.Authors == nil {
+		c.Authors = []string{}
+	}
+
+	existing, err := s.r.GetCouponByCode(ctx, c.Code)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errCodeTaken
+	}
+
+	c.CreatedAt = time.Now()
+	id, err := s.r.SaveCoupon(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	c.ID = *id
+
+	return &c, nil
+}
+
+// GetCoupon returns the coupon with the code
+func (s *Service) GetCoupon(ctx context.Context, code string) (*Coupon, error) {
+	c, err := s.r.GetCouponByCode(ctx, NormalizeCode(code))
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errCouponNotFound
+	}
+
+	return c, nil
+}
+
+// eligible reports whether the coupon covers the book of the line
+func (c *Coupon) eligible(l Line) bool {
+	if len(c.BookIDs) == 0 && len(c.Authors) == 0 {
+		return true
+	}
+	for _, v := range c.BookIDs {
+		if v == l.BookID {
+			return true
+		}
+	}
+	for _, v := range c.Authors {
+		if strings.EqualFold(v, l.Author) {
+			return true
+		}
+	}
+	return false
+}
+
+// Discount computes how much the coupon takes off the lines, only counting the books it covers
+func (c *Coupon) Discount(lines []Line) money.Amount {
+	var eligible money.Amount
+	for _, l := range lines {
+		if c.eligible(l) {
+			eligible += l.Price.Mul(l.Quantity)
+		}
+	}
+
+	switch c.Type {
+	case TypePercentage:
+		return eligible.MulRate(int64(c.Percent), 100)
+	case TypeFixed:
+		if c.Amount > eligible {
+			return eligible
+		}
+		return c.Amount
+	}
+	return 0
+}
+
+// Apply checks that the customer can use the coupon for an order with the lines and computes its discount. The usage
+// limits are checked again when the order is stored, as concurrent orders may use the coupon in the meantime
+func (s *Service) Apply(ctx context.Context, customerID int64, code string, lines []Line) (*Redemption, error) {
+	c, err := s.r.GetCouponByCode(ctx, NormalizeCode(code))
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		// unknown codes can't be applied, same as any other code that doesn't work for the order
+		return nil, fmt.Errorf("%w: unknown code", errNotApplicable)
+	}
+
+	now := time.Now()
+	if c.StartsAt != nil && now.Before(*c.StartsAt) {
+		return nil, errNotStarted
+	}
+	if c.EndsAt != nil && !now.Before(*c.EndsAt) {
+		return nil, errExpired
+	}
+
+	var subtotal money.Amount
+	for _, l := range lines {
+		subtotal += l.Price.Mul(l.Quantity)
+	}
+	if subtotal < c.MinOrderValue {
+		return nil, fmt.Errorf("%w of %s", errBelowMinimum, c.MinOrderValue)
+	}
+
+	discount := c.Discount(lines)
+	if discount == 0 {
+		return nil, errNoEligibleBooks
+	}
+
+	if c.MaxUses > 0 || c.MaxUsesPerCustomer > 0 {
+		total, byCustomer, err := s.r.CountRedemptions(ctx, c.ID, customerID)
+		if err != nil {
+			return nil, err
+		}
+		if (c.MaxUses > 0 && total >= c.MaxUses) || (c.MaxUsesPerCustomer > 0 && byCustomer >= c.MaxUsesPerCustomer) {
+			return nil, errUsedUp
+		}
+	}
+
+	return &Redemption{Code: c.Code, Discount: discount}, nil
+}
@@ -0,0 +1,194 @@
+package promotion
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"testing"
+	"time"
+)
+
+// MockRepository keeps the coupons in memory, by code, with a fixed number of redemptions
+type MockRepository struct {
+	Coupons    map[string]Coupon
+	Total      int
+	ByCustomer int
+	Err        error
+}
+
+func (m *MockRepository) SaveCoupon(ctx context.Context, c Coupon) (*int64, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	c.ID = int64(len(m.Coupons) + 1)
+	m.Coupons[c.Code] = c
+	return &c.ID, nil
+}
+
+func (m *MockRepository) GetCouponByCode(ctx context.Context, code string) (*Coupon, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	c, ok := m.Coupons[code]
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (m *MockRepository) CountRedemptions(ctx context.Context, couponID, customerID int64) (int, int, error) {
+	if m.Err != nil {
+		return 0, 0, m.Err
+	}
+	return m.Total, m.ByCustomer, nil
+}
+
+func TestService_CreateCoupon(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name          string
+		coupon        Coupon
+		expectedError error
+	}{
+		{name: "Percentage", coupon: Coupon{Code: "save10", Type: TypePercentage, Percent: 10}},
+		{name: "Fixed", coupon: Coupon{Code: "FIVE-OFF", Type: TypeFixed, Amount: 500, MinOrderValue: 2000, BookIDs: []int64{1}, Authors: []string{"Author1"}}},
+		{name: "Window", coupon: Coupon{Code: "SUMMER", Type: TypePercentage, Percent: 20, StartsAt: &now, EndsAt: &later}},
+		{name: "CodeTaken", coupon: Coupon{Code: "Taken", Type: TypePercentage, Percent: 10}, expectedError: errCodeTaken},
+		{name: "InvalidCode", coupon: Coupon{Code: "a b", Type: TypePercentage, Percent: 10}, expectedError: errInvalidCode},
+		{name: "InvalidType", coupon: Coupon{Code: "SAVE10", Type: "free"}, expectedError: errInvalidType},
+		{name: "InvalidPercent", coupon: Coupon{Code: "SAVE10", Type: TypePercentage, Percent: 101}, expectedError: errInvalidPercent},
+		{name: "InvalidAmount", coupon: Coupon{Code: "SAVE10", Type: TypeFixed}, expectedError: errInvalidAmount},
+		{name: "InvalidMinimum", coupon: Coupon{Code: "SAVE10", Type: TypeFixed, Amount: 500, MinOrderValue: -1}, expectedError: errInvalidMinimum},
+		{name: "InvalidLimit", coupon: Coupon{Code: "SAVE10", Type: TypeFixed, Amount: 500, MaxUses: -1}, expectedError: errInvalidLimit},
+		{name: "InvalidWindow", coupon: Coupon{Code: "SAVE10", Type: TypeFixed, Amount: 500, StartsAt: &later, EndsAt: &now}, expectedError: errInvalidWindow},
+		{name: "InvalidBookID", coupon: Coupon{Code: "SAVE10", Type: TypeFixed, Amount: 500, BookIDs: []int64{0}}, expectedError: errInvalidBookID},
+		{name: "InvalidAuthor", coupon: Coupon{Code: "SAVE10", Type: TypeFixed, Amount: 500, Authors: []string{""}}, expectedError: errInvalidAuthor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &MockRepository{Coupons: map[string]Coupon{"TAKEN": {ID: 1, Code: "TAKEN"}}}
+			s := NewService(repo)
+
+			c, err := s.CreateCoupon(context.Background(), tt.coupon)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+			if IsCodeTaken(err) != (tt.expectedError == errCodeTaken) {
+				t.Errorf("IsCodeTaken mismatch for error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+
+			if c.ID == 0 || c.Code != NormalizeCode(tt.coupon.Code) || c.BookIDs == nil || c.Authors == nil {
+				t.Fatalf("Expected the coupon to be stored with an uppercase code, got: %+v", c)
+			}
+		})
+	}
+}
+
+func TestService_GetCoupon(t *testing.T) {
+	s := NewService(&MockRepository{Coupons: map[string]Coupon{"SAVE10": {ID: 1, Code: "SAVE10"}}})
+
+	c, err := s.GetCoupon(context.Background(), "save10")
+	if err != nil || c.ID != 1 {
+		t.Fatalf("Expected the coupon regardless of the case, got: %+v, %v", c, err)
+	}
+
+	if _, err := s.GetCoupon(context.Background(), "NOPE"); !IsNotFound(err) {
+		t.Fatalf("Expected error: %v, got: %v", errCouponNotFound, err)
+	}
+}
+
+func TestCoupon_Discount(t *testing.T) {
+	lines := []Line{
+		{BookID: 1, Author: "Author1", Price: 1099, Quantity: 2},
+		{BookID: 2, Author: "Author2", Price: 500, Quantity: 1},
+	}
+
+	tests := []struct {
+		name     string
+		coupon   Coupon
+		expected money.Amount
+	}{
+		{name: "PercentageOfEverything", coupon: Coupon{Type: TypePercentage, Percent: 10}, expected: 270},
+		{name: "PercentageRoundsHalfUp", coupon: Coupon{Type: TypePercentage, Percent: 25, BookIDs: []int64{1}}, expected: 550},
+		{name: "FixedOnBook", coupon: Coupon{Type: TypeFixed, Amount: 300, BookIDs: []int64{2}}, expected: 300},
+		{name: "FixedCappedToEligibleBooks", coupon: Coupon{Type: TypeFixed, Amount: 1000, BookIDs: []int64{2}}, expected: 500},
+		{name: "ByAuthor", coupon: Coupon{Type: TypePercentage, Percent: 50, Authors: []string{"author1"}}, expected: 1099},
+		{name: "BookOrAuthor", coupon: Coupon{Type: TypePercentage, Percent: 100, BookIDs: []int64{2}, Authors: []string{"Author1"}}, expected: 2698},
+		{name: "NothingEligible", coupon: Coupon{Type: TypeFixed, Amount: 300, BookIDs: []int64{3}}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if d := tt.coupon.Discount(lines); d != tt.expected {
+				t.Errorf("Expected discount: %s, got %s", tt.expected, d)
+			}
+		})
+	}
+}
+
+func TestService_Apply(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	lines := []Line{{BookID: 1, Author: "Author1", Price: 1000, Quantity: 2}}
+	repoErr := errors.New("repo err")
+
+	tests := []struct {
+		name             string
+		coupon           Coupon
+		total            int
+		byCustomer       int
+		repoErr          error
+		expectedError    error
+		expectedDiscount money.Amount
+	}{
+		{name: "Applied", coupon: Coupon{Type: TypePercentage, Percent: 10}, expectedDiscount: 200},
+		{name: "InsideWindow", coupon: Coupon{Type: TypeFixed, Amount: 500, StartsAt: &past, EndsAt: &future}, expectedDiscount: 500},
+		{name: "NotStarted", coupon: Coupon{Type: TypeFixed, Amount: 500, StartsAt: &future}, expectedError: errNotStarted},
+		{name: "Expired", coupon: Coupon{Type: TypeFixed, Amount: 500, EndsAt: &past}, expectedError: errExpired},
+		{name: "MinimumReached", coupon: Coupon{Type: TypeFixed, Amount: 500, MinOrderValue: 2000}, expectedDiscount: 500},
+		{name: "BelowMinimum", coupon: Coupon{Type: TypeFixed, Amount: 500, MinOrderValue: 2001}, expectedError: errBelowMinimum},
+		{name: "NoEligibleBooks", coupon: Coupon{Type: TypeFixed, Amount: 500, BookIDs: []int64{2}}, expectedError: errNoEligibleBooks},
+		{name: "UnderGlobalLimit", coupon: Coupon{Type: TypeFixed, Amount: 500, MaxUses: 3}, total: 2, expectedDiscount: 500},
+		{name: "GlobalLimit", coupon: Coupon{Type: TypeFixed, Amount: 500, MaxUses: 3}, total: 3, expectedError: errUsedUp},
+		{name: "CustomerLimit", coupon: Coupon{Type: TypeFixed, Amount: 500, MaxUsesPerCustomer: 1}, total: 5, byCustomer: 1, expectedError: errUsedUp},
+		{name: "RepositoryError", coupon: Coupon{Type: TypeFixed, Amount: 500}, repoErr: repoErr, expectedError: repoErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.coupon.ID = 1
+			tt.coupon.Code = "SAVE"
+			repo := &MockRepository{Coupons: map[string]Coupon{"SAVE": tt.coupon}, Total: tt.total, ByCustomer: tt.byCustomer, Err: tt.repoErr}
+			s := NewService(repo)
+
+			r, err := s.Apply(context.Background(), 1, "save", lines)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+			if IsNotApplicable(err) != (tt.expectedError != nil && tt.expectedError != repoErr) {
+				t.Errorf("IsNotApplicable mismatch for error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+
+			if r.Code != "SAVE" || r.Discount != tt.expectedDiscount {
+				t.Fatalf("Expected a discount of %s, got: %+v", tt.expectedDiscount, r)
+			}
+		})
+	}
+
+	t.Run("UnknownCode", func(t *testing.T) {
+		s := NewService(&MockRepository{Coupons: map[string]Coupon{}})
+
+		if _, err := s.Apply(context.Background(), 1, "nope", lines); !IsNotApplicable(err) {
+			t.Fatalf("Expected error: %v, got: %v", errNotApplicable, err)
+		}
+	})
+}
@@ -0,0 +1,545 @@
+package returns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/payment"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"log/slog"
+	"time"
+)
+
+var (
+	errInvalidOrderID    = errors.New("invalid order ID")
+	errInvalidReturnID   = errors.New("invalid return ID")
+	errEmptyItems        = errors.New("invalid empty items")
+	errInvalidBookID     = errors.New("invalid book ID")
+	errInvalidQuantity   = errors.New("invalid book quantity")
+	errDuplicateItem     = errors.New("duplicate bookID, use quantity instead")
+	errReason            = errors.New("invalid reason: needs to have between 1 and 255 characters")
+	errNote              = errors.New("invalid note: needs to have at most 255 characters")
+	errInvalidStatus     = errors.New("invalid status: needs to be one of requested, approved, rejected or refunded")
+	errInvalidLimit      = errors.New("invalid limit: needs to be between 1 and 100")
+	errInvalidCursor     = errors.New("invalid cursor")
+	errOrderNotFound     = errors.New("order not found")
+	errReturnNotFound    = errors.New("return not found")
+	errNotDelivered      = errors.New("only delivered orders can be returned")
+	errWindowOver        = errors.New("the order can no longer be returned")
+	errItemNotInOrder    = errors.New("book not found in the order")
+	errTooManyUnits      = errors.New("more units than the ones that can still be returned")
+	errInvalidTransition = errors.New("invalid return status transition")
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Status is the stage of its lifecycle a return is in
+type Status string
+
+const (
+	StatusRequested Status = "requested"
+	StatusApproved  Status = "approved"
+	StatusRejected  Status = "rejected"
+	StatusRefunded  Status = "refunded"
+)
+
+// Valid reports whether s is one of the known statuses
+func (s Status) Valid() bool {
+	switch s {
+	case StatusRequested, StatusApproved, StatusRejected, StatusRefunded:
+		return true
+	}
+	return false
+}
+
+// Item is a book being returned, Price is the unit price that was paid for it in the order
+type Item struct {
+	BookID   int64        `json:"book_id"`
+	Quantity int          `json:"quantity"`
+	Price    money.Amount `json:"price"`
+}
+
+// Return is a request to send back some of the books of an order, Discount and Tax are the parts of the order
+// discount and tax that the items got and RefundAmount is set once it's approved
+type Return struct {
+	ID           int64        `json:"id"`
+	OrderID      int64        `json:"order_id"`
+	CustomerID   int64        `json:"customer_id"`
+	Status       Status       `json:"status"`
+	Reason       string       `json:"reason"`
+	Items        []Item       `json:"items"`
+	Discount     money.Amount `json:"discount"`
+	Tax          money.Amount `json:"tax"`
+	RefundAmount money.Amount `json:"refund_amount"`
+	Currency     string       `json:"currency"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// Event is an entry of the audit trail of a return, From is empty for the request itself
+type Event struct {
+	ID        int64     `json:"id"`
+	ReturnID  int64     `json:"return_id"`
+	From      Status    `json:"from,omitempty"`
+	To        Status    `json:"to"`
+	Note      string    `json:"note,omitempty"`
+	ActorID   *int64    `json:"actor_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrderItem is a book of an order, Returned is how many of its units are already part of a return that
+// wasn't rejected
+type OrderItem struct {
+	BookID   int64
+	Quantity int
+	Price    money.Amount
+	Tax      money.Amount
+	Returned int
+}
+
+// Order is what's needed to decide whether books of an order can be returned,
+// DeliveredAt is nil if the order was never delivered
+type Order struct {
+	CustomerID  int64
+	Status      order.Status
+	Currency    string
+	Discount    money.Amount
+	DeliveredAt *time.Time
+	Items       []OrderItem
+}
+
+// TooManyUnitsError is returned by the Repository when a return asks for more units of a book than the ones
+// that were bought and not returned yet
+type TooManyUnitsError struct {
+	BookID int64
+}
+
+func (e *TooManyUnitsError) Error() string {
+	return fmt.Sprintf("book %d doesn't have that many units left to return", e.BookID)
+}
+
+// ListParams are the options of a return listing as requested by the client
+type ListParams struct {
+	Status Status
+	Limit  int
+	Cursor string
+}
+
+// Cursor is the position right after the last return of a page
+type Cursor struct {
+	ID int64 `json:"id"`
+}
+
+// Page is a slice of the returns, oldest first, NextCursor is empty when there are no more returns
+type Page struct {
+	Items      []Return `json:"items"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+type Repository interface {
+	// GetOrder returns nil if the order doesn't exist
+	GetOrder(ctx context.Context, orderID int64) (*Order, error)
+	// SaveReturn stores the return as requested together with the first event of its audit trail, failing with
+	// *TooManyUnitsError if a concurrent return already took the units
+	SaveReturn(ctx context.Context, r Return) (*int64, error)
+	// GetReturn returns nil if the return doesn't exist
+	GetReturn(ctx context.Context, returnID int64) (*Return, error)
+	// ListOrderReturns returns the returns of an order, oldest first
+	ListOrderReturns(ctx context.Context, orderID int64) ([]Return, error)
+	// ListReturns returns up to limit returns in the status, oldest first, starting right after the after ID
+	ListReturns(ctx context.Context, status Status, limit int, after int64) ([]Return, error)
+	// UpdateReturnStatus moves the return from event.From to event.To, storing refundAmount and the event in a single
+	// transaction and giving the books back to the stock when the return is approved. It also releases the claim on
+	// its refund and returns nil if the return is no longer in event.From
+	UpdateReturnStatus(ctx context.Context, event Event, refundAmount money.Amount) (*Event, error)
+	// ClaimRefund marks the refund of an approved return as being made, reporting false if the return isn't approved
+	// anymore or its refund was already claimed
+	ClaimRefund(ctx context.Context, returnID int64) (bool, error)
+	// ReleaseRefund forgets the claim on the refund of a return, so a failed refund can be retried
+	ReleaseRefund(ctx context.Context, returnID int64) error
+	// GetReturnEvents returns the audit trail of a return, oldest first
+	GetReturnEvents(ctx context.Context, returnID int64) ([]Event, error)
+}
+
+type PaymentService interface {
+	// GetPayment returns nil if the order doesn't have a payment
+	GetPayment(ctx context.Context, orderID int64) (*payment.Payment, error)
+	Refund(ctx context.Context, orderID int64, amount money.Amount) error
+}
+
+type Service struct {
+	r              Repository
+	paymentService PaymentService
+	window         time.Duration
+}
+
+// NewService creates the returns service, books can be returned up to window after their order was delivered
+func NewService(r Repository, paymentService PaymentService, window time.Duration) *Service {
+	return &Service{r, paymentService, window}
+}
+
+// IsNotFound reports whether err means that the return, or the order it's for, doesn't exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, errReturnNotFound) || errors.Is(err, errOrderNotFound)
+}
+
+// IsNotReturnable reports whether err means that the order or some of its books can't be returned anymore
+func IsNotReturnable(err error) bool {
+	return errors.Is(err, errNotDelivered) || errors.Is(err, errWindowOver) || errors.Is(err, errTooManyUnits)
+}
+
+// IsInvalidTransition reports whether err means that the return was already decided
+func IsInvalidTransition(err error) bool {
+	return errors.Is(err, errInvalidTransition)
+}
+
+// RefundAmount is what is given back for the items, computed from the prices they were bought for minus their
+// share of the order discount plus their share of the order tax
+func RefundAmount(items []Item, discount, tax money.Amount) money.Amount {
+	var r money.Amount
+	for _, v := range items {
+		r += v.Price.Mul(v.Quantity)
+	}
+	return r - discount + tax
+}
+
+// DiscountShare splits the order discount proportionally to the value of the items. The share is rounded up, so
+// returning every book of the order never gives back more than what was paid for it
+func DiscountShare(o Order, items []Item) money.Amount {
+	if o.Discount <= 0 {
+		return 0
+	}
+
+	var subtotal, value money.Amount
+	for _, v := range o.Items {
+		subtotal += v.Price.Mul(v.Quantity)
+	}
+	for _, v := range items {
+		value += v.Price.Mul(v.Quantity)
+	}
+	if subtotal <= 0 {
+		return 0
+	}
+
+	return (o.Discount*value + subtotal - 1) / subtotal
+}
+
+// TaxShare is the tax paid for the returned units of the items. The share is rounded down, so returning every book
+// of the order never gives back more than what was paid for it
+func TaxShare(o Order, items []Item) money.Amount {
+	bought := map[int64]OrderItem{}
+	for _, v := range o.Items {
+		bought[v.BookID] = v
+	}
+
+	var r money.Amount
+	for _, v := range items {
+		orderItem, ok := bought[v.BookID]
+		if !ok || orderItem.Quantity <= 0 {
+			continue
+		}
+		r += orderItem.Tax * money.Amount(v.Quantity) / money.Amount(orderItem.Quantity)
+	}
+	return r
+}
+
+type RequestItem struct {
+	BookID   int64 `json:"book_id"`
+	Quantity int   `json:"quantity"`
+}
+
+// RequestReturn asks to return some of the books of one of the customer orders, which is only possible for a while
+// after it was delivered. Orders of other customers are reported as not found
+func (s *Service) RequestReturn(ctx context.Context, customerID, orderID int64, items []RequestItem, reason string) (*Return, error) {
+	if orderID <= 0 {
+		return nil, errInvalidOrderID
+	}
+	if len(items) == 0 {
+		return nil, errEmptyItems
+	}
+	seen := map[int64]struct{}{}
+	for _, v := range items {
+		if v.BookID <= 0 {
+			return nil, errInvalidBookID
+		}
+		if v.Quantity <= 0 {
+			return nil, errInvalidQuantity
+		}
+		if _, ok := seen[v.BookID]; ok {
+			return nil, errDuplicateItem
+		}
+		seen[v.BookID] = struct{}{}
+	}
+	if reason == "" || len(reason) > 255 {
+		return nil, errReason
+	}
+
+	o, err := s.r.GetOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil || o.CustomerID != customerID {
+		return nil, errOrderNotFound
+	}
+	if o.Status != order.StatusDelivered || o.DeliveredAt == nil {
+		return nil, errNotDelivered
+	}
+	if time.Since(*o.DeliveredAt) > s.window {
+		return nil, fmt.Errorf("%w: it was delivered more than %s ago", errWindowOver, s.window)
+	}
+
+	bought := map[int64]OrderItem{}
+	for _, v := range o.Items {
+		bought[v.BookID] = v
+	}
+
+	now := time.Now()
+	r := Return{
+		OrderID:    orderID,
+		CustomerID: customerID,
+		Status:     StatusRequested,
+		Reason:     reason,
+		Items:      make([]Item, len(items)),
+		Currency:   o.Currency,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	for i, v := range items {
+		orderItem, ok := bought[v.BookID]
+		if !ok {
+			return nil, fmt.Errorf("%w: book %d", errItemNotInOrder, v.BookID)
+		}
+		if v.Quantity > orderItem.Quantity-orderItem.Returned {
+			return nil, fmt.Errorf("%w: book %d", errTooManyUnits, v.BookID)
+		}
+		r.Items[i] = Item{BookID: v.BookID, Quantity: v.Quantity, Price: orderItem.Price}
+	}
+	r.Discount = DiscountShare(*o, r.Items)
+	r.Tax = TaxShare(*o, r.Items)
+
+	id, err := s.r.SaveReturn(ctx, r)
+	if err != nil {
+		var unitsErr *TooManyUnitsError
+		if errors.As(err, &unitsErr) {
+			return nil, fmt.Errorf("%w: book %d", errTooManyUnits, unitsErr.BookID)
+		}
+		return nil, fmt.Errorf("return creation failed: %w", err)
+	}
+	r.ID = *id
+
+	return &r, nil
+}
+
+// ListOrderReturns returns the returns of one of the customer orders, orders of other customers are reported
+// as not found
+func (s *Service) ListOrderReturns(ctx context.Context, customerID, orderID int64) ([]Return, error) {
+	if orderID <= 0 {
+		return nil, errInvalidOrderID
+	}
+
+	o, err := s.r.GetOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil || o.CustomerID != customerID {
+		return nil, errOrderNotFound
+	}
+
+	returns, err := s.r.ListOrderReturns(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if returns == nil {
+		returns = []Return{}
+	}
+
+	return returns, nil
+}
+
+// ListReturns returns a page of the returns in a status, oldest first, so the ones waiting the longest are
+// decided first
+func (s *Service) ListReturns(ctx context.Context, params ListParams) (*Page, error) {
+	if params.Status == "" {
+		params.Status = StatusRequested
+	}
+	if !params.Status.Valid() {
+		return nil, errInvalidStatus
+	}
+	if params.Limit == 0 {
+		params.Limit = defaultPageSize
+	}
+	if params.Limit < 0 || params.Limit > maxPageSize {
+		return nil, errInvalidLimit
+	}
+	var after Cursor
+	if params.Cursor != "" {
+		if err := utils.DecodeCursor(params.Cursor, &after); err != nil || after.ID <= 0 {
+			return nil, errInvalidCursor
+		}
+	}
+
+	// ask for one extra return to know if there is a next page
+	returns, err := s.r.ListReturns(ctx, params.Status, params.Limit+1, after.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Items: returns}
+	if page.Items == nil {
+		page.Items = []Return{}
+	}
+	if len(returns) > params.Limit {
+		page.Items = returns[:params.Limit]
+		page.NextCursor, err = utils.EncodeCursor(Cursor{ID: page.Items[params.Limit-1].ID})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// GetReturn returns a return with its items
+func (s *Service) GetReturn(ctx context.Context, returnID int64) (*Return, error) {
+	if returnID <= 0 {
+		return nil, errInvalidReturnID
+	}
+
+	r, err := s.r.GetReturn(ctx, returnID)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, errReturnNotFound
+	}
+
+	return r, nil
+}
+
+// Approve accepts a requested return, puts its books back in stock and refunds what was paid for them. If the
+// refund fails the return stays approved, and approving it again retries the refund. A return whose refund went
+// through but couldn't be marked refunded is never refunded again
+func (s *Service) Approve(ctx context.Context, returnID, adminID int64, note string) (*Return, error) {
+	if len(note) > 255 {
+		return nil, errNote
+	}
+
+	r, err := s.GetReturn(ctx, returnID)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Status == StatusRequested {
+		r.RefundAmount = RefundAmount(r.Items, r.Discount, r.Tax)
+		if err := s.changeStatus(ctx, r, StatusApproved, note, adminID); err != nil {
+			return nil, err
+		}
+	}
+	if r.Status != StatusApproved {
+		return nil, fmt.Errorf("%w: the return is already %s", errInvalidTransition, r.Status)
+	}
+
+	// claim the refund first, so concurrent or repeated approvals can't give the money back twice
+	claimed, err := s.r.ClaimRefund(ctx, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, fmt.Errorf("%w: the return is no longer approved or its refund is already being made", errInvalidTransition)
+	}
+
+	if err := s.refund(ctx, r); err != nil {
+		if releaseErr := s.r.ReleaseRefund(ctx, r.ID); releaseErr != nil {
+			slog.Error("error releasing the refund of a return", "return", r.ID, "error", releaseErr)
+		}
+		return nil, fmt.Errorf("refund failed: %w", err)
+	}
+	if err := s.changeStatus(ctx, r, StatusRefunded, "", adminID); err != nil {
+		// the money was given back, the claim is kept so the return is never refunded again
+		slog.Error("error marking a refunded return", "return", r.ID, "error", err)
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// refund gives the refund amount of the return back through the order payment. Returns with nothing to give back,
+// like the ones of fully discounted orders, and orders without a payment don't go through the provider
+func (s *Service) refund(ctx context.Context, r *Return) error {
+	if r.RefundAmount == 0 {
+		return nil
+	}
+
+	p, err := s.paymentService.GetPayment(ctx, r.OrderID)
+	if err != nil || p == nil {
+		return err
+	}
+
+	return s.paymentService.Refund(ctx, r.OrderID, r.RefundAmount)
+}
+
+// Reject turns down a requested return, note tells the customer why
+func (s *Service) Reject(ctx context.Context, returnID, adminID int64, note string) (*Return, error) {
+	if note == "" || len(note) > 255 {
+		return nil, errNote
+	}
+
+	r, err := s.GetReturn(ctx, returnID)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != StatusRequested {
+		return nil, fmt.Errorf("%w: the return is already %s", errInvalidTransition, r.Status)
+	}
+
+	if err := s.changeStatus(ctx, r, StatusRejected, note, adminID); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// changeStatus stores the next status of the return, as long as it's still in its current one, and updates r
+func (s *Service) changeStatus(ctx context.Context, r *Return, next Status, note string, actorID int64) error {
+	event, err := s.r.UpdateReturnStatus(ctx, Event{
+		ReturnID:  r.ID,
+		From:      r.Status,
+		To:        next,
+		Note:      note,
+		ActorID:   &actorID,
+		CreatedAt: time.Now(),
+	}, r.RefundAmount)
+	if err != nil {
+		return err
+	}
+	if event == nil {
+		// the return changed since we read it, it has to be decided again
+		return fmt.Errorf("%w: the return is no longer %s", errInvalidTransition, r.Status)
+	}
+
+	r.Status = next
+	r.UpdatedAt = event.CreatedAt
+	return nil
+}
+
+// GetEvents returns the audit trail of a return, oldest first
+func (s *Service) GetEvents(ctx context.Context, returnID int64) ([]Event, error) {
+	if _, err := s.GetReturn(ctx, returnID); err != nil {
+		return nil, err
+	}
+
+	events, err := s.r.GetReturnEvents(ctx, returnID)
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []Event{}
+	}
+
+	return events, nil
+}
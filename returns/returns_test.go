@@ -0,0 +1,587 @@
+package returns
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/payment"
+	"strings"
+	"testing"
+	"time"
+)
+
+// MockRepository keeps the orders and returns in memory, mimicking the database behavior
+type MockRepository struct {
+	Orders    map[int64]*Order
+	Returns   []Return
+	Events    []Event
+	Refunding map[int64]bool
+	Err       error
+}
+
+func newMockRepository() *MockRepository {
+	delivered := time.Now().Add(-24 * time.Hour)
+	return &MockRepository{Refunding: map[int64]bool{}, Orders: map[int64]*Order{
+		1: {CustomerID: 1, Status: order.StatusDelivered, Currency: money.Currency, DeliveredAt: &delivered, Items: []OrderItem{
+			{BookID: 1, Quantity: 3, Price: 1099},
+			{BookID: 2, Quantity: 1, Price: 500},
+		}},
+	}}
+}
+
+func (m *MockRepository) GetOrder(ctx context.Context, orderID int64) (*Order, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	o, ok := m.Orders[orderID]
+	if !ok {
+		return nil, nil
+	}
+	c := *o
+	c.Items = nil
+	for _, v := range o.Items {
+		for _, r := range m.Returns {
+			if r.OrderID != orderID || r.Status == StatusRejected {
+				continue
+			}
+			for _, item := range r.Items {
+				if item.BookID == v.BookID {
+					v.Returned += item.Quantity
+				}
+			}
+		}
+		c.Items = append(c.Items, v)
+	}
+	return &c, nil
+}
+
+func (m *MockRepository) SaveReturn(ctx context.Context, r Return) (*int64, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	r.ID = int64(len(m.Returns) + 1)
+	m.Returns = append(m.Returns, r)
+	m.Events = append(m.Events, Event{ID: int64(len(m.Events) + 1), ReturnID: r.ID, To: r.Status, ActorID: &r.CustomerID})
+	return &r.ID, nil
+}
+
+func (m *MockRepository) GetReturn(ctx context.Context, returnID int64) (*Return, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if returnID > int64(len(m.Returns)) {
+		return nil, nil
+	}
+	r := m.Returns[returnID-1]
+	return &r, nil
+}
+
+func (m *MockRepository) ListOrderReturns(ctx context.Context, orderID int64) ([]Return, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	var r []Return
+	for _, v := range m.Returns {
+		if v.OrderID == orderID {
+			r = append(r, v)
+		}
+	}
+	return r, nil
+}
+
+func (m *MockRepository) ListReturns(ctx context.Context, status Status, limit int, after int64) ([]Return, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	var r []Return
+	for _, v := range m.Returns {
+		if v.Status == status && v.ID > after && len(r) < limit {
+			r = append(r, v)
+		}
+	}
+	return r, nil
+}
+
+func (m *MockRepository) UpdateReturnStatus(ctx context.Context, event Event, refundAmount money.Amount) (*Event, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	r := &m.Returns[event.ReturnID-1]
+	if r.Status != event.From {
+		return nil, nil
+	}
+	r.Status = event.To
+	r.RefundAmount = refundAmount
+	delete(m.Refunding, r.ID)
+	event.ID = int64(len(m.Events) + 1)
+	m.Events = append(m.Events, event)
+	return &event, nil
+}
+
+func (m *MockRepository) ClaimRefund(ctx context.Context, returnID int64) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	if m.Returns[returnID-1].Status != StatusApproved || m.Refunding[returnID] {
+		return false, nil
+	}
+	m.Refunding[returnID] = true
+	return true, nil
+}
+
+func (m *MockRepository) ReleaseRefund(ctx context.Context, returnID int64) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	delete(m.Refunding, returnID)
+	return nil
+}
+
+func (m *MockRepository) GetReturnEvents(ctx context.Context, returnID int64) ([]Event, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	var r []Event
+	for _, v := range m.Events {
+		if v.ReturnID == returnID {
+			r = append(r, v)
+		}
+	}
+	return r, nil
+}
+
+// MockPaymentService has a payment for every order unless NoPayment is set
+type MockPaymentService struct {
+	Refunded  money.Amount
+	NoPayment bool
+	Err       error
+}
+
+func (m *MockPaymentService) GetPayment(ctx context.Context, orderID int64) (*payment.Payment, error) {
+	if m.NoPayment {
+		return nil, nil
+	}
+	return &payment.Payment{OrderID: orderID, Status: payment.StatusCaptured}, nil
+}
+
+func (m *MockPaymentService) Refund(ctx context.Context, orderID int64, amount money.Amount) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	m.Refunded += amount
+	return nil
+}
+
+func TestRefundAmount(t *testing.T) {
+	items := []Item{{BookID: 1, Quantity: 2, Price: 1099}, {BookID: 2, Quantity: 1, Price: 333}}
+	if total := RefundAmount(items, 0, 0); total != 2531 {
+		t.Fatalf("Expected 25.31, got: %s", total)
+	}
+	if total := RefundAmount(items, 531, 100); total != 2100 {
+		t.Fatalf("Expected 20.00, got: %s", total)
+	}
+}
+
+func TestDiscountShare(t *testing.T) {
+	o := Order{Discount: 1000, Items: []OrderItem{{BookID: 1, Quantity: 1, Price: 1000}, {BookID: 2, Quantity: 2, Price: 1000}}}
+
+	one := DiscountShare(o, []Item{{BookID: 1, Quantity: 1, Price: 1000}})
+	if one != 334 {
+		t.Fatalf("Expected a third of the discount rounded up, got: %s", one)
+	}
+
+	// returning the books one by one never refunds more than what was paid
+	var refunded money.Amount
+	for _, v := range []Item{{BookID: 1, Quantity: 1, Price: 1000}, {BookID: 2, Quantity: 1, Price: 1000}, {BookID: 2, Quantity: 1, Price: 1000}} {
+		items := []Item{v}
+		refunded += RefundAmount(items, DiscountShare(o, items), 0)
+	}
+	if refunded > 2000 {
+		t.Fatalf("Expected at most 20.00 to be refunded, got: %s", refunded)
+	}
+
+	if share := DiscountShare(Order{Items: o.Items}, []Item{{BookID: 1, Quantity: 1, Price: 1000}}); share != 0 {
+		t.Fatalf("Expected no share without a discount, got: %s", share)
+	}
+}
+
+func TestService_RequestReturn(t *testing.T) {
+	repoErr := errors.New("repo err")
+
+	tests := []struct {
+		name          string
+		customerID    int64
+		orderID       int64
+		items         []RequestItem
+		reason        string
+		setup         func(m *MockRepository)
+		expectedError error
+	}{
+		{
+			name:       "Valid",
+			customerID: 1,
+			orderID:    1,
+			items:      []RequestItem{{BookID: 1, Quantity: 2}},
+			reason:     "damaged",
+		},
+		{
+			name:       "WhatIsLeftAfterAnotherReturn",
+			customerID: 1,
+			orderID:    1,
+			items:      []RequestItem{{BookID: 1, Quantity: 1}},
+			reason:     "damaged",
+			setup: func(m *MockRepository) {
+				m.Returns = append(m.Returns, Return{ID: 1, OrderID: 1, Status: StatusRequested, Items: []Item{{BookID: 1, Quantity: 2}}})
+			},
+		},
+		{
+			name:       "RejectedReturnsDontCount",
+			customerID: 1,
+			orderID:    1,
+			items:      []RequestItem{{BookID: 1, Quantity: 3}},
+			reason:     "damaged",
+			setup: func(m *MockRepository) {
+				m.Returns = append(m.Returns, Return{ID: 1, OrderID: 1, Status: StatusRejected, Items: []Item{{BookID: 1, Quantity: 3}}})
+			},
+		},
+		{
+			name:       "TooManyUnits",
+			customerID: 1,
+			orderID:    1,
+			items:      []RequestItem{{BookID: 1, Quantity: 2}},
+			reason:     "damaged",
+			setup: func(m *MockRepository) {
+				m.Returns = append(m.Returns, Return{ID: 1, OrderID: 1, Status: StatusApproved, Items: []Item{{BookID: 1, Quantity: 2}}})
+			},
+			expectedError: errTooManyUnits,
+		},
+		{
+			name:          "BookNotInOrder",
+			customerID:    1,
+			orderID:       1,
+			items:         []RequestItem{{BookID: 3, Quantity: 1}},
+			reason:        "damaged",
+			expectedError: errItemNotInOrder,
+		},
+		{
+			name:          "AnotherCustomerOrder",
+			customerID:    2,
+			orderID:       1,
+			items:         []RequestItem{{BookID: 1, Quantity: 1}},
+			reason:        "damaged",
+			expectedError: errOrderNotFound,
+		},
+		{
+			name:          "OrderNotFound",
+			customerID:    1,
+			orderID:       5,
+			items:         []RequestItem{{BookID: 1, Quantity: 1}},
+			reason:        "damaged",
+			expectedError: errOrderNotFound,
+		},
+		{
+			name:       "NotDelivered",
+			customerID: 1,
+			orderID:    1,
+			items:      []RequestItem{{BookID: 1, Quantity: 1}},
+			reason:     "damaged",
+			setup: func(m *MockRepository) {
+				m.Orders[1].Status = order.StatusShipped
+			},
+			expectedError: errNotDelivered,
+		},
+		{
+			name:       "WindowOver",
+			customerID: 1,
+			orderID:    1,
+			items:      []RequestItem{{BookID: 1, Quantity: 1}},
+			reason:     "damaged",
+			setup: func(m *MockRepository) {
+				delivered := time.Now().Add(-31 * 24 * time.Hour)
+				m.Orders[1].DeliveredAt = &delivered
+			},
+			expectedError: errWindowOver,
+		},
+		{
+			name:          "InvalidOrderID",
+			customerID:    1,
+			orderID:       0,
+			items:         []RequestItem{{BookID: 1, Quantity: 1}},
+			reason:        "damaged",
+			expectedError: errInvalidOrderID,
+		},
+		{
+			name:          "EmptyItems",
+			customerID:    1,
+			orderID:       1,
+			reason:        "damaged",
+			expectedError: errEmptyItems,
+		},
+		{
+			name:          "InvalidQuantity",
+			customerID:    1,
+			orderID:       1,
+			items:         []RequestItem{{BookID: 1, Quantity: 0}},
+			reason:        "damaged",
+			expectedError: errInvalidQuantity,
+		},
+		{
+			name:          "DuplicateBook",
+			customerID:    1,
+			orderID:       1,
+			items:         []RequestItem{{BookID: 1, Quantity: 1}, {BookID: 1, Quantity: 1}},
+			reason:        "damaged",
+			expectedError: errDuplicateItem,
+		},
+		{
+			name:          "ReasonTooLong",
+			customerID:    1,
+			orderID:       1,
+			items:         []RequestItem{{BookID: 1, Quantity: 1}},
+			reason:        strings.Repeat("a", 256),
+			expectedError: errReason,
+		},
+		{
+			name:       "RepositoryError",
+			customerID: 1,
+			orderID:    1,
+			items:      []RequestItem{{BookID: 1, Quantity: 1}},
+			reason:     "damaged",
+			setup: func(m *MockRepository) {
+				m.Err = repoErr
+			},
+			expectedError: repoErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockRepository()
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+			s := NewService(m, &MockPaymentService{}, 30*24*time.Hour)
+
+			r, err := s.RequestReturn(context.Background(), tt.customerID, tt.orderID, tt.items, tt.reason)
+
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+				}
+				if IsNotFound(err) != (tt.expectedError == errOrderNotFound) {
+					t.Errorf("IsNotFound mismatch for error: %v", err)
+				}
+				if IsNotReturnable(err) != (tt.expectedError == errNotDelivered || tt.expectedError == errWindowOver || tt.expectedError == errTooManyUnits) {
+					t.Errorf("IsNotReturnable mismatch for error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if r.ID == 0 || r.Status != StatusRequested || r.Items[0].Price != 1099 || r.RefundAmount != 0 {
+				t.Fatalf("Expected a requested return with the order prices, got: %+v", r)
+			}
+		})
+	}
+}
+
+func TestService_RequestReturn_DiscountShare(t *testing.T) {
+	m := newMockRepository()
+	m.Orders[1].Discount = 383
+	s := NewService(m, &MockPaymentService{}, 30*24*time.Hour)
+
+	r, err := s.RequestReturn(context.Background(), 1, 1, []RequestItem{{BookID: 2, Quantity: 1}}, "damaged")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if r.Discount != 51 || m.Returns[0].Discount != 51 {
+		t.Fatalf("Expected the return to keep its share of the discount, got: %s", r.Discount)
+	}
+}
+
+func TestService_Approve(t *testing.T) {
+	newService := func(payments *MockPaymentService) (*Service, *MockRepository) {
+		m := newMockRepository()
+		s := NewService(m, payments, time.Hour)
+		m.Returns = []Return{{ID: 1, OrderID: 1, Status: StatusRequested, Items: []Item{{BookID: 1, Quantity: 2, Price: 1099}, {BookID: 2, Quantity: 1, Price: 500}}}}
+		return s, m
+	}
+
+	t.Run("RefundsThePricesPaid", func(t *testing.T) {
+		payments := &MockPaymentService{}
+		s, m := newService(payments)
+
+		r, err := s.Approve(context.Background(), 1, 42, "")
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if r.Status != StatusRefunded || r.RefundAmount != 2698 || payments.Refunded != 2698 {
+			t.Fatalf("Expected 26.98 to be refunded, got: %+v, refunded: %s", r, payments.Refunded)
+		}
+		if len(m.Events) != 2 || m.Events[0].To != StatusApproved || m.Events[1].To != StatusRefunded || *m.Events[1].ActorID != 42 {
+			t.Fatalf("Expected every step to be recorded, got: %+v", m.Events)
+		}
+
+		if _, err := s.Approve(context.Background(), 1, 42, ""); !IsInvalidTransition(err) {
+			t.Fatalf("Expected error: %v, got: %v", errInvalidTransition, err)
+		}
+		if payments.Refunded != 2698 {
+			t.Fatalf("Expected a single refund, got: %s", payments.Refunded)
+		}
+	})
+
+	t.Run("TakesTheDiscountShareOff", func(t *testing.T) {
+		payments := &MockPaymentService{}
+		s, m := newService(payments)
+		m.Returns[0].Discount = 698
+
+		r, err := s.Approve(context.Background(), 1, 42, "")
+		if err != nil || r.RefundAmount != 2000 || payments.Refunded != 2000 {
+			t.Fatalf("Expected 20.00 to be refunded, got: %+v, %v", r, err)
+		}
+	})
+
+	t.Run("FullyDiscountedOrder", func(t *testing.T) {
+		// a 100% coupon leaves nothing to pay, so there is no payment and nothing to give back
+		payments := &MockPaymentService{NoPayment: true, Err: errors.New("there is nothing to refund")}
+		s, m := newService(payments)
+		m.Returns[0].Discount = 2698
+
+		r, err := s.Approve(context.Background(), 1, 42, "")
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if r.Status != StatusRefunded || r.RefundAmount != 0 || payments.Refunded != 0 {
+			t.Fatalf("Expected the return to be refunded without a refund, got: %+v, refunded: %s", r, payments.Refunded)
+		}
+	})
+
+	t.Run("OrderWithoutPayment", func(t *testing.T) {
+		payments := &MockPaymentService{NoPayment: true, Err: errors.New("there is no payment to refund")}
+		s, _ := newService(payments)
+
+		r, err := s.Approve(context.Background(), 1, 42, "")
+		if err != nil || r.Status != StatusRefunded || r.RefundAmount != 2698 || payments.Refunded != 0 {
+			t.Fatalf("Expected the return to be refunded without the provider, got: %+v, %v", r, err)
+		}
+	})
+
+	t.Run("FailedRefundCanBeRetried", func(t *testing.T) {
+		paymentErr := errors.New("provider err")
+		payments := &MockPaymentService{Err: paymentErr}
+		s, m := newService(payments)
+
+		if _, err := s.Approve(context.Background(), 1, 42, ""); !errors.Is(err, paymentErr) {
+			t.Fatalf("Expected error: %v, got: %v", paymentErr, err)
+		}
+		if m.Returns[0].Status != StatusApproved || m.Refunding[1] {
+			t.Fatalf("Expected the return to stay approved and its refund to be released, got: %s", m.Returns[0].Status)
+		}
+
+		payments.Err = nil
+		r, err := s.Approve(context.Background(), 1, 42, "")
+		if err != nil || r.Status != StatusRefunded || payments.Refunded != 2698 {
+			t.Fatalf("Expected the refund to be retried, got: %+v, %v", r, err)
+		}
+	})
+
+	t.Run("RefundBeingMadeIsNotMadeAgain", func(t *testing.T) {
+		payments := &MockPaymentService{}
+		s, m := newService(payments)
+		m.Returns[0].Status = StatusApproved
+		m.Refunding[1] = true
+
+		if _, err := s.Approve(context.Background(), 1, 42, ""); !IsInvalidTransition(err) {
+			t.Fatalf("Expected error: %v, got: %v", errInvalidTransition, err)
+		}
+		if payments.Refunded != 0 {
+			t.Fatalf("Expected no refund, got: %s", payments.Refunded)
+		}
+	})
+
+	t.Run("RejectedCantBeApproved", func(t *testing.T) {
+		s, m := newService(&MockPaymentService{})
+		m.Returns[0].Status = StatusRejected
+
+		if _, err := s.Approve(context.Background(), 1, 42, ""); !IsInvalidTransition(err) {
+			t.Fatalf("Expected error: %v, got: %v", errInvalidTransition, err)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		s, _ := newService(&MockPaymentService{})
+
+		if _, err := s.Approve(context.Background(), 2, 42, ""); !IsNotFound(err) {
+			t.Fatalf("Expected error: %v, got: %v", errReturnNotFound, err)
+		}
+	})
+}
+
+func TestService_Reject(t *testing.T) {
+	m := newMockRepository()
+	s := NewService(m, &MockPaymentService{}, time.Hour)
+	m.Returns = []Return{{ID: 1, OrderID: 1, Status: StatusRequested, Items: []Item{{BookID: 1, Quantity: 2, Price: 1099}}}}
+
+	if _, err := s.Reject(context.Background(), 1, 42, ""); !errors.Is(err, errNote) {
+		t.Fatalf("Expected error: %v, got: %v", errNote, err)
+	}
+
+	r, err := s.Reject(context.Background(), 1, 42, "the book was used")
+	if err != nil || r.Status != StatusRejected || r.RefundAmount != 0 {
+		t.Fatalf("Expected the return to be rejected, got: %+v, %v", r, err)
+	}
+
+	events, err := s.GetEvents(context.Background(), 1)
+	if err != nil || len(events) != 1 || events[0].From != StatusRequested || events[0].Note != "the book was used" {
+		t.Fatalf("Expected the rejection to be recorded, got: %+v, %v", events, err)
+	}
+
+	if _, err := s.Reject(context.Background(), 1, 42, "again"); !IsInvalidTransition(err) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidTransition, err)
+	}
+}
+
+func TestService_ListReturns(t *testing.T) {
+	m := newMockRepository()
+	s := NewService(m, &MockPaymentService{}, time.Hour)
+	for i := 1; i <= 3; i++ {
+		m.Returns = append(m.Returns, Return{ID: int64(i), OrderID: 1, Status: StatusRequested})
+	}
+	m.Returns = append(m.Returns, Return{ID: 4, OrderID: 1, Status: StatusRefunded})
+
+	page, err := s.ListReturns(context.Background(), ListParams{Limit: 2})
+	if err != nil || len(page.Items) != 2 || page.Items[0].ID != 1 || page.NextCursor == "" {
+		t.Fatalf("Expected the first page of requested returns, got: %+v, %v", page, err)
+	}
+
+	page, err = s.ListReturns(context.Background(), ListParams{Limit: 2, Cursor: page.NextCursor})
+	if err != nil || len(page.Items) != 1 || page.Items[0].ID != 3 || page.NextCursor != "" {
+		t.Fatalf("Expected the last page of requested returns, got: %+v, %v", page, err)
+	}
+
+	if _, err := s.ListReturns(context.Background(), ListParams{Status: "lost"}); !errors.Is(err, errInvalidStatus) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidStatus, err)
+	}
+	if _, err := s.ListReturns(context.Background(), ListParams{Limit: 101}); !errors.Is(err, errInvalidLimit) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidLimit, err)
+	}
+	if _, err := s.ListReturns(context.Background(), ListParams{Cursor: "nope"}); !errors.Is(err, errInvalidCursor) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidCursor, err)
+	}
+}
+
+func TestService_ListOrderReturns(t *testing.T) {
+	m := newMockRepository()
+	s := NewService(m, &MockPaymentService{}, time.Hour)
+
+	r, err := s.ListOrderReturns(context.Background(), 1, 1)
+	if err != nil || r == nil || len(r) != 0 {
+		t.Fatalf("Expected an empty list, got: %+v, %v", r, err)
+	}
+
+	if _, err := s.ListOrderReturns(context.Background(), 2, 1); !IsNotFound(err) {
+		t.Fatalf("Expected error: %v, got: %v", errOrderNotFound, err)
+	}
+}
@@ -1,35 +1,56 @@
 package security
 
 import (
-	"fmt"
+	"context"
 	"github.com/ap-pauloafonso/bookstore/utils"
 	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 )
 
-var (
-	jwtSecret = []byte("my-secret-key")
+const (
+	// RoleCustomer is given to every registered customer
+	RoleCustomer = "customer"
+	// RoleAdmin is given to the staff that manages the catalog and the orders
+	RoleAdmin = "admin"
 )
 
-func GenerateJwtToken(email string, id int64) (string, error) {
-	// Create a token with customer information
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// AccessClaims is what an access token says about the customer and the session it was issued to
+type AccessClaims struct {
+	CustomerID int64
+	Email      string
+	Roles      []string
+	SessionID  int64
+	// TokenID is the jti claim, which identifies the token so it can be revoked on its own
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+// RevocationChecker tells whether the session of a token, or the token itself, was revoked
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, sessionID int64, tokenID string) (bool, error)
+}
+
+// GenerateJwtToken creates an access token with the claims, signed by the signing key of the set
+func (k *KeySet) GenerateJwtToken(claims AccessClaims) (string, error) {
+	return k.Sign(jwt.MapClaims{
 		// in case we want to hide the customerID from the end user we could use symmetric encryption here
 		// but let's keep it simple, and just put the plain id there, as this information is not too sensitive.
-		"id":    id,
-		"email": email,
-		"exp":   time.Now().Add(time.Hour * 24).Unix(), // 1 day expires
+		"id":    claims.CustomerID,
+		"email": claims.Email,
+		"roles": claims.Roles,
+		"sid":   claims.SessionID,
+		"jti":   claims.TokenID,
+		"exp":   claims.ExpiresAt.Unix(),
 	})
-
-	// Sign and get the complete encoded token as a string
-	return token.SignedString(jwtSecret)
-
 }
 
-func JwtCheckMiddleware() echo.MiddlewareFunc {
+// JwtCheckMiddleware only lets the request through if it has a token verified by one of the keys of the set,
+// whose session and jti weren't revoked
+func (k *KeySet) JwtCheckMiddleware(revocations RevocationChecker) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			// Get the "Authorization" header from the request
@@ -43,37 +64,108 @@ func JwtCheckMiddleware() echo.MiddlewareFunc {
 			// Extract the token from the header (excluding "Bearer ")
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				// Validate the signing method
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("invalid signing method")
-				}
-				return jwtSecret, nil
-			})
+			// Verify the token with the key of its kid header
+			claims, err := k.Parse(tokenString)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: "Unauthorized"})
+			}
+
+			// Extract and store the email in the context
+			if email, ok := claims["email"].(string); ok {
+				c.Set("email", email)
+			} else {
+				return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: "Unauthorized"})
+			}
+
+			// Extract and store the id in the context
+			if id, ok := claims["id"].(float64); ok {
+				c.Set("id", int64(id))
+			} else {
+				return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: "Unauthorized"})
+			}
+
+			// Extract the session and the token id, tokens issued before sessions existed can't be revoked so they aren't accepted
+			sessionID, ok := claims["sid"].(float64)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: "Unauthorized"})
+			}
+			tokenID, ok := claims["jti"].(string)
+			if !ok || tokenID == "" {
+				return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: "Unauthorized"})
+			}
 
+			revoked, err := revocations.IsRevoked(c.Request().Context(), int64(sessionID), tokenID)
 			if err != nil {
+				slog.Error(err.Error())
+				return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "Internal Server Error"})
+			}
+			if revoked {
 				return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: "Unauthorized"})
 			}
+			c.Set("session", int64(sessionID))
+			c.Set("jti", tokenID)
 
-			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-				// Extract and store the email in the context
-				if email, ok := claims["email"].(string); ok {
-					c.Set("email", email)
-				} else {
-					return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: "Unauthorized"})
+			// Extract and store the roles in the context, tokens issued before roles existed don't have any
+			roles := []string{}
+			if rawRoles, ok := claims["roles"].([]interface{}); ok {
+				for _, r := range rawRoles {
+					if role, ok := r.(string); ok {
+						roles = append(roles, role)
+					}
 				}
+			}
+			c.Set("roles", roles)
 
-				// Extract and store the id in the context
-				if id, ok := claims["id"].(float64); ok {
-					c.Set("id", int64(id))
-				} else {
-					return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: "Unauthorized"})
-				}
+			return next(c)
+		}
+	}
+}
+
+// RequireRole only lets the request through if the authenticated customer has at least one of the given roles,
+// it must be placed after JwtCheckMiddleware
+func RequireRole(allowed ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			roles, ok := c.Get("roles").([]string)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: "Unauthorized"})
+			}
 
-				return next(c)
+			for _, role := range roles {
+				for _, a := range allowed {
+					if role == a {
+						return next(c)
+					}
+				}
 			}
 
-			return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: "Unauthorized"})
+			return c.JSON(http.StatusForbidden, utils.ErrorMessage{ErrorMessage: "Forbidden"})
 		}
 	}
 }
+
+// GenerateEmailToken creates a token for a link emailed to the customer, it is only valid for the purpose and
+// the email it was sent to. It can't be used as an access token since it doesn't belong to a session
+func (k *KeySet) GenerateEmailToken(purpose string, customerID int64, email string, expiresAt time.Time) (string, error) {
+	return k.Sign(jwt.MapClaims{
+		"purpose": purpose,
+		"id":      customerID,
+		"email":   email,
+		"exp":     expiresAt.Unix(),
+	})
+}
+
+// ParseEmailToken verifies a token of GenerateEmailToken, returning the customer and the email it was sent to
+func (k *KeySet) ParseEmailToken(purpose, tokenString string) (int64, string, error) {
+	claims, err := k.Parse(tokenString)
+	if err != nil {
+		return 0, "", err
+	}
+
+	id, idOK := claims["id"].(float64)
+	email, emailOK := claims["email"].(string)
+	if claims["purpose"] != purpose || !idOK || !emailOK {
+		return 0, "", errInvalidEmailToken
+	}
+	return int64(id), email, nil
+}
@@ -0,0 +1,317 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"log/slog"
+	"math/big"
+	"os"
+)
+
+const (
+	minSecretLength = 32
+	minRSABits      = 2048
+)
+
+var (
+	errInvalidKey        = errors.New("invalid jwt key")
+	errDuplicateKey      = errors.New("duplicate jwt key")
+	errUnknownKey        = errors.New("unknown jwt key")
+	errKeyMethodInvalid  = errors.New("the token signing method doesn't match its key")
+	errInvalidEmailToken = errors.New("the token isn't meant for this link")
+)
+
+// Key signs or verifies tokens with one algorithm, ID is the kid header of the tokens it signs
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// NewSecretKey creates an HS256 Key, the secret needs to have at least 32 bytes and is never published
+func NewSecretKey(secret []byte) (*Key, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("%w: the secret needs at least %d bytes", errInvalidKey, minSecretLength)
+	}
+	return newKey(jwt.SigningMethodHS256, secret, secret)
+}
+
+// GenerateKey creates a random EdDSA Key
+func GenerateKey() (*Key, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newKey(jwt.SigningMethodEdDSA, private, public)
+}
+
+// ParsePrivateKey reads a PEM encoded RSA (RS256) or Ed25519 (EdDSA) private key
+func ParsePrivateKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data found", errInvalidKey)
+	}
+
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: unexpected PEM block %q", errInvalidKey, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err.Error())
+	}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		return newKey(jwt.SigningMethodRS256, k, &k.PublicKey)
+	case ed25519.PrivateKey:
+		return newKey(jwt.SigningMethodEdDSA, k, k.Public())
+	}
+	return nil, fmt.Errorf("%w: only RSA and Ed25519 keys are supported", errInvalidKey)
+}
+
+// ParsePublicKey reads a PEM encoded RSA or Ed25519 public key, the Key can only verify tokens.
+// Private keys are accepted too, only their public half is kept
+func ParsePublicKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data found", errInvalidKey)
+	}
+
+	var public interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err := ParsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		key.private = nil
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err.Error())
+	}
+
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return newKey(jwt.SigningMethodRS256, nil, k)
+	case ed25519.PublicKey:
+		return newKey(jwt.SigningMethodEdDSA, nil, k)
+	}
+	return nil, fmt.Errorf("%w: only RSA and Ed25519 keys are supported", errInvalidKey)
+}
+
+func newKey(method jwt.SigningMethod, private, public interface{}) (*Key, error) {
+	if k, ok := public.(*rsa.PublicKey); ok && k.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("%w: RSA keys need at least %d bits", errInvalidKey, minRSABits)
+	}
+
+	key := &Key{Method: method, private: private, public: public}
+	key.ID = key.thumbprint()
+	return key, nil
+}
+
+// secretKeyIDLabel is signed with a secret to get its id, so the same secret always gets the same id
+const secretKeyIDLabel = "bookstore jwt key id"
+
+// thumbprint is the RFC 7638 thumbprint of a public key, so the same key always gets the same id. A secret gets an
+// HMAC of a fixed label instead, its thumbprint would be a plain hash of the secret that anyone could check guesses
+// against
+func (k *Key) thumbprint() string {
+	if secret, ok := k.public.([]byte); ok {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(secretKeyIDLabel))
+		return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	// the members have to be in lexicographic order, which is how json.Marshal writes a map
+	var members map[string]string
+	switch key := k.public.(type) {
+	case *rsa.PublicKey:
+		members = map[string]string{"kty": "RSA", "n": encodeInt(key.N), "e": encodeInt(big.NewInt(int64(key.E)))}
+	case ed25519.PublicKey:
+		members = map[string]string{"kty": "OKP", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(key)}
+	}
+
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWK is the public part of a Key, as described by RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the set of keys other services can verify the tokens with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// jwk returns the public part of the key, secrets don't have one
+func (k *Key) jwk() (JWK, bool) {
+	switch key := k.public.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", Kid: k.ID, Use: "sig", Alg: k.Method.Alg(), N: encodeInt(key.N), E: encodeInt(big.NewInt(int64(key.E)))}, true
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Kid: k.ID, Use: "sig", Alg: k.Method.Alg(), Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(key)}, true
+	}
+	return JWK{}, false
+}
+
+func encodeInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// KeySet signs the tokens with one key and verifies them with any of its keys, the previous signing keys are
+// kept as verification keys while the tokens they signed haven't expired, so the signing key can be rotated
+// without logging everyone out
+type KeySet struct {
+	signing *Key
+	keys    []*Key
+	byID    map[string]*Key
+}
+
+// NewKeySet creates a KeySet that signs with signing, which can't be a verification only key
+func NewKeySet(signing *Key, verification ...*Key) (*KeySet, error) {
+	if signing == nil || signing.private == nil {
+		return nil, fmt.Errorf("%w: the signing key needs its private part", errInvalidKey)
+	}
+
+	k := &KeySet{signing: signing, byID: map[string]*Key{}}
+	for _, v := range append([]*Key{signing}, verification...) {
+		if _, ok := k.byID[v.ID]; ok {
+			return nil, fmt.Errorf("%w: %s", errDuplicateKey, v.ID)
+		}
+		k.byID[v.ID] = v
+		k.keys = append(k.keys, v)
+	}
+
+	return k, nil
+}
+
+// LoadKeySet builds the KeySet of the configuration: the signing key comes from the PEM file at signingKeyFile or
+// from the HS256 secret, and the PEM files at verificationKeyFiles are the keys of the previous signing keys.
+// A random key is generated when neither is given, the tokens it signs stop working when the server restarts
+func LoadKeySet(signingKeyFile, secret string, verificationKeyFiles []string) (*KeySet, error) {
+	var signing *Key
+	var err error
+	switch {
+	case signingKeyFile != "" && secret != "":
+		return nil, fmt.Errorf("%w: either a signing key file or a secret can be given, not both", errInvalidKey)
+	case signingKeyFile != "":
+		data, err := os.ReadFile(signingKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading jwt signing key: %w", err)
+		}
+		signing, err = ParsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+	case secret != "":
+		signing, err = NewSecretKey([]byte(secret))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		slog.Warn("no jwt signing key configured, using a random one: tokens won't survive a restart")
+		signing, err = GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	verification := make([]*Key, 0, len(verificationKeyFiles))
+	for _, path := range verificationKeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading jwt verification key: %w", err)
+		}
+		key, err := ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		verification = append(verification, key)
+	}
+
+	return NewKeySet(signing, verification...)
+}
+
+// Sign creates a token with the claims, signed by the signing key and with its id in the kid header
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+	return token.SignedString(k.signing.private)
+}
+
+// Parse verifies the token with the key of its kid header and validates its claims
+func (k *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, k.verificationKey)
+	if err != nil {
+		// the jwt package wraps the errors of verificationKey without letting errors.Is see them
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Inner != nil {
+			return nil, validationErr.Inner
+		}
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errInvalidKey
+	}
+	return claims, nil
+}
+
+// verificationKey finds the key of the token, which needs to be of the algorithm the token claims to be signed with,
+// otherwise a public key could be used as an HMAC secret
+func (k *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", errUnknownKey, kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errKeyMethodInvalid
+	}
+	return key.public, nil
+}
+
+// JWKS returns the public keys of the set, the signing key first. Secrets are left out
+func (k *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, v := range k.keys {
+		if jwk, ok := v.jwk(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
@@ -0,0 +1,510 @@
+package security
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testClaims(id int64, roles ...string) AccessClaims {
+	return AccessClaims{CustomerID: id, Email: "a@a.com", Roles: roles, SessionID: 3, TokenID: "jti", ExpiresAt: time.Now().Add(time.Minute)}
+}
+
+func rsaPEM(t *testing.T, bits int) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func ed25519PEM(t *testing.T) (private, public []byte) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+}
+
+func TestKeySet_SignAndParse(t *testing.T) {
+	edPrivate, _ := ed25519PEM(t)
+	rsaKey, err := ParsePrivateKey(rsaPEM(t, 2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := ParsePrivateKey(edPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretKey, err := NewSecretKey([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  *Key
+		alg  string
+	}{
+		{name: "RS256", key: rsaKey, alg: "RS256"},
+		{name: "EdDSA", key: edKey, alg: "EdDSA"},
+		{name: "HS256", key: secretKey, alg: "HS256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeySet(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tokenString, err := keys.GenerateJwtToken(testClaims(7, RoleCustomer))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Header["alg"] != tt.alg || token.Header["kid"] != tt.key.ID {
+				t.Fatalf("Expected alg %s and kid %s, got: %v", tt.alg, tt.key.ID, token.Header)
+			}
+
+			claims, err := keys.Parse(tokenString)
+			if err != nil {
+				t.Fatalf("Expected the token to be valid, got: %v", err)
+			}
+			if claims["email"] != "a@a.com" || claims["id"] != float64(7) {
+				t.Fatalf("Unexpected claims: %v", claims)
+			}
+		})
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	oldPrivate, oldPublic := ed25519PEM(t)
+	oldKey, err := ParsePrivateKey(oldPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKeys, err := NewKeySet(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := oldKeys.GenerateJwtToken(testClaims(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := ParsePrivateKey(rsaPEM(t, 2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := ParsePublicKey(oldPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous.ID != oldKey.ID {
+		t.Fatalf("Expected the public key to have the id of its private key: %s, got: %s", oldKey.ID, previous.ID)
+	}
+
+	keys, err := NewKeySet(newKey, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(oldToken); err != nil {
+		t.Fatalf("Expected the token of the previous key to be valid, got: %v", err)
+	}
+
+	// once the previous key is dropped its tokens are rejected
+	keys, err = NewKeySet(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(oldToken); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("Expected error: %v, got: %v", errUnknownKey, err)
+	}
+
+	if _, err := NewKeySet(newKey, newKey); !errors.Is(err, errDuplicateKey) {
+		t.Fatalf("Expected error: %v, got: %v", errDuplicateKey, err)
+	}
+	if _, err := NewKeySet(previous); !errors.Is(err, errInvalidKey) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidKey, err)
+	}
+}
+
+func TestKeySet_Parse_Invalid(t *testing.T) {
+	key, err := ParsePrivateKey(rsaPEM(t, 2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an HMAC token that uses the public key of the set as its secret
+	public := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(key.public.(*rsa.PublicKey))})
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 1, "email": "a@a.com"})
+	forged.Header["kid"] = key.ID
+	forgedString, err := forged.SignedString(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(forgedString); !errors.Is(err, errKeyMethodInvalid) {
+		t.Fatalf("Expected error: %v, got: %v", errKeyMethodInvalid, err)
+	}
+
+	// the tokens signed with the old hardcoded secret don't have a kid
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 1}).SignedString([]byte("my-secret-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(legacy); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("Expected error: %v, got: %v", errUnknownKey, err)
+	}
+
+	expired, err := keys.Sign(jwt.MapClaims{"id": 1, "exp": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(expired); err == nil {
+		t.Fatal("Expected an expired token to be rejected")
+	}
+}
+
+func TestNewSecretKey_ID(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	key, err := NewSecretKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewSecretKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID == "" || key.ID != again.ID {
+		t.Fatalf("Expected the same secret to always get the same id, got: %q and %q", key.ID, again.ID)
+	}
+
+	// the id can't be computed from the secret alone, like its RFC 7638 thumbprint could
+	members, _ := json.Marshal(map[string]string{"kty": "oct", "k": base64.RawURLEncoding.EncodeToString(secret)})
+	thumbprint := sha256.Sum256(members)
+	plain := sha256.Sum256(secret)
+	for _, sum := range [][]byte{thumbprint[:], plain[:]} {
+		if key.ID == base64.RawURLEncoding.EncodeToString(sum) {
+			t.Fatalf("Expected the id not to be a plain hash of the secret, got: %s", key.ID)
+		}
+	}
+}
+
+func TestParseKeys_Invalid(t *testing.T) {
+	if _, err := NewSecretKey([]byte("short")); !errors.Is(err, errInvalidKey) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidKey, err)
+	}
+	if _, err := ParsePrivateKey([]byte("not a key")); !errors.Is(err, errInvalidKey) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidKey, err)
+	}
+	if _, err := ParsePrivateKey(rsaPEM(t, 1024)); !errors.Is(err, errInvalidKey) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidKey, err)
+	}
+	_, public := ed25519PEM(t)
+	if _, err := ParsePrivateKey(public); !errors.Is(err, errInvalidKey) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidKey, err)
+	}
+}
+
+func TestKeySet_JWKS(t *testing.T) {
+	rsaKey, err := ParsePrivateKey(rsaPEM(t, 2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edPublic := ed25519PEM(t)
+	edKey, err := ParsePublicKey(edPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretKey, err := NewSecretKey([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := NewKeySet(rsaKey, edKey, secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := keys.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("Expected only the public keys, got: %+v", set.Keys)
+	}
+	if k := set.Keys[0]; k.Kid != rsaKey.ID || k.Kty != "RSA" || k.Alg != "RS256" || k.E != "AQAB" || k.N == "" {
+		t.Errorf("Unexpected RSA key: %+v", k)
+	}
+	if k := set.Keys[1]; k.Kid != edKey.ID || k.Kty != "OKP" || k.Crv != "Ed25519" || k.Alg != "EdDSA" || k.X == "" {
+		t.Errorf("Unexpected Ed25519 key: %+v", k)
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir := t.TempDir()
+	signingPrivate, _ := ed25519PEM(t)
+	previousPrivate, previousPublic := ed25519PEM(t)
+	signingFile := filepath.Join(dir, "signing.pem")
+	previousFile := filepath.Join(dir, "previous.pem")
+	if err := os.WriteFile(signingFile, signingPrivate, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(previousFile, previousPublic, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadKeySet(signingFile, "", []string{previousFile})
+	if err != nil {
+		t.Fatalf("Expected the keys to be loaded, got: %v", err)
+	}
+	if len(keys.JWKS().Keys) != 2 {
+		t.Fatalf("Expected the signing and the previous keys, got: %+v", keys.JWKS())
+	}
+
+	// tokens of the previous key are still accepted
+	previousKey, err := ParsePrivateKey(previousPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousKeys, err := NewKeySet(previousKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := previousKeys.GenerateJwtToken(testClaims(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(token); err != nil {
+		t.Fatalf("Expected the token of the previous key to be valid, got: %v", err)
+	}
+
+	if keys, err := LoadKeySet("", "", nil); err != nil || len(keys.JWKS().Keys) != 1 {
+		t.Fatalf("Expected a generated key, got: %v", err)
+	}
+	if _, err := LoadKeySet(signingFile, "0123456789abcdef0123456789abcdef", nil); !errors.Is(err, errInvalidKey) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidKey, err)
+	}
+	if _, err := LoadKeySet(filepath.Join(dir, "missing.pem"), "", nil); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
+
+type mockRevocations struct {
+	revoked bool
+	err     error
+}
+
+func (m *mockRevocations) IsRevoked(ctx context.Context, sessionID int64, tokenID string) (bool, error) {
+	return m.revoked, m.err
+}
+
+func TestKeySet_JwtCheckMiddleware(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := keys.GenerateJwtToken(testClaims(42, RoleAdmin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutSession, err := keys.Sign(jwt.MapClaims{"id": 42, "email": "a@a.com", "exp": time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		authorization  string
+		revocations    mockRevocations
+		expectedStatus int
+	}{
+		{name: "Valid", authorization: "Bearer " + token, expectedStatus: http.StatusOK},
+		{name: "Missing", expectedStatus: http.StatusUnauthorized},
+		{name: "Tampered", authorization: "Bearer " + token + "x", expectedStatus: http.StatusUnauthorized},
+		{name: "WithoutSession", authorization: "Bearer " + withoutSession, expectedStatus: http.StatusUnauthorized},
+		{name: "Revoked", authorization: "Bearer " + token, revocations: mockRevocations{revoked: true}, expectedStatus: http.StatusUnauthorized},
+		{name: "CheckFailed", authorization: "Bearer " + token, revocations: mockRevocations{err: errors.New("db down")}, expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", tt.authorization)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			handler := keys.JwtCheckMiddleware(&tt.revocations)(func(c echo.Context) error {
+				if c.Get("id") != int64(42) || c.Get("email") != "a@a.com" || c.Get("session") != int64(3) || c.Get("jti") != "jti" {
+					t.Errorf("Unexpected context values: %v, %v, %v, %v", c.Get("id"), c.Get("email"), c.Get("session"), c.Get("jti"))
+				}
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status: %d, got: %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestKeySet_JwtCheckMiddleware_Roles(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withRoles, err := keys.GenerateJwtToken(testClaims(42, RoleCustomer, RoleAdmin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tokens issued before roles existed don't have the claim
+	withoutRoles, err := keys.Sign(jwt.MapClaims{"id": 42, "email": "a@a.com", "sid": 3, "jti": "jti", "exp": time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		token         string
+		expectedRoles []string
+	}{
+		{name: "WithRoles", token: withRoles, expectedRoles: []string{RoleCustomer, RoleAdmin}},
+		{name: "WithoutRoles", token: withoutRoles, expectedRoles: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			var roles []string
+			handler := keys.JwtCheckMiddleware(&mockRevocations{})(func(c echo.Context) error {
+				roles, _ = c.Get("roles").([]string)
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatal(err)
+			}
+			if roles == nil || strings.Join(roles, ",") != strings.Join(tt.expectedRoles, ",") {
+				t.Fatalf("Expected roles: %v, got: %v", tt.expectedRoles, roles)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name           string
+		roles          any
+		expectedStatus int
+	}{
+		{name: "AllowedRole", roles: []string{RoleCustomer, RoleAdmin}, expectedStatus: http.StatusOK},
+		{name: "MissingRole", roles: []string{RoleCustomer}, expectedStatus: http.StatusForbidden},
+		{name: "EmptyRoles", roles: []string{}, expectedStatus: http.StatusForbidden},
+		{name: "NoRolesInContext", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+			if tt.roles != nil {
+				c.Set("roles", tt.roles)
+			}
+
+			handler := RequireRole(RoleAdmin)(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status: %d, got: %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestKeySet_EmailToken(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := keys.GenerateEmailToken("verify_email", 5, "a@a.com", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, email, err := keys.ParseEmailToken("verify_email", token)
+	if err != nil || id != 5 || email != "a@a.com" {
+		t.Fatalf("Expected the customer and the email of the token, got: %d, %s, %v", id, email, err)
+	}
+
+	if _, _, err := keys.ParseEmailToken("reset_password", token); !errors.Is(err, errInvalidEmailToken) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidEmailToken, err)
+	}
+
+	access, err := keys.GenerateJwtToken(testClaims(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keys.ParseEmailToken("verify_email", access); !errors.Is(err, errInvalidEmailToken) {
+		t.Fatalf("Expected an access token to be rejected, got: %v", err)
+	}
+
+	expired, err := keys.GenerateEmailToken("verify_email", 5, "a@a.com", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keys.ParseEmailToken("verify_email", expired); err == nil {
+		t.Fatal("Expected an expired token to be rejected")
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+)
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type changeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// accountError maps the errors of the account changes to their responses
+func accountError(c echo.Context, err error) error {
+	if customer.IsWrongPassword(err) {
+		return c.JSON(http.StatusForbidden, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if customer.IsNotFound(err) {
+		return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if customer.IsEmailAlreadyTaken(err) {
+		return c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if utils.IsStorageRelatedError(err) {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+	return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+}
+
+// ChangePasswordHandler
+// @Summary Change the password
+// @Description Set a new password for the authenticated customer, who needs to give the current one. Every other session of the customer is logged out
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param passwords body changePasswordRequest true "current and new password"
+// @Success 200 {object} ResultMessage
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/me/password [put]
+func (s *Server) ChangePasswordHandler(c echo.Context) error {
+	var r changePasswordRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to change the password: %s", err.Error())})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+	sessionID, ok := c.Get("session").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "session context value missing"})
+	}
+
+	ctx := c.Request().Context()
+	if err := s.customerService.ChangePassword(ctx, customerID, r.CurrentPassword, r.NewPassword); err != nil {
+		return accountError(c, err)
+	}
+
+	// whoever else knew the old password is logged out, the device that changed it stays logged in
+	if err := s.sessionService.LogoutOthers(ctx, customerID, sessionID); err != nil {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+
+	return c.JSON(http.StatusOK, ResultMessage{Message: "password changed"})
+}
+
+// ChangeEmailHandler
+// @Summary Change the email
+// @Description Set a new email for the authenticated customer, who needs to give their password. The new email isn't verified,
+// @Description a link to verify it is sent to it
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param email body changeEmailRequest true "new email and current password"
+// @Success 200 {object} ResultMessage
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/me/email [put]
+func (s *Server) ChangeEmailHandler(c echo.Context) error {
+	var r changeEmailRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to change the email: %s", err.Error())})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	ctx := c.Request().Context()
+	if err := s.customerService.ChangeEmail(ctx, customerID, r.Password, r.Email); err != nil {
+		return accountError(c, err)
+	}
+
+	// the email is already changed, the customer can ask for another link if this one doesn't arrive
+	s.verificationService.SendVerificationLater(ctx, customerID)
+
+	return c.JSON(http.StatusOK, ResultMessage{Message: "email changed, a verification link was sent to the new address"})
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+)
+
+type addressRequest struct {
+	Recipient  string `json:"recipient"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+	Default    bool   `json:"default"`
+}
+
+func (r addressRequest) address() customer.Address {
+	return customer.Address{
+		Recipient:  r.Recipient,
+		Line1:      r.Line1,
+		Line2:      r.Line2,
+		City:       r.City,
+		Region:     r.Region,
+		PostalCode: r.PostalCode,
+		Country:    r.Country,
+		Default:    r.Default,
+	}
+}
+
+// addressError maps the errors of the address book operations to their responses
+func addressError(c echo.Context, err error) error {
+	if customer.IsAddressNotFound(err) {
+		return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if utils.IsStorageRelatedError(err) {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+	return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+}
+
+// ListAddressesHandler
+// @Summary List the addresses
+// @Description List the authenticated customer addresses, the default one first
+// @Tags addresses
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Success 200 {array} customer.Address
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/me/addresses [get]
+func (s *Server) ListAddressesHandler(c echo.Context) error {
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	addresses, err := s.addressService.ListAddresses(c.Request().Context(), customerID)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, addresses)
+}
+
+// CreateAddressHandler
+// @Summary Add an address
+// @Description Add an address to the authenticated customer address book, the first address is always the default one
+// @Tags addresses
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param address body addressRequest true "address data"
+// @Success 201 {object} customer.Address
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/me/addresses [post]
+func (s *Server) CreateAddressHandler(c echo.Context) error {
+	var r addressRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to add address: %s", err.Error())})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	address, err := s.addressService.AddAddress(c.Request().Context(), customerID, r.address())
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, address)
+}
+
+// GetAddressHandler
+// @Summary Get an address
+// @Description Get one of the authenticated customer addresses
+// @Tags addresses
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "address id"
+// @Success 200 {object} customer.Address
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/me/addresses/{id} [get]
+func (s *Server) GetAddressHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	address, err := s.addressService.GetAddress(c.Request().Context(), customerID, id)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, address)
+}
+
+// UpdateAddressHandler
+// @Summary Replace an address
+// @Description Replace one of the authenticated customer addresses, orders already made with it keep the address they were made with.
+// @Description Setting default makes it the default address, the default address stays the default until another one takes its place
+// @Tags addresses
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "address id"
+// @Param address body addressRequest true "address data"
+// @Success 200 {object} customer.Address
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/me/addresses/{id} [put]
+func (s *Server) UpdateAddressHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var r addressRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to update address: %s", err.Error())})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	a := r.address()
+	a.ID = id
+	address, err := s.addressService.UpdateAddress(c.Request().Context(), customerID, a)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, address)
+}
+
+// DeleteAddressHandler
+// @Summary Delete an address
+// @Description Remove one of the authenticated customer addresses, deleting the default address makes the oldest remaining one the default
+// @Tags addresses
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "address id"
+// @Success 200 {object} ResultMessage
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/me/addresses/{id} [delete]
+func (s *Server) DeleteAddressHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	if err := s.addressService.DeleteAddress(c.Request().Context(), customerID, id); err != nil {
+		return addressError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, ResultMessage{Message: "address deleted"})
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/book"
+	"github.com/ap-pauloafonso/bookstore/cart"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/payment"
+	"github.com/ap-pauloafonso/bookstore/promotion"
+	"github.com/ap-pauloafonso/bookstore/shipping"
+	"github.com/ap-pauloafonso/bookstore/tax"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+)
+
+type cartItemRequest struct {
+	BookID   int64 `json:"book_id"`
+	Quantity int   `json:"quantity"`
+}
+
+type cartQuantityRequest struct {
+	Quantity int `json:"quantity"`
+}
+
+type checkoutRequest struct {
+	PaymentToken   string          `json:"payment_token"`
+	CouponCode     string          `json:"coupon_code,omitempty"`
+	AddressID      *int64          `json:"address_id,omitempty"`
+	Destination    tax.Destination `json:"destination"`
+	ShippingMethod shipping.Method `json:"shipping_method,omitempty"`
+}
+
+// cartError maps the errors of the cart operations to their responses
+func cartError(c echo.Context, err error) error {
+	if book.IsNotFound(err) || cart.IsItemNotFound(err) {
+		return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if customer.IsEmailNotVerified(err) {
+		return c.JSON(http.StatusForbidden, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if order.IsOutOfStock(err) || order.IsCartChanged(err) {
+		return c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if promotion.IsNotApplicable(err) || tax.IsUnsupportedDestination(err) || customer.IsAddressNotFound(err) || shipping.IsUnavailable(err) {
+		return c.JSON(http.StatusUnprocessableEntity, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if payment.IsDeclined(err) {
+		return c.JSON(http.StatusPaymentRequired, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if payment.IsProviderError(err) {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusBadGateway, utils.ErrorMessage{ErrorMessage: errPaymentUnavailable.Error()})
+	}
+	if utils.IsStorageRelatedError(err) {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+	return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+}
+
+// GetCartHandler
+// @Summary Get the cart
+// @Description Get the authenticated customer cart with the current prices of the books
+// @Tags cart
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Success 200 {object} cart.Cart
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/cart/items [get]
+func (s *Server) GetCartHandler(c echo.Context) error {
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	result, err := s.cartService.GetCart(c.Request().Context(), customerID)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// AddCartItemHandler
+// @Summary Add a book to the cart
+// @Description Add units of a book to the authenticated customer cart, adding to the quantity if the book is already there
+// @Tags cart
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param item body cartItemRequest true "book and quantity"
+// @Success 200 {object} cart.Cart
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/cart/items [post]
+func (s *Server) AddCartItemHandler(c echo.Context) error {
+	var r cartItemRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to add cart item: %s", err.Error())})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	result, err := s.cartService.AddItem(c.Request().Context(), customerID, r.BookID, r.Quantity)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// UpdateCartItemHandler
+// @Summary Change the quantity of a book in the cart
+// @Description Set how many units of a book the authenticated customer cart has
+// @Tags cart
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param bookID path int true "book id"
+// @Param quantity body cartQuantityRequest true "new quantity"
+// @Success 200 {object} cart.Cart
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/cart/items/{bookID} [patch]
+func (s *Server) UpdateCartItemHandler(c echo.Context) error {
+	bookID, err := parseIDParam(c, "bookID")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var r cartQuantityRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to update cart item: %s", err.Error())})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	result, err := s.cartService.UpdateItem(c.Request().Context(), customerID, bookID, r.Quantity)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// RemoveCartItemHandler
+// @Summary Remove a book from the cart
+// @Description Take a book out of the authenticated customer cart
+// @Tags cart
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param bookID path int true "book id"
+// @Success 200 {object} cart.Cart
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/cart/items/{bookID} [delete]
+func (s *Server) RemoveCartItemHandler(c echo.Context) error {
+	bookID, err := parseIDParam(c, "bookID")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	result, err := s.cartService.RemoveItem(c.Request().Context(), customerID, bookID)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// CheckoutHandler
+// @Summary Check out the cart
+// @Description Turn the authenticated customer cart into an order and empty it, the cart is kept if the order can't be made and the checkout fails with 409 if the cart changes meanwhile.
+// @Description Requests sent with an Idempotency-Key are only processed once, retrying them returns the original order with the Idempotent-Replayed header
+// @Tags cart
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param Idempotency-Key header string false "unique key of the request, e.g. a UUID"
+// @Param payment body checkoutRequest true "payment token, optional coupon code, address id or destination and shipping method"
+// @Success 200 {object} order.Order
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 402 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 422 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Failure 502 {object} utils.ErrorMessage
+// @Router /api/cart/checkout [post]
+func (s *Server) CheckoutHandler(c echo.Context) error {
+	var r checkoutRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to check out: %s", err.Error())})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	key := c.Request().Header.Get(idempotencyKeyHeader)
+	if done, err := s.beginIdempotent(c, customerID, key, r); done {
+		return err
+	}
+
+	newOrder, err := s.cartService.Checkout(c.Request().Context(), customerID, order.OrderRequest{
+		PaymentToken:   r.PaymentToken,
+		CouponCode:     r.CouponCode,
+		AddressID:      r.AddressID,
+		Destination:    r.Destination,
+		ShippingMethod: r.ShippingMethod,
+	})
+	if err != nil {
+		// the cart wasn't checked out, so the client can retry with the same key
+		s.releaseIdempotent(c, customerID, key)
+		return cartError(c, err)
+	}
+
+	return s.completeIdempotent(c, customerID, key, http.StatusOK, newOrder)
+}
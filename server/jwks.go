@@ -0,0 +1,19 @@
+package server
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// JWKSHandler
+// @Summary Get the token verification keys
+// @Description Get the public keys the tokens issued by the store can be verified with, as a JSON Web Key Set.
+// @Description Tokens name their key in the kid header, keys of previous rotations are listed while they are still accepted
+// @Tags auth
+// @Produce json
+// @Success 200 {object} security.JWKS
+// @Router /.well-known/jwks.json [get]
+func (s *Server) JWKSHandler(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, s.keys.JWKS())
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPasswordHandler
+// @Summary Ask for a password reset
+// @Description Email a link to reset the password to the customer, at most once every PASSWORD_RESET_RESEND_INTERVAL. The answer is the same whether the email is registered or not
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param email body forgotPasswordRequest true "customer email"
+// @Success 200 {object} ResultMessage
+// @Failure 400 {object} utils.ErrorMessage
+// @Router /api/password/forgot [post]
+func (s *Server) ForgotPasswordHandler(c echo.Context) error {
+	var r forgotPasswordRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to ask for a password reset: %s", err.Error())})
+	}
+
+	s.passwordResetService.Forgot(c.Request().Context(), r.Email)
+
+	return c.JSON(http.StatusOK, ResultMessage{Message: "if the email is registered, a link to reset the password was sent to it"})
+}
+
+// ResetPasswordHandler
+// @Summary Reset the password
+// @Description Set a new password with the token of the emailed link, the token can only be used once and the customer is logged out of every device
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param reset body resetPasswordRequest true "reset token and new password"
+// @Success 200 {object} ResultMessage
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/password/reset [post]
+func (s *Server) ResetPasswordHandler(c echo.Context) error {
+	var r resetPasswordRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to reset the password: %s", err.Error())})
+	}
+
+	if err := s.passwordResetService.Reset(c.Request().Context(), r.Token, r.Password); err != nil {
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, ResultMessage{Message: "password reset"})
+}
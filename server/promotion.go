@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/promotion"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type couponRequest struct {
+	Code               string         `json:"code"`
+	Type               promotion.Type `json:"type"`
+	Percent            int            `json:"percent"`
+	Amount             money.Amount   `json:"amount"`
+	MinOrderValue      money.Amount   `json:"min_order_value"`
+	MaxUses            int            `json:"max_uses"`
+	MaxUsesPerCustomer int            `json:"max_uses_per_customer"`
+	StartsAt           *time.Time     `json:"starts_at"`
+	EndsAt             *time.Time     `json:"ends_at"`
+	BookIDs            []int64        `json:"book_ids"`
+	Authors            []string       `json:"authors"`
+}
+
+// promotionError maps the errors of the coupon operations to their responses
+func promotionError(c echo.Context, err error) error {
+	if promotion.IsNotFound(err) {
+		return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if promotion.IsCodeTaken(err) {
+		return c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if utils.IsStorageRelatedError(err) {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+	return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+}
+
+// CreateCouponHandler
+// @Summary Create a coupon
+// @Description Create a percentage or fixed amount discount code. Zero usage limits mean unlimited, the validity dates are optional
+// @Description and empty book_ids and authors make the coupon cover every book
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param coupon body couponRequest true "coupon data"
+// @Success 201 {object} promotion.Coupon
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/coupons [post]
+func (s *Server) CreateCouponHandler(c echo.Context) error {
+	var r couponRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to create coupon: %s", err.Error())})
+	}
+
+	coupon, err := s.promotionService.CreateCoupon(c.Request().Context(), promotion.Coupon{
+		Code:               r.Code,
+		Type:               r.Type,
+		Percent:            r.Percent,
+		Amount:             r.Amount,
+		MinOrderValue:      r.MinOrderValue,
+		MaxUses:            r.MaxUses,
+		MaxUsesPerCustomer: r.MaxUsesPerCustomer,
+		StartsAt:           r.StartsAt,
+		EndsAt:             r.EndsAt,
+		BookIDs:            r.BookIDs,
+		Authors:            r.Authors,
+	})
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, coupon)
+}
+
+// GetCouponHandler
+// @Summary Get a coupon
+// @Description Get a coupon by its code, codes are case insensitive
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param code path string true "coupon code"
+// @Success 200 {object} promotion.Coupon
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/coupons/{code} [get]
+func (s *Server) GetCouponHandler(c echo.Context) error {
+	coupon, err := s.promotionService.GetCoupon(c.Request().Context(), c.Param("code"))
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, coupon)
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/payment"
+	"github.com/ap-pauloafonso/bookstore/returns"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type returnRequest struct {
+	Items  []returns.RequestItem `json:"items"`
+	Reason string                `json:"reason"`
+}
+
+type returnDecisionRequest struct {
+	Note string `json:"note"`
+}
+
+// returnError maps the errors of the return operations to their responses
+func returnError(c echo.Context, err error) error {
+	if returns.IsNotFound(err) {
+		return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if returns.IsNotReturnable(err) || returns.IsInvalidTransition(err) {
+		return c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if payment.IsDeclined(err) {
+		return c.JSON(http.StatusPaymentRequired, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if payment.IsProviderError(err) {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusBadGateway, utils.ErrorMessage{ErrorMessage: errPaymentUnavailable.Error()})
+	}
+	if utils.IsStorageRelatedError(err) {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+	return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+}
+
+// RequestReturnHandler
+// @Summary Request a return
+// @Description Ask to return some of the books of one of the authenticated customer orders, which is only possible for a while after it was delivered
+// @Tags returns
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "order id"
+// @Param return body returnRequest true "books, quantities and why they are being returned"
+// @Success 200 {object} returns.Return
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/orders/{id}/returns [post]
+func (s *Server) RequestReturnHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var r returnRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to request return: %s", err.Error())})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	result, err := s.returnService.RequestReturn(c.Request().Context(), customerID, id, r.Items, r.Reason)
+	if err != nil {
+		return returnError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// GetOrderReturnsHandler
+// @Summary Get the returns of an order
+// @Description Get the returns of one of the authenticated customer orders, oldest first
+// @Tags returns
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "order id"
+// @Success 200 {array} returns.Return
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/orders/{id}/returns [get]
+func (s *Server) GetOrderReturnsHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	result, err := s.returnService.ListOrderReturns(c.Request().Context(), customerID, id)
+	if err != nil {
+		return returnError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// ListReturnsHandler
+// @Summary List returns
+// @Description List the returns in a status, oldest first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param status query string false "requested (default), approved, rejected or refunded"
+// @Param limit query int false "page size, between 1 and 100 (default 20)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} returns.Page
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/returns [get]
+func (s *Server) ListReturnsHandler(c echo.Context) error {
+	params := returns.ListParams{
+		Status: returns.Status(c.QueryParam("status")),
+		Cursor: c.QueryParam("cursor"),
+	}
+
+	var err error
+	if raw := c.QueryParam("limit"); raw != "" {
+		if params.Limit, err = strconv.Atoi(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: "invalid limit parameter"})
+		}
+	}
+
+	page, err := s.returnService.ListReturns(c.Request().Context(), params)
+	if err != nil {
+		return returnError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// GetReturnHandler
+// @Summary Get a return
+// @Description Get a return with its items
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "return id"
+// @Success 200 {object} returns.Return
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/returns/{id} [get]
+func (s *Server) GetReturnHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	result, err := s.returnService.GetReturn(c.Request().Context(), id)
+	if err != nil {
+		return returnError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// ApproveReturnHandler
+// @Summary Approve a return
+// @Description Accept a requested return, putting its books back in stock and refunding what was paid for them. A return whose refund failed stays approved and can be approved again to retry it
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "return id"
+// @Param decision body returnDecisionRequest false "optional note"
+// @Success 200 {object} returns.Return
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 402 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Failure 502 {object} utils.ErrorMessage
+// @Router /api/admin/returns/{id}/approve [post]
+func (s *Server) ApproveReturnHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var r returnDecisionRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to approve return: %s", err.Error())})
+	}
+
+	adminID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	result, err := s.returnService.Approve(c.Request().Context(), id, adminID, r.Note)
+	if err != nil {
+		return returnError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// RejectReturnHandler
+// @Summary Reject a return
+// @Description Turn down a requested return, the note tells the customer why
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "return id"
+// @Param decision body returnDecisionRequest true "why the return is rejected"
+// @Success 200 {object} returns.Return
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/returns/{id}/reject [post]
+func (s *Server) RejectReturnHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var r returnDecisionRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to reject return: %s", err.Error())})
+	}
+
+	adminID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	result, err := s.returnService.Reject(c.Request().Context(), id, adminID, r.Note)
+	if err != nil {
+		return returnError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// GetReturnHistoryHandler
+// @Summary Get the audit trail of a return
+// @Description Get every step a return went through, oldest first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "return id"
+// @Success 200 {array} returns.Event
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/returns/{id}/history [get]
+func (s *Server) GetReturnHistoryHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	events, err := s.returnService.GetEvents(c.Request().Context(), id)
+	if err != nil {
+		return returnError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
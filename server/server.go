@@ -1,13 +1,24 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/ap-pauloafonso/bookstore/book"
+	"github.com/ap-pauloafonso/bookstore/cart"
 	"github.com/ap-pauloafonso/bookstore/customer"
 	_ "github.com/ap-pauloafonso/bookstore/docs"
+	"github.com/ap-pauloafonso/bookstore/idempotency"
+	"github.com/ap-pauloafonso/bookstore/money"
 	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/payment"
+	"github.com/ap-pauloafonso/bookstore/promotion"
+	"github.com/ap-pauloafonso/bookstore/returns"
 	"github.com/ap-pauloafonso/bookstore/security"
+	"github.com/ap-pauloafonso/bookstore/session"
+	"github.com/ap-pauloafonso/bookstore/shipping"
+	"github.com/ap-pauloafonso/bookstore/tax"
 	"github.com/ap-pauloafonso/bookstore/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -15,18 +26,36 @@ import (
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 var (
-	errInternalSever = errors.New("internal server error")
+	errInternalSever      = errors.New("internal server error")
+	errPaymentUnavailable = errors.New("the payment provider is unavailable, try again later")
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	paymentTokenHeader       = "Payment-Token"
 )
 
 // Server represents the application instance
 type Server struct {
-	E               *echo.Echo
-	customerService *customer.Service
-	bookService     *book.Service
-	orderService    *order.Service
+	E                    *echo.Echo
+	customerService      *customer.Service
+	bookService          *book.Service
+	orderService         *order.Service
+	idempotencyService   *idempotency.Service
+	cartService          *cart.Service
+	returnService        *returns.Service
+	promotionService     *promotion.Service
+	addressService       *customer.AddressService
+	keys                 *security.KeySet
+	sessionService       *session.Service
+	passwordResetService *customer.PasswordResetService
+	verificationService  *customer.VerificationService
 }
 
 type customerRequest struct {
@@ -34,17 +63,54 @@ type customerRequest struct {
 	Password string `json:"password"`
 }
 
+// TokenResponse has the access token, which is sent in the Authorization header until ExpiresAt,
+// and the refresh token that gets a new one from /api/token/refresh
 type TokenResponse struct {
-	Token string `json:"token"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func newTokenResponse(t *session.Tokens) TokenResponse {
+	return TokenResponse{Token: t.AccessToken, RefreshToken: t.RefreshToken, ExpiresAt: t.ExpiresAt}
 }
 
 type ResultMessage struct {
 	Message string `json:"message"`
 }
 
+type stockRequest struct {
+	Quantity int    `json:"quantity"`
+	Reason   string `json:"reason"`
+}
+
+type statusRequest struct {
+	Status order.Status `json:"status"`
+	Reason string       `json:"reason"`
+}
+
+type cancelRequest struct {
+	Reason string `json:"reason"`
+}
+
+type bookRequest struct {
+	Title  string       `json:"title"`
+	Author string       `json:"author"`
+	Price  money.Amount `json:"price"`
+}
+
+// parseIDParam reads a positive int64 from the given path parameter
+func parseIDParam(c echo.Context, name string) (int64, error) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+	return id, nil
+}
+
 // RegisterUserHandler
 // @Summary customer Register
-// @Description Register a new customer with email and password
+// @Description Register a new customer with email and password, a link to verify the email is sent to it
 // @Accept json
 // @Produce json
 // @Tags auth
@@ -70,13 +136,17 @@ func (s *Server) RegisterUserHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
 	}
 
-	// generate jwt token
-	tokenString, err := security.GenerateJwtToken(u.Email, *id)
+	// the customer is already registered, they can ask for another link if this one doesn't arrive
+	s.verificationService.SendVerificationLater(c.Request().Context(), *id)
+
+	// open a session, which issues the tokens
+	tokens, err := s.sessionService.Start(c.Request().Context(), &customer.Model{Id: *id, Email: u.Email, Roles: []string{security.RoleCustomer}})
 	if err != nil {
+		slog.Error(err.Error())
 		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "Internal Server Error"})
 	}
 
-	return c.JSON(http.StatusOK, TokenResponse{Token: tokenString})
+	return c.JSON(http.StatusOK, newTokenResponse(tokens))
 }
 
 // LoginUserHandler
@@ -108,27 +178,107 @@ func (s *Server) LoginUserHandler(c echo.Context) error {
 
 	}
 
-	// generate jwt token
-	tokenString, err := security.GenerateJwtToken(u.Email, newcustomer.Id)
+	// open a session, which issues the tokens
+	tokens, err := s.sessionService.Start(c.Request().Context(), newcustomer)
 	if err != nil {
+		slog.Error(err.Error())
 		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "Internal Server Error"})
 	}
 
-	return c.JSON(http.StatusOK, TokenResponse{Token: tokenString})
+	return c.JSON(http.StatusOK, newTokenResponse(tokens))
+}
+
+// parseOptionalAmount reads a money query parameter, returning nil when it isn't present
+func parseOptionalAmount(c echo.Context, name string) (*money.Amount, error) {
+	raw := c.QueryParam(name)
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := money.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s parameter: %w", name, err)
+	}
+	return &v, nil
 }
 
 // GetBooksHandler
-// @Summary Get all books
-// @Description Get a list of all books
+// @Summary Get books
+// @Description Get a page of the catalog, optionally sorted and filtered
 // @Tags books
 // @Accept json
 // @Produce json
-// @Success 200 {array} book.Model
+// @Param limit query int false "page size, between 1 and 100 (default 20)"
+// @Param cursor query string false "next_cursor returned by the previous page"
+// @Param sort query string false "sort field" Enums(price, title, author)
+// @Param direction query string false "sort direction" Enums(asc, desc)
+// @Param author query string false "only books from this author"
+// @Param min_price query number false "minimum price"
+// @Param max_price query number false "maximum price"
+// @Param with_total query bool false "also count the books matching the filters"
+// @Success 200 {object} book.Page
+// @Failure 400 {object} utils.ErrorMessage
 // @Failure 500 {object} utils.ErrorMessage
 // @Router /api/books [get]
 func (s *Server) GetBooksHandler(c echo.Context) error {
+	params := book.ListParams{
+		Cursor:    c.QueryParam("cursor"),
+		Sort:      c.QueryParam("sort"),
+		Direction: c.QueryParam("direction"),
+		Author:    c.QueryParam("author"),
+	}
+
+	var err error
+	if raw := c.QueryParam("limit"); raw != "" {
+		if params.Limit, err = strconv.Atoi(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: "invalid limit parameter"})
+		}
+	}
+	if params.MinPrice, err = parseOptionalAmount(c, "min_price"); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if params.MaxPrice, err = parseOptionalAmount(c, "max_price"); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if raw := c.QueryParam("with_total"); raw != "" {
+		if params.WithTotal, err = strconv.ParseBool(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: "invalid with_total parameter"})
+		}
+	}
+
+	page, err := s.bookService.ListBooks(c.Request().Context(), params)
+	if err != nil {
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// SearchBooksHandler
+// @Summary Search books
+// @Description Full-text search over the title and author of the books, ordered by relevance. Words also match as prefixes
+// @Tags books
+// @Accept json
+// @Produce json
+// @Param q query string true "search text"
+// @Param limit query int false "max amount of results, between 1 and 100 (default 20)"
+// @Success 200 {array} book.Model
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/books/search [get]
+func (s *Server) SearchBooksHandler(c echo.Context) error {
+	var limit int
+	if raw := c.QueryParam("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: "invalid limit parameter"})
+		}
+	}
 
-	books, err := s.bookService.GetAllBooks(c.Request().Context())
+	books, err := s.bookService.SearchBooks(c.Request().Context(), c.QueryParam("q"), limit)
 	if err != nil {
 		if utils.IsStorageRelatedError(err) {
 			slog.Error(err.Error())
@@ -140,63 +290,504 @@ func (s *Server) GetBooksHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, books)
 }
 
+// GetBookHandler
+// @Summary Get a book
+// @Description Get a single book by its id
+// @Tags books
+// @Accept json
+// @Produce json
+// @Param id path int true "book id"
+// @Success 200 {object} book.Model
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/books/{id} [get]
+func (s *Server) GetBookHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	b, err := s.bookService.GetBookByID(c.Request().Context(), id)
+	if err != nil {
+		if book.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, b)
+}
+
+// CreateBookHandler
+// @Summary Create a book
+// @Description Add a new book to the catalog
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param book body bookRequest true "book data"
+// @Success 201 {object} book.Model
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/books [post]
+func (s *Server) CreateBookHandler(c echo.Context) error {
+	var b bookRequest
+
+	if err := c.Bind(&b); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to create book: %s", err.Error())})
+	}
+
+	newBook, err := s.bookService.CreateBook(c.Request().Context(), b.Title, b.Author, b.Price)
+	if err != nil {
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, newBook)
+}
+
+// UpdateBookHandler
+// @Summary Update a book
+// @Description Replace all the fields of an existing book
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "book id"
+// @Param book body bookRequest true "book data"
+// @Success 200 {object} book.Model
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/books/{id} [put]
+func (s *Server) UpdateBookHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var b bookRequest
+	if err := c.Bind(&b); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to update book: %s", err.Error())})
+	}
+
+	updatedBook, err := s.bookService.UpdateBook(c.Request().Context(), id, b.Title, b.Author, b.Price)
+	if err != nil {
+		if book.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, updatedBook)
+}
+
+// PatchBookHandler
+// @Summary Partially update a book
+// @Description Update only the provided fields of an existing book, the weight in grams can only be changed here
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "book id"
+// @Param book body book.Patch true "fields to update"
+// @Success 200 {object} book.Model
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/books/{id} [patch]
+func (s *Server) PatchBookHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var patch book.Patch
+	if err := c.Bind(&patch); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to update book: %s", err.Error())})
+	}
+
+	updatedBook, err := s.bookService.PatchBook(c.Request().Context(), id, patch)
+	if err != nil {
+		if book.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, updatedBook)
+}
+
+// DeleteBookHandler
+// @Summary Delete a book
+// @Description Remove a book from the catalog, books that are part of an order or had their stock adjusted can't be removed
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "book id"
+// @Success 200 {object} ResultMessage
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/books/{id} [delete]
+func (s *Server) DeleteBookHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	err = s.bookService.DeleteBook(c.Request().Context(), id)
+	if err != nil {
+		if book.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if utils.IsForeignKeyViolation(err) {
+			return c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: "book is referenced by existing orders or its stock history"})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, ResultMessage{Message: "book deleted"})
+}
+
+// AdjustStockHandler
+// @Summary Adjust the stock of a book
+// @Description Add (positive quantity) or remove (negative quantity) units of a book, the change is recorded in the stock history
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "book id"
+// @Param adjustment body stockRequest true "stock adjustment"
+// @Success 200 {object} book.Model
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/books/{id}/stock [post]
+func (s *Server) AdjustStockHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var r stockRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to adjust stock: %s", err.Error())})
+	}
+
+	adminID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	updatedBook, err := s.bookService.AdjustStock(c.Request().Context(), id, r.Quantity, r.Reason, adminID)
+	if err != nil {
+		if book.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if book.IsNotEnoughStock(err) {
+			return c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, updatedBook)
+}
+
+// GetStockMovementsHandler
+// @Summary Get the stock history of a book
+// @Description Get the latest stock movements of a book, newest first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "book id"
+// @Param limit query int false "max amount of movements, between 1 and 100 (default 20)"
+// @Success 200 {array} book.StockMovement
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/books/{id}/stock/movements [get]
+func (s *Server) GetStockMovementsHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var limit int
+	if raw := c.QueryParam("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: "invalid limit parameter"})
+		}
+	}
+
+	movements, err := s.bookService.GetStockMovements(c.Request().Context(), id, limit)
+	if err != nil {
+		if book.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, movements)
+}
+
+// parseOptionalTime reads a date (2006-01-02) or RFC 3339 timestamp query parameter, returning nil when it isn't present
+func parseOptionalTime(c echo.Context, name string) (*time.Time, error) {
+	raw := c.QueryParam(name)
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, raw); err != nil {
+			return nil, fmt.Errorf("invalid %s parameter: needs to be a date (2006-01-02) or an RFC 3339 timestamp", name)
+		}
+	}
+	return &t, nil
+}
+
 // GetcustomerOrdersHandler
 // @Summary Get customer orders
-// @Description Get a list of orders for the authenticated customer
+// @Description Get a page of the authenticated customer orders, newest first, optionally filtered by date and status
 // @Tags orders
 // @Accept json
 // @Produce json
 // @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
-// @Success 200 {array} order.Order
+// @Param limit query int false "page size, between 1 and 100 (default 20)"
+// @Param cursor query string false "next_cursor returned by the previous page"
+// @Param from query string false "only orders made at or after this date (2006-01-02) or RFC 3339 timestamp"
+// @Param to query string false "only orders made before this date (2006-01-02) or RFC 3339 timestamp"
+// @Param status query string false "only orders in this status" Enums(pending, paid, shipped, delivered, cancelled, refunded)
+// @Success 200 {object} order.Page
 // @Failure 400 {object} utils.ErrorMessage
 // @Failure 500 {object} utils.ErrorMessage
 // @Router /api/orders [get]
 func (s *Server) GetcustomerOrdersHandler(c echo.Context) error {
 	ctx := c.Request().Context()
-	email, ok := c.Get("email").(string)
+	customerID, ok := c.Get("id").(int64)
 	if !ok {
-		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "email context value missing"})
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	params := order.ListParams{
+		Cursor: c.QueryParam("cursor"),
+		Status: order.Status(c.QueryParam("status")),
 	}
 
-	customer, err := s.customerService.Getcustomer(ctx, email)
+	var err error
+	if raw := c.QueryParam("limit"); raw != "" {
+		if params.Limit, err = strconv.Atoi(raw); err != nil {
+			return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: "invalid limit parameter"})
+		}
+	}
+	if params.From, err = parseOptionalTime(c, "from"); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if params.To, err = parseOptionalTime(c, "to"); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	// the id, unlike the email of the token, doesn't change
+	page, err := s.orderService.ListOrders(ctx, customerID, params)
 	if err != nil {
 		if utils.IsStorageRelatedError(err) {
 			slog.Error(err.Error())
 			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
 		}
+
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// GetOrderHandler
+// @Summary Get an order
+// @Description Get one of the authenticated customer orders with its items and total
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "order id"
+// @Success 200 {object} order.Order
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/orders/{id} [get]
+func (s *Server) GetOrderHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
 	}
-	orders, err := s.orderService.GetOrdersByCustomer(ctx, customer.Id)
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	o, err := s.orderService.GetOrderByID(c.Request().Context(), customerID, id)
 	if err != nil {
+		if order.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
 		if utils.IsStorageRelatedError(err) {
 			slog.Error(err.Error())
 			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
 		}
-
 		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
 	}
 
-	return c.JSON(http.StatusOK, orders)
+	return c.JSON(http.StatusOK, o)
+}
+
+// idempotencyRoute is the route an Idempotency-Key is scoped to, the same key can be used on different endpoints
+func idempotencyRoute(c echo.Context) string {
+	return c.Request().Method + " " + c.Path()
+}
+
+// beginIdempotent claims the Idempotency-Key of a request, when it has one. It reports true when the response was
+// already sent, replaying the first request made with the key or failing, and the handler has nothing else to do
+func (s *Server) beginIdempotent(c echo.Context, customerID int64, key string, request any) (bool, error) {
+	if key == "" {
+		return false, nil
+	}
+
+	previous, err := s.idempotencyService.Begin(c.Request().Context(), customerID, idempotencyRoute(c), key, request)
+	if err != nil {
+		if idempotency.IsKeyMismatch(err) {
+			return true, c.JSON(http.StatusUnprocessableEntity, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if idempotency.IsInProgress(err) {
+			return true, c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return true, c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return true, c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+	if previous != nil {
+		c.Response().Header().Set(idempotentReplayedHeader, "true")
+		return true, c.JSONBlob(previous.StatusCode, previous.Response)
+	}
+
+	return false, nil
+}
+
+// releaseIdempotent frees the Idempotency-Key of a request that failed, so it can be retried with the same key
+func (s *Server) releaseIdempotent(c echo.Context, customerID int64, key string) {
+	if key == "" {
+		return
+	}
+	if err := s.idempotencyService.Release(c.Request().Context(), customerID, idempotencyRoute(c), key); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// completeIdempotent sends the response of a request, storing it first when it has an Idempotency-Key so retries
+// get exactly the same bytes
+func (s *Server) completeIdempotent(c echo.Context, customerID int64, key string, statusCode int, response any) error {
+	if key == "" {
+		return c.JSON(statusCode, response)
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+	// the request is already processed, failing to store the response only means that a retry will get a 409
+	if err := s.idempotencyService.Complete(c.Request().Context(), customerID, idempotencyRoute(c), key, statusCode, body); err != nil {
+		slog.Error(err.Error())
+	}
+
+	return c.JSONBlob(statusCode, body)
+}
+
+// makeOrderRequest is the body of MakeOrderHandler. Besides the order request it still takes the bare array of items
+// the endpoint took before orders were paid, whose payment token comes in the Payment-Token header
+type makeOrderRequest struct {
+	order.OrderRequest
+	itemsOnly bool
+}
+
+func (r *makeOrderRequest) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		r.itemsOnly = true
+		return json.Unmarshal(b, &r.Items)
+	}
+	return json.Unmarshal(b, &r.OrderRequest)
 }
 
 // MakeOrderHandler
 // @Summary Create an order
-// @Description Create a new order with the provided items
+// @Description Create a new order with the provided items, paid with the payment token, discounted by the optional coupon code and shipped with the shipping method to the picked address or the default address, only orders that are picked up can do with a destination. Requests sent with an Idempotency-Key are only processed once,
+// @Description retrying them returns the original order with the Idempotent-Replayed header.
+// @Description Breaking change: the body used to be a bare array of order items. That form is still accepted, deprecated, with the payment token in the Payment-Token header and the order shipped to the default address
 // @Tags orders
 // @Accept json
 // @Produce json
 // @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
-// @Param orderItems body []order.OrderRequestItem true "List of order items"
+// @Param Idempotency-Key header string false "unique key of the request, e.g. a UUID"
+// @Param Payment-Token header string false "payment token of a body sent as a bare array of order items (deprecated)"
+// @Param order body order.OrderRequest true "order items, payment token, optional coupon code, address id or destination and shipping method"
 // @Success 200 {object} order.Order
 // @Failure 400 {object} utils.ErrorMessage
+// @Failure 402 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 422 {object} utils.ErrorMessage
 // @Failure 500 {object} utils.ErrorMessage
+// @Failure 502 {object} utils.ErrorMessage
 // @Router /api/orders [post]
 func (s *Server) MakeOrderHandler(c echo.Context) error {
-	orderItems := []order.OrderRequestItem{}
+	var body makeOrderRequest
 
-	if err := c.Bind(&orderItems); err != nil {
+	if err := c.Bind(&body); err != nil {
 		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed login: %s", err.Error())})
 	}
+	orderRequest := body.OrderRequest
+	if body.itemsOnly {
+		orderRequest.PaymentToken = c.Request().Header.Get(paymentTokenHeader)
+	}
 
 	ctx := c.Request().Context()
 	customerID, ok := c.Get("id").(int64)
@@ -204,8 +795,149 @@ func (s *Server) MakeOrderHandler(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "email context value missing"})
 	}
 
-	order, err := s.orderService.MakeOrder(ctx, customerID, orderItems)
+	key := c.Request().Header.Get(idempotencyKeyHeader)
+	if done, err := s.beginIdempotent(c, customerID, key, orderRequest); done {
+		return err
+	}
+
+	newOrder, err := s.orderService.MakeOrder(ctx, customerID, orderRequest)
+	if err != nil {
+		// the order wasn't made, so the client can retry with the same key
+		s.releaseIdempotent(c, customerID, key)
+		if customer.IsEmailNotVerified(err) {
+			return c.JSON(http.StatusForbidden, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if order.IsOutOfStock(err) {
+			return c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if promotion.IsNotApplicable(err) || tax.IsUnsupportedDestination(err) || customer.IsAddressNotFound(err) || shipping.IsUnavailable(err) {
+			return c.JSON(http.StatusUnprocessableEntity, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if payment.IsDeclined(err) {
+			return c.JSON(http.StatusPaymentRequired, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if payment.IsProviderError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusBadGateway, utils.ErrorMessage{ErrorMessage: errPaymentUnavailable.Error()})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return s.completeIdempotent(c, customerID, key, http.StatusOK, newOrder)
+
+}
+
+// CancelOrderHandler
+// @Summary Cancel an order
+// @Description Cancel one of the authenticated customer orders, which is only possible for a while after making it and before it's shipped
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "order id"
+// @Param cancellation body cancelRequest true "why the order is being cancelled"
+// @Success 200 {object} order.StatusChange
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 402 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Failure 502 {object} utils.ErrorMessage
+// @Router /api/orders/{id}/cancel [post]
+func (s *Server) CancelOrderHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var r cancelRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to cancel order: %s", err.Error())})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	change, err := s.orderService.CancelOrder(c.Request().Context(), customerID, id, r.Reason)
+	if err != nil {
+		if order.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if order.IsInvalidTransition(err) || order.IsCancellationWindowOver(err) {
+			return c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if payment.IsDeclined(err) {
+			return c.JSON(http.StatusPaymentRequired, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if payment.IsProviderError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusBadGateway, utils.ErrorMessage{ErrorMessage: errPaymentUnavailable.Error()})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, change)
+}
+
+// UpdateOrderStatusHandler
+// @Summary Update the status of an order
+// @Description Move an order to the next status of its lifecycle: pending -> paid -> shipped -> delivered, pending/paid -> cancelled, paid/delivered -> refunded
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "order id"
+// @Param status body statusRequest true "new status"
+// @Success 200 {object} order.StatusChange
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 402 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Failure 502 {object} utils.ErrorMessage
+// @Router /api/admin/orders/{id}/status [post]
+func (s *Server) UpdateOrderStatusHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
 	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	var r statusRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to update order status: %s", err.Error())})
+	}
+
+	adminID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	change, err := s.orderService.UpdateStatus(c.Request().Context(), id, r.Status, r.Reason, adminID)
+	if err != nil {
+		if order.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if order.IsInvalidTransition(err) {
+			return c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if payment.IsDeclined(err) {
+			return c.JSON(http.StatusPaymentRequired, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if payment.IsProviderError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusBadGateway, utils.ErrorMessage{ErrorMessage: errPaymentUnavailable.Error()})
+		}
 		if utils.IsStorageRelatedError(err) {
 			slog.Error(err.Error())
 			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
@@ -213,25 +945,123 @@ func (s *Server) MakeOrderHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
 	}
 
-	return c.JSON(http.StatusOK, order)
+	return c.JSON(http.StatusOK, change)
+}
+
+// GetOrderStatusHistoryHandler
+// @Summary Get the status history of an order
+// @Description Get every status an order went through, oldest first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param id path int true "order id"
+// @Success 200 {array} order.StatusChange
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 403 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/admin/orders/{id}/status/history [get]
+func (s *Server) GetOrderStatusHistoryHandler(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	history, err := s.orderService.GetStatusHistory(c.Request().Context(), id)
+	if err != nil {
+		if order.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
 
+	return c.JSON(http.StatusOK, history)
 }
 
 // New creates a new instance of the Server
-func New(customerService *customer.Service, bookService *book.Service, orderService *order.Service) *Server {
+func New(customerService *customer.Service, bookService *book.Service, orderService *order.Service, idempotencyService *idempotency.Service,
+	cartService *cart.Service, returnService *returns.Service, promotionService *promotion.Service, addressService *customer.AddressService,
+	keys *security.KeySet, sessionService *session.Service, passwordResetService *customer.PasswordResetService,
+	verificationService *customer.VerificationService) *Server {
 	server := &Server{
-		E:               echo.New(),
-		customerService: customerService,
-		bookService:     bookService,
-		orderService:    orderService,
+		E:                    echo.New(),
+		customerService:      customerService,
+		bookService:          bookService,
+		orderService:         orderService,
+		idempotencyService:   idempotencyService,
+		cartService:          cartService,
+		returnService:        returnService,
+		promotionService:     promotionService,
+		addressService:       addressService,
+		keys:                 keys,
+		sessionService:       sessionService,
+		passwordResetService: passwordResetService,
+		verificationService:  verificationService,
 	}
 
+	// authenticated routes need a valid access token of a session that wasn't revoked
+	auth := server.keys.JwtCheckMiddleware(sessionService)
+
 	// set up API routes
 	server.E.POST("/api/register", server.RegisterUserHandler)
 	server.E.POST("/api/login", server.LoginUserHandler)
+	server.E.POST("/api/token/refresh", server.RefreshTokenHandler)
+	server.E.POST("/api/logout", server.LogoutHandler, auth)
+	server.E.POST("/api/logout/all", server.LogoutAllHandler, auth)
+	server.E.POST("/api/password/forgot", server.ForgotPasswordHandler)
+	server.E.POST("/api/password/reset", server.ResetPasswordHandler)
+	server.E.POST("/api/verify-email", server.VerifyEmailHandler)
+	server.E.POST("/api/verify-email/resend", server.ResendVerificationHandler, auth)
+	server.E.GET("/.well-known/jwks.json", server.JWKSHandler)
 	server.E.GET("/api/books", server.GetBooksHandler)
-	server.E.GET("/api/orders", server.GetcustomerOrdersHandler, security.JwtCheckMiddleware())
-	server.E.POST("/api/orders", server.MakeOrderHandler, security.JwtCheckMiddleware())
+	server.E.GET("/api/books/search", server.SearchBooksHandler)
+	server.E.GET("/api/books/:id", server.GetBookHandler)
+	server.E.GET("/api/orders", server.GetcustomerOrdersHandler, auth)
+	server.E.POST("/api/orders", server.MakeOrderHandler, auth)
+	server.E.GET("/api/orders/:id", server.GetOrderHandler, auth)
+	server.E.POST("/api/orders/:id/cancel", server.CancelOrderHandler, auth)
+	server.E.POST("/api/orders/:id/returns", server.RequestReturnHandler, auth)
+	server.E.GET("/api/orders/:id/returns", server.GetOrderReturnsHandler, auth)
+
+	me := server.E.Group("/api/me", auth)
+	me.PUT("/password", server.ChangePasswordHandler)
+	me.PUT("/email", server.ChangeEmailHandler)
+	me.GET("/addresses", server.ListAddressesHandler)
+	me.POST("/addresses", server.CreateAddressHandler)
+	me.GET("/addresses/:id", server.GetAddressHandler)
+	me.PUT("/addresses/:id", server.UpdateAddressHandler)
+	me.DELETE("/addresses/:id", server.DeleteAddressHandler)
+
+	server.E.POST("/api/shipping/quote", server.QuoteShippingHandler, auth)
+
+	carts := server.E.Group("/api/cart", auth)
+	carts.GET("/items", server.GetCartHandler)
+	carts.POST("/items", server.AddCartItemHandler)
+	carts.PATCH("/items/:bookID", server.UpdateCartItemHandler)
+	carts.DELETE("/items/:bookID", server.RemoveCartItemHandler)
+	carts.POST("/checkout", server.CheckoutHandler)
+
+	admin := server.E.Group("/api/admin", auth, security.RequireRole(security.RoleAdmin))
+	admin.POST("/books", server.CreateBookHandler)
+	admin.PUT("/books/:id", server.UpdateBookHandler)
+	admin.PATCH("/books/:id", server.PatchBookHandler)
+	admin.DELETE("/books/:id", server.DeleteBookHandler)
+	admin.POST("/books/:id/stock", server.AdjustStockHandler)
+	admin.GET("/books/:id/stock/movements", server.GetStockMovementsHandler)
+	admin.POST("/orders/:id/status", server.UpdateOrderStatusHandler)
+	admin.GET("/orders/:id/status/history", server.GetOrderStatusHistoryHandler)
+	admin.GET("/returns", server.ListReturnsHandler)
+	admin.GET("/returns/:id", server.GetReturnHandler)
+	admin.POST("/returns/:id/approve", server.ApproveReturnHandler)
+	admin.POST("/returns/:id/reject", server.RejectReturnHandler)
+	admin.GET("/returns/:id/history", server.GetReturnHistoryHandler)
+	admin.POST("/coupons", server.CreateCouponHandler)
+	admin.GET("/coupons/:code", server.GetCouponHandler)
 	server.E.GET("/health", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 	})
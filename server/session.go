@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/session"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+)
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshTokenHandler
+// @Summary Refresh the tokens
+// @Description Exchange a refresh token for a new access token and a new refresh token. Every refresh token can only be used once,
+// @Description using it again revokes the whole session
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token body refreshTokenRequest true "refresh token"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 401 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/token/refresh [post]
+func (s *Server) RefreshTokenHandler(c echo.Context) error {
+	var r refreshTokenRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to refresh the tokens: %s", err.Error())})
+	}
+
+	tokens, err := s.sessionService.Refresh(c.Request().Context(), r.RefreshToken)
+	if err != nil {
+		if session.IsInvalidRefreshToken(err) {
+			return c.JSON(http.StatusUnauthorized, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+
+	return c.JSON(http.StatusOK, newTokenResponse(tokens))
+}
+
+// LogoutHandler
+// @Summary Log out
+// @Description Revoke the session of the access token, along with its refresh token
+// @Tags auth
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Success 200 {object} ResultMessage
+// @Failure 401 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/logout [post]
+func (s *Server) LogoutHandler(c echo.Context) error {
+	sessionID, ok := c.Get("session").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "session context value missing"})
+	}
+	tokenID, ok := c.Get("jti").(string)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "jti context value missing"})
+	}
+
+	if err := s.sessionService.Logout(c.Request().Context(), sessionID, tokenID); err != nil {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+
+	return c.JSON(http.StatusOK, ResultMessage{Message: "logged out"})
+}
+
+// LogoutAllHandler
+// @Summary Log out of all devices
+// @Description Revoke every session of the authenticated customer, including the one of the access token
+// @Tags auth
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Success 200 {object} ResultMessage
+// @Failure 401 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/logout/all [post]
+func (s *Server) LogoutAllHandler(c echo.Context) error {
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	if err := s.sessionService.LogoutAll(c.Request().Context(), customerID); err != nil {
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+
+	return c.JSON(http.StatusOK, ResultMessage{Message: "logged out of all devices"})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/book"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/shipping"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+)
+
+// QuoteShippingHandler
+// @Summary Quote the shipping of some books
+// @Description Get the cost and delivery time of every shipping method available to ship the items to the picked address, the destination
+// @Description or the default address, like an order made with them would be shipped
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Param quote body order.ShippingQuoteRequest true "items and address id or destination"
+// @Success 200 {array} shipping.Option
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 422 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/shipping/quote [post]
+func (s *Server) QuoteShippingHandler(c echo.Context) error {
+	var r order.ShippingQuoteRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to quote shipping: %s", err.Error())})
+	}
+
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	options, err := s.orderService.QuoteShipping(c.Request().Context(), customerID, r)
+	if err != nil {
+		if book.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if shipping.IsUnavailable(err) || customer.IsAddressNotFound(err) {
+			return c.JSON(http.StatusUnprocessableEntity, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, options)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/utils"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+)
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// VerifyEmailHandler
+// @Summary Verify the email
+// @Description Verify the email of the customer with the token of the link emailed to them, the link stops working if the customer changes their email
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param verification body verifyEmailRequest true "verification token"
+// @Success 200 {object} ResultMessage
+// @Failure 400 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/verify-email [post]
+func (s *Server) VerifyEmailHandler(c echo.Context) error {
+	var r verifyEmailRequest
+	if err := c.Bind(&r); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: fmt.Sprintf("Failed to verify the email: %s", err.Error())})
+	}
+
+	if err := s.verificationService.Verify(c.Request().Context(), r.Token); err != nil {
+		if utils.IsStorageRelatedError(err) {
+			slog.Error(err.Error())
+			return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, utils.ErrorMessage{ErrorMessage: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, ResultMessage{Message: "email verified"})
+}
+
+// ResendVerificationHandler
+// @Summary Resend the verification email
+// @Description Email a new verification link to the authenticated customer, a new one can only be sent once in a while
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Insert your access token" default(Bearer <Add access token here>)
+// @Success 200 {object} ResultMessage
+// @Failure 404 {object} utils.ErrorMessage
+// @Failure 409 {object} utils.ErrorMessage
+// @Failure 429 {object} utils.ErrorMessage
+// @Failure 500 {object} utils.ErrorMessage
+// @Router /api/verify-email/resend [post]
+func (s *Server) ResendVerificationHandler(c echo.Context) error {
+	customerID, ok := c.Get("id").(int64)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: "id context value missing"})
+	}
+
+	if err := s.verificationService.SendVerification(c.Request().Context(), customerID); err != nil {
+		if customer.IsNotFound(err) {
+			return c.JSON(http.StatusNotFound, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if customer.IsEmailAlreadyVerified(err) {
+			return c.JSON(http.StatusConflict, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		if customer.IsVerificationThrottled(err) {
+			return c.JSON(http.StatusTooManyRequests, utils.ErrorMessage{ErrorMessage: err.Error()})
+		}
+		// the email couldn't be stored or sent
+		slog.Error(err.Error())
+		return c.JSON(http.StatusInternalServerError, utils.ErrorMessage{ErrorMessage: errInternalSever.Error()})
+	}
+
+	return c.JSON(http.StatusOK, ResultMessage{Message: "a verification link was sent to your email"})
+}
@@ -0,0 +1,273 @@
+// Package session issues the tokens of the logged in customers: short-lived access tokens and refresh tokens that
+// are rotated every time they are used, and revokes them on logout.
+package session
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/security"
+	"log/slog"
+	"time"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token already used, the session was revoked")
+)
+
+// Session is a login of a customer, every refresh token issued since the login belongs to it
+type Session struct {
+	ID         int64
+	CustomerID int64
+	CreatedAt  time.Time
+	RevokedAt  *time.Time
+}
+
+// RefreshToken is a refresh token as it is stored, only the hash of the token is kept.
+// UsedAt is set when the token is exchanged for a new one, a used token can't be exchanged again
+type RefreshToken struct {
+	Hash      string
+	SessionID int64
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// Tokens are what the customer gets when logging in or refreshing the session
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+type Repository interface {
+	// CreateSession stores a new session of the customer with its first refresh token, whose SessionID is set to the
+	// id of the new session, returning the session id
+	CreateSession(ctx context.Context, customerID int64, token RefreshToken, createdAt time.Time) (int64, error)
+	// GetSession returns nil if there is no session with the id
+	GetSession(ctx context.Context, id int64) (*Session, error)
+	// GetRefreshToken returns nil if no refresh token has the hash
+	GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error)
+	// RotateRefreshToken marks the token as used and stores next in its place, reporting false when the token
+	// was already used
+	RotateRefreshToken(ctx context.Context, hash string, next RefreshToken, usedAt time.Time) (bool, error)
+	// RevokeSession revokes the session, which revokes its refresh tokens and the access tokens issued with them
+	RevokeSession(ctx context.Context, id int64, revokedAt time.Time) error
+	// RevokeCustomerSessions revokes every session of the customer but the one with the id except, 0 revokes them all
+	RevokeCustomerSessions(ctx context.Context, customerID, except int64, revokedAt time.Time) error
+	// RevokeToken rejects the access token with the id until expiresAt
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	// IsRevoked reports whether the session was revoked or doesn't exist anymore, or the token id was revoked
+	IsRevoked(ctx context.Context, sessionID int64, tokenID string) (bool, error)
+	// DeleteExpired removes the refresh tokens and the revoked token ids that expired before, and the sessions
+	// that don't have any refresh token left, returning how many rows were removed
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+type CustomerService interface {
+	GetcustomerByID(ctx context.Context, id int64) (*customer.Model, error)
+}
+
+type Signer interface {
+	GenerateJwtToken(claims security.AccessClaims) (string, error)
+}
+
+type Service struct {
+	r          Repository
+	customers  CustomerService
+	signer     Signer
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewService creates the session service, access tokens last accessTTL and refresh tokens refreshTTL
+func NewService(r Repository, customers CustomerService, signer Signer, accessTTL, refreshTTL time.Duration) *Service {
+	return &Service{r, customers, signer, accessTTL, refreshTTL}
+}
+
+// IsInvalidRefreshToken reports whether err means that the refresh token can't be exchanged, because it's unknown,
+// expired, revoked or was already used
+func IsInvalidRefreshToken(err error) bool {
+	return errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenReused)
+}
+
+// IsRefreshTokenReused reports whether err means that an already used refresh token was presented again
+func IsRefreshTokenReused(err error) bool {
+	return errors.Is(err, errRefreshTokenReused)
+}
+
+// newRefreshToken creates a refresh token of the session, returning it along with how it is stored
+func (s *Service) newRefreshToken(sessionID int64, now time.Time) (string, RefreshToken, error) {
+	token, err := security.RandomToken(32)
+	if err != nil {
+		return "", RefreshToken{}, err
+	}
+	return token, RefreshToken{Hash: security.HashToken(token), SessionID: sessionID, ExpiresAt: now.Add(s.refreshTTL), CreatedAt: now}, nil
+}
+
+// accessToken issues an access token of the session to the customer
+func (s *Service) accessToken(c *customer.Model, sessionID int64, now time.Time) (string, time.Time, error) {
+	tokenID, err := security.RandomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := now.Add(s.accessTTL)
+	token, err := s.signer.GenerateJwtToken(security.AccessClaims{
+		CustomerID: c.Id,
+		Email:      c.Email,
+		Roles:      c.Roles,
+		SessionID:  sessionID,
+		TokenID:    tokenID,
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// Start opens a new session of the customer that just logged in
+func (s *Service) Start(ctx context.Context, c *customer.Model) (*Tokens, error) {
+	now := time.Now()
+	refreshToken, stored, err := s.newRefreshToken(0, now)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := s.r.CreateSession(ctx, c.Id, stored, now)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, expiresAt, err := s.accessToken(c, sessionID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresAt: expiresAt}, nil
+}
+
+// Refresh exchanges the refresh token for a new access token and a new refresh token. A refresh token can only be
+// used once, presenting it again means that it was stolen, so the whole session is revoked
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	if refreshToken == "" {
+		return nil, errInvalidRefreshToken
+	}
+
+	hash := security.HashToken(refreshToken)
+	stored, err := s.r.GetRefreshToken(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	session, err := s.r.GetSession(ctx, stored.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.RevokedAt != nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	now := time.Now()
+	if stored.UsedAt != nil {
+		return nil, s.revokeReused(ctx, session)
+	}
+	if !now.Before(stored.ExpiresAt) {
+		return nil, errInvalidRefreshToken
+	}
+
+	c, err := s.customers.GetcustomerByID(ctx, session.CustomerID)
+	if err != nil {
+		if customer.IsNotFound(err) {
+			return nil, errInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	nextToken, next, err := s.newRefreshToken(session.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := s.r.RotateRefreshToken(ctx, hash, next, now)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// the token was used by another request in the meantime
+		return nil, s.revokeReused(ctx, session)
+	}
+
+	accessToken, expiresAt, err := s.accessToken(c, session.ID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{AccessToken: accessToken, RefreshToken: nextToken, ExpiresAt: expiresAt}, nil
+}
+
+// revokeReused revokes the session whose refresh token was reused
+func (s *Service) revokeReused(ctx context.Context, session *Session) error {
+	slog.Warn("refresh token reused, revoking the session", "session", session.ID, "customer", session.CustomerID)
+	if err := s.r.RevokeSession(ctx, session.ID, time.Now()); err != nil {
+		return err
+	}
+	return errRefreshTokenReused
+}
+
+// Logout revokes the session and the access token used to log out
+func (s *Service) Logout(ctx context.Context, sessionID int64, tokenID string) error {
+	now := time.Now()
+	if err := s.r.RevokeSession(ctx, sessionID, now); err != nil {
+		return err
+	}
+	// the access token expires at most accessTTL after now
+	return s.r.RevokeToken(ctx, tokenID, now.Add(s.accessTTL))
+}
+
+// LogoutAll revokes every session of the customer, logging them out of all devices
+func (s *Service) LogoutAll(ctx context.Context, customerID int64) error {
+	return s.r.RevokeCustomerSessions(ctx, customerID, 0, time.Now())
+}
+
+// LogoutOthers revokes every session of the customer but the current one, logging out the other devices
+func (s *Service) LogoutOthers(ctx context.Context, customerID, sessionID int64) error {
+	return s.r.RevokeCustomerSessions(ctx, customerID, sessionID, time.Now())
+}
+
+// IsRevoked reports whether an access token of the session with the token id can't be used anymore
+func (s *Service) IsRevoked(ctx context.Context, sessionID int64, tokenID string) (bool, error) {
+	return s.r.IsRevoked(ctx, sessionID, tokenID)
+}
+
+// PurgeExpired removes the refresh tokens and the revoked token ids that expired
+func (s *Service) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.r.DeleteExpired(ctx, time.Now())
+}
+
+// PurgePeriodically calls PurgeExpired every interval until ctx is done
+func (s *Service) PurgePeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.PurgeExpired(ctx)
+			if err != nil {
+				slog.Error("error purging expired sessions", "error", err)
+				continue
+			}
+			if n > 0 {
+				slog.Info("purged expired sessions", "count", n)
+			}
+		}
+	}
+}
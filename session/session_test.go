@@ -0,0 +1,363 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/security"
+	"testing"
+	"time"
+)
+
+// MockRepository keeps the sessions in memory, mimicking the database behavior
+type MockRepository struct {
+	Sessions      map[int64]*Session
+	RefreshTokens map[string]*RefreshToken
+	RevokedTokens map[string]time.Time
+	Err           error
+}
+
+func newMockRepository() *MockRepository {
+	return &MockRepository{Sessions: map[int64]*Session{}, RefreshTokens: map[string]*RefreshToken{}, RevokedTokens: map[string]time.Time{}}
+}
+
+func (m *MockRepository) CreateSession(ctx context.Context, customerID int64, token RefreshToken, createdAt time.Time) (int64, error) {
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	id := int64(len(m.Sessions) + 1)
+	m.Sessions[id] = &Session{ID: id, CustomerID: customerID, CreatedAt: createdAt}
+	token.SessionID = id
+	m.RefreshTokens[token.Hash] = &token
+	return id, nil
+}
+
+func (m *MockRepository) GetSession(ctx context.Context, id int64) (*Session, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	s, ok := m.Sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *s
+	return &c, nil
+}
+
+func (m *MockRepository) GetRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	t, ok := m.RefreshTokens[hash]
+	if !ok {
+		return nil, nil
+	}
+	c := *t
+	return &c, nil
+}
+
+func (m *MockRepository) RotateRefreshToken(ctx context.Context, hash string, next RefreshToken, usedAt time.Time) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	t, ok := m.RefreshTokens[hash]
+	if !ok || t.UsedAt != nil {
+		return false, nil
+	}
+	t.UsedAt = &usedAt
+	m.RefreshTokens[next.Hash] = &next
+	return true, nil
+}
+
+func (m *MockRepository) RevokeSession(ctx context.Context, id int64, revokedAt time.Time) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	if s, ok := m.Sessions[id]; ok && s.RevokedAt == nil {
+		s.RevokedAt = &revokedAt
+	}
+	return nil
+}
+
+func (m *MockRepository) RevokeCustomerSessions(ctx context.Context, customerID, except int64, revokedAt time.Time) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, s := range m.Sessions {
+		if s.CustomerID == customerID && s.ID != except && s.RevokedAt == nil {
+			s.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (m *MockRepository) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	m.RevokedTokens[tokenID] = expiresAt
+	return nil
+}
+
+func (m *MockRepository) IsRevoked(ctx context.Context, sessionID int64, tokenID string) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	s, ok := m.Sessions[sessionID]
+	_, revoked := m.RevokedTokens[tokenID]
+	return !ok || s.RevokedAt != nil || revoked, nil
+}
+
+func (m *MockRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	var n int64
+	for hash, t := range m.RefreshTokens {
+		if t.ExpiresAt.Before(before) {
+			delete(m.RefreshTokens, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// mockCustomerRepository backs a real customer.Service, it only knows the customers by id
+type mockCustomerRepository struct {
+	customers map[int64]*customer.Model
+}
+
+func (m *mockCustomerRepository) SaveCustomer(ctx context.Context, email, password string, createdAt time.Time) (*int64, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockCustomerRepository) GetCustomer(ctx context.Context, email string) (*customer.Model, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockCustomerRepository) GetCustomerByID(ctx context.Context, id int64) (*customer.Model, error) {
+	return m.customers[id], nil
+}
+
+func (m *mockCustomerRepository) UpdatePassword(ctx context.Context, id int64, password string) error {
+	return errors.New("not implemented")
+}
+
+func (m *mockCustomerRepository) UpdateEmail(ctx context.Context, id int64, email string) error {
+	return errors.New("not implemented")
+}
+
+// mockSigner records the claims of the issued tokens, the token is the jti
+type mockSigner struct {
+	claims map[string]security.AccessClaims
+}
+
+func (m *mockSigner) GenerateJwtToken(claims security.AccessClaims) (string, error) {
+	m.claims[claims.TokenID] = claims
+	return claims.TokenID, nil
+}
+
+var testCustomer = &customer.Model{Id: 1, Email: "a@a.com", Roles: []string{security.RoleCustomer}}
+
+func newTestService() (*Service, *MockRepository, *mockSigner) {
+	repo := newMockRepository()
+	customers := customer.NewService(&mockCustomerRepository{customers: map[int64]*customer.Model{1: testCustomer}}, nil)
+	signer := &mockSigner{claims: map[string]security.AccessClaims{}}
+	return NewService(repo, customers, signer, 15*time.Minute, time.Hour), repo, signer
+}
+
+func TestService_Start(t *testing.T) {
+	service, repo, signer := newTestService()
+
+	tokens, err := service.Start(context.Background(), testCustomer)
+	if err != nil {
+		t.Fatalf("Expected the session to start, got: %v", err)
+	}
+
+	claims := signer.claims[tokens.AccessToken]
+	if claims.CustomerID != 1 || claims.Email != "a@a.com" || claims.SessionID != 1 || !claims.ExpiresAt.Equal(tokens.ExpiresAt) {
+		t.Fatalf("Unexpected access token claims: %+v", claims)
+	}
+	if time.Until(tokens.ExpiresAt) > 15*time.Minute {
+		t.Fatalf("Expected the access token to expire in 15 minutes, it expires at: %v", tokens.ExpiresAt)
+	}
+
+	stored, ok := repo.RefreshTokens[security.HashToken(tokens.RefreshToken)]
+	if !ok || stored.SessionID != 1 {
+		t.Fatalf("Expected only the hash of the refresh token to be stored, got: %+v", repo.RefreshTokens)
+	}
+	if _, ok := repo.RefreshTokens[tokens.RefreshToken]; ok {
+		t.Fatal("The plain refresh token shouldn't be stored")
+	}
+
+	repo.Err = errors.New("db down")
+	if _, err := service.Start(context.Background(), testCustomer); !errors.Is(err, repo.Err) {
+		t.Fatalf("Expected error: %v, got: %v", repo.Err, err)
+	}
+}
+
+func TestService_Refresh(t *testing.T) {
+	t.Run("Rotates", func(t *testing.T) {
+		service, repo, signer := newTestService()
+		first, err := service.Start(context.Background(), testCustomer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		second, err := service.Refresh(context.Background(), first.RefreshToken)
+		if err != nil {
+			t.Fatalf("Expected the tokens to be refreshed, got: %v", err)
+		}
+		if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+			t.Fatal("Expected new tokens")
+		}
+		if claims := signer.claims[second.AccessToken]; claims.SessionID != 1 || claims.CustomerID != 1 {
+			t.Fatalf("Expected an access token of the same session, got: %+v", claims)
+		}
+		if repo.RefreshTokens[security.HashToken(first.RefreshToken)].UsedAt == nil {
+			t.Fatal("Expected the first refresh token to be used")
+		}
+
+		if _, err := service.Refresh(context.Background(), second.RefreshToken); err != nil {
+			t.Fatalf("Expected the new refresh token to work, got: %v", err)
+		}
+	})
+
+	t.Run("ReuseRevokesTheSession", func(t *testing.T) {
+		service, repo, _ := newTestService()
+		first, err := service.Start(context.Background(), testCustomer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := service.Refresh(context.Background(), first.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = service.Refresh(context.Background(), first.RefreshToken)
+		if !IsRefreshTokenReused(err) || !IsInvalidRefreshToken(err) {
+			t.Fatalf("Expected error: %v, got: %v", errRefreshTokenReused, err)
+		}
+		if repo.Sessions[1].RevokedAt == nil {
+			t.Fatal("Expected the session to be revoked")
+		}
+
+		// the token issued to whoever used the refresh token first doesn't work anymore either
+		if _, err := service.Refresh(context.Background(), second.RefreshToken); !errors.Is(err, errInvalidRefreshToken) {
+			t.Fatalf("Expected error: %v, got: %v", errInvalidRefreshToken, err)
+		}
+		if revoked, _ := service.IsRevoked(context.Background(), 1, "any"); !revoked {
+			t.Fatal("Expected the access tokens of the session to be revoked")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		service, repo, _ := newTestService()
+		tokens, err := service.Start(context.Background(), testCustomer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, token := range []string{"", "unknown"} {
+			if _, err := service.Refresh(context.Background(), token); !errors.Is(err, errInvalidRefreshToken) {
+				t.Fatalf("Expected error: %v, got: %v", errInvalidRefreshToken, err)
+			}
+		}
+
+		repo.RefreshTokens[security.HashToken(tokens.RefreshToken)].ExpiresAt = time.Now().Add(-time.Second)
+		if _, err := service.Refresh(context.Background(), tokens.RefreshToken); !errors.Is(err, errInvalidRefreshToken) {
+			t.Fatalf("Expected error for an expired token: %v, got: %v", errInvalidRefreshToken, err)
+		}
+	})
+
+	t.Run("DeletedCustomer", func(t *testing.T) {
+		service, _, _ := newTestService()
+		tokens, err := service.Start(context.Background(), &customer.Model{Id: 2, Email: "b@b.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := service.Refresh(context.Background(), tokens.RefreshToken); !errors.Is(err, errInvalidRefreshToken) {
+			t.Fatalf("Expected error: %v, got: %v", errInvalidRefreshToken, err)
+		}
+	})
+
+	t.Run("StorageError", func(t *testing.T) {
+		service, repo, _ := newTestService()
+		repo.Err = errors.New("db down")
+		if _, err := service.Refresh(context.Background(), "token"); !errors.Is(err, repo.Err) || IsInvalidRefreshToken(err) {
+			t.Fatalf("Expected error: %v, got: %v", repo.Err, err)
+		}
+	})
+}
+
+func TestService_Logout(t *testing.T) {
+	service, repo, _ := newTestService()
+	first, err := service.Start(context.Background(), testCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.Start(context.Background(), testCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.Logout(context.Background(), 1, first.AccessToken); err != nil {
+		t.Fatalf("Expected the logout to work, got: %v", err)
+	}
+	if revoked, err := service.IsRevoked(context.Background(), 1, "other"); err != nil || !revoked {
+		t.Fatalf("Expected the session to be revoked, got: %v, %v", revoked, err)
+	}
+	if expiresAt, ok := repo.RevokedTokens[first.AccessToken]; !ok || expiresAt.After(time.Now().Add(15*time.Minute)) {
+		t.Fatalf("Expected the access token to be revoked until it expires, got: %v", repo.RevokedTokens)
+	}
+	if revoked, _ := service.IsRevoked(context.Background(), 2, second.AccessToken); revoked {
+		t.Fatal("Expected the other session to keep working")
+	}
+	if _, err := service.Refresh(context.Background(), first.RefreshToken); !errors.Is(err, errInvalidRefreshToken) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidRefreshToken, err)
+	}
+
+	if err := service.LogoutAll(context.Background(), testCustomer.Id); err != nil {
+		t.Fatalf("Expected the logout of all devices to work, got: %v", err)
+	}
+	if revoked, _ := service.IsRevoked(context.Background(), 2, second.AccessToken); !revoked {
+		t.Fatal("Expected every session to be revoked")
+	}
+}
+
+func TestService_LogoutOthers(t *testing.T) {
+	service, _, _ := newTestService()
+	first, err := service.Start(context.Background(), testCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.Start(context.Background(), testCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.LogoutOthers(context.Background(), testCustomer.Id, 2); err != nil {
+		t.Fatalf("Expected the logout of the other devices to work, got: %v", err)
+	}
+	if revoked, _ := service.IsRevoked(context.Background(), 1, first.AccessToken); !revoked {
+		t.Fatal("Expected the other session to be revoked")
+	}
+	if revoked, _ := service.IsRevoked(context.Background(), 2, second.AccessToken); revoked {
+		t.Fatal("Expected the current session to keep working")
+	}
+}
+
+func TestService_PurgeExpired(t *testing.T) {
+	service, repo, _ := newTestService()
+	if _, err := service.Start(context.Background(), testCustomer); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range repo.RefreshTokens {
+		token.ExpiresAt = time.Now().Add(-time.Minute)
+	}
+
+	n, err := service.PurgeExpired(context.Background())
+	if err != nil || n != 1 || len(repo.RefreshTokens) != 0 {
+		t.Fatalf("Expected the expired refresh token to be purged, got: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,209 @@
+// Package shipping computes how much it costs to ship an order with each delivery method.
+package shipping
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/tax"
+	"os"
+	"strings"
+)
+
+var (
+	errInvalidMethod          = errors.New("invalid shipping method: needs to be standard, express or pickup")
+	errInvalidZone            = errors.New("invalid shipping zone")
+	errInvalidRate            = errors.New("invalid shipping rate")
+	errDuplicateZone          = errors.New("duplicate shipping zone")
+	errDuplicateRate          = errors.New("duplicate shipping rate")
+	errUnsupportedDestination = errors.New("the store doesn't ship to this destination")
+	errMethodUnavailable      = errors.New("the shipping method isn't available for this destination")
+)
+
+//go:embed rates.json
+var defaultRates []byte
+
+type Method string
+
+const (
+	MethodStandard Method = "standard"
+	MethodExpress  Method = "express"
+	MethodPickup   Method = "pickup"
+)
+
+func (m Method) valid() bool {
+	return m == MethodStandard || m == MethodExpress || m == MethodPickup
+}
+
+// Parcel is what is shipped, Items is the number of units and Weight is their total weight in grams
+type Parcel struct {
+	Items  int
+	Weight int
+}
+
+// kilograms returns the weight of the parcel in kilograms, every started kilogram counts as a whole one
+func (p Parcel) kilograms() int {
+	return (p.Weight + 999) / 1000
+}
+
+// Option is a way of shipping a parcel, it arrives between MinDays and MaxDays after the order is made
+type Option struct {
+	Method  Method       `json:"method"`
+	Cost    money.Amount `json:"cost"`
+	MinDays int          `json:"min_days"`
+	MaxDays int          `json:"max_days"`
+}
+
+// Calculator returns every Option available to ship the parcel to destination
+type Calculator interface {
+	Quote(ctx context.Context, destination tax.Destination, parcel Parcel) ([]Option, error)
+}
+
+// IsUnavailable reports whether err means that the store doesn't ship to the destination, or not with the method
+func IsUnavailable(err error) bool {
+	return errors.Is(err, errUnsupportedDestination) || errors.Is(err, errMethodUnavailable)
+}
+
+// Select returns the option of the method, an empty method picks the standard one
+func Select(options []Option, method Method) (*Option, error) {
+	if method == "" {
+		method = MethodStandard
+	}
+	if !method.valid() {
+		return nil, errInvalidMethod
+	}
+
+	for _, v := range options {
+		if v.Method == method {
+			return &v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", errMethodUnavailable, method)
+}
+
+// Zone is a group of countries that share the same rates
+type Zone struct {
+	Name      string   `json:"name"`
+	Countries []string `json:"countries"`
+}
+
+// Rate is the price of a method in a zone: Base plus PerItem for every unit and PerKg for every started kilogram
+type Rate struct {
+	Method  Method       `json:"method"`
+	Zone    string       `json:"zone"`
+	Base    money.Amount `json:"base"`
+	PerItem money.Amount `json:"per_item"`
+	PerKg   money.Amount `json:"per_kg"`
+	MinDays int          `json:"min_days"`
+	MaxDays int          `json:"max_days"`
+}
+
+func (r Rate) cost(p Parcel) money.Amount {
+	return r.Base + r.PerItem.Mul(p.Items) + r.PerKg.Mul(p.kilograms())
+}
+
+// Rules are the zones and the rates of a Table
+type Rules struct {
+	Zones []Zone `json:"zones"`
+	Rates []Rate `json:"rates"`
+}
+
+// Table is a Calculator that looks the rates up by the zone of the destination country
+type Table struct {
+	zones map[string]string
+	rates map[string][]Rate
+}
+
+// NewTable validates the rules and builds a Table with them
+func NewTable(rules Rules) (*Table, error) {
+	t := &Table{zones: map[string]string{}, rates: map[string][]Rate{}}
+
+	for _, z := range rules.Zones {
+		if z.Name == "" {
+			return nil, errInvalidZone
+		}
+		if _, ok := t.rates[z.Name]; ok {
+			return nil, fmt.Errorf("%w: %s", errDuplicateZone, z.Name)
+		}
+		t.rates[z.Name] = nil
+
+		for _, c := range z.Countries {
+			d := tax.Destination{Country: c}.Normalize()
+			if err := d.Validate(); err != nil {
+				return nil, err
+			}
+			if other, ok := t.zones[d.Country]; ok {
+				return nil, fmt.Errorf("%w: %s is in %s and %s", errInvalidZone, d.Country, other, z.Name)
+			}
+			t.zones[d.Country] = z.Name
+		}
+	}
+
+	for _, r := range rules.Rates {
+		if !r.Method.valid() {
+			return nil, fmt.Errorf("%w: %s", errInvalidMethod, r.Method)
+		}
+		rates, ok := t.rates[r.Zone]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errInvalidZone, r.Zone)
+		}
+		if r.Base < 0 || r.PerItem < 0 || r.PerKg < 0 || r.MinDays < 0 || r.MinDays > r.MaxDays {
+			return nil, fmt.Errorf("%w: %s in %s", errInvalidRate, r.Method, r.Zone)
+		}
+		for _, v := range rates {
+			if v.Method == r.Method {
+				return nil, fmt.Errorf("%w: %s in %s", errDuplicateRate, r.Method, r.Zone)
+			}
+		}
+		t.rates[r.Zone] = append(rates, r)
+	}
+
+	return t, nil
+}
+
+// ParseTable reads the rules of a Table from a JSON object with its zones and rates
+func ParseTable(data []byte) (*Table, error) {
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid shipping rules: %w", err)
+	}
+	return NewTable(rules)
+}
+
+// LoadTable reads the rules of a Table from a JSON file
+func LoadTable(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading shipping rules: %w", err)
+	}
+	return ParseTable(data)
+}
+
+// DefaultTable returns the Table with the rules shipped with the store
+func DefaultTable() *Table {
+	t, err := ParseTable(defaultRates)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Quote prices the parcel with every rate of the zone of the destination, in the order the rates were given
+func (t *Table) Quote(ctx context.Context, destination tax.Destination, parcel Parcel) ([]Option, error) {
+	country := strings.ToUpper(strings.TrimSpace(destination.Country))
+	zone, ok := t.zones[country]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedDestination, country)
+	}
+
+	options := make([]Option, 0, len(t.rates[zone]))
+	for _, r := range t.rates[zone] {
+		options = append(options, Option{Method: r.Method, Cost: r.cost(parcel), MinDays: r.MinDays, MaxDays: r.MaxDays})
+	}
+
+	return options, nil
+}
@@ -0,0 +1,142 @@
+package shipping
+
+import (
+	"context"
+	"errors"
+	"github.com/ap-pauloafonso/bookstore/tax"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testRules = Rules{
+	Zones: []Zone{{Name: "domestic", Countries: []string{"us"}}, {Name: "europe", Countries: []string{"DE", "FR"}}},
+	Rates: []Rate{
+		{Method: MethodStandard, Zone: "domestic", Base: 499, PerItem: 50, PerKg: 100, MinDays: 3, MaxDays: 5},
+		{Method: MethodPickup, Zone: "domestic", MinDays: 0, MaxDays: 1},
+		{Method: MethodExpress, Zone: "europe", Base: 2999, PerKg: 1000, MinDays: 2, MaxDays: 4},
+	},
+}
+
+func TestNewTable(t *testing.T) {
+	tests := []struct {
+		name          string
+		rules         Rules
+		expectedError error
+	}{
+		{name: "Valid", rules: testRules},
+		{name: "UnnamedZone", rules: Rules{Zones: []Zone{{Countries: []string{"US"}}}}, expectedError: errInvalidZone},
+		{name: "DuplicateZone", rules: Rules{Zones: []Zone{{Name: "a"}, {Name: "a"}}}, expectedError: errDuplicateZone},
+		{name: "CountryInTwoZones", rules: Rules{Zones: []Zone{{Name: "a", Countries: []string{"US"}}, {Name: "b", Countries: []string{"us"}}}}, expectedError: errInvalidZone},
+		{name: "UnknownZone", rules: Rules{Rates: []Rate{{Method: MethodStandard, Zone: "mars"}}}, expectedError: errInvalidZone},
+		{name: "InvalidMethod", rules: Rules{Zones: []Zone{{Name: "a"}}, Rates: []Rate{{Method: "drone", Zone: "a"}}}, expectedError: errInvalidMethod},
+		{name: "NegativeCost", rules: Rules{Zones: []Zone{{Name: "a"}}, Rates: []Rate{{Method: MethodStandard, Zone: "a", PerKg: -1}}}, expectedError: errInvalidRate},
+		{name: "InvalidDays", rules: Rules{Zones: []Zone{{Name: "a"}}, Rates: []Rate{{Method: MethodStandard, Zone: "a", MinDays: 5, MaxDays: 3}}}, expectedError: errInvalidRate},
+		{name: "DuplicateRate", rules: Rules{Zones: []Zone{{Name: "a"}}, Rates: []Rate{{Method: MethodStandard, Zone: "a"}, {Method: MethodStandard, Zone: "a"}}}, expectedError: errDuplicateRate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTable(tt.rules)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestTable_Quote(t *testing.T) {
+	table, err := NewTable(testRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		destination   tax.Destination
+		parcel        Parcel
+		expected      []Option
+		expectedError error
+	}{
+		{
+			// 4.99 plus 0.50 for each of the 3 units and 1.00 for each of the 2 started kilograms
+			name:        "ByItemsAndWeight",
+			destination: tax.Destination{Country: "US", Region: "CA"},
+			parcel:      Parcel{Items: 3, Weight: 1001},
+			expected:    []Option{{Method: MethodStandard, Cost: 849, MinDays: 3, MaxDays: 5}, {Method: MethodPickup, MinDays: 0, MaxDays: 1}},
+		},
+		{
+			name:        "WholeKilograms",
+			destination: tax.Destination{Country: " de "},
+			parcel:      Parcel{Items: 5, Weight: 2000},
+			expected:    []Option{{Method: MethodExpress, Cost: 4999, MinDays: 2, MaxDays: 4}},
+		},
+		{name: "Unsupported", destination: tax.Destination{Country: "BR"}, parcel: Parcel{Items: 1, Weight: 400}, expectedError: errUnsupportedDestination},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := table.Quote(context.Background(), tt.destination, tt.parcel)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+			if IsUnavailable(err) != (tt.expectedError != nil) {
+				t.Errorf("IsUnavailable mismatch for error: %v", err)
+			}
+
+			if len(options) != len(tt.expected) {
+				t.Fatalf("Expected options: %+v, got: %+v", tt.expected, options)
+			}
+			for i := range options {
+				if options[i] != tt.expected[i] {
+					t.Errorf("Expected option: %+v, got: %+v", tt.expected[i], options[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	options := []Option{{Method: MethodStandard, Cost: 499}, {Method: MethodExpress, Cost: 1299}}
+
+	if o, err := Select(options, ""); err != nil || o.Method != MethodStandard {
+		t.Fatalf("Expected the standard method by default, got: %+v, %v", o, err)
+	}
+	if o, err := Select(options, MethodExpress); err != nil || o.Cost != 1299 {
+		t.Fatalf("Expected the express method, got: %+v, %v", o, err)
+	}
+	if _, err := Select(options, MethodPickup); !errors.Is(err, errMethodUnavailable) || !IsUnavailable(err) {
+		t.Fatalf("Expected error: %v, got: %v", errMethodUnavailable, err)
+	}
+	if _, err := Select(options, "drone"); !errors.Is(err, errInvalidMethod) || IsUnavailable(err) {
+		t.Fatalf("Expected error: %v, got: %v", errInvalidMethod, err)
+	}
+}
+
+func TestLoadTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	rules := `{"zones": [{"name": "local", "countries": ["PT"]}], "rates": [{"method": "standard", "zone": "local", "base": "2.5", "per_item": 0.1, "max_days": 2}]}`
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := LoadTable(path)
+	if err != nil {
+		t.Fatalf("Expected the table to be loaded, got: %v", err)
+	}
+	options, err := table.Quote(context.Background(), tax.Destination{Country: "PT"}, Parcel{Items: 2, Weight: 800})
+	if err != nil || len(options) != 1 || options[0].Cost != 270 {
+		t.Fatalf("Expected the rates of the file, got: %+v, %v", options, err)
+	}
+
+	if _, err := LoadTable(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
+
+func TestDefaultTable(t *testing.T) {
+	options, err := DefaultTable().Quote(context.Background(), tax.Destination{Country: "US"}, Parcel{Items: 1, Weight: 400})
+	if err != nil || len(options) != 3 {
+		t.Fatalf("Expected every domestic method, got: %+v, %v", options, err)
+	}
+}
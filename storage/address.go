@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type AddressRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAddressRepository(db *pgxpool.Pool) *AddressRepository {
+	return &AddressRepository{db}
+}
+
+const addressColumns = "id, recipient, line1, line2, city, region, postal_code, country, is_default, created_at"
+
+func scanAddress(row pgx.Row) (*customer.Address, error) {
+	var a customer.Address
+	if err := row.Scan(&a.ID, &a.Recipient, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country, &a.Default, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// lockAddressBook locks the customer row, so changes to the default address of the same customer happen one at a time
+func lockAddressBook(ctx context.Context, tx pgx.Tx, customerID int64) error {
+	if _, err := tx.Exec(ctx, "SELECT id FROM customers WHERE id = $1 FOR UPDATE", customerID); err != nil {
+		return fmt.Errorf("error locking address book: %w", err)
+	}
+	return nil
+}
+
+func (r *AddressRepository) ListAddresses(ctx context.Context, customerID int64) ([]customer.Address, error) {
+	query := "SELECT " + addressColumns + " FROM addresses WHERE customer_id = $1 ORDER BY is_default DESC, id"
+	rows, err := r.db.Query(ctx, query, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching addresses: %w", err)
+	}
+	defer rows.Close()
+
+	var addresses []customer.Address
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching addresses: %w", err)
+		}
+		addresses = append(addresses, *a)
+	}
+
+	return addresses, rows.Err()
+}
+
+func (r *AddressRepository) GetAddress(ctx context.Context, customerID, addressID int64) (*customer.Address, error) {
+	query := "SELECT " + addressColumns + " FROM addresses WHERE customer_id = $1 AND id = $2"
+	a, err := scanAddress(r.db.QueryRow(ctx, query, customerID, addressID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching address: %w", err)
+	}
+	return a, nil
+}
+
+func (r *AddressRepository) GetDefaultAddress(ctx context.Context, customerID int64) (*customer.Address, error) {
+	query := "SELECT " + addressColumns + " FROM addresses WHERE customer_id = $1 AND is_default"
+	a, err := scanAddress(r.db.QueryRow(ctx, query, customerID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching default address: %w", err)
+	}
+	return a, nil
+}
+
+func (r *AddressRepository) SaveAddress(ctx context.Context, customerID int64, a customer.Address) (*customer.Address, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockAddressBook(ctx, tx, customerID); err != nil {
+		return nil, err
+	}
+
+	if a.Default {
+		if _, err := tx.Exec(ctx, "UPDATE addresses SET is_default = FALSE WHERE customer_id = $1 AND is_default", customerID); err != nil {
+			return nil, fmt.Errorf("error unsetting default address: %w", err)
+		}
+	}
+
+	// the first address of the customer is the default one
+	query := `
+		INSERT INTO addresses (customer_id, recipient, line1, line2, city, region, postal_code, country, is_default, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9 OR NOT EXISTS (SELECT 1 FROM addresses WHERE customer_id = $1 AND is_default), $10)
+		RETURNING ` + addressColumns
+	saved, err := scanAddress(tx.QueryRow(ctx, query, customerID, a.Recipient, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, a.Country,
+		a.Default, a.CreatedAt))
+	if err != nil {
+		return nil, fmt.Errorf("error saving address: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return saved, nil
+}
+
+func (r *AddressRepository) UpdateAddress(ctx context.Context, customerID int64, a customer.Address) (*customer.Address, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockAddressBook(ctx, tx, customerID); err != nil {
+		return nil, err
+	}
+
+	if a.Default {
+		_, err := tx.Exec(ctx, "UPDATE addresses SET is_default = FALSE WHERE customer_id = $1 AND id <> $2 AND is_default", customerID, a.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error unsetting default address: %w", err)
+		}
+	}
+
+	// the default address stays the default one until another address takes its place
+	query := `
+		UPDATE addresses
+		SET recipient = $3, line1 = $4, line2 = $5, city = $6, region = $7, postal_code = $8, country = $9, is_default = is_default OR $10
+		WHERE customer_id = $1 AND id = $2
+		RETURNING ` + addressColumns
+	updated, err := scanAddress(tx.QueryRow(ctx, query, customerID, a.ID, a.Recipient, a.Line1, a.Line2, a.City, a.Region, a.PostalCode,
+		a.Country, a.Default))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error updating address: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return updated, nil
+}
+
+func (r *AddressRepository) DeleteAddress(ctx context.Context, customerID, addressID int64) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockAddressBook(ctx, tx, customerID); err != nil {
+		return false, err
+	}
+
+	var wasDefault bool
+	err = tx.QueryRow(ctx, "DELETE FROM addresses WHERE customer_id = $1 AND id = $2 RETURNING is_default", customerID, addressID).Scan(&wasDefault)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error deleting address: %w", err)
+	}
+
+	if wasDefault {
+		query := `
+			UPDATE addresses SET is_default = TRUE
+			WHERE id = (SELECT id FROM addresses WHERE customer_id = $1 ORDER BY id LIMIT 1)
+		`
+		if _, err := tx.Exec(ctx, query, customerID); err != nil {
+			return false, fmt.Errorf("error setting default address: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"testing"
+	"time"
+)
+
+func TestAddressRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	t.Parallel()
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:latest",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "test",
+			"POSTGRES_DB":       "MY_DB",
+		},
+		WaitingFor: wait.ForAll(wait.ForListeningPort("5432/tcp"), wait.ForLog("database system is ready to accept connections")),
+	}
+	postgresC, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start PostgreSQL container: %v", err)
+	}
+	defer postgresC.Terminate(context.Background())
+
+	host, err := postgresC.Host(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		t.Error(err)
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", host, port.Port())
+
+	time.Sleep(3 * time.Second) // a bit of delay to make sure that container is ready
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewAddressRepository(pool)
+	orderRepo := NewOrderRepository(pool)
+	customerRepo := NewCustomerRepository(pool)
+
+	err = RunMigrations(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customerID, err := customerRepo.SaveCustomer(context.Background(), "address@gmail.com", "123", time.Now())
+	if err != nil {
+		t.Fatalf("should not have an error while creating the customer: %v", err)
+	}
+
+	newAddress := func(city string, isDefault bool) customer.Address {
+		return customer.Address{Recipient: "John", Line1: "1 Main St", City: city, Region: "CA", Country: "US", Default: isDefault, CreatedAt: time.Now()}
+	}
+
+	var first, second *customer.Address
+
+	t.Run("the first address is the default one", func(t *testing.T) {
+		first, err = repo.SaveAddress(context.Background(), *customerID, newAddress("Los Angeles", false))
+		if err != nil || first == nil || first.ID == 0 || !first.Default {
+			t.Fatalf("the first address should be the default one, got: %+v, %v", first, err)
+		}
+
+		second, err = repo.SaveAddress(context.Background(), *customerID, newAddress("San Diego", false))
+		if err != nil || second == nil || second.Default {
+			t.Fatalf("the second address shouldn't be the default one, got: %+v, %v", second, err)
+		}
+	})
+
+	t.Run("a new default address replaces the previous one", func(t *testing.T) {
+		third, err := repo.SaveAddress(context.Background(), *customerID, newAddress("San Francisco", true))
+		if err != nil || !third.Default {
+			t.Fatalf("should save the default address, got: %+v, %v", third, err)
+		}
+
+		d, err := repo.GetDefaultAddress(context.Background(), *customerID)
+		if err != nil || d == nil || d.ID != third.ID {
+			t.Fatalf("should return the new default address, got: %+v, %v", d, err)
+		}
+
+		addresses, err := repo.ListAddresses(context.Background(), *customerID)
+		if err != nil || len(addresses) != 3 || addresses[0].ID != third.ID || addresses[1].ID != first.ID || addresses[1].Default {
+			t.Fatalf("should list the default address first, got: %+v, %v", addresses, err)
+		}
+
+		updated, err := repo.UpdateAddress(context.Background(), *customerID, customer.Address{ID: first.ID, Recipient: "Jane", Line1: "2 Main St",
+			City: "Sacramento", Region: "CA", Country: "US", Default: true})
+		if err != nil || updated == nil || !updated.Default || updated.City != "Sacramento" || !updated.CreatedAt.Equal(first.CreatedAt) {
+			t.Fatalf("should update the address and make it the default one, got: %+v, %v", updated, err)
+		}
+
+		kept, err := repo.UpdateAddress(context.Background(), *customerID, customer.Address{ID: first.ID, Recipient: "Jane", Line1: "2 Main St",
+			City: "Sacramento", Region: "CA", Country: "US"})
+		if err != nil || kept == nil || !kept.Default {
+			t.Fatalf("the default address should stay the default one, got: %+v, %v", kept, err)
+		}
+	})
+
+	t.Run("addresses of other customers aren't found", func(t *testing.T) {
+		otherID, err := customerRepo.SaveCustomer(context.Background(), "other-address@gmail.com", "123", time.Now())
+		if err != nil {
+			t.Fatalf("should not have an error while creating the customer: %v", err)
+		}
+
+		a, err := repo.GetAddress(context.Background(), *otherID, first.ID)
+		if err != nil || a != nil {
+			t.Fatalf("should not return the address, got: %+v, %v", a, err)
+		}
+		other := newAddress("Fresno", false)
+		other.ID = first.ID
+		updated, err := repo.UpdateAddress(context.Background(), *otherID, other)
+		if err != nil || updated != nil {
+			t.Fatalf("should not update the address, got: %+v, %v", updated, err)
+		}
+		deleted, err := repo.DeleteAddress(context.Background(), *otherID, first.ID)
+		if err != nil || deleted {
+			t.Fatalf("should not delete the address, got: %v, %v", deleted, err)
+		}
+	})
+
+	t.Run("orders keep a copy of their address", func(t *testing.T) {
+		shipping := &order.ShippingAddress{AddressID: second.ID, Recipient: second.Recipient, Line1: second.Line1, City: second.City,
+			Region: second.Region, Country: second.Country}
+		orderID, err := orderRepo.SaveOrder(context.Background(), *customerID, order.Order{
+			Currency: money.Currency, Status: order.StatusPaid, OrderDate: time.Now(), ShippingAddress: shipping,
+			Items: []order.OrderItem{{BookID: 1, Quantity: 1, Price: 1099}},
+		})
+		if err != nil {
+			t.Fatalf("should not have an error while saving the order: %v", err)
+		}
+
+		deleted, err := repo.DeleteAddress(context.Background(), *customerID, second.ID)
+		if err != nil || !deleted {
+			t.Fatalf("should delete the address, got: %v, %v", deleted, err)
+		}
+
+		o, err := orderRepo.GetOrderByID(context.Background(), *customerID, *orderID)
+		if err != nil || o == nil || o.ShippingAddress == nil || *o.ShippingAddress != *shipping {
+			t.Fatalf("the order should keep its address, got: %+v, %v", o, err)
+		}
+	})
+
+	t.Run("deleting the default address makes the oldest one the default", func(t *testing.T) {
+		deleted, err := repo.DeleteAddress(context.Background(), *customerID, first.ID)
+		if err != nil || !deleted {
+			t.Fatalf("should delete the address, got: %v, %v", deleted, err)
+		}
+
+		addresses, err := repo.ListAddresses(context.Background(), *customerID)
+		if err != nil || len(addresses) != 1 || !addresses[0].Default {
+			t.Fatalf("the remaining address should be the default one, got: %+v, %v", addresses, err)
+		}
+	})
+}
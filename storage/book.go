@@ -2,9 +2,13 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ap-pauloafonso/bookstore/book"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"strings"
 )
 
 type BookRepository struct {
@@ -15,8 +19,76 @@ func NewBookRepository(db *pgxpool.Pool) *BookRepository {
 	return &BookRepository{db}
 }
 
-func (r *BookRepository) GetAllBooks(ctx context.Context) ([]*book.Model, error) {
-	rows, err := r.db.Query(ctx, "SELECT id, title, author, price FROM books")
+// bookSortColumns maps the allowed sort options to their columns, so user input never reaches the query text
+var bookSortColumns = map[string]string{
+	book.SortID:     "id",
+	book.SortPrice:  "price",
+	book.SortTitle:  "title",
+	book.SortAuthor: "author",
+}
+
+// bookFilters builds the WHERE conditions shared by the listing and the count queries
+func bookFilters(query book.Query) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if query.Author != "" {
+		args = append(args, query.Author)
+		conditions = append(conditions, fmt.Sprintf("lower(author) = lower($%d)", len(args)))
+	}
+	if query.MinPrice != nil {
+		args = append(args, *query.MinPrice)
+		conditions = append(conditions, fmt.Sprintf("price >= $%d", len(args)))
+	}
+	if query.MaxPrice != nil {
+		args = append(args, *query.MaxPrice)
+		conditions = append(conditions, fmt.Sprintf("price <= $%d", len(args)))
+	}
+
+	return conditions, args
+}
+
+func (r *BookRepository) ListBooks(ctx context.Context, query book.Query) ([]*book.Model, error) {
+	column, ok := bookSortColumns[query.Sort]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort column: %s", query.Sort)
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	conditions, args := bookFilters(query)
+
+	// keyset pagination: continue right after the (sort value, id) of the last book of the previous page
+	if query.After != nil {
+		if column == "id" {
+			args = append(args, query.After.ID)
+			conditions = append(conditions, fmt.Sprintf("id %s $%d", comparison, len(args)))
+		} else {
+			cast := ""
+			if column == "price" {
+				cast = "::numeric"
+			}
+			args = append(args, query.After.Value, query.After.ID)
+			conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d%s, $%d)", column, comparison, len(args)-1, cast, len(args)))
+		}
+	}
+
+	sql := "SELECT id, title, author, price, stock, weight FROM books"
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if column == "id" {
+		sql += fmt.Sprintf(" ORDER BY id %s", direction)
+	} else {
+		sql += fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+	}
+	args = append(args, query.Limit)
+	sql += fmt.Sprintf(" LIMIT $%d", len(args))
+
+	rows, err := r.db.Query(ctx, sql, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching books: %w", err)
 	}
@@ -25,12 +97,207 @@ func (r *BookRepository) GetAllBooks(ctx context.Context) ([]*book.Model, error)
 	var books []*book.Model
 	for rows.Next() {
 		var b book.Model
-		err := rows.Scan(&b.ID, &b.Title, &b.Author, &b.Price)
+		err := rows.Scan(&b.ID, &b.Title, &b.Author, &b.Price, &b.Stock, &b.Weight)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, &b)
+	}
+
+	return books, rows.Err()
+}
+
+func (r *BookRepository) CountBooks(ctx context.Context, query book.Query) (int64, error) {
+	conditions, args := bookFilters(query)
+
+	sql := "SELECT count(*) FROM books"
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := r.db.QueryRow(ctx, sql, args...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("error counting books: %w", err)
+	}
+
+	return total, nil
+}
+
+func (r *BookRepository) SearchBooks(ctx context.Context, terms []string, limit int) ([]*book.Model, error) {
+	// every term is turned into a prefix match, and all of them must match
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+
+	query := `
+		SELECT id, title, author, price, stock, weight
+		FROM books, to_tsquery('english', $1) query
+		WHERE search_vector @@ query
+		ORDER BY ts_rank(search_vector, query) DESC, id
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, strings.Join(prefixes, " & "), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error searching books: %w", err)
+	}
+	defer rows.Close()
+
+	var books []*book.Model
+	for rows.Next() {
+		var b book.Model
+		err := rows.Scan(&b.ID, &b.Title, &b.Author, &b.Price, &b.Stock, &b.Weight)
 		if err != nil {
 			return nil, err
 		}
 		books = append(books, &b)
 	}
 
-	return books, nil
+	return books, rows.Err()
+}
+
+func (r *BookRepository) GetBookByID(ctx context.Context, id int64) (*book.Model, error) {
+	var b book.Model
+	err := r.db.QueryRow(ctx, "SELECT id, title, author, price, stock, weight FROM books WHERE id = $1", id).Scan(&b.ID, &b.Title, &b.Author, &b.Price, &b.Stock, &b.Weight)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching book: %w", err)
+	}
+
+	return &b, nil
+}
+
+func (r *BookRepository) GetBooksByIDs(ctx context.Context, ids []int64) ([]*book.Model, error) {
+	rows, err := r.db.Query(ctx, "SELECT id, title, author, price, stock, weight FROM books WHERE id = ANY($1)", ids)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching books: %w", err)
+	}
+	defer rows.Close()
+
+	var books []*book.Model
+	for rows.Next() {
+		var b book.Model
+		err := rows.Scan(&b.ID, &b.Title, &b.Author, &b.Price, &b.Stock, &b.Weight)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, &b)
+	}
+
+	return books, rows.Err()
+}
+
+func (r *BookRepository) SaveBook(ctx context.Context, title, author string, price money.Amount) (*int64, error) {
+	var id int64
+	err := r.db.QueryRow(ctx, "INSERT INTO books (title, author, price) VALUES ($1, $2, $3) RETURNING id", title, author, price).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("error saving book: %w", err)
+	}
+
+	return &id, nil
+}
+
+func (r *BookRepository) UpdateBook(ctx context.Context, id int64, title, author string, price money.Amount) (*book.Model, error) {
+	var b book.Model
+	err := r.db.QueryRow(ctx, "UPDATE books SET title = $2, author = $3, price = $4 WHERE id = $1 RETURNING id, title, author, price, stock, weight", id, title, author, price).
+		Scan(&b.ID, &b.Title, &b.Author, &b.Price, &b.Stock, &b.Weight)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error updating book: %w", err)
+	}
+
+	return &b, nil
+}
+
+func (r *BookRepository) PatchBook(ctx context.Context, id int64, patch book.Patch) (*book.Model, error) {
+	query := `
+		UPDATE books
+		SET title  = COALESCE($2, title),
+		    author = COALESCE($3, author),
+		    price  = COALESCE($4, price),
+		    weight = COALESCE($5, weight)
+		WHERE id = $1
+		RETURNING id, title, author, price, stock, weight
+	`
+
+	var b book.Model
+	err := r.db.QueryRow(ctx, query, id, patch.Title, patch.Author, patch.Price, patch.Weight).Scan(&b.ID, &b.Title, &b.Author, &b.Price, &b.Stock, &b.Weight)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error patching book: %w", err)
+	}
+
+	return &b, nil
+}
+
+func (r *BookRepository) DeleteBook(ctx context.Context, id int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, "DELETE FROM books WHERE id = $1", id)
+	if err != nil {
+		return false, fmt.Errorf("error deleting book: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *BookRepository) AdjustStock(ctx context.Context, movement book.StockMovement) (*book.Model, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var b book.Model
+	err = tx.QueryRow(ctx, "UPDATE books SET stock = stock + $2 WHERE id = $1 AND stock + $2 >= 0 RETURNING id, title, author, price, stock, weight", movement.BookID, movement.Quantity).
+		Scan(&b.ID, &b.Title, &b.Author, &b.Price, &b.Stock, &b.Weight)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error updating stock: %w", err)
+	}
+
+	movementInsertSQL := "INSERT INTO stock_movements (book_id, quantity, reason, order_id, created_by, created_at) VALUES ($1, $2, $3, $4, $5, $6)"
+	if _, err := tx.Exec(ctx, movementInsertSQL, movement.BookID, movement.Quantity, movement.Reason, movement.OrderID, movement.CreatedBy, movement.CreatedAt); err != nil {
+		return nil, fmt.Errorf("error saving stock movement: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return &b, nil
+}
+
+func (r *BookRepository) GetStockMovements(ctx context.Context, bookID int64, limit int) ([]book.StockMovement, error) {
+	query := `
+		SELECT id, book_id, quantity, reason, order_id, created_by, created_at
+		FROM stock_movements
+		WHERE book_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, bookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching stock movements: %w", err)
+	}
+	defer rows.Close()
+
+	var movements []book.StockMovement
+	for rows.Next() {
+		var m book.StockMovement
+		if err := rows.Scan(&m.ID, &m.BookID, &m.Quantity, &m.Reason, &m.OrderID, &m.CreatedBy, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+
+	return movements, rows.Err()
 }
@@ -3,6 +3,9 @@ package storage
 import (
 	"context"
 	"fmt"
+	"github.com/ap-pauloafonso/bookstore/book"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/utils"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -56,7 +59,7 @@ func TestBookRepository(t *testing.T) {
 
 	t.Run("Get books fails because the table doesn't exist yet", func(t *testing.T) {
 
-		_, err := repo.GetAllBooks(context.Background())
+		_, err := repo.ListBooks(context.Background(), book.Query{Limit: 10, Sort: book.SortID})
 		if err == nil {
 			t.Fatalf("get all books fails because there no table yet")
 		}
@@ -67,10 +70,261 @@ func TestBookRepository(t *testing.T) {
 
 	t.Run("Get books works", func(t *testing.T) {
 
-		_, err := repo.GetAllBooks(context.Background())
+		books, err := repo.ListBooks(context.Background(), book.Query{Limit: 10, Sort: book.SortID})
 		if err != nil {
 			t.Fatalf("should not return error")
 		}
 
+		if len(books) != 10 {
+			t.Fatalf("should return the 10 books from the migration, got: %d", len(books))
+		}
+
+	})
+
+	t.Run("List books follows sorting, filters and cursor", func(t *testing.T) {
+		minPrice := money.Amount(1000)
+		query := book.Query{Limit: 3, Sort: book.SortPrice, Desc: true, Author: "j.k. rowling", MinPrice: &minPrice}
+
+		firstPage, err := repo.ListBooks(context.Background(), query)
+		if err != nil {
+			t.Fatalf("should not return error: %v", err)
+		}
+		if len(firstPage) != 3 || firstPage[0].Price != 1699 || firstPage[2].Price != 1499 {
+			t.Fatalf("unexpected first page: %+v", firstPage)
+		}
+
+		last := firstPage[len(firstPage)-1]
+		query.After = &book.Cursor{Value: "14.99", ID: last.ID}
+		secondPage, err := repo.ListBooks(context.Background(), query)
+		if err != nil {
+			t.Fatalf("should not return error: %v", err)
+		}
+		if len(secondPage) != 3 || secondPage[0].Price != 1399 {
+			t.Fatalf("unexpected second page: %+v", secondPage)
+		}
+
+		total, err := repo.CountBooks(context.Background(), query)
+		if err != nil {
+			t.Fatalf("should not return error: %v", err)
+		}
+		if total != 7 {
+			t.Fatalf("7 books cost at least 10, got: %d", total)
+		}
+	})
+
+	t.Run("Search books matches prefixes and ranks title matches first", func(t *testing.T) {
+		books, err := repo.SearchBooks(context.Background(), []string{"pott", "azk"}, 10)
+		if err != nil {
+			t.Fatalf("should not return error: %v", err)
+		}
+		if len(books) != 1 || books[0].Title != "Harry Potter and the Prisoner of Azkaban" {
+			t.Fatalf("should only find the prisoner of azkaban, got: %+v", books)
+		}
+
+		books, err = repo.SearchBooks(context.Background(), []string{"rowling"}, 3)
+		if err != nil {
+			t.Fatalf("should not return error: %v", err)
+		}
+		if len(books) != 3 {
+			t.Fatalf("should respect the limit, got: %d", len(books))
+		}
+
+		books, err = repo.SearchBooks(context.Background(), []string{"tolkien"}, 10)
+		if err != nil || len(books) != 0 {
+			t.Fatalf("should not find any book, got: %+v, %v", books, err)
+		}
+	})
+
+	var createdID int64
+	t.Run("Save book works", func(t *testing.T) {
+		id, err := repo.SaveBook(context.Background(), "The Hobbit", "J.R.R. Tolkien", 1999)
+		if err != nil {
+			t.Fatalf("should not return error while saving a book: %v", err)
+		}
+		createdID = *id
+	})
+
+	t.Run("Get book by id works", func(t *testing.T) {
+		b, err := repo.GetBookByID(context.Background(), createdID)
+		if err != nil {
+			t.Fatalf("should not return error while fetching a book: %v", err)
+		}
+		if b == nil || b.ID != createdID || b.Title != "The Hobbit" {
+			t.Fatalf("should return the saved book, got: %+v", b)
+		}
+	})
+
+	t.Run("Get book by id returns nil when the book doesn't exist", func(t *testing.T) {
+		b, err := repo.GetBookByID(context.Background(), 999999)
+		if err != nil || b != nil {
+			t.Fatalf("should return nil book and nil error, got: %+v, %v", b, err)
+		}
+	})
+
+	t.Run("Get books by ids only returns the requested books", func(t *testing.T) {
+		books, err := repo.GetBooksByIDs(context.Background(), []int64{1, createdID, 999999})
+		if err != nil {
+			t.Fatalf("should not return error while fetching books: %v", err)
+		}
+		if len(books) != 2 {
+			t.Fatalf("should return only the 2 existing books, got: %d", len(books))
+		}
+	})
+
+	t.Run("Update book works", func(t *testing.T) {
+		b, err := repo.UpdateBook(context.Background(), createdID, "The Hobbit (Illustrated)", "J.R.R. Tolkien", 2999)
+		if err != nil {
+			t.Fatalf("should not return error while updating a book: %v", err)
+		}
+		if b == nil || b.Title != "The Hobbit (Illustrated)" || b.Price != 2999 {
+			t.Fatalf("book should have been updated, got: %+v", b)
+		}
+	})
+
+	t.Run("Update book returns nil when the book doesn't exist", func(t *testing.T) {
+		b, err := repo.UpdateBook(context.Background(), 999999, "title", "author", 1)
+		if err != nil || b != nil {
+			t.Fatalf("should return nil book and nil error, got: %+v, %v", b, err)
+		}
+	})
+
+	t.Run("Patch book only changes the provided fields", func(t *testing.T) {
+		price := money.Amount(2450)
+		b, err := repo.PatchBook(context.Background(), createdID, book.Patch{Price: &price})
+		if err != nil {
+			t.Fatalf("should not return error while patching a book: %v", err)
+		}
+		if b == nil || b.Title != "The Hobbit (Illustrated)" || b.Price != price || b.Weight != book.DefaultWeight {
+			t.Fatalf("only the price should have been updated, got: %+v", b)
+		}
+
+		weight := 850
+		b, err = repo.PatchBook(context.Background(), createdID, book.Patch{Weight: &weight})
+		if err != nil || b == nil || b.Weight != weight || b.Price != price {
+			t.Fatalf("only the weight should have been updated, got: %+v, %v", b, err)
+		}
+	})
+
+	t.Run("Adjust stock works", func(t *testing.T) {
+		b, err := repo.AdjustStock(context.Background(), book.StockMovement{BookID: createdID, Quantity: 5, Reason: "restock", CreatedAt: time.Now()})
+		if err != nil {
+			t.Fatalf("should not return error while adjusting stock: %v", err)
+		}
+		if b == nil || b.Stock != 5 {
+			t.Fatalf("stock should be 5, got: %+v", b)
+		}
+
+		b, err = repo.AdjustStock(context.Background(), book.StockMovement{BookID: createdID, Quantity: -6, Reason: "damaged", CreatedAt: time.Now()})
+		if err != nil || b != nil {
+			t.Fatalf("should not allow negative stock, got: %+v, %v", b, err)
+		}
+
+		movements, err := repo.GetStockMovements(context.Background(), createdID, 10)
+		if err != nil {
+			t.Fatalf("should not return error while fetching movements: %v", err)
+		}
+		if len(movements) != 1 || movements[0].Quantity != 5 || movements[0].Reason != "restock" {
+			t.Fatalf("only the applied adjustment should be recorded, got: %+v", movements)
+		}
+	})
+
+	t.Run("Delete book keeps the stock history", func(t *testing.T) {
+		_, err := repo.DeleteBook(context.Background(), createdID)
+		if !utils.IsForeignKeyViolation(err) {
+			t.Fatalf("a book with stock movements should not be deleted, got: %v", err)
+		}
+	})
+
+	t.Run("Delete book works", func(t *testing.T) {
+		id, err := repo.SaveBook(context.Background(), "to delete", "someone", 1000)
+		if err != nil {
+			t.Fatalf("should not return error while saving book: %v", err)
+		}
+
+		deleted, err := repo.DeleteBook(context.Background(), *id)
+		if err != nil || !deleted {
+			t.Fatalf("book should have been deleted, got: %v, %v", deleted, err)
+		}
+
+		deleted, err = repo.DeleteBook(context.Background(), *id)
+		if err != nil || deleted {
+			t.Fatalf("deleting a missing book should return false, got: %v, %v", deleted, err)
+		}
 	})
 }
+
+func BenchmarkBookRepository_GetBooksByIDs(b *testing.B) {
+	if testing.Short() {
+		b.Skip("skipping benchmark in short mode.")
+	}
+
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:latest",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "test",
+			"POSTGRES_DB":       "MY_DB",
+		},
+		WaitingFor: wait.ForAll(wait.ForListeningPort("5432/tcp"), wait.ForLog("database system is ready to accept connections")),
+	}
+	postgresC, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		b.Fatalf("Failed to start PostgreSQL container: %v", err)
+	}
+	defer postgresC.Terminate(context.Background())
+
+	host, err := postgresC.Host(context.Background())
+	if err != nil {
+		b.Fatal(err)
+	}
+	port, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	time.Sleep(3 * time.Second) // a bit of delay to make sure that container is ready
+
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", host, port.Port())
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pool.Close()
+
+	if err := RunMigrations(dsn); err != nil {
+		b.Fatal(err)
+	}
+
+	repo := NewBookRepository(pool)
+	requested := []int64{1, 50, 500, 999}
+
+	// the catalog grows between the runs, the lookup time shouldn't grow with it
+	for _, catalogSize := range []int{1_000, 100_000, 1_000_000} {
+		query := `
+			INSERT INTO books (title, author, price)
+			SELECT 'title ' || n, 'author ' || n, 1000 FROM generate_series((SELECT COUNT(*) FROM books) + 1, $1) AS n
+		`
+		if _, err := pool.Exec(context.Background(), query, catalogSize); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := pool.Exec(context.Background(), "ANALYZE books"); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("catalog_%d", catalogSize), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				books, err := repo.GetBooksByIDs(context.Background(), requested)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(books) != len(requested) {
+					b.Fatalf("expected %d books, got %d", len(requested), len(books))
+				}
+			}
+		})
+	}
+}
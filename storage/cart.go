@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/cart"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type CartRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewCartRepository(db *pgxpool.Pool) *CartRepository {
+	return &CartRepository{db}
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+func getCartItems(ctx context.Context, q querier, customerID int64) ([]cart.Item, error) {
+	rows, err := q.Query(ctx, "SELECT book_id, quantity, added_at FROM cart_items WHERE customer_id = $1 ORDER BY added_at, book_id", customerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching cart items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []cart.Item
+	for rows.Next() {
+		var item cart.Item
+		if err := rows.Scan(&item.BookID, &item.Quantity, &item.AddedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (r *CartRepository) GetCartItems(ctx context.Context, customerID int64) ([]cart.Item, error) {
+	return getCartItems(ctx, r.db, customerID)
+}
+
+func (r *CartRepository) AddCartItem(ctx context.Context, customerID, bookID int64, quantity int, addedAt time.Time) (int, error) {
+	// touching the cart row waits for a checkout being stored, so the item goes to the next order instead of being lost
+	query := `
+		WITH c AS (
+			INSERT INTO carts (customer_id, updated_at) VALUES ($1, $4)
+			ON CONFLICT (customer_id) DO UPDATE SET updated_at = EXCLUDED.updated_at
+			RETURNING customer_id
+		)
+		INSERT INTO cart_items (customer_id, book_id, quantity, added_at)
+		SELECT customer_id, $2, $3, $4 FROM c
+		ON CONFLICT (customer_id, book_id) DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity
+		RETURNING quantity
+	`
+
+	var total int
+	if err := r.db.QueryRow(ctx, query, customerID, bookID, quantity, addedAt).Scan(&total); err != nil {
+		return 0, fmt.Errorf("error adding cart item: %w", err)
+	}
+
+	return total, nil
+}
+
+func (r *CartRepository) SetCartItemQuantity(ctx context.Context, customerID, bookID int64, quantity int) (bool, error) {
+	tag, err := r.db.Exec(ctx, "UPDATE cart_items SET quantity = $3 WHERE customer_id = $1 AND book_id = $2", customerID, bookID, quantity)
+	if err != nil {
+		return false, fmt.Errorf("error updating cart item: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *CartRepository) RemoveCartItem(ctx context.Context, customerID, bookID int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, "DELETE FROM cart_items WHERE customer_id = $1 AND book_id = $2", customerID, bookID)
+	if err != nil {
+		return false, fmt.Errorf("error removing cart item: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// emptyCart takes the items of an order out of the cart of the customer within the transaction storing the order,
+// failing with *order.CartChangedError if the cart doesn't have exactly those items anymore
+func emptyCart(ctx context.Context, tx pgx.Tx, customerID int64, items []order.OrderItem) error {
+	// lock the cart so the books added meanwhile wait for the order and go to the next one
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM carts WHERE customer_id = $1 FOR UPDATE", customerID); err != nil {
+		return fmt.Errorf("error locking cart: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, "DELETE FROM cart_items WHERE customer_id = $1 RETURNING book_id, quantity", customerID)
+	if err != nil {
+		return fmt.Errorf("error emptying cart: %w", err)
+	}
+	defer rows.Close()
+
+	inCart := make(map[int64]int)
+	for rows.Next() {
+		var bookID int64
+		var quantity int
+		if err := rows.Scan(&bookID, &quantity); err != nil {
+			return err
+		}
+		inCart[bookID] = quantity
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error emptying cart: %w", err)
+	}
+
+	for _, item := range items {
+		if inCart[item.BookID] != item.Quantity {
+			return &order.CartChangedError{BookID: item.BookID}
+		}
+		delete(inCart, item.BookID)
+	}
+	for bookID := range inCart {
+		return &order.CartChangedError{BookID: bookID}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"testing"
+	"time"
+)
+
+func TestCartRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	t.Parallel()
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:latest",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "test",
+			"POSTGRES_DB":       "MY_DB",
+		},
+		WaitingFor: wait.ForAll(wait.ForListeningPort("5432/tcp"), wait.ForLog("database system is ready to accept connections")),
+	}
+	postgresC, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start PostgreSQL container: %v", err)
+	}
+	defer postgresC.Terminate(context.Background())
+
+	host, err := postgresC.Host(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(3 * time.Second) // a bit of delay to make sure that container is ready
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", host, port.Port())
+
+	err = RunMigrations(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewCartRepository(pool)
+
+	customerID, err := NewCustomerRepository(pool).SaveCustomer(context.Background(), "cart@gmail.com", "123456", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("add items", func(t *testing.T) {
+		qty, err := repo.AddCartItem(context.Background(), *customerID, 1, 2, time.Now())
+		if err != nil || qty != 2 {
+			t.Fatalf("should add the book to the cart, got: %d, %v", qty, err)
+		}
+
+		qty, err = repo.AddCartItem(context.Background(), *customerID, 1, 3, time.Now())
+		if err != nil || qty != 5 {
+			t.Fatalf("should add to the quantity of a book already in the cart, got: %d, %v", qty, err)
+		}
+
+		if _, err := repo.AddCartItem(context.Background(), *customerID, 2, 1, time.Now()); err != nil {
+			t.Fatalf("should add another book to the cart: %v", err)
+		}
+
+		items, err := repo.GetCartItems(context.Background(), *customerID)
+		if err != nil || len(items) != 2 || items[0].BookID != 1 || items[0].Quantity != 5 || items[1].BookID != 2 {
+			t.Fatalf("should return the cart items, oldest first, got: %+v, %v", items, err)
+		}
+	})
+
+	t.Run("add an unknown book fails", func(t *testing.T) {
+		if _, err := repo.AddCartItem(context.Background(), *customerID, 999999, 1, time.Now()); err == nil {
+			t.Fatalf("should fail to add a book that doesn't exist")
+		}
+	})
+
+	t.Run("update and remove items", func(t *testing.T) {
+		ok, err := repo.SetCartItemQuantity(context.Background(), *customerID, 2, 4)
+		if err != nil || !ok {
+			t.Fatalf("should update the quantity, got: %v, %v", ok, err)
+		}
+		ok, err = repo.SetCartItemQuantity(context.Background(), *customerID, 3, 4)
+		if err != nil || ok {
+			t.Fatalf("should report a book that isn't in the cart, got: %v, %v", ok, err)
+		}
+
+		ok, err = repo.RemoveCartItem(context.Background(), *customerID, 2)
+		if err != nil || !ok {
+			t.Fatalf("should remove the book, got: %v, %v", ok, err)
+		}
+		ok, err = repo.RemoveCartItem(context.Background(), *customerID, 2)
+		if err != nil || ok {
+			t.Fatalf("should report a book that isn't in the cart, got: %v, %v", ok, err)
+		}
+	})
+
+	orderRepo := NewOrderRepository(pool)
+
+	t.Run("an order from a cart that changed fails and keeps the cart", func(t *testing.T) {
+		o := pendingOrder([]order.OrderItem{{BookID: 1, Quantity: 4, Price: 1000}})
+		o.FromCart = true
+		_, err := orderRepo.SaveOrder(context.Background(), *customerID, o)
+		var cartErr *order.CartChangedError
+		if !errors.As(err, &cartErr) || cartErr.BookID != 1 {
+			t.Fatalf("should fail with a cart changed error, got: %v", err)
+		}
+
+		items, err := repo.GetCartItems(context.Background(), *customerID)
+		if err != nil || len(items) != 1 || items[0].Quantity != 5 {
+			t.Fatalf("should keep the cart items, got: %+v, %v", items, err)
+		}
+	})
+
+	t.Run("an order from the cart empties it", func(t *testing.T) {
+		o := pendingOrder([]order.OrderItem{{BookID: 1, Quantity: 5, Price: 1000}})
+		o.FromCart = true
+		if _, err := orderRepo.SaveOrder(context.Background(), *customerID, o); err != nil {
+			t.Fatalf("should store the order: %v", err)
+		}
+
+		items, err := repo.GetCartItems(context.Background(), *customerID)
+		if err != nil || len(items) != 0 {
+			t.Fatalf("should empty the cart, got: %+v, %v", items, err)
+		}
+	})
+}
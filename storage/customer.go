@@ -2,8 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"time"
 )
@@ -28,10 +30,47 @@ func (c *CustomerRepository) SaveCustomer(ctx context.Context, email, password s
 
 func (c *CustomerRepository) GetCustomer(ctx context.Context, email string) (*customer.Model, error) {
 	var u customer.Model
-	err := c.db.QueryRow(ctx, "SELECT id, email, password FROM customers WHERE email = $1", email).Scan(&u.Id, &u.Email, &u.Password)
+	err := c.db.QueryRow(ctx, "SELECT id, email, password, roles, email_verified FROM customers WHERE email = $1", email).Scan(&u.Id, &u.Email, &u.Password, &u.Roles, &u.EmailVerified)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching customer: %w", err)
 	}
 
 	return &u, nil
 }
+
+func (c *CustomerRepository) GetCustomerByID(ctx context.Context, id int64) (*customer.Model, error) {
+	var u customer.Model
+	err := c.db.QueryRow(ctx, "SELECT id, email, password, roles, email_verified FROM customers WHERE id = $1", id).Scan(&u.Id, &u.Email, &u.Password, &u.Roles, &u.EmailVerified)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching customer: %w", err)
+	}
+
+	return &u, nil
+}
+
+// resetsDeleted removes the password reset links of the customer $1 that weren't used, along with the update that
+// follows it, so they can't undo the change
+const resetsDeleted = "WITH resets AS (DELETE FROM password_resets WHERE customer_id = $1 AND used_at IS NULL) "
+
+func (c *CustomerRepository) UpdatePassword(ctx context.Context, id int64, password string) error {
+	_, err := c.db.Exec(ctx, resetsDeleted+"UPDATE customers SET password = $2 WHERE id = $1", id, password)
+	if err != nil {
+		return fmt.Errorf("error updating password: %w", err)
+	}
+
+	return nil
+}
+
+func (c *CustomerRepository) UpdateEmail(ctx context.Context, id int64, email string) error {
+	// the new email wasn't verified, and its first verification email isn't throttled by the ones of the old email
+	query := resetsDeleted + "UPDATE customers SET email = $2, email_verified = FALSE, verification_sent_at = NULL WHERE id = $1"
+	_, err := c.db.Exec(ctx, query, id, email)
+	if err != nil {
+		return fmt.Errorf("error updating email: %w", err)
+	}
+
+	return nil
+}
@@ -3,9 +3,12 @@ package storage
 import (
 	"context"
 	"fmt"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/ap-pauloafonso/bookstore/utils"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"strings"
 	"testing"
 	"time"
 )
@@ -90,6 +93,27 @@ func TestCustomerRepository(t *testing.T) {
 		if *customerSave != customerGET.Id {
 			t.Fatalf("customer id from query should be the same the inserted id")
 		}
+
+		if len(customerGET.Roles) != 1 || customerGET.Roles[0] != "customer" {
+			t.Fatalf("new customers should only have the customer role, got: %v", customerGET.Roles)
+		}
+	})
+
+	t.Run("GetCustomerByID", func(t *testing.T) {
+		id, err := repo.SaveCustomer(context.Background(), "test3@gmail.com", "123456", time.Now())
+		if err != nil {
+			t.Fatalf("should not have error while saving new customer")
+		}
+
+		customerGET, err := repo.GetCustomerByID(context.Background(), *id)
+		if err != nil || customerGET == nil || customerGET.Email != "test3@gmail.com" {
+			t.Fatalf("should find the customer by its id, got: %v, %v", customerGET, err)
+		}
+
+		customerGET, err = repo.GetCustomerByID(context.Background(), 999999)
+		if err != nil || customerGET != nil {
+			t.Fatalf("should return nil for an unknown id, got: %v, %v", customerGET, err)
+		}
 	})
 
 	t.Run("Getcustomer fails because no customer is found", func(t *testing.T) {
@@ -100,4 +124,98 @@ func TestCustomerRepository(t *testing.T) {
 
 	})
 
+	t.Run("UpdatePassword", func(t *testing.T) {
+		id, err := repo.SaveCustomer(context.Background(), "test4@gmail.com", "123456", time.Now())
+		if err != nil {
+			t.Fatalf("should not have error while saving new customer")
+		}
+
+		if err := repo.UpdatePassword(context.Background(), *id, "654321"); err != nil {
+			t.Fatalf("should not have error while updating the password: %v", err)
+		}
+
+		customerGET, err := repo.GetCustomerByID(context.Background(), *id)
+		if err != nil || customerGET.Password != "654321" {
+			t.Fatalf("should have the new password, got: %v, %v", customerGET, err)
+		}
+	})
+
+	t.Run("UpdateEmail", func(t *testing.T) {
+		id, err := repo.SaveCustomer(context.Background(), "test5@gmail.com", "123456", time.Now())
+		if err != nil {
+			t.Fatalf("should not have error while saving new customer")
+		}
+		if _, err := pool.Exec(context.Background(), "UPDATE customers SET email_verified = TRUE WHERE id = $1", *id); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.UpdateEmail(context.Background(), *id, "test6@gmail.com"); err != nil {
+			t.Fatalf("should not have error while updating the email: %v", err)
+		}
+
+		customerGET, err := repo.GetCustomerByID(context.Background(), *id)
+		if err != nil || customerGET.Email != "test6@gmail.com" || customerGET.EmailVerified {
+			t.Fatalf("should have the new unverified email, got: %v, %v", customerGET, err)
+		}
+
+		err = repo.UpdateEmail(context.Background(), *id, "test3@gmail.com")
+		if !utils.IsUniqueViolation(err) {
+			t.Fatalf("should not take the email of another customer, got: %v", err)
+		}
+	})
+
+	t.Run("UpdatePassword and UpdateEmail delete the unused password resets", func(t *testing.T) {
+		resets := NewPasswordResetRepository(pool)
+		id, err := repo.SaveCustomer(context.Background(), "test7@gmail.com", "123456", time.Now())
+		if err != nil {
+			t.Fatalf("should not have error while saving new customer")
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		saveReset := func(c string) string {
+			hash := strings.Repeat(c, 64)
+			reset := customer.PasswordReset{TokenHash: hash, CustomerID: *id, ExpiresAt: now.Add(time.Hour), CreatedAt: now}
+			if saved, err := resets.SaveReset(context.Background(), reset, now); err != nil || !saved {
+				t.Fatalf("should save the reset, got: %v, %v", saved, err)
+			}
+			return hash
+		}
+		countResets := func() int {
+			var count int
+			if err := pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM password_resets WHERE customer_id = $1", *id).Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			return count
+		}
+
+		// a used reset is kept
+		if _, err := resets.ResetPassword(context.Background(), saveReset("a"), "654321", now); err != nil {
+			t.Fatal(err)
+		}
+
+		hash := saveReset("b")
+		if err := repo.UpdatePassword(context.Background(), *id, "111111"); err != nil {
+			t.Fatalf("should not have error while updating the password: %v", err)
+		}
+		if used, err := resets.ResetPassword(context.Background(), hash, "222222", now); err != nil || used != nil || countResets() != 1 {
+			t.Fatalf("the reset sent before the password change shouldn't work, got: %v, %v", used, err)
+		}
+
+		hash = saveReset("c")
+		if err := repo.UpdateEmail(context.Background(), *id, "test8@gmail.com"); err != nil {
+			t.Fatalf("should not have error while updating the email: %v", err)
+		}
+		if used, err := resets.ResetPassword(context.Background(), hash, "222222", now); err != nil || used != nil || countResets() != 1 {
+			t.Fatalf("the reset sent to the old email shouldn't work, got: %v, %v", used, err)
+		}
+
+		// a failed update keeps the resets
+		saveReset("d")
+		if err := repo.UpdateEmail(context.Background(), *id, "test3@gmail.com"); !utils.IsUniqueViolation(err) {
+			t.Fatalf("should not take the email of another customer, got: %v", err)
+		}
+		if countResets() != 2 {
+			t.Fatal("the resets should be kept when the email isn't changed")
+		}
+	})
 }
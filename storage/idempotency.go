@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/idempotency"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type IdempotencyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewIdempotencyRepository(db *pgxpool.Pool) *IdempotencyRepository {
+	return &IdempotencyRepository{db}
+}
+
+func (r *IdempotencyRepository) ClaimKey(ctx context.Context, record idempotency.Record, expiredBefore time.Time) (bool, error) {
+	// a key used before expiredBefore is taken over as if it was new
+	query := `
+		INSERT INTO idempotency_keys (customer_id, route, key, request_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (customer_id, route, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = NULL, response = NULL, created_at = EXCLUDED.created_at
+		WHERE idempotency_keys.created_at < $6
+	`
+
+	tag, err := r.db.Exec(ctx, query, record.CustomerID, record.Route, record.Key, record.RequestHash, record.CreatedAt, expiredBefore)
+	if err != nil {
+		return false, fmt.Errorf("error claiming idempotency key: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *IdempotencyRepository) GetKey(ctx context.Context, customerID int64, route, key string) (*idempotency.Record, error) {
+	query := `
+		SELECT customer_id, route, key, request_hash, status_code, response, created_at
+		FROM idempotency_keys
+		WHERE customer_id = $1 AND route = $2 AND key = $3
+	`
+
+	var record idempotency.Record
+	var statusCode *int
+	err := r.db.QueryRow(ctx, query, customerID, route, key).
+		Scan(&record.CustomerID, &record.Route, &record.Key, &record.RequestHash, &statusCode, &record.Response, &record.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching idempotency key: %w", err)
+	}
+	if statusCode != nil {
+		record.StatusCode = *statusCode
+	}
+
+	return &record, nil
+}
+
+func (r *IdempotencyRepository) CompleteKey(ctx context.Context, customerID int64, route, key string, statusCode int, response []byte) error {
+	_, err := r.db.Exec(ctx, "UPDATE idempotency_keys SET status_code = $4, response = $5 WHERE customer_id = $1 AND route = $2 AND key = $3", customerID, route, key, statusCode, response)
+	if err != nil {
+		return fmt.Errorf("error completing idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (r *IdempotencyRepository) ReleaseKey(ctx context.Context, customerID int64, route, key string) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM idempotency_keys WHERE customer_id = $1 AND route = $2 AND key = $3", customerID, route, key)
+	if err != nil {
+		return fmt.Errorf("error releasing idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (r *IdempotencyRepository) DeleteExpiredKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, "DELETE FROM idempotency_keys WHERE created_at < $1", expiredBefore)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired idempotency keys: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/idempotency"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	t.Parallel()
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:latest",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "test",
+			"POSTGRES_DB":       "MY_DB",
+		},
+		WaitingFor: wait.ForAll(wait.ForListeningPort("5432/tcp"), wait.ForLog("database system is ready to accept connections")),
+	}
+	postgresC, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start PostgreSQL container: %v", err)
+	}
+	defer postgresC.Terminate(context.Background())
+
+	host, err := postgresC.Host(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(3 * time.Second) // a bit of delay to make sure that container is ready
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", host, port.Port())
+
+	err = RunMigrations(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewIdempotencyRepository(pool)
+
+	customerID, err := NewCustomerRepository(pool).SaveCustomer(context.Background(), "idempotency@gmail.com", "123456", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := idempotency.Record{Key: "key-1", CustomerID: *customerID, Route: "POST /api/orders", RequestHash: strings.Repeat("a", 64), CreatedAt: time.Now()}
+
+	t.Run("claim a new key", func(t *testing.T) {
+		claimed, err := repo.ClaimKey(context.Background(), record, time.Now().Add(-time.Hour))
+		if err != nil || !claimed {
+			t.Fatalf("should claim a new key, got: %v, %v", claimed, err)
+		}
+
+		claimed, err = repo.ClaimKey(context.Background(), record, time.Now().Add(-time.Hour))
+		if err != nil || claimed {
+			t.Fatalf("should not claim a key that is already in use, got: %v, %v", claimed, err)
+		}
+
+		r, err := repo.GetKey(context.Background(), *customerID, record.Route, "key-1")
+		if err != nil || r == nil || r.RequestHash != record.RequestHash || r.Response != nil {
+			t.Fatalf("should return the claimed key without a response, got: %+v, %v", r, err)
+		}
+	})
+
+	t.Run("claim the same key on another route", func(t *testing.T) {
+		checkout := record
+		checkout.Route = "POST /api/cart/checkout"
+		claimed, err := repo.ClaimKey(context.Background(), checkout, time.Now().Add(-time.Hour))
+		if err != nil || !claimed {
+			t.Fatalf("should claim a key that is only in use on another route, got: %v, %v", claimed, err)
+		}
+
+		if err := repo.ReleaseKey(context.Background(), *customerID, checkout.Route, "key-1"); err != nil {
+			t.Fatal(err)
+		}
+		r, err := repo.GetKey(context.Background(), *customerID, record.Route, "key-1")
+		if err != nil || r == nil {
+			t.Fatalf("releasing the key on another route should keep this one, got: %+v, %v", r, err)
+		}
+	})
+
+	t.Run("complete a key", func(t *testing.T) {
+		err := repo.CompleteKey(context.Background(), *customerID, record.Route, "key-1", 200, []byte(`{"total":10,"id":7}`))
+		if err != nil {
+			t.Fatalf("should not have an error while completing the key: %v", err)
+		}
+
+		r, err := repo.GetKey(context.Background(), *customerID, record.Route, "key-1")
+		if err != nil || r == nil || r.StatusCode != 200 || string(r.Response) != `{"total":10,"id":7}` {
+			t.Fatalf("should return the stored response, got: %+v, %v", r, err)
+		}
+	})
+
+	t.Run("claim an expired key", func(t *testing.T) {
+		renewed := record
+		renewed.RequestHash = strings.Repeat("b", 64)
+		renewed.CreatedAt = time.Now().Add(time.Minute)
+		claimed, err := repo.ClaimKey(context.Background(), renewed, time.Now().Add(time.Second))
+		if err != nil || !claimed {
+			t.Fatalf("should take over an expired key, got: %v, %v", claimed, err)
+		}
+
+		r, err := repo.GetKey(context.Background(), *customerID, record.Route, "key-1")
+		if err != nil || r == nil || r.RequestHash != renewed.RequestHash || r.Response != nil {
+			t.Fatalf("should forget the previous request, got: %+v, %v", r, err)
+		}
+	})
+
+	t.Run("release a key", func(t *testing.T) {
+		if err := repo.ReleaseKey(context.Background(), *customerID, record.Route, "key-1"); err != nil {
+			t.Fatalf("should not have an error while releasing the key: %v", err)
+		}
+
+		r, err := repo.GetKey(context.Background(), *customerID, record.Route, "key-1")
+		if err != nil || r != nil {
+			t.Fatalf("should return nil for a released key, got: %+v, %v", r, err)
+		}
+	})
+
+	t.Run("delete expired keys", func(t *testing.T) {
+		old := record
+		old.Key = "old"
+		old.CreatedAt = time.Now().Add(-48 * time.Hour)
+		if _, err := repo.ClaimKey(context.Background(), old, time.Now().Add(-72*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.ClaimKey(context.Background(), record, time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := repo.DeleteExpiredKeys(context.Background(), time.Now().Add(-24*time.Hour))
+		if err != nil || n != 1 {
+			t.Fatalf("should delete only the expired key, got: %d, %v", n, err)
+		}
+
+		r, err := repo.GetKey(context.Background(), *customerID, record.Route, "key-1")
+		if err != nil || r == nil {
+			t.Fatalf("should keep the key that didn't expire, got: %+v, %v", r, err)
+		}
+	})
+}
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE customers ADD COLUMN roles TEXT[] NOT NULL DEFAULT '{customer}';
+
+-- +goose Down
+ALTER TABLE customers DROP COLUMN IF EXISTS roles;
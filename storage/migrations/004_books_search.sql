@@ -0,0 +1,12 @@
+-- +goose Up
+-- title matches weigh more than author matches, the description should be added here with weight 'C' once books have one
+ALTER TABLE books ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(author, '')), 'B')
+) STORED;
+
+CREATE INDEX books_search_vector_idx ON books USING GIN (search_vector);
+
+-- +goose Down
+DROP INDEX IF EXISTS books_search_vector_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE books ADD COLUMN stock INT NOT NULL DEFAULT 0 CHECK (stock >= 0);
+
+-- the books of the catalog were sold without any limit until now, so they all start with some units available
+-- instead of becoming unorderable, their real stock is set through the stock adjustments afterwards
+UPDATE books SET stock = 100;
+
+CREATE TABLE stock_movements (
+    id SERIAL PRIMARY KEY,
+    -- the movements are the audit trail of the stock, a book that has any can't be deleted
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE RESTRICT,
+    quantity INT NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    order_id INT REFERENCES orders(id),
+    created_by INT REFERENCES customers(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX stock_movements_book_id_idx ON stock_movements (book_id, id);
+
+-- +goose Down
+DROP TABLE IF EXISTS stock_movements;
+ALTER TABLE books DROP COLUMN IF EXISTS stock;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- amounts are exact DECIMAL(10, 2) values in the currency the order was placed in
+ALTER TABLE orders ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+
+-- +goose Down
+ALTER TABLE orders DROP COLUMN IF EXISTS currency;
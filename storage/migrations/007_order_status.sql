@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE orders ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'paid', 'shipped', 'delivered', 'cancelled', 'refunded'));
+
+CREATE TABLE order_status_history (
+    id SERIAL PRIMARY KEY,
+    order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    from_status VARCHAR(16),
+    to_status VARCHAR(16) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    changed_by INT REFERENCES customers(id),
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX order_status_history_order_id_idx ON order_status_history (order_id, id);
+
+-- the existing orders were never anything but created, so their history starts as pending
+INSERT INTO order_status_history (order_id, to_status, changed_at)
+SELECT id, 'pending', create_id FROM orders;
+
+-- +goose Down
+DROP TABLE IF EXISTS order_status_history;
+ALTER TABLE orders DROP COLUMN IF EXISTS status;
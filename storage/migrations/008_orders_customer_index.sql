@@ -0,0 +1,6 @@
+-- +goose Up
+-- the order history is listed per customer, newest orders first
+CREATE INDEX orders_customer_id_idx ON orders (customer_id, id DESC);
+
+-- +goose Down
+DROP INDEX IF EXISTS orders_customer_id_idx;
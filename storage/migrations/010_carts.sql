@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE carts (
+    customer_id INT PRIMARY KEY REFERENCES customers(id) ON DELETE CASCADE,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE cart_items (
+    customer_id INT NOT NULL REFERENCES carts(customer_id) ON DELETE CASCADE,
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (customer_id, book_id)
+);
+
+CREATE INDEX cart_items_book_id_idx ON cart_items (book_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS cart_items;
+DROP TABLE IF EXISTS carts;
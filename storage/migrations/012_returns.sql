@@ -0,0 +1,41 @@
+-- +goose Up
+CREATE TABLE returns (
+    id SERIAL PRIMARY KEY,
+    order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    customer_id INT NOT NULL REFERENCES customers(id),
+    status VARCHAR(16) NOT NULL CHECK (status IN ('requested', 'approved', 'rejected', 'refunded')),
+    reason VARCHAR(255) NOT NULL,
+    refund_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    currency CHAR(3) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX returns_order_id_idx ON returns (order_id, id);
+CREATE INDEX returns_status_idx ON returns (status, id);
+
+-- the unit price is copied from orderitems so the refund is always computed from what was paid
+CREATE TABLE return_items (
+    return_id INT NOT NULL REFERENCES returns(id) ON DELETE CASCADE,
+    book_id INT NOT NULL REFERENCES books(id),
+    quantity INT NOT NULL CHECK (quantity > 0),
+    price DECIMAL(10, 2) NOT NULL,
+    PRIMARY KEY (return_id, book_id)
+);
+
+CREATE TABLE return_events (
+    id SERIAL PRIMARY KEY,
+    return_id INT NOT NULL REFERENCES returns(id) ON DELETE CASCADE,
+    from_status VARCHAR(16),
+    to_status VARCHAR(16) NOT NULL,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    actor_id INT REFERENCES customers(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX return_events_return_id_idx ON return_events (return_id, id);
+
+-- +goose Down
+DROP TABLE IF EXISTS return_events;
+DROP TABLE IF EXISTS return_items;
+DROP TABLE IF EXISTS returns;
@@ -0,0 +1,40 @@
+-- +goose Up
+-- an empty book_ids and authors means that the coupon covers every book
+CREATE TABLE coupons (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL UNIQUE,
+    type VARCHAR(16) NOT NULL CHECK (type IN ('percentage', 'fixed')),
+    percent INT NOT NULL DEFAULT 0 CHECK (percent BETWEEN 0 AND 100),
+    amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    min_order_value DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    max_uses INT NOT NULL DEFAULT 0,
+    max_uses_per_customer INT NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP,
+    ends_at TIMESTAMP,
+    book_ids INT[] NOT NULL DEFAULT '{}',
+    authors TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE coupon_redemptions (
+    coupon_id INT NOT NULL REFERENCES coupons(id),
+    order_id INT NOT NULL UNIQUE REFERENCES orders(id) ON DELETE CASCADE,
+    customer_id INT NOT NULL REFERENCES customers(id),
+    discount DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX coupon_redemptions_coupon_id_idx ON coupon_redemptions (coupon_id, customer_id);
+
+ALTER TABLE orders ADD COLUMN discount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN coupon_code VARCHAR(64);
+
+-- the part of the order discount that the returned books got, it isn't refunded
+ALTER TABLE returns ADD COLUMN discount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE returns DROP COLUMN IF EXISTS discount;
+ALTER TABLE orders DROP COLUMN IF EXISTS coupon_code;
+ALTER TABLE orders DROP COLUMN IF EXISTS discount;
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS coupons;
@@ -0,0 +1,28 @@
+-- +goose Up
+ALTER TABLE orders ADD COLUMN subtotal DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN tax DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN total DECIMAL(10, 2) NOT NULL DEFAULT 0;
+-- where the order is shipped to decides its tax, orders made before taxes existed have none
+ALTER TABLE orders ADD COLUMN tax_country CHAR(2);
+ALTER TABLE orders ADD COLUMN tax_region VARCHAR(64);
+
+UPDATE orders o SET subtotal = i.subtotal, total = GREATEST(i.subtotal - o.discount, 0)
+FROM (SELECT order_id, SUM(price * quantity) AS subtotal FROM orderitems GROUP BY order_id) i
+WHERE i.order_id = o.id;
+
+-- the rate is in hundredths of a percent, 7.25% is 725
+ALTER TABLE orderitems ADD COLUMN tax_rate INT NOT NULL DEFAULT 0;
+ALTER TABLE orderitems ADD COLUMN tax DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+-- the part of the tax of the order that the returned books paid, it's refunded with them
+ALTER TABLE returns ADD COLUMN tax DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE returns DROP COLUMN IF EXISTS tax;
+ALTER TABLE orderitems DROP COLUMN IF EXISTS tax;
+ALTER TABLE orderitems DROP COLUMN IF EXISTS tax_rate;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_region;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_country;
+ALTER TABLE orders DROP COLUMN IF EXISTS total;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax;
+ALTER TABLE orders DROP COLUMN IF EXISTS subtotal;
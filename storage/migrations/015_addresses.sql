@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE addresses (
+    id SERIAL PRIMARY KEY,
+    customer_id INT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    recipient VARCHAR(100) NOT NULL,
+    line1 VARCHAR(200) NOT NULL,
+    line2 VARCHAR(200) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL,
+    region VARCHAR(64) NOT NULL DEFAULT '',
+    postal_code VARCHAR(20) NOT NULL DEFAULT '',
+    country CHAR(2) NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX addresses_customer_id_idx ON addresses (customer_id, id);
+CREATE UNIQUE INDEX addresses_default_idx ON addresses (customer_id) WHERE is_default;
+
+-- a copy of the address the order is shipped to, editing or deleting the address doesn't change it
+ALTER TABLE orders ADD COLUMN shipping_address JSONB;
+
+-- +goose Down
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_address;
+DROP TABLE IF EXISTS addresses;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- the weight is in grams, books start with the weight of an average paperback
+ALTER TABLE books ADD COLUMN weight INT NOT NULL DEFAULT 400 CHECK (weight > 0);
+
+-- orders made before shipping existed don't have a method and were shipped for free
+ALTER TABLE orders ADD COLUMN shipping_method VARCHAR(16);
+ALTER TABLE orders ADD COLUMN shipping_cost DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_cost;
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_method;
+ALTER TABLE books DROP COLUMN IF EXISTS weight;
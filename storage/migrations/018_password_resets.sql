@@ -0,0 +1,14 @@
+-- +goose Up
+-- only the hash of the reset tokens is stored, used ones are kept as a record of the resets
+CREATE TABLE password_resets (
+    token_hash CHAR(64) PRIMARY KEY,
+    customer_id INT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX password_resets_customer_id_idx ON password_resets (customer_id) WHERE used_at IS NULL;
+
+-- +goose Down
+DROP TABLE IF EXISTS password_resets;
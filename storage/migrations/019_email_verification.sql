@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE customers ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+-- when the last verification email was sent, to throttle the resends
+ALTER TABLE customers ADD COLUMN verification_sent_at TIMESTAMP;
+
+-- the customers that registered before the verification existed keep ordering
+UPDATE customers SET email_verified = TRUE;
+
+-- +goose Down
+ALTER TABLE customers DROP COLUMN IF EXISTS verification_sent_at;
+ALTER TABLE customers DROP COLUMN IF EXISTS email_verified;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- the status an order is being moved to while its payment is settled, no other transition can start meanwhile
+ALTER TABLE orders ADD COLUMN next_status VARCHAR(16)
+    CHECK (next_status IN ('pending', 'paid', 'shipped', 'delivered', 'cancelled', 'refunded'));
+
+-- +goose Down
+ALTER TABLE orders DROP COLUMN IF EXISTS next_status;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- the responses are replayed byte for byte, JSONB would reorder their keys and drop their formatting
+ALTER TABLE idempotency_keys ALTER COLUMN response TYPE BYTEA USING convert_to(response::text, 'UTF8');
+
+-- +goose Down
+ALTER TABLE idempotency_keys ALTER COLUMN response TYPE JSONB USING convert_from(response, 'UTF8')::jsonb;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- set while the refund of an approved return is being made, so it can't be made twice
+ALTER TABLE returns ADD COLUMN refunding BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE returns DROP COLUMN IF EXISTS refunding;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- the last reset sent to a customer is looked up to throttle the emails, used ones included
+CREATE INDEX password_resets_customer_id_created_at_idx ON password_resets (customer_id, created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS password_resets_customer_id_created_at_idx;
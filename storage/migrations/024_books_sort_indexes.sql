@@ -0,0 +1,10 @@
+-- +goose Up
+-- the catalog is paginated by (sort column, id), these let every sort order be read straight from an index
+CREATE INDEX books_price_id_idx ON books (price, id);
+CREATE INDEX books_title_id_idx ON books (title, id);
+CREATE INDEX books_author_id_idx ON books (author, id);
+
+-- +goose Down
+DROP INDEX IF EXISTS books_author_id_idx;
+DROP INDEX IF EXISTS books_title_id_idx;
+DROP INDEX IF EXISTS books_price_id_idx;
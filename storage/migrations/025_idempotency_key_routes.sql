@@ -0,0 +1,15 @@
+-- +goose Up
+-- keys are scoped to the endpoint they were sent to, the ones stored before were nearly all sent to create orders
+ALTER TABLE idempotency_keys ADD COLUMN route VARCHAR(255) NOT NULL DEFAULT 'POST /api/orders';
+ALTER TABLE idempotency_keys ALTER COLUMN route DROP DEFAULT;
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (customer_id, route, key);
+
+-- +goose Down
+-- the same key used on several routes can't be kept under the old primary key, only the newest one survives
+DELETE FROM idempotency_keys k
+USING idempotency_keys newer
+WHERE k.customer_id = newer.customer_id AND k.key = newer.key AND (k.created_at, k.route) < (newer.created_at, newer.route);
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (customer_id, key);
+ALTER TABLE idempotency_keys DROP COLUMN route;
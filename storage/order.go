@@ -2,11 +2,15 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/shipping"
+	"github.com/ap-pauloafonso/bookstore/tax"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"sort"
-	"time"
+	"strings"
 )
 
 type OrderRepository struct {
@@ -17,7 +21,10 @@ func NewOrderRepository(db *pgxpool.Pool) *OrderRepository {
 	return &OrderRepository{db}
 }
 
-func (r *OrderRepository) SaveOrder(ctx context.Context, customerID int64, orderDate time.Time, items []order.OrderItem) (*int64, error) {
+func (r *OrderRepository) SaveOrder(ctx context.Context, customerID int64, o order.Order) (*int64, error) {
+	items := o.Items
+	orderDate := o.OrderDate
+
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error starting transaction: %w", err)
@@ -26,19 +33,74 @@ func (r *OrderRepository) SaveOrder(ctx context.Context, customerID int64, order
 
 	// Insert an order record
 	var orderID int64 // Change the data type to int64
-	orderInsertSQL := "INSERT INTO orders (customer_id, create_id) VALUES ($1, $2) RETURNING id"
-	if err := tx.QueryRow(ctx, orderInsertSQL, customerID, orderDate).Scan(&orderID); err != nil {
+	var taxCountry, taxRegion string
+	if o.Destination != nil {
+		taxCountry, taxRegion = o.Destination.Country, o.Destination.Region
+	}
+	orderInsertSQL := `INSERT INTO orders (customer_id, create_id, currency, status, discount, coupon_code, subtotal, tax, total, tax_country, tax_region,
+			shipping_address, shipping_method, shipping_cost)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9, NULLIF($10, ''), NULLIF($11, ''), $12, NULLIF($13, ''), $14) RETURNING id`
+	if err := tx.QueryRow(ctx, orderInsertSQL, customerID, orderDate, o.Currency, string(o.Status), o.Discount, o.CouponCode,
+		o.Subtotal, o.Tax, o.Total, taxCountry, taxRegion, o.ShippingAddress, string(o.ShippingMethod), o.ShippingCost).Scan(&orderID); err != nil {
 		return nil, fmt.Errorf("error creating order: %w", err)
 	}
 
+	if o.CouponCode != "" {
+		if err := redeemCoupon(ctx, tx, customerID, orderID, o); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.FromCart {
+		if err := emptyCart(ctx, tx, customerID, items); err != nil {
+			return nil, err
+		}
+	}
+
+	historyInsertSQL := "INSERT INTO order_status_history (order_id, to_status, changed_by, changed_at) VALUES ($1, $2, $3, $4)"
+	if _, err := tx.Exec(ctx, historyInsertSQL, orderID, string(o.Status), customerID, orderDate); err != nil {
+		return nil, fmt.Errorf("error saving order status: %w", err)
+	}
+
+	if p := o.Payment; p != nil {
+		paymentInsertSQL := `INSERT INTO payments (order_id, provider, authorization_id, amount, refunded_amount, currency, status, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)`
+		if _, err := tx.Exec(ctx, paymentInsertSQL, orderID, p.Provider, p.AuthorizationID, p.Amount, p.RefundedAmount, p.Currency, string(p.Status), p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error saving payment: %w", err)
+		}
+	}
+
 	// Insert order items
-	orderItemInsertSQL := "INSERT INTO orderitems (order_id, book_id, quantity, price) VALUES ($1, $2, $3, $4)"
+	orderItemInsertSQL := "INSERT INTO orderitems (order_id, book_id, quantity, price, tax_rate, tax) VALUES ($1, $2, $3, $4, $5, $6)"
 	for _, item := range items {
-		if _, err := tx.Exec(ctx, orderItemInsertSQL, orderID, item.BookID, item.Quantity, item.Price); err != nil {
+		if _, err := tx.Exec(ctx, orderItemInsertSQL, orderID, item.BookID, item.Quantity, item.Price, int64(item.TaxRate), item.Tax); err != nil {
 			return nil, fmt.Errorf("error adding order item: %w", err)
 		}
 	}
 
+	// Take the items out of stock, locking the books always in the same order so concurrent orders can't deadlock
+	byBook := make([]order.OrderItem, len(items))
+	copy(byBook, items)
+	sort.Slice(byBook, func(i, j int) bool {
+		return byBook[i].BookID < byBook[j].BookID
+	})
+
+	stockUpdateSQL := "UPDATE books SET stock = stock - $2 WHERE id = $1 AND stock >= $2"
+	movementInsertSQL := "INSERT INTO stock_movements (book_id, quantity, reason, order_id, created_at) VALUES ($1, $2, 'order', $3, $4)"
+	for _, item := range byBook {
+		tag, err := tx.Exec(ctx, stockUpdateSQL, item.BookID, item.Quantity)
+		if err != nil {
+			return nil, fmt.Errorf("error updating stock: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return nil, &order.OutOfStockError{BookID: item.BookID}
+		}
+
+		if _, err := tx.Exec(ctx, movementInsertSQL, item.BookID, -item.Quantity, orderID, orderDate); err != nil {
+			return nil, fmt.Errorf("error saving stock movement: %w", err)
+		}
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("error committing transaction: %w", err)
 	}
@@ -46,55 +108,284 @@ func (r *OrderRepository) SaveOrder(ctx context.Context, customerID int64, order
 	return &orderID, nil // Return the order ID as int64
 }
 
-func (r *OrderRepository) GetOrdersByCustomer(ctx context.Context, customerID int64) ([]order.Order, error) {
-	query := `
-        SELECT o.id, o.create_id, oi.book_id, b.title, oi.quantity, oi.price
-        FROM orders o
-        JOIN orderitems oi ON o.id = oi.order_id
+func (r *OrderRepository) ListOrders(ctx context.Context, query order.Query) ([]order.Order, error) {
+	conditions := []string{"customer_id = $1"}
+	args := []any{query.CustomerID}
+
+	// keyset pagination: continue right after the last order of the previous page
+	if query.After != nil {
+		args = append(args, query.After.ID)
+		conditions = append(conditions, fmt.Sprintf("id < $%d", len(args)))
+	}
+	if query.From != nil {
+		args = append(args, *query.From)
+		conditions = append(conditions, fmt.Sprintf("create_id >= $%d", len(args)))
+	}
+	if query.To != nil {
+		args = append(args, *query.To)
+		conditions = append(conditions, fmt.Sprintf("create_id < $%d", len(args)))
+	}
+	if query.Status != "" {
+		args = append(args, string(query.Status))
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+	args = append(args, query.Limit)
+
+	// limit the orders first, then bring the items of the page
+	sql := fmt.Sprintf(`
+		WITH page AS (
+			SELECT id, create_id, currency, status, subtotal, discount, tax, total, coupon_code, tax_country, tax_region, shipping_address,
+				shipping_method, shipping_cost
+			FROM orders
+			WHERE %s
+			ORDER BY id DESC
+			LIMIT $%d
+		)
+		SELECT p.id, p.create_id, p.currency, p.status, p.subtotal, p.discount, p.tax, p.total, p.coupon_code, p.tax_country, p.tax_region, p.shipping_address,
+			p.shipping_method, p.shipping_cost,
+			oi.book_id, b.title, oi.quantity, oi.price, oi.tax_rate, oi.tax
+		FROM page p
+		JOIN orderitems oi ON p.id = oi.order_id
 		JOIN books b ON b.id = oi.book_id
-        WHERE o.customer_id = $1
-    `
+		ORDER BY p.id DESC, oi.id
+	`, strings.Join(conditions, " AND "), len(args))
 
-	rows, err := r.db.Query(ctx, query, customerID)
+	rows, err := r.db.Query(ctx, sql, args...)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error fetching orders: %w", err)
 	}
 	defer rows.Close()
 
-	// Create a map to group orders by their IDs
-	orderMap := make(map[int64]*order.Order)
-
+	// the rows of an order come one after the other, so each order is complete when the next one starts
+	var orders []order.Order
 	for rows.Next() {
-
 		var orderItem order.OrderItem
 		var o order.Order
-		if err := rows.Scan(&o.ID, &o.OrderDate, &orderItem.BookID, &orderItem.BookTitle, &orderItem.Quantity, &orderItem.Price); err != nil {
+		var status string
+		var couponCode, taxCountry, taxRegion, shippingMethod *string
+		var taxRate int64
+		if err := rows.Scan(&o.ID, &o.OrderDate, &o.Currency, &status, &o.Subtotal, &o.Discount, &o.Tax, &o.Total, &couponCode, &taxCountry, &taxRegion, &o.ShippingAddress,
+			&shippingMethod, &o.ShippingCost, &orderItem.BookID, &orderItem.BookTitle, &orderItem.Quantity, &orderItem.Price, &taxRate, &orderItem.Tax); err != nil {
 			return nil, err
 		}
+		orderItem.TaxRate = tax.Rate(taxRate)
 
-		// Check if the order exists in the map, if not, create a new one
-		if existingOrder, ok := orderMap[o.ID]; ok {
-			existingOrder.Items = append(existingOrder.Items, orderItem)
-		} else {
-			newOrder := &order.Order{
-				ID:        o.ID,
-				OrderDate: o.OrderDate,
-				Items:     []order.OrderItem{orderItem},
+		if len(orders) == 0 || orders[len(orders)-1].ID != o.ID {
+			o.Status = order.Status(status)
+			if couponCode != nil {
+				o.CouponCode = *couponCode
 			}
-			orderMap[o.ID] = newOrder
+			o.Destination = scanDestination(taxCountry, taxRegion)
+			if shippingMethod != nil {
+				o.ShippingMethod = shipping.Method(*shippingMethod)
+			}
+			orders = append(orders, o)
 		}
+		last := &orders[len(orders)-1]
+		last.Items = append(last.Items, orderItem)
 	}
 
-	// Convert the map values (orders) into a slice
-	orders := []order.Order{}
-	for _, o := range orderMap {
-		orders = append(orders, *o)
+	return orders, rows.Err()
+}
+
+// scanDestination builds the tax destination of an order from its nullable columns
+func scanDestination(country, region *string) *tax.Destination {
+	if country == nil {
+		return nil
+	}
+	d := &tax.Destination{Country: *country}
+	if region != nil {
+		d.Region = *region
 	}
+	return d
+}
 
-	// Sort the orders in descending order by Order ID
-	sort.Slice(orders, func(i, j int) bool {
-		return orders[i].ID > orders[j].ID
-	})
+func (r *OrderRepository) GetOrderByID(ctx context.Context, customerID, orderID int64) (*order.Order, error) {
+	query := `
+		SELECT o.id, o.create_id, o.currency, o.status, o.subtotal, o.discount, o.tax, o.total, o.coupon_code, o.tax_country, o.tax_region, o.shipping_address,
+			o.shipping_method, o.shipping_cost,
+			oi.book_id, b.title, oi.quantity, oi.price, oi.tax_rate, oi.tax
+		FROM orders o
+		JOIN orderitems oi ON o.id = oi.order_id
+		JOIN books b ON b.id = oi.book_id
+		WHERE o.id = $1 AND o.customer_id = $2
+		ORDER BY oi.id
+	`
+
+	rows, err := r.db.Query(ctx, query, orderID, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching order: %w", err)
+	}
+	defer rows.Close()
+
+	var o *order.Order
+	for rows.Next() {
+		var item order.OrderItem
+		var current order.Order
+		var status string
+		var couponCode, taxCountry, taxRegion, shippingMethod *string
+		var taxRate int64
+		if err := rows.Scan(&current.ID, &current.OrderDate, &current.Currency, &status, &current.Subtotal, &current.Discount, &current.Tax,
+			&current.Total, &couponCode, &taxCountry, &taxRegion,
+			&current.ShippingAddress, &shippingMethod, &current.ShippingCost, &item.BookID, &item.BookTitle, &item.Quantity, &item.Price, &taxRate, &item.Tax); err != nil {
+			return nil, err
+		}
+		item.TaxRate = tax.Rate(taxRate)
+		if o == nil {
+			current.Status = order.Status(status)
+			if couponCode != nil {
+				current.CouponCode = *couponCode
+			}
+			current.Destination = scanDestination(taxCountry, taxRegion)
+			if shippingMethod != nil {
+				current.ShippingMethod = shipping.Method(*shippingMethod)
+			}
+			o = &current
+		}
+		o.Items = append(o.Items, item)
+	}
+
+	return o, rows.Err()
+}
+
+func (r *OrderRepository) GetOrderState(ctx context.Context, orderID int64) (*order.State, error) {
+	var state order.State
+	var status string
+	err := r.db.QueryRow(ctx, "SELECT customer_id, status, create_id FROM orders WHERE id = $1", orderID).Scan(&state.CustomerID, &status, &state.OrderDate)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching order state: %w", err)
+	}
+
+	state.Status = order.Status(status)
+	return &state, nil
+}
+
+func (r *OrderRepository) ClaimStatusChange(ctx context.Context, orderID int64, from, to order.Status) (bool, error) {
+	query := `
+		UPDATE orders SET next_status = $3
+		WHERE id = $1 AND status = $2 AND (next_status IS NULL OR next_status = $3)
+	`
+
+	tag, err := r.db.Exec(ctx, query, orderID, string(from), string(to))
+	if err != nil {
+		return false, fmt.Errorf("error claiming order status change: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *OrderRepository) ReleaseStatusChange(ctx context.Context, orderID int64, to order.Status) error {
+	if _, err := r.db.Exec(ctx, "UPDATE orders SET next_status = NULL WHERE id = $1 AND next_status = $2", orderID, string(to)); err != nil {
+		return fmt.Errorf("error releasing order status change: %w", err)
+	}
+
+	return nil
+}
+
+func (r *OrderRepository) UpdateOrderStatus(ctx context.Context, change order.StatusChange) (*order.StatusChange, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// only move the order if nobody else moved it since change.From was read, nor claimed another transition
+	query := `
+		UPDATE orders SET status = $3, next_status = NULL
+		WHERE id = $1 AND status = $2 AND (next_status IS NULL OR next_status = $3)
+	`
+	tag, err := tx.Exec(ctx, query, change.OrderID, string(change.From), string(change.To))
+	if err != nil {
+		return nil, fmt.Errorf("error updating order status: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, nil
+	}
+
+	historyInsertSQL := "INSERT INTO order_status_history (order_id, from_status, to_status, reason, changed_by, changed_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+	if err := tx.QueryRow(ctx, historyInsertSQL, change.OrderID, string(change.From), string(change.To), change.Reason, change.ChangedBy, change.ChangedAt).Scan(&change.ID); err != nil {
+		return nil, fmt.Errorf("error saving order status: %w", err)
+	}
+
+	if change.Restocks() {
+		if err := restockOrder(ctx, tx, change); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return &change, nil
+}
+
+// restockOrder gives the items of an order back to the stock, recording a movement for each of them
+func restockOrder(ctx context.Context, tx pgx.Tx, change order.StatusChange) error {
+	// lock the books in the same order SaveOrder does, so concurrent orders can't deadlock
+	rows, err := tx.Query(ctx, "SELECT book_id, quantity FROM orderitems WHERE order_id = $1 ORDER BY book_id", change.OrderID)
+	if err != nil {
+		return fmt.Errorf("error fetching order items: %w", err)
+	}
+	var items []order.OrderItem
+	for rows.Next() {
+		var item order.OrderItem
+		if err := rows.Scan(&item.BookID, &item.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error fetching order items: %w", err)
+	}
+
+	reason := "order " + string(change.To)
+	movementInsertSQL := "INSERT INTO stock_movements (book_id, quantity, reason, order_id, created_by, created_at) VALUES ($1, $2, $3, $4, $5, $6)"
+	for _, item := range items {
+		if _, err := tx.Exec(ctx, "UPDATE books SET stock = stock + $2 WHERE id = $1", item.BookID, item.Quantity); err != nil {
+			return fmt.Errorf("error updating stock: %w", err)
+		}
+		if _, err := tx.Exec(ctx, movementInsertSQL, item.BookID, item.Quantity, reason, change.OrderID, change.ChangedBy, change.ChangedAt); err != nil {
+			return fmt.Errorf("error saving stock movement: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *OrderRepository) GetStatusHistory(ctx context.Context, orderID int64) ([]order.StatusChange, error) {
+	query := `
+		SELECT id, order_id, from_status, to_status, reason, changed_by, changed_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY id
+	`
+
+	rows, err := r.db.Query(ctx, query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching order status history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []order.StatusChange
+	for rows.Next() {
+		var c order.StatusChange
+		var from *string
+		var to string
+		if err := rows.Scan(&c.ID, &c.OrderID, &from, &to, &c.Reason, &c.ChangedBy, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		if from != nil {
+			c.From = order.Status(*from)
+		}
+		c.To = order.Status(to)
+		history = append(history, c)
+	}
 
-	return orders, nil
+	return history, rows.Err()
 }
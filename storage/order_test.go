@@ -2,8 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
 	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/shipping"
+	"github.com/ap-pauloafonso/bookstore/tax"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -59,7 +63,7 @@ func TestOrderRepository(t *testing.T) {
 
 	t.Run("get orders fails because there is no table yet", func(t *testing.T) {
 
-		_, err := repo.GetOrdersByCustomer(context.Background(), 1)
+		_, err := repo.ListOrders(context.Background(), order.Query{CustomerID: 1, Limit: 10})
 		if err == nil {
 			t.Fatalf("shoould have an error because there is no table created yet")
 		}
@@ -68,11 +72,11 @@ func TestOrderRepository(t *testing.T) {
 
 	t.Run("save order fails because the is no table yet", func(t *testing.T) {
 
-		_, err := repo.SaveOrder(context.Background(), 1, time.Now(), []order.OrderItem{
-			{BookID: 1, Quantity: 1, Price: 5},
-			{BookID: 2, Quantity: 10, Price: 7},
-			{BookID: 3, Quantity: 30, Price: 9},
-		})
+		_, err := repo.SaveOrder(context.Background(), 1, pendingOrder([]order.OrderItem{
+			{BookID: 1, Quantity: 1, Price: 500},
+			{BookID: 2, Quantity: 10, Price: 700},
+			{BookID: 3, Quantity: 30, Price: 900},
+		}))
 		if err == nil {
 			t.Fatalf("shoould have an error because there is no table created yet")
 		}
@@ -93,20 +97,65 @@ func TestOrderRepository(t *testing.T) {
 			t.Fatalf("should not have an error while creating a customer to create order later")
 		}
 
-		_, err := repo.SaveOrder(context.Background(), *customerid, time.Now(), []order.OrderItem{
-			{BookID: 1, Quantity: 1, Price: 5},
-			{BookID: 2, Quantity: 10, Price: 7},
-			{BookID: 3, Quantity: 30, Price: 9},
-		})
+		_, err := repo.SaveOrder(context.Background(), *customerid, pendingOrder([]order.OrderItem{
+			{BookID: 1, Quantity: 1, Price: 500},
+			{BookID: 2, Quantity: 10, Price: 700},
+			{BookID: 3, Quantity: 30, Price: 900},
+		}))
 		if err != nil {
 			t.Fatalf("should not have an error while inserting the order")
 		}
 
 	})
 
+	t.Run("save order fails when a book is out of stock", func(t *testing.T) {
+		bookRepo := NewBookRepository(pool)
+		before, err := bookRepo.GetBookByID(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("should not have an error while fetching the book: %v", err)
+		}
+
+		_, err = repo.SaveOrder(context.Background(), *customerid, pendingOrder([]order.OrderItem{
+			{BookID: 2, Quantity: 1, Price: 700},
+			{BookID: 1, Quantity: before.Stock + 1, Price: 500},
+		}))
+
+		var stockErr *order.OutOfStockError
+		if !errors.As(err, &stockErr) || stockErr.BookID != 1 {
+			t.Fatalf("should fail with an out of stock error for book 1, got: %v", err)
+		}
+
+		after, err := bookRepo.GetBookByID(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("should not have an error while fetching the book: %v", err)
+		}
+		if after.Stock != before.Stock {
+			t.Fatalf("stock should not change when the order fails, before: %d after: %d", before.Stock, after.Stock)
+		}
+	})
+
+	t.Run("save order takes the items out of stock", func(t *testing.T) {
+		bookRepo := NewBookRepository(pool)
+		b, err := bookRepo.GetBookByID(context.Background(), 3)
+		if err != nil {
+			t.Fatalf("should not have an error while fetching the book: %v", err)
+		}
+		if b.Stock != 70 {
+			t.Fatalf("the previous order took 30 units out of the 100 in stock, got: %d", b.Stock)
+		}
+
+		movements, err := bookRepo.GetStockMovements(context.Background(), 3, 10)
+		if err != nil {
+			t.Fatalf("should not have an error while fetching the movements: %v", err)
+		}
+		if len(movements) != 1 || movements[0].Quantity != -30 || movements[0].OrderID == nil {
+			t.Fatalf("the order should be recorded as a stock movement, got: %+v", movements)
+		}
+	})
+
 	t.Run("get orders works", func(t *testing.T) {
 
-		o, err := repo.GetOrdersByCustomer(context.Background(), *customerid)
+		o, err := repo.ListOrders(context.Background(), order.Query{CustomerID: *customerid, Limit: 10})
 		if err != nil {
 			t.Fatalf("should not have an error while hetting the orders")
 		}
@@ -121,4 +170,234 @@ func TestOrderRepository(t *testing.T) {
 		}
 	})
 
+	t.Run("order total matches the sum computed by the database", func(t *testing.T) {
+		items := []order.OrderItem{
+			{BookID: 4, Quantity: 3, Price: 1099},
+			{BookID: 5, Quantity: 7, Price: 333},
+		}
+		orderID, err := repo.SaveOrder(context.Background(), *customerid, pendingOrder(items))
+		if err != nil {
+			t.Fatalf("should not have an error while inserting the order: %v", err)
+		}
+
+		var sum money.Amount
+		err = pool.QueryRow(context.Background(), "SELECT SUM(quantity * price) FROM orderitems WHERE order_id = $1", *orderID).Scan(&sum)
+		if err != nil {
+			t.Fatalf("should not have an error while summing the order items: %v", err)
+		}
+
+		total := order.CalculateTotal(items, 0)
+		if total != 5628 || sum != total {
+			t.Fatalf("expected both totals to be 56.28, got calculated: %s, database: %s", total, sum)
+		}
+	})
+
+	t.Run("cancelling an order gives the items back to the stock", func(t *testing.T) {
+		bookRepo := NewBookRepository(pool)
+		before, err := bookRepo.GetBookByID(context.Background(), 6)
+		if err != nil {
+			t.Fatalf("should not have an error while fetching the book: %v", err)
+		}
+
+		orderID, err := repo.SaveOrder(context.Background(), *customerid, pendingOrder([]order.OrderItem{
+			{BookID: 6, Quantity: 2, Price: 1599},
+		}))
+		if err != nil {
+			t.Fatalf("should not have an error while inserting the order: %v", err)
+		}
+
+		state, err := repo.GetOrderState(context.Background(), *orderID)
+		if err != nil || state == nil || state.Status != order.StatusPending || state.CustomerID != *customerid {
+			t.Fatalf("a new order should be pending, got: %+v, %v", state, err)
+		}
+
+		change, err := repo.UpdateOrderStatus(context.Background(), order.StatusChange{
+			OrderID:   *orderID,
+			From:      order.StatusPending,
+			To:        order.StatusCancelled,
+			Reason:    "customer changed their mind",
+			ChangedBy: customerid,
+			ChangedAt: time.Now(),
+		})
+		if err != nil || change == nil || change.ID == 0 {
+			t.Fatalf("should update the status, got: %+v, %v", change, err)
+		}
+
+		after, err := bookRepo.GetBookByID(context.Background(), 6)
+		if err != nil {
+			t.Fatalf("should not have an error while fetching the book: %v", err)
+		}
+		if after.Stock != before.Stock {
+			t.Fatalf("the units should be back in stock, before: %d after: %d", before.Stock, after.Stock)
+		}
+
+		stale, err := repo.UpdateOrderStatus(context.Background(), order.StatusChange{
+			OrderID:   *orderID,
+			From:      order.StatusPending,
+			To:        order.StatusPaid,
+			ChangedAt: time.Now(),
+		})
+		if err != nil || stale != nil {
+			t.Fatalf("should not update an order that is no longer in the expected status, got: %+v, %v", stale, err)
+		}
+
+		history, err := repo.GetStatusHistory(context.Background(), *orderID)
+		if err != nil {
+			t.Fatalf("should not have an error while fetching the history: %v", err)
+		}
+		if len(history) != 2 || history[0].From != "" || history[0].To != order.StatusPending ||
+			history[1].From != order.StatusPending || history[1].To != order.StatusCancelled || history[1].Reason != "customer changed their mind" {
+			t.Fatalf("unexpected status history: %+v", history)
+		}
+	})
+
+	t.Run("a claimed status change blocks the other transitions", func(t *testing.T) {
+		orderID, err := repo.SaveOrder(context.Background(), *customerid, pendingOrder([]order.OrderItem{
+			{BookID: 6, Quantity: 1, Price: 1599},
+		}))
+		if err != nil {
+			t.Fatalf("should not have an error while inserting the order: %v", err)
+		}
+
+		claimed, err := repo.ClaimStatusChange(context.Background(), *orderID, order.StatusPending, order.StatusPaid)
+		if err != nil || !claimed {
+			t.Fatalf("should claim the transition, got: %v, %v", claimed, err)
+		}
+		claimed, err = repo.ClaimStatusChange(context.Background(), *orderID, order.StatusPending, order.StatusPaid)
+		if err != nil || !claimed {
+			t.Fatalf("should claim the same transition again, got: %v, %v", claimed, err)
+		}
+		claimed, err = repo.ClaimStatusChange(context.Background(), *orderID, order.StatusPending, order.StatusCancelled)
+		if err != nil || claimed {
+			t.Fatalf("should not claim another transition, got: %v, %v", claimed, err)
+		}
+		cancelled, err := repo.UpdateOrderStatus(context.Background(), order.StatusChange{
+			OrderID: *orderID, From: order.StatusPending, To: order.StatusCancelled, ChangedAt: time.Now(),
+		})
+		if err != nil || cancelled != nil {
+			t.Fatalf("should not store another transition, got: %+v, %v", cancelled, err)
+		}
+
+		if err := repo.ReleaseStatusChange(context.Background(), *orderID, order.StatusPaid); err != nil {
+			t.Fatalf("should release the claim: %v", err)
+		}
+		claimed, err = repo.ClaimStatusChange(context.Background(), *orderID, order.StatusPending, order.StatusCancelled)
+		if err != nil || !claimed {
+			t.Fatalf("should claim another transition once released, got: %v, %v", claimed, err)
+		}
+		cancelled, err = repo.UpdateOrderStatus(context.Background(), order.StatusChange{
+			OrderID: *orderID, From: order.StatusPending, To: order.StatusCancelled, ChangedAt: time.Now(),
+		})
+		if err != nil || cancelled == nil {
+			t.Fatalf("should store the claimed transition, got: %+v, %v", cancelled, err)
+		}
+		claimed, err = repo.ClaimStatusChange(context.Background(), *orderID, order.StatusCancelled, order.StatusRefunded)
+		if err != nil || !claimed {
+			t.Fatalf("storing the transition should release its claim, got: %v, %v", claimed, err)
+		}
+	})
+
+	t.Run("list orders paginates and filters in the database", func(t *testing.T) {
+		all, err := repo.ListOrders(context.Background(), order.Query{CustomerID: *customerid, Limit: 10})
+		if err != nil {
+			t.Fatalf("should not have an error while listing the orders: %v", err)
+		}
+		if len(all) != 3 || all[0].ID < all[1].ID || all[1].ID < all[2].ID {
+			t.Fatalf("should list the 3 orders newest first, got: %+v", all)
+		}
+
+		firstPage, err := repo.ListOrders(context.Background(), order.Query{CustomerID: *customerid, Limit: 2})
+		if err != nil || len(firstPage) != 2 || firstPage[0].ID != all[0].ID || len(firstPage[0].Items) != len(all[0].Items) {
+			t.Fatalf("should return the 2 newest orders with all their items, got: %+v, %v", firstPage, err)
+		}
+
+		secondPage, err := repo.ListOrders(context.Background(), order.Query{CustomerID: *customerid, Limit: 2, After: &order.Cursor{ID: firstPage[1].ID}})
+		if err != nil || len(secondPage) != 1 || secondPage[0].ID != all[2].ID {
+			t.Fatalf("should continue after the cursor, got: %+v, %v", secondPage, err)
+		}
+
+		cancelled, err := repo.ListOrders(context.Background(), order.Query{CustomerID: *customerid, Limit: 10, Status: order.StatusCancelled})
+		if err != nil || len(cancelled) != 1 || cancelled[0].Status != order.StatusCancelled {
+			t.Fatalf("should only return the cancelled order, got: %+v, %v", cancelled, err)
+		}
+
+		future := time.Now().Add(time.Hour)
+		none, err := repo.ListOrders(context.Background(), order.Query{CustomerID: *customerid, Limit: 10, From: &future})
+		if err != nil || len(none) != 0 {
+			t.Fatalf("should not return orders made before from, got: %+v, %v", none, err)
+		}
+		past := time.Now().Add(-time.Hour)
+		some, err := repo.ListOrders(context.Background(), order.Query{CustomerID: *customerid, Limit: 10, From: &past, To: &future})
+		if err != nil || len(some) != 3 {
+			t.Fatalf("should return the orders made in the range, got: %+v, %v", some, err)
+		}
+	})
+
+	t.Run("get order by id only returns the customer orders", func(t *testing.T) {
+		orders, err := repo.ListOrders(context.Background(), order.Query{CustomerID: *customerid, Limit: 10})
+		if err != nil || len(orders) == 0 {
+			t.Fatalf("should have orders to look up, got: %v", err)
+		}
+
+		o, err := repo.GetOrderByID(context.Background(), *customerid, orders[0].ID)
+		if err != nil {
+			t.Fatalf("should not have an error while fetching the order: %v", err)
+		}
+		if o == nil || o.ID != orders[0].ID || len(o.Items) != len(orders[0].Items) || o.Items[0].BookTitle == "" {
+			t.Fatalf("should return the order with its items, got: %+v", o)
+		}
+
+		otherID, err := customerRepo.SaveCustomer(context.Background(), "other@gmail.com", "123", time.Now())
+		if err != nil {
+			t.Fatalf("should not have an error while creating another customer: %v", err)
+		}
+		o, err = repo.GetOrderByID(context.Background(), *otherID, orders[0].ID)
+		if err != nil || o != nil {
+			t.Fatalf("should not return orders of other customers, got: %+v, %v", o, err)
+		}
+	})
+
+	t.Run("the tax, destination and shipping of an order are stored", func(t *testing.T) {
+		o := pendingOrder([]order.OrderItem{{BookID: 7, Quantity: 2, Price: 1000, TaxRate: 725, Tax: 145}})
+		o.Destination = &tax.Destination{Country: "US", Region: "CA"}
+		o.ShippingMethod, o.ShippingCost = shipping.MethodExpress, 1299
+		o.Subtotal, o.Tax, o.Total = 2000, 145, 3444
+		orderID, err := repo.SaveOrder(context.Background(), *customerid, o)
+		if err != nil {
+			t.Fatalf("should not have an error while inserting the order: %v", err)
+		}
+
+		stored, err := repo.GetOrderByID(context.Background(), *customerid, *orderID)
+		if err != nil || stored == nil {
+			t.Fatalf("should return the order, got: %+v, %v", stored, err)
+		}
+		if stored.Destination == nil || *stored.Destination != *o.Destination || stored.Items[0].TaxRate != 725 || stored.Items[0].Tax != 145 {
+			t.Fatalf("should return the tax of the order, got: %+v", stored)
+		}
+		if stored.ShippingMethod != shipping.MethodExpress || stored.ShippingCost != 1299 {
+			t.Fatalf("should return the shipping of the order, got: %+v", stored)
+		}
+
+		if stored.Subtotal != 2000 || stored.Tax != 145 || stored.Total != 3444 {
+			t.Fatalf("should return the stored totals of the order, got: %+v", stored)
+		}
+
+		orders, err := repo.ListOrders(context.Background(), order.Query{CustomerID: *customerid, Limit: 1})
+		if err != nil || len(orders) != 1 || orders[0].ID != *orderID || orders[0].Subtotal != 2000 || orders[0].Tax != 145 || orders[0].Total != 3444 {
+			t.Fatalf("should list the order with its stored totals, got: %+v, %v", orders, err)
+		}
+	})
+
+	t.Run("get order state returns nil for unknown orders", func(t *testing.T) {
+		state, err := repo.GetOrderState(context.Background(), 999999)
+		if err != nil || state != nil {
+			t.Fatalf("should return nil state and nil error, got: %+v, %v", state, err)
+		}
+	})
+
+}
+
+// pendingOrder is an order as it was stored before payments existed
+func pendingOrder(items []order.OrderItem) order.Order {
+	return order.Order{Currency: money.Currency, Status: order.StatusPending, OrderDate: time.Now(), Items: items}
 }
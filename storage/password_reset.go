@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type PasswordResetRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPasswordResetRepository(db *pgxpool.Pool) *PasswordResetRepository {
+	return &PasswordResetRepository{db}
+}
+
+func (r *PasswordResetRepository) SaveReset(ctx context.Context, reset customer.PasswordReset, notSentSince time.Time) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// lock the customer so concurrent requests can't both send a link
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM customers WHERE id = $1 FOR UPDATE", reset.CustomerID); err != nil {
+		return false, fmt.Errorf("error locking customer: %w", err)
+	}
+
+	var sentRecently bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM password_resets WHERE customer_id = $1 AND created_at > $2)", reset.CustomerID, notSentSince).
+		Scan(&sentRecently)
+	if err != nil {
+		return false, fmt.Errorf("error checking password resets: %w", err)
+	}
+	if sentRecently {
+		return false, nil
+	}
+
+	// only the last link sent to the customer works
+	if _, err := tx.Exec(ctx, "DELETE FROM password_resets WHERE customer_id = $1 AND used_at IS NULL", reset.CustomerID); err != nil {
+		return false, fmt.Errorf("error deleting password resets: %w", err)
+	}
+
+	query := "INSERT INTO password_resets (token_hash, customer_id, expires_at, created_at) VALUES ($1, $2, $3, $4)"
+	if _, err := tx.Exec(ctx, query, reset.TokenHash, reset.CustomerID, reset.ExpiresAt, reset.CreatedAt); err != nil {
+		return false, fmt.Errorf("error saving password reset: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+func (r *PasswordResetRepository) ResetPassword(ctx context.Context, tokenHash, password string, now time.Time) (*int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE password_resets SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING customer_id
+	`
+
+	var customerID int64
+	if err := tx.QueryRow(ctx, query, tokenHash, now).Scan(&customerID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error using password reset: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE customers SET password = $2 WHERE id = $1", customerID, password); err != nil {
+		return nil, fmt.Errorf("error updating password: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return &customerID, nil
+}
+
+func (r *PasswordResetRepository) DeleteReset(ctx context.Context, tokenHash string) error {
+	query := "DELETE FROM password_resets WHERE token_hash = $1 AND used_at IS NULL"
+	if _, err := r.db.Exec(ctx, query, tokenHash); err != nil {
+		return fmt.Errorf("error deleting password reset: %w", err)
+	}
+
+	return nil
+}
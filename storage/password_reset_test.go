@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/customer"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordResetRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	t.Parallel()
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:latest",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "test",
+			"POSTGRES_DB":       "MY_DB",
+		},
+		WaitingFor: wait.ForAll(wait.ForListeningPort("5432/tcp"), wait.ForLog("database system is ready to accept connections")),
+	}
+	postgresC, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start PostgreSQL container: %v", err)
+	}
+	defer postgresC.Terminate(context.Background())
+
+	host, err := postgresC.Host(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		t.Error(err)
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", host, port.Port())
+
+	time.Sleep(3 * time.Second) // a bit of delay to make sure that container is ready
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewPasswordResetRepository(pool)
+	customerRepo := NewCustomerRepository(pool)
+
+	err = RunMigrations(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customerID, err := customerRepo.SaveCustomer(context.Background(), "reset@gmail.com", "old", time.Now())
+	if err != nil {
+		t.Fatalf("should not have an error while creating the customer: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	reset := func(c string, expiresAt time.Time) customer.PasswordReset {
+		return customer.PasswordReset{TokenHash: strings.Repeat(c, 64), CustomerID: *customerID, ExpiresAt: expiresAt, CreatedAt: now}
+	}
+
+	t.Run("SaveReset replaces the unused resets", func(t *testing.T) {
+		if saved, err := repo.SaveReset(context.Background(), reset("a", now.Add(time.Hour)), now); err != nil || !saved {
+			t.Fatalf("should save the reset, got: %v, %v", saved, err)
+		}
+		if saved, err := repo.SaveReset(context.Background(), reset("b", now.Add(time.Hour)), now); err != nil || !saved {
+			t.Fatalf("should save the reset, got: %v, %v", saved, err)
+		}
+
+		id, err := repo.ResetPassword(context.Background(), strings.Repeat("a", 64), "new", now)
+		if err != nil || id != nil {
+			t.Fatalf("the replaced reset shouldn't work, got: %v, %v", id, err)
+		}
+	})
+
+	t.Run("SaveReset is throttled", func(t *testing.T) {
+		saved, err := repo.SaveReset(context.Background(), reset("d", now.Add(time.Hour)), now.Add(-time.Minute))
+		if err != nil || saved {
+			t.Fatalf("should not save a reset while the last one is recent, got: %v, %v", saved, err)
+		}
+	})
+
+	t.Run("ResetPassword", func(t *testing.T) {
+		id, err := repo.ResetPassword(context.Background(), strings.Repeat("b", 64), "new", now)
+		if err != nil || id == nil || *id != *customerID {
+			t.Fatalf("should reset the password of the customer, got: %v, %v", id, err)
+		}
+
+		c, err := customerRepo.GetCustomer(context.Background(), "reset@gmail.com")
+		if err != nil || c.Password != "new" {
+			t.Fatalf("the password should be changed, got: %+v, %v", c, err)
+		}
+
+		id, err = repo.ResetPassword(context.Background(), strings.Repeat("b", 64), "newer", now)
+		if err != nil || id != nil {
+			t.Fatalf("a used reset shouldn't work, got: %v, %v", id, err)
+		}
+	})
+
+	t.Run("ResetPassword fails because the reset expired", func(t *testing.T) {
+		if _, err := repo.SaveReset(context.Background(), reset("c", now.Add(-time.Minute)), now); err != nil {
+			t.Fatal(err)
+		}
+
+		id, err := repo.ResetPassword(context.Background(), strings.Repeat("c", 64), "newer", now)
+		if err != nil || id != nil {
+			t.Fatalf("an expired reset shouldn't work, got: %v, %v", id, err)
+		}
+	})
+
+	t.Run("DeleteReset", func(t *testing.T) {
+		// sent after the others, so it would throttle the next one if it was kept
+		sent := reset("e", now.Add(time.Hour))
+		sent.CreatedAt = now.Add(time.Minute)
+		if saved, err := repo.SaveReset(context.Background(), sent, now); err != nil || !saved {
+			t.Fatalf("should save the reset, got: %v, %v", saved, err)
+		}
+		if err := repo.DeleteReset(context.Background(), strings.Repeat("e", 64)); err != nil {
+			t.Fatal(err)
+		}
+
+		id, err := repo.ResetPassword(context.Background(), strings.Repeat("e", 64), "newer", now)
+		if err != nil || id != nil {
+			t.Fatalf("a deleted reset shouldn't work, got: %v, %v", id, err)
+		}
+		if saved, err := repo.SaveReset(context.Background(), reset("f", now.Add(time.Hour)), now); err != nil || !saved {
+			t.Fatalf("a deleted reset shouldn't throttle the next one, got: %v, %v", saved, err)
+		}
+	})
+}
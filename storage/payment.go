@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/payment"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type PaymentRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPaymentRepository(db *pgxpool.Pool) *PaymentRepository {
+	return &PaymentRepository{db}
+}
+
+func (r *PaymentRepository) GetPaymentByOrder(ctx context.Context, orderID int64) (*payment.Payment, error) {
+	query := `
+		SELECT id, order_id, provider, authorization_id, amount, refunded_amount, currency, status, created_at
+		FROM payments
+		WHERE order_id = $1
+	`
+
+	var p payment.Payment
+	var status string
+	err := r.db.QueryRow(ctx, query, orderID).
+		Scan(&p.ID, &p.OrderID, &p.Provider, &p.AuthorizationID, &p.Amount, &p.RefundedAmount, &p.Currency, &status, &p.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching payment: %w", err)
+	}
+	p.Status = payment.Status(status)
+
+	return &p, nil
+}
+
+func (r *PaymentRepository) UpdatePaymentStatus(ctx context.Context, paymentID int64, from, to payment.Status) (bool, error) {
+	query := "UPDATE payments SET status = $3, updated_at = $4 WHERE id = $1 AND status = $2"
+	tag, err := r.db.Exec(ctx, query, paymentID, string(from), string(to), time.Now())
+	if err != nil {
+		return false, fmt.Errorf("error updating payment: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *PaymentRepository) ReserveRefund(ctx context.Context, paymentID int64, amount money.Amount) (bool, error) {
+	// a single statement, so concurrent refunds can't both take what is left of the payment
+	query := `
+		UPDATE payments
+		SET refunded_amount = refunded_amount + $2,
+			status = CASE WHEN refunded_amount + $2 = amount THEN $4 ELSE status END,
+			updated_at = $5
+		WHERE id = $1 AND status = $3 AND refunded_amount + $2 <= amount
+	`
+	tag, err := r.db.Exec(ctx, query, paymentID, amount, string(payment.StatusCaptured), string(payment.StatusRefunded), time.Now())
+	if err != nil {
+		return false, fmt.Errorf("error reserving refund: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *PaymentRepository) ReleaseRefund(ctx context.Context, paymentID int64, amount money.Amount) error {
+	query := "UPDATE payments SET refunded_amount = refunded_amount - $2, status = $3, updated_at = $4 WHERE id = $1"
+	if _, err := r.db.Exec(ctx, query, paymentID, amount, string(payment.StatusCaptured), time.Now()); err != nil {
+		return fmt.Errorf("error releasing refund: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/payment"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"testing"
+	"time"
+)
+
+func TestPaymentRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	t.Parallel()
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:latest",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "test",
+			"POSTGRES_DB":       "MY_DB",
+		},
+		WaitingFor: wait.ForAll(wait.ForListeningPort("5432/tcp"), wait.ForLog("database system is ready to accept connections")),
+	}
+	postgresC, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start PostgreSQL container: %v", err)
+	}
+	defer postgresC.Terminate(context.Background())
+
+	host, err := postgresC.Host(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		t.Error(err)
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", host, port.Port())
+
+	time.Sleep(3 * time.Second) // a bit of delay to make sure that container is ready
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewPaymentRepository(pool)
+	orderRepo := NewOrderRepository(pool)
+	customerRepo := NewCustomerRepository(pool)
+
+	err = RunMigrations(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customerID, err := customerRepo.SaveCustomer(context.Background(), "payment@gmail.com", "123", time.Now())
+	if err != nil {
+		t.Fatalf("should not have an error while creating the customer: %v", err)
+	}
+
+	items := []order.OrderItem{{BookID: 1, Quantity: 2, Price: 1099}}
+
+	t.Run("the payment is stored with its order", func(t *testing.T) {
+		orderID, err := orderRepo.SaveOrder(context.Background(), *customerID, order.Order{
+			Currency:  money.Currency,
+			Status:    order.StatusPaid,
+			OrderDate: time.Now(),
+			Items:     items,
+			Payment: &payment.Payment{
+				Provider:        "fake",
+				AuthorizationID: "fake_auth_1",
+				Amount:          2198,
+				Currency:        money.Currency,
+				Status:          payment.StatusAuthorized,
+				CreatedAt:       time.Now(),
+			},
+		})
+		if err != nil {
+			t.Fatalf("should not have an error while inserting the order: %v", err)
+		}
+
+		p, err := repo.GetPaymentByOrder(context.Background(), *orderID)
+		if err != nil || p == nil {
+			t.Fatalf("should find the payment of the order, got: %+v, %v", p, err)
+		}
+		if p.OrderID != *orderID || p.AuthorizationID != "fake_auth_1" || p.Amount != 2198 || p.RefundedAmount != 0 || p.Status != payment.StatusAuthorized {
+			t.Fatalf("unexpected payment: %+v", p)
+		}
+
+		moved, err := repo.UpdatePaymentStatus(context.Background(), p.ID, payment.StatusAuthorized, payment.StatusCaptured)
+		if err != nil || !moved {
+			t.Fatalf("should capture the payment, got: %v, %v", moved, err)
+		}
+		moved, err = repo.UpdatePaymentStatus(context.Background(), p.ID, payment.StatusAuthorized, payment.StatusVoided)
+		if err != nil || moved {
+			t.Fatalf("should not void a payment that isn't authorized anymore, got: %v, %v", moved, err)
+		}
+
+		reserved, err := repo.ReserveRefund(context.Background(), p.ID, 1099)
+		if err != nil || !reserved {
+			t.Fatalf("should reserve the refund, got: %v, %v", reserved, err)
+		}
+		reserved, err = repo.ReserveRefund(context.Background(), p.ID, 1100)
+		if err != nil || reserved {
+			t.Fatalf("should not reserve more than what is left, got: %v, %v", reserved, err)
+		}
+
+		updated, err := repo.GetPaymentByOrder(context.Background(), *orderID)
+		if err != nil || updated == nil || updated.Status != payment.StatusCaptured || updated.RefundedAmount != 1099 {
+			t.Fatalf("the payment should be updated, got: %+v, %v", updated, err)
+		}
+
+		reserved, err = repo.ReserveRefund(context.Background(), p.ID, 1099)
+		if err != nil || !reserved {
+			t.Fatalf("should reserve the rest of the payment, got: %v, %v", reserved, err)
+		}
+		updated, err = repo.GetPaymentByOrder(context.Background(), *orderID)
+		if err != nil || updated == nil || updated.Status != payment.StatusRefunded || updated.RefundedAmount != 2198 {
+			t.Fatalf("the payment should be refunded, got: %+v, %v", updated, err)
+		}
+
+		if err := repo.ReleaseRefund(context.Background(), p.ID, 1099); err != nil {
+			t.Fatalf("should not have an error while releasing the refund: %v", err)
+		}
+		updated, err = repo.GetPaymentByOrder(context.Background(), *orderID)
+		if err != nil || updated == nil || updated.Status != payment.StatusCaptured || updated.RefundedAmount != 1099 {
+			t.Fatalf("the payment should be captured again, got: %+v, %v", updated, err)
+		}
+	})
+
+	t.Run("orders without a payment have none", func(t *testing.T) {
+		orderID, err := orderRepo.SaveOrder(context.Background(), *customerID, order.Order{
+			Currency:  money.Currency,
+			Status:    order.StatusPending,
+			OrderDate: time.Now(),
+			Items:     items,
+		})
+		if err != nil {
+			t.Fatalf("should not have an error while inserting the order: %v", err)
+		}
+
+		p, err := repo.GetPaymentByOrder(context.Background(), *orderID)
+		if err != nil || p != nil {
+			t.Fatalf("should not find a payment, got: %+v, %v", p, err)
+		}
+	})
+}
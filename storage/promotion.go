@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/promotion"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+type PromotionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPromotionRepository(db *pgxpool.Pool) *PromotionRepository {
+	return &PromotionRepository{db}
+}
+
+func (r *PromotionRepository) SaveCoupon(ctx context.Context, c promotion.Coupon) (*int64, error) {
+	query := `INSERT INTO coupons (code, type, percent, amount, min_order_value, max_uses, max_uses_per_customer, starts_at, ends_at, book_ids, authors, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`
+
+	var id int64
+	err := r.db.QueryRow(ctx, query, c.Code, string(c.Type), c.Percent, c.Amount, c.MinOrderValue, c.MaxUses, c.MaxUsesPerCustomer,
+		c.StartsAt, c.EndsAt, c.BookIDs, c.Authors, c.CreatedAt).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("error creating coupon: %w", err)
+	}
+
+	return &id, nil
+}
+
+func (r *PromotionRepository) GetCouponByCode(ctx context.Context, code string) (*promotion.Coupon, error) {
+	query := `
+		SELECT id, code, type, percent, amount, min_order_value, max_uses, max_uses_per_customer, starts_at, ends_at, book_ids, authors, created_at
+		FROM coupons
+		WHERE code = $1
+	`
+
+	var c promotion.Coupon
+	var couponType string
+	err := r.db.QueryRow(ctx, query, code).Scan(&c.ID, &c.Code, &couponType, &c.Percent, &c.Amount, &c.MinOrderValue, &c.MaxUses,
+		&c.MaxUsesPerCustomer, &c.StartsAt, &c.EndsAt, &c.BookIDs, &c.Authors, &c.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching coupon: %w", err)
+	}
+	c.Type = promotion.Type(couponType)
+
+	return &c, nil
+}
+
+func (r *PromotionRepository) CountRedemptions(ctx context.Context, couponID, customerID int64) (int, int, error) {
+	return countRedemptions(ctx, r.db, couponID, customerID)
+}
+
+// countRedemptions counts the orders that used the coupon, cancelled orders give their use back
+func countRedemptions(ctx context.Context, q rowQuerier, couponID, customerID int64) (int, int, error) {
+	query := `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE cr.customer_id = $2)
+		FROM coupon_redemptions cr
+		JOIN orders o ON o.id = cr.order_id
+		WHERE cr.coupon_id = $1 AND o.status <> $3
+	`
+
+	var total, byCustomer int
+	if err := q.QueryRow(ctx, query, couponID, customerID, string(order.StatusCancelled)).Scan(&total, &byCustomer); err != nil {
+		return 0, 0, fmt.Errorf("error counting coupon redemptions: %w", err)
+	}
+
+	return total, byCustomer, nil
+}
+
+// redeemCoupon records that the order used the coupon, failing with *promotion.UsageLimitError if the coupon was used
+// up in the meantime. The coupon row stays locked until tx ends, so concurrent orders can't go over its limits
+func redeemCoupon(ctx context.Context, tx pgx.Tx, customerID, orderID int64, o order.Order) error {
+	var couponID int64
+	var maxUses, maxUsesPerCustomer int
+	err := tx.QueryRow(ctx, "SELECT id, max_uses, max_uses_per_customer FROM coupons WHERE code = $1 FOR UPDATE", o.CouponCode).
+		Scan(&couponID, &maxUses, &maxUsesPerCustomer)
+	if err != nil {
+		return fmt.Errorf("error locking coupon: %w", err)
+	}
+
+	total, byCustomer, err := countRedemptions(ctx, tx, couponID, customerID)
+	if err != nil {
+		return err
+	}
+	if (maxUses > 0 && total >= maxUses) || (maxUsesPerCustomer > 0 && byCustomer >= maxUsesPerCustomer) {
+		return &promotion.UsageLimitError{Code: o.CouponCode}
+	}
+
+	redemptionInsertSQL := "INSERT INTO coupon_redemptions (coupon_id, order_id, customer_id, discount, created_at) VALUES ($1, $2, $3, $4, $5)"
+	if _, err := tx.Exec(ctx, redemptionInsertSQL, couponID, orderID, customerID, o.Discount, o.OrderDate); err != nil {
+		return fmt.Errorf("error saving coupon redemption: %w", err)
+	}
+
+	return nil
+}
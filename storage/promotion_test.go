@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/promotion"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"testing"
+	"time"
+)
+
+func TestPromotionRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	t.Parallel()
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:latest",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "test",
+			"POSTGRES_DB":       "MY_DB",
+		},
+		WaitingFor: wait.ForAll(wait.ForListeningPort("5432/tcp"), wait.ForLog("database system is ready to accept connections")),
+	}
+	postgresC, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start PostgreSQL container: %v", err)
+	}
+	defer postgresC.Terminate(context.Background())
+
+	host, err := postgresC.Host(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		t.Error(err)
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", host, port.Port())
+
+	time.Sleep(3 * time.Second) // a bit of delay to make sure that container is ready
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewPromotionRepository(pool)
+	orderRepo := NewOrderRepository(pool)
+	customerRepo := NewCustomerRepository(pool)
+
+	err = RunMigrations(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customerID, err := customerRepo.SaveCustomer(context.Background(), "coupons@gmail.com", "123", time.Now())
+	if err != nil {
+		t.Fatalf("should not have an error while creating the customer: %v", err)
+	}
+	otherCustomerID, err := customerRepo.SaveCustomer(context.Background(), "coupons2@gmail.com", "123", time.Now())
+	if err != nil {
+		t.Fatalf("should not have an error while creating the customer: %v", err)
+	}
+
+	ends := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	couponID, err := repo.SaveCoupon(context.Background(), promotion.Coupon{
+		Code:               "SAVE10",
+		Type:               promotion.TypePercentage,
+		Percent:            10,
+		MinOrderValue:      1000,
+		MaxUses:            2,
+		MaxUsesPerCustomer: 1,
+		EndsAt:             &ends,
+		BookIDs:            []int64{1, 2},
+		Authors:            []string{"Author1"},
+		CreatedAt:          time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("should not have an error while creating the coupon: %v", err)
+	}
+
+	t.Run("the coupon is found by its code", func(t *testing.T) {
+		c, err := repo.GetCouponByCode(context.Background(), "SAVE10")
+		if err != nil || c == nil {
+			t.Fatalf("should find the coupon, got: %+v, %v", c, err)
+		}
+		if c.ID != *couponID || c.Type != promotion.TypePercentage || c.Percent != 10 || c.MinOrderValue != 1000 || c.MaxUses != 2 ||
+			c.MaxUsesPerCustomer != 1 || c.StartsAt != nil || c.EndsAt == nil || !c.EndsAt.Equal(ends) || len(c.BookIDs) != 2 || len(c.Authors) != 1 {
+			t.Fatalf("unexpected coupon: %+v", c)
+		}
+
+		missing, err := repo.GetCouponByCode(context.Background(), "NOPE")
+		if err != nil || missing != nil {
+			t.Fatalf("should not find the coupon, got: %+v, %v", missing, err)
+		}
+	})
+
+	discounted := func(customerID int64) (*int64, error) {
+		return orderRepo.SaveOrder(context.Background(), customerID, order.Order{
+			Discount:   220,
+			CouponCode: "SAVE10",
+			Currency:   money.Currency,
+			Status:     order.StatusPaid,
+			OrderDate:  time.Now(),
+			Items:      []order.OrderItem{{BookID: 1, Quantity: 2, Price: 1099}},
+		})
+	}
+
+	var orderID *int64
+	t.Run("orders store their discount and redeem the coupon", func(t *testing.T) {
+		orderID, err = discounted(*customerID)
+		if err != nil {
+			t.Fatalf("should not have an error while inserting the order: %v", err)
+		}
+
+		o, err := orderRepo.GetOrderByID(context.Background(), *customerID, *orderID)
+		if err != nil || o == nil || o.Discount != 220 || o.CouponCode != "SAVE10" {
+			t.Fatalf("the order should keep its discount, got: %+v, %v", o, err)
+		}
+		if total := order.CalculateTotal(o.Items, o.Discount); total != 1978 {
+			t.Fatalf("expected a total of 19.78, got: %s", total)
+		}
+
+		total, byCustomer, err := repo.CountRedemptions(context.Background(), *couponID, *customerID)
+		if err != nil || total != 1 || byCustomer != 1 {
+			t.Fatalf("expected a single redemption, got: %d, %d, %v", total, byCustomer, err)
+		}
+	})
+
+	t.Run("the per customer limit is enforced when storing the order", func(t *testing.T) {
+		_, err := discounted(*customerID)
+		if !promotion.IsNotApplicable(err) {
+			t.Fatalf("expected a usage limit error, got: %v", err)
+		}
+	})
+
+	t.Run("the global limit is enforced when storing the order", func(t *testing.T) {
+		if _, err := discounted(*otherCustomerID); err != nil {
+			t.Fatalf("should not have an error while inserting the order: %v", err)
+		}
+
+		thirdCustomerID, err := customerRepo.SaveCustomer(context.Background(), "coupons3@gmail.com", "123", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := discounted(*thirdCustomerID); !promotion.IsNotApplicable(err) {
+			t.Fatalf("expected a usage limit error, got: %v", err)
+		}
+	})
+
+	t.Run("cancelled orders give their use back", func(t *testing.T) {
+		change := order.StatusChange{OrderID: *orderID, From: order.StatusPaid, To: order.StatusCancelled, ChangedAt: time.Now()}
+		if _, err := orderRepo.UpdateOrderStatus(context.Background(), change); err != nil {
+			t.Fatal(err)
+		}
+
+		total, byCustomer, err := repo.CountRedemptions(context.Background(), *couponID, *customerID)
+		if err != nil || total != 1 || byCustomer != 0 {
+			t.Fatalf("expected the cancelled order not to count, got: %d, %d, %v", total, byCustomer, err)
+		}
+		if _, err := discounted(*customerID); err != nil {
+			t.Fatalf("should be able to use the coupon again, got: %v", err)
+		}
+	})
+}
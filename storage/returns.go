@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/returns"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type ReturnRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewReturnRepository(db *pgxpool.Pool) *ReturnRepository {
+	return &ReturnRepository{db}
+}
+
+// getReturnableItems returns the items of an order with how many of their units are part of returns that weren't rejected
+func getReturnableItems(ctx context.Context, q querier, orderID int64) ([]returns.OrderItem, error) {
+	query := `
+		SELECT oi.book_id, oi.quantity, oi.price, oi.tax, COALESCE((
+			SELECT SUM(ri.quantity)
+			FROM return_items ri
+			JOIN returns r ON r.id = ri.return_id
+			WHERE r.order_id = oi.order_id AND ri.book_id = oi.book_id AND r.status <> 'rejected'
+		), 0)
+		FROM orderitems oi
+		WHERE oi.order_id = $1
+		ORDER BY oi.id
+	`
+
+	rows, err := q.Query(ctx, query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching order items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []returns.OrderItem
+	for rows.Next() {
+		var item returns.OrderItem
+		if err := rows.Scan(&item.BookID, &item.Quantity, &item.Price, &item.Tax, &item.Returned); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (r *ReturnRepository) GetOrder(ctx context.Context, orderID int64) (*returns.Order, error) {
+	query := `
+		SELECT customer_id, status, currency, discount, (
+			SELECT MAX(changed_at) FROM order_status_history WHERE order_id = o.id AND to_status = 'delivered'
+		)
+		FROM orders o
+		WHERE id = $1
+	`
+
+	var o returns.Order
+	var status string
+	if err := r.db.QueryRow(ctx, query, orderID).Scan(&o.CustomerID, &status, &o.Currency, &o.Discount, &o.DeliveredAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching order: %w", err)
+	}
+	o.Status = order.Status(status)
+
+	items, err := getReturnableItems(ctx, r.db, orderID)
+	if err != nil {
+		return nil, err
+	}
+	o.Items = items
+
+	return &o, nil
+}
+
+func (r *ReturnRepository) SaveReturn(ctx context.Context, ret returns.Return) (*int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// lock the order so concurrent returns of the same order can't take the same units
+	if _, err := tx.Exec(ctx, "SELECT id FROM orders WHERE id = $1 FOR UPDATE", ret.OrderID); err != nil {
+		return nil, fmt.Errorf("error locking order: %w", err)
+	}
+
+	orderItems, err := getReturnableItems(ctx, tx, ret.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	left := map[int64]int{}
+	for _, v := range orderItems {
+		left[v.BookID] = v.Quantity - v.Returned
+	}
+	for _, v := range ret.Items {
+		if v.Quantity > left[v.BookID] {
+			return nil, &returns.TooManyUnitsError{BookID: v.BookID}
+		}
+	}
+
+	var returnID int64
+	returnInsertSQL := `INSERT INTO returns (order_id, customer_id, status, reason, discount, tax, currency, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
+	if err := tx.QueryRow(ctx, returnInsertSQL, ret.OrderID, ret.CustomerID, string(ret.Status), ret.Reason, ret.Discount, ret.Tax, ret.Currency, ret.CreatedAt, ret.UpdatedAt).Scan(&returnID); err != nil {
+		return nil, fmt.Errorf("error creating return: %w", err)
+	}
+
+	itemInsertSQL := "INSERT INTO return_items (return_id, book_id, quantity, price) VALUES ($1, $2, $3, $4)"
+	for _, item := range ret.Items {
+		if _, err := tx.Exec(ctx, itemInsertSQL, returnID, item.BookID, item.Quantity, item.Price); err != nil {
+			return nil, fmt.Errorf("error adding return item: %w", err)
+		}
+	}
+
+	eventInsertSQL := "INSERT INTO return_events (return_id, to_status, actor_id, created_at) VALUES ($1, $2, $3, $4)"
+	if _, err := tx.Exec(ctx, eventInsertSQL, returnID, string(ret.Status), ret.CustomerID, ret.CreatedAt); err != nil {
+		return nil, fmt.Errorf("error saving return event: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return &returnID, nil
+}
+
+const returnColumns = "id, order_id, customer_id, status, reason, discount, tax, refund_amount, currency, created_at, updated_at"
+
+func scanReturn(row pgx.Row) (*returns.Return, error) {
+	var ret returns.Return
+	var status string
+	if err := row.Scan(&ret.ID, &ret.OrderID, &ret.CustomerID, &status, &ret.Reason, &ret.Discount, &ret.Tax, &ret.RefundAmount, &ret.Currency, &ret.CreatedAt, &ret.UpdatedAt); err != nil {
+		return nil, err
+	}
+	ret.Status = returns.Status(status)
+	ret.Items = []returns.Item{}
+	return &ret, nil
+}
+
+// queryReturns runs a query selecting returnColumns and brings the items of every return
+func (r *ReturnRepository) queryReturns(ctx context.Context, query string, args ...any) ([]returns.Return, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching returns: %w", err)
+	}
+	defer rows.Close()
+
+	var list []returns.Return
+	byID := map[int64]int{}
+	for rows.Next() {
+		ret, err := scanReturn(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[ret.ID] = len(list)
+		list = append(list, *ret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching returns: %w", err)
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	ids := make([]int64, len(list))
+	for i := range list {
+		ids[i] = list[i].ID
+	}
+	itemRows, err := r.db.Query(ctx, "SELECT return_id, book_id, quantity, price FROM return_items WHERE return_id = ANY($1) ORDER BY return_id, book_id", ids)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching return items: %w", err)
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var returnID int64
+		var item returns.Item
+		if err := itemRows.Scan(&returnID, &item.BookID, &item.Quantity, &item.Price); err != nil {
+			return nil, err
+		}
+		i := byID[returnID]
+		list[i].Items = append(list[i].Items, item)
+	}
+
+	return list, itemRows.Err()
+}
+
+func (r *ReturnRepository) GetReturn(ctx context.Context, returnID int64) (*returns.Return, error) {
+	list, err := r.queryReturns(ctx, "SELECT "+returnColumns+" FROM returns WHERE id = $1", returnID)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+
+	return &list[0], nil
+}
+
+func (r *ReturnRepository) ListOrderReturns(ctx context.Context, orderID int64) ([]returns.Return, error) {
+	return r.queryReturns(ctx, "SELECT "+returnColumns+" FROM returns WHERE order_id = $1 ORDER BY id", orderID)
+}
+
+func (r *ReturnRepository) ListReturns(ctx context.Context, status returns.Status, limit int, after int64) ([]returns.Return, error) {
+	return r.queryReturns(ctx, "SELECT "+returnColumns+" FROM returns WHERE status = $1 AND id > $2 ORDER BY id LIMIT $3", string(status), after, limit)
+}
+
+func (r *ReturnRepository) UpdateReturnStatus(ctx context.Context, event returns.Event, refundAmount money.Amount) (*returns.Event, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// only move the return if nobody else moved it since event.From was read
+	var orderID int64
+	err = tx.QueryRow(ctx, "UPDATE returns SET status = $3, refund_amount = $4, updated_at = $5, refunding = FALSE WHERE id = $1 AND status = $2 RETURNING order_id",
+		event.ReturnID, string(event.From), string(event.To), refundAmount, event.CreatedAt).Scan(&orderID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error updating return status: %w", err)
+	}
+
+	eventInsertSQL := "INSERT INTO return_events (return_id, from_status, to_status, note, actor_id, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+	if err := tx.QueryRow(ctx, eventInsertSQL, event.ReturnID, string(event.From), string(event.To), event.Note, event.ActorID, event.CreatedAt).Scan(&event.ID); err != nil {
+		return nil, fmt.Errorf("error saving return event: %w", err)
+	}
+
+	if event.To == returns.StatusApproved {
+		if err := restockReturn(ctx, tx, orderID, event); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return &event, nil
+}
+
+func (r *ReturnRepository) ClaimRefund(ctx context.Context, returnID int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, "UPDATE returns SET refunding = TRUE WHERE id = $1 AND status = $2 AND NOT refunding",
+		returnID, string(returns.StatusApproved))
+	if err != nil {
+		return false, fmt.Errorf("error claiming return refund: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *ReturnRepository) ReleaseRefund(ctx context.Context, returnID int64) error {
+	if _, err := r.db.Exec(ctx, "UPDATE returns SET refunding = FALSE WHERE id = $1", returnID); err != nil {
+		return fmt.Errorf("error releasing return refund: %w", err)
+	}
+	return nil
+}
+
+// restockReturn puts the returned books back in stock, recording a movement for each of them
+func restockReturn(ctx context.Context, tx pgx.Tx, orderID int64, event returns.Event) error {
+	// lock the books in the same order SaveOrder does, so concurrent orders can't deadlock
+	rows, err := tx.Query(ctx, "SELECT book_id, quantity FROM return_items WHERE return_id = $1 ORDER BY book_id", event.ReturnID)
+	if err != nil {
+		return fmt.Errorf("error fetching return items: %w", err)
+	}
+	var items []returns.Item
+	for rows.Next() {
+		var item returns.Item
+		if err := rows.Scan(&item.BookID, &item.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error fetching return items: %w", err)
+	}
+
+	movementInsertSQL := "INSERT INTO stock_movements (book_id, quantity, reason, order_id, created_by, created_at) VALUES ($1, $2, 'return', $3, $4, $5)"
+	for _, item := range items {
+		if _, err := tx.Exec(ctx, "UPDATE books SET stock = stock + $2 WHERE id = $1", item.BookID, item.Quantity); err != nil {
+			return fmt.Errorf("error updating stock: %w", err)
+		}
+		if _, err := tx.Exec(ctx, movementInsertSQL, item.BookID, item.Quantity, orderID, event.ActorID, event.CreatedAt); err != nil {
+			return fmt.Errorf("error saving stock movement: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *ReturnRepository) GetReturnEvents(ctx context.Context, returnID int64) ([]returns.Event, error) {
+	query := `
+		SELECT id, return_id, from_status, to_status, note, actor_id, created_at
+		FROM return_events
+		WHERE return_id = $1
+		ORDER BY id
+	`
+
+	rows, err := r.db.Query(ctx, query, returnID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching return events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []returns.Event
+	for rows.Next() {
+		var e returns.Event
+		var from *string
+		var to string
+		if err := rows.Scan(&e.ID, &e.ReturnID, &from, &to, &e.Note, &e.ActorID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if from != nil {
+			e.From = returns.Status(*from)
+		}
+		e.To = returns.Status(to)
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"github.com/ap-pauloafonso/bookstore/order"
+	"github.com/ap-pauloafonso/bookstore/returns"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"testing"
+	"time"
+)
+
+func TestReturnRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	t.Parallel()
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:latest",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "test",
+			"POSTGRES_DB":       "MY_DB",
+		},
+		WaitingFor: wait.ForAll(wait.ForListeningPort("5432/tcp"), wait.ForLog("database system is ready to accept connections")),
+	}
+	postgresC, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start PostgreSQL container: %v", err)
+	}
+	defer postgresC.Terminate(context.Background())
+
+	host, err := postgresC.Host(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		t.Error(err)
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", host, port.Port())
+
+	time.Sleep(3 * time.Second) // a bit of delay to make sure that container is ready
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewReturnRepository(pool)
+	orderRepo := NewOrderRepository(pool)
+	bookRepo := NewBookRepository(pool)
+	customerRepo := NewCustomerRepository(pool)
+
+	err = RunMigrations(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customerID, err := customerRepo.SaveCustomer(context.Background(), "returns@gmail.com", "123", time.Now())
+	if err != nil {
+		t.Fatalf("should not have an error while creating the customer: %v", err)
+	}
+
+	orderID, err := orderRepo.SaveOrder(context.Background(), *customerID, order.Order{
+		Currency:  money.Currency,
+		Status:    order.StatusPaid,
+		OrderDate: time.Now(),
+		Items:     []order.OrderItem{{BookID: 1, Quantity: 3, Price: 1099}, {BookID: 2, Quantity: 1, Price: 500}},
+	})
+	if err != nil {
+		t.Fatalf("should not have an error while inserting the order: %v", err)
+	}
+
+	t.Run("orders that weren't delivered have no delivery date", func(t *testing.T) {
+		o, err := repo.GetOrder(context.Background(), *orderID)
+		if err != nil || o == nil || o.CustomerID != *customerID || o.Status != order.StatusPaid || o.DeliveredAt != nil || len(o.Items) != 2 {
+			t.Fatalf("unexpected order: %+v, %v", o, err)
+		}
+
+		missing, err := repo.GetOrder(context.Background(), *orderID+100)
+		if err != nil || missing != nil {
+			t.Fatalf("should not find the order, got: %+v, %v", missing, err)
+		}
+	})
+
+	for _, next := range []order.Status{order.StatusShipped, order.StatusDelivered} {
+		state, err := orderRepo.GetOrderState(context.Background(), *orderID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := orderRepo.UpdateOrderStatus(context.Background(), order.StatusChange{OrderID: *orderID, From: state.Status, To: next, ChangedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var returnID int64
+	t.Run("a return takes units of the order", func(t *testing.T) {
+		o, err := repo.GetOrder(context.Background(), *orderID)
+		if err != nil || o.DeliveredAt == nil || o.Status != order.StatusDelivered {
+			t.Fatalf("the order should be delivered, got: %+v, %v", o, err)
+		}
+
+		id, err := repo.SaveReturn(context.Background(), returns.Return{
+			OrderID:    *orderID,
+			CustomerID: *customerID,
+			Status:     returns.StatusRequested,
+			Reason:     "damaged",
+			Items:      []returns.Item{{BookID: 1, Quantity: 2, Price: 1099}},
+			Currency:   money.Currency,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("should not have an error while saving the return: %v", err)
+		}
+		returnID = *id
+
+		o, err = repo.GetOrder(context.Background(), *orderID)
+		if err != nil || o.Items[0].BookID != 1 || o.Items[0].Returned != 2 || o.Items[1].Returned != 0 {
+			t.Fatalf("the returned units should be counted, got: %+v, %v", o, err)
+		}
+
+		_, err = repo.SaveReturn(context.Background(), returns.Return{
+			OrderID:    *orderID,
+			CustomerID: *customerID,
+			Status:     returns.StatusRequested,
+			Reason:     "damaged",
+			Items:      []returns.Item{{BookID: 1, Quantity: 2, Price: 1099}},
+			Currency:   money.Currency,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		})
+		var unitsErr *returns.TooManyUnitsError
+		if !errors.As(err, &unitsErr) || unitsErr.BookID != 1 {
+			t.Fatalf("should fail with a too many units error for book 1, got: %v", err)
+		}
+	})
+
+	t.Run("approving a return puts the books back in stock", func(t *testing.T) {
+		before, err := bookRepo.GetBookByID(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		event, err := repo.UpdateReturnStatus(context.Background(), returns.Event{
+			ReturnID:  returnID,
+			From:      returns.StatusRequested,
+			To:        returns.StatusApproved,
+			Note:      "ok",
+			ActorID:   customerID,
+			CreatedAt: time.Now(),
+		}, 2198)
+		if err != nil || event == nil || event.ID == 0 {
+			t.Fatalf("should approve the return, got: %+v, %v", event, err)
+		}
+
+		stale, err := repo.UpdateReturnStatus(context.Background(), returns.Event{
+			ReturnID:  returnID,
+			From:      returns.StatusRequested,
+			To:        returns.StatusRejected,
+			CreatedAt: time.Now(),
+		}, 0)
+		if err != nil || stale != nil {
+			t.Fatalf("should not update a return that is no longer requested, got: %+v, %v", stale, err)
+		}
+
+		after, err := bookRepo.GetBookByID(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.Stock != before.Stock+2 {
+			t.Fatalf("the returned units should be back in stock, before: %d after: %d", before.Stock, after.Stock)
+		}
+
+		r, err := repo.GetReturn(context.Background(), returnID)
+		if err != nil || r == nil || r.Status != returns.StatusApproved || r.RefundAmount != 2198 || len(r.Items) != 1 || r.Items[0].Price != 1099 {
+			t.Fatalf("unexpected return: %+v, %v", r, err)
+		}
+
+		events, err := repo.GetReturnEvents(context.Background(), returnID)
+		if err != nil || len(events) != 2 || events[0].From != "" || events[0].To != returns.StatusRequested ||
+			events[1].From != returns.StatusRequested || events[1].To != returns.StatusApproved || events[1].Note != "ok" {
+			t.Fatalf("unexpected audit trail: %+v, %v", events, err)
+		}
+	})
+
+	t.Run("the refund of a return is only claimed once", func(t *testing.T) {
+		claimed, err := repo.ClaimRefund(context.Background(), returnID)
+		if err != nil || !claimed {
+			t.Fatalf("should claim the refund, got: %v, %v", claimed, err)
+		}
+		claimed, err = repo.ClaimRefund(context.Background(), returnID)
+		if err != nil || claimed {
+			t.Fatalf("should not claim a refund already being made, got: %v, %v", claimed, err)
+		}
+
+		if err := repo.ReleaseRefund(context.Background(), returnID); err != nil {
+			t.Fatalf("should release the refund: %v", err)
+		}
+		claimed, err = repo.ClaimRefund(context.Background(), returnID)
+		if err != nil || !claimed {
+			t.Fatalf("should claim a released refund, got: %v, %v", claimed, err)
+		}
+		if err := repo.ReleaseRefund(context.Background(), returnID); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("list returns", func(t *testing.T) {
+		list, err := repo.ListOrderReturns(context.Background(), *orderID)
+		if err != nil || len(list) != 1 || list[0].ID != returnID || len(list[0].Items) != 1 {
+			t.Fatalf("unexpected order returns: %+v, %v", list, err)
+		}
+
+		list, err = repo.ListReturns(context.Background(), returns.StatusApproved, 10, 0)
+		if err != nil || len(list) != 1 || list[0].ID != returnID {
+			t.Fatalf("unexpected approved returns: %+v, %v", list, err)
+		}
+
+		list, err = repo.ListReturns(context.Background(), returns.StatusRequested, 10, 0)
+		if err != nil || len(list) != 0 {
+			t.Fatalf("should not have requested returns, got: %+v, %v", list, err)
+		}
+	})
+}
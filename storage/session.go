@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/session"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type SessionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewSessionRepository(db *pgxpool.Pool) *SessionRepository {
+	return &SessionRepository{db}
+}
+
+func (r *SessionRepository) CreateSession(ctx context.Context, customerID int64, token session.RefreshToken, createdAt time.Time) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, "INSERT INTO sessions (customer_id, created_at) VALUES ($1, $2) RETURNING id", customerID, createdAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error saving session: %w", err)
+	}
+
+	token.SessionID = id
+	if err := insertRefreshToken(ctx, tx, token); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return id, nil
+}
+
+func insertRefreshToken(ctx context.Context, tx pgx.Tx, token session.RefreshToken) error {
+	query := "INSERT INTO refresh_tokens (token_hash, session_id, expires_at, created_at) VALUES ($1, $2, $3, $4)"
+	if _, err := tx.Exec(ctx, query, token.Hash, token.SessionID, token.ExpiresAt, token.CreatedAt); err != nil {
+		return fmt.Errorf("error saving refresh token: %w", err)
+	}
+	return nil
+}
+
+func (r *SessionRepository) GetSession(ctx context.Context, id int64) (*session.Session, error) {
+	var s session.Session
+	err := r.db.QueryRow(ctx, "SELECT id, customer_id, created_at, revoked_at FROM sessions WHERE id = $1", id).
+		Scan(&s.ID, &s.CustomerID, &s.CreatedAt, &s.RevokedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching session: %w", err)
+	}
+
+	return &s, nil
+}
+
+func (r *SessionRepository) GetRefreshToken(ctx context.Context, hash string) (*session.RefreshToken, error) {
+	var t session.RefreshToken
+	err := r.db.QueryRow(ctx, "SELECT token_hash, session_id, expires_at, used_at, created_at FROM refresh_tokens WHERE token_hash = $1", hash).
+		Scan(&t.Hash, &t.SessionID, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching refresh token: %w", err)
+	}
+
+	return &t, nil
+}
+
+func (r *SessionRepository) RotateRefreshToken(ctx context.Context, hash string, next session.RefreshToken, usedAt time.Time) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// only one of the requests that use the same token at the same time gets to mark it as used
+	tag, err := tx.Exec(ctx, "UPDATE refresh_tokens SET used_at = $2 WHERE token_hash = $1 AND used_at IS NULL", hash, usedAt)
+	if err != nil {
+		return false, fmt.Errorf("error using refresh token: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+func (r *SessionRepository) RevokeSession(ctx context.Context, id int64, revokedAt time.Time) error {
+	_, err := r.db.Exec(ctx, "UPDATE sessions SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL", id, revokedAt)
+	if err != nil {
+		return fmt.Errorf("error revoking session: %w", err)
+	}
+	return nil
+}
+
+func (r *SessionRepository) RevokeCustomerSessions(ctx context.Context, customerID, except int64, revokedAt time.Time) error {
+	query := "UPDATE sessions SET revoked_at = $3 WHERE customer_id = $1 AND id <> $2 AND revoked_at IS NULL"
+	if _, err := r.db.Exec(ctx, query, customerID, except, revokedAt); err != nil {
+		return fmt.Errorf("error revoking sessions: %w", err)
+	}
+	return nil
+}
+
+func (r *SessionRepository) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	query := "INSERT INTO revoked_tokens (token_id, expires_at) VALUES ($1, $2) ON CONFLICT (token_id) DO NOTHING"
+	if _, err := r.db.Exec(ctx, query, tokenID, expiresAt); err != nil {
+		return fmt.Errorf("error revoking token: %w", err)
+	}
+	return nil
+}
+
+func (r *SessionRepository) IsRevoked(ctx context.Context, sessionID int64, tokenID string) (bool, error) {
+	query := `
+		SELECT NOT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL)
+			OR EXISTS (SELECT 1 FROM revoked_tokens WHERE token_id = $2)
+	`
+
+	var revoked bool
+	if err := r.db.QueryRow(ctx, query, sessionID, tokenID).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("error checking token revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+func (r *SessionRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var deleted int64
+	for _, query := range []string{
+		"DELETE FROM refresh_tokens WHERE expires_at < $1",
+		"DELETE FROM revoked_tokens WHERE expires_at < $1",
+		// a session without refresh tokens can't be used anymore, the access tokens it issued expired long before
+		"DELETE FROM sessions s WHERE NOT EXISTS (SELECT 1 FROM refresh_tokens WHERE session_id = s.id) AND s.created_at < $1",
+	} {
+		tag, err := tx.Exec(ctx, query, before)
+		if err != nil {
+			return 0, fmt.Errorf("error deleting expired sessions: %w", err)
+		}
+		deleted += tag.RowsAffected()
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return deleted, nil
+}
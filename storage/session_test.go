@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/session"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	t.Parallel()
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:latest",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "test",
+			"POSTGRES_DB":       "MY_DB",
+		},
+		WaitingFor: wait.ForAll(wait.ForListeningPort("5432/tcp"), wait.ForLog("database system is ready to accept connections")),
+	}
+	postgresC, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start PostgreSQL container: %v", err)
+	}
+	defer postgresC.Terminate(context.Background())
+
+	host, err := postgresC.Host(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		t.Error(err)
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", host, port.Port())
+
+	time.Sleep(3 * time.Second) // a bit of delay to make sure that container is ready
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewSessionRepository(pool)
+	customerRepo := NewCustomerRepository(pool)
+
+	err = RunMigrations(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customerID, err := customerRepo.SaveCustomer(context.Background(), "session@gmail.com", "123", time.Now())
+	if err != nil {
+		t.Fatalf("should not have an error while creating the customer: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	token := func(c string) session.RefreshToken {
+		return session.RefreshToken{Hash: strings.Repeat(c, 64), ExpiresAt: now.Add(time.Hour), CreatedAt: now}
+	}
+
+	var sessionID int64
+	t.Run("CreateSession", func(t *testing.T) {
+		sessionID, err = repo.CreateSession(context.Background(), *customerID, token("a"), now)
+		if err != nil {
+			t.Fatalf("should not have an error while creating the session: %v", err)
+		}
+
+		s, err := repo.GetSession(context.Background(), sessionID)
+		if err != nil || s == nil || s.CustomerID != *customerID || s.RevokedAt != nil {
+			t.Fatalf("should get the new session, got: %+v, %v", s, err)
+		}
+
+		stored, err := repo.GetRefreshToken(context.Background(), strings.Repeat("a", 64))
+		if err != nil || stored == nil || stored.SessionID != sessionID || stored.UsedAt != nil || !stored.ExpiresAt.Equal(now.Add(time.Hour)) {
+			t.Fatalf("should get the refresh token of the session, got: %+v, %v", stored, err)
+		}
+
+		if s, err := repo.GetSession(context.Background(), 999999); err != nil || s != nil {
+			t.Fatalf("should return nil for an unknown session, got: %+v, %v", s, err)
+		}
+		if stored, err := repo.GetRefreshToken(context.Background(), strings.Repeat("z", 64)); err != nil || stored != nil {
+			t.Fatalf("should return nil for an unknown token, got: %+v, %v", stored, err)
+		}
+	})
+
+	t.Run("RotateRefreshToken", func(t *testing.T) {
+		next := token("b")
+		next.SessionID = sessionID
+		rotated, err := repo.RotateRefreshToken(context.Background(), strings.Repeat("a", 64), next, now)
+		if err != nil || !rotated {
+			t.Fatalf("should rotate the refresh token, got: %v, %v", rotated, err)
+		}
+
+		stored, err := repo.GetRefreshToken(context.Background(), strings.Repeat("a", 64))
+		if err != nil || stored.UsedAt == nil {
+			t.Fatalf("should mark the token as used, got: %+v, %v", stored, err)
+		}
+
+		// a used token can't be rotated again
+		again := token("c")
+		again.SessionID = sessionID
+		rotated, err = repo.RotateRefreshToken(context.Background(), strings.Repeat("a", 64), again, now)
+		if err != nil || rotated {
+			t.Fatalf("should not rotate a used token, got: %v, %v", rotated, err)
+		}
+		if stored, _ := repo.GetRefreshToken(context.Background(), strings.Repeat("c", 64)); stored != nil {
+			t.Fatal("should not store the next token of a used token")
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		if revoked, err := repo.IsRevoked(context.Background(), sessionID, "jti-1"); err != nil || revoked {
+			t.Fatalf("the session should be active, got: %v, %v", revoked, err)
+		}
+
+		if err := repo.RevokeToken(context.Background(), "jti-1", now.Add(time.Minute)); err != nil {
+			t.Fatalf("should not have an error while revoking the token: %v", err)
+		}
+		if revoked, err := repo.IsRevoked(context.Background(), sessionID, "jti-1"); err != nil || !revoked {
+			t.Fatalf("the token should be revoked, got: %v, %v", revoked, err)
+		}
+		if revoked, _ := repo.IsRevoked(context.Background(), sessionID, "jti-2"); revoked {
+			t.Fatal("the other tokens of the session should still work")
+		}
+
+		other, err := repo.CreateSession(context.Background(), *customerID, token("d"), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.RevokeCustomerSessions(context.Background(), *customerID, other, now); err != nil {
+			t.Fatalf("should not have an error while revoking the sessions: %v", err)
+		}
+		if revoked, _ := repo.IsRevoked(context.Background(), sessionID, "jti-2"); !revoked {
+			t.Fatal("the session should be revoked")
+		}
+		if revoked, _ := repo.IsRevoked(context.Background(), other, "jti-2"); revoked {
+			t.Fatal("the excepted session should still work")
+		}
+
+		if err := repo.RevokeSession(context.Background(), other, now); err != nil {
+			t.Fatalf("should not have an error while revoking the session: %v", err)
+		}
+		if s, _ := repo.GetSession(context.Background(), other); s.RevokedAt == nil {
+			t.Fatal("the session should be revoked")
+		}
+		if revoked, _ := repo.IsRevoked(context.Background(), 999999, "jti-2"); !revoked {
+			t.Fatal("unknown sessions should count as revoked")
+		}
+	})
+
+	t.Run("DeleteExpired", func(t *testing.T) {
+		n, err := repo.DeleteExpired(context.Background(), now.Add(2*time.Hour))
+		if err != nil {
+			t.Fatalf("should not have an error while deleting the expired rows: %v", err)
+		}
+		// the 3 refresh tokens, the revoked token and the 2 sessions
+		if n != 6 {
+			t.Fatalf("should delete every row, deleted: %d", n)
+		}
+		if s, _ := repo.GetSession(context.Background(), sessionID); s != nil {
+			t.Fatal("the session without refresh tokens should be deleted")
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"time"
+)
+
+type VerificationRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewVerificationRepository(db *pgxpool.Pool) *VerificationRepository {
+	return &VerificationRepository{db}
+}
+
+func (r *VerificationRepository) MarkVerificationSent(ctx context.Context, customerID int64, sentAt, notSentSince time.Time) (bool, error) {
+	// a single update, so concurrent resends can't both get through
+	query := `
+		UPDATE customers SET verification_sent_at = $2
+		WHERE id = $1 AND (verification_sent_at IS NULL OR verification_sent_at <= $3)
+	`
+
+	tag, err := r.db.Exec(ctx, query, customerID, sentAt, notSentSince)
+	if err != nil {
+		return false, fmt.Errorf("error marking verification sent: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *VerificationRepository) UnmarkVerificationSent(ctx context.Context, customerID int64, sentAt time.Time) error {
+	query := "UPDATE customers SET verification_sent_at = NULL WHERE id = $1 AND verification_sent_at = $2"
+	if _, err := r.db.Exec(ctx, query, customerID, sentAt); err != nil {
+		return fmt.Errorf("error unmarking verification sent: %w", err)
+	}
+
+	return nil
+}
+
+func (r *VerificationRepository) VerifyEmail(ctx context.Context, customerID int64, email string) (bool, error) {
+	tag, err := r.db.Exec(ctx, "UPDATE customers SET email_verified = TRUE WHERE id = $1 AND email = $2", customerID, email)
+	if err != nil {
+		return false, fmt.Errorf("error verifying email: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
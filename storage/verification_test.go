@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"testing"
+	"time"
+)
+
+func TestVerificationRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	t.Parallel()
+	req := testcontainers.ContainerRequest{
+		Image:        "postgres:latest",
+		ExposedPorts: []string{"5432/tcp"},
+		Env: map[string]string{
+			"POSTGRES_PASSWORD": "test",
+			"POSTGRES_DB":       "MY_DB",
+		},
+		WaitingFor: wait.ForAll(wait.ForListeningPort("5432/tcp"), wait.ForLog("database system is ready to accept connections")),
+	}
+	postgresC, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start PostgreSQL container: %v", err)
+	}
+	defer postgresC.Terminate(context.Background())
+
+	host, err := postgresC.Host(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := postgresC.MappedPort(context.Background(), "5432")
+	if err != nil {
+		t.Error(err)
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=postgres password=test dbname=MY_DB sslmode=disable", host, port.Port())
+
+	time.Sleep(3 * time.Second) // a bit of delay to make sure that container is ready
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewVerificationRepository(pool)
+	customerRepo := NewCustomerRepository(pool)
+
+	err = RunMigrations(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customerID, err := customerRepo.SaveCustomer(context.Background(), "verify@gmail.com", "pass", time.Now())
+	if err != nil {
+		t.Fatalf("should not have an error while creating the customer: %v", err)
+	}
+
+	t.Run("new customers aren't verified", func(t *testing.T) {
+		c, err := customerRepo.GetCustomerByID(context.Background(), *customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.EmailVerified {
+			t.Fatal("expected the email of a new customer to not be verified")
+		}
+	})
+
+	t.Run("MarkVerificationSent throttles the resends", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+		marked, err := repo.MarkVerificationSent(context.Background(), *customerID, now, now.Add(-time.Minute))
+		if err != nil || !marked {
+			t.Fatalf("expected the first email to be marked, got: %v, %v", marked, err)
+		}
+
+		marked, err = repo.MarkVerificationSent(context.Background(), *customerID, now.Add(time.Second), now.Add(time.Second-time.Minute))
+		if err != nil || marked {
+			t.Fatalf("expected a resend within the interval to be throttled, got: %v, %v", marked, err)
+		}
+
+		marked, err = repo.MarkVerificationSent(context.Background(), *customerID, now.Add(time.Minute), now)
+		if err != nil || !marked {
+			t.Fatalf("expected a resend after the interval to be marked, got: %v, %v", marked, err)
+		}
+	})
+
+	t.Run("UnmarkVerificationSent only forgets the given email", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+		marked, err := repo.MarkVerificationSent(context.Background(), *customerID, now, now.Add(-time.Minute))
+		if err != nil || !marked {
+			t.Fatalf("expected the email to be marked, got: %v, %v", marked, err)
+		}
+
+		if err := repo.UnmarkVerificationSent(context.Background(), *customerID, now.Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		marked, err = repo.MarkVerificationSent(context.Background(), *customerID, now.Add(time.Second), now.Add(time.Second-time.Minute))
+		if err != nil || marked {
+			t.Fatalf("expected an older email to not be unmarked, got: %v, %v", marked, err)
+		}
+
+		if err := repo.UnmarkVerificationSent(context.Background(), *customerID, now); err != nil {
+			t.Fatal(err)
+		}
+		marked, err = repo.MarkVerificationSent(context.Background(), *customerID, now.Add(time.Second), now.Add(time.Second-time.Minute))
+		if err != nil || !marked {
+			t.Fatalf("expected a resend right after an unmarked email to be marked, got: %v, %v", marked, err)
+		}
+	})
+
+	t.Run("VerifyEmail only verifies the current email", func(t *testing.T) {
+		verified, err := repo.VerifyEmail(context.Background(), *customerID, "old@gmail.com")
+		if err != nil || verified {
+			t.Fatalf("expected another email to not be verified, got: %v, %v", verified, err)
+		}
+
+		verified, err = repo.VerifyEmail(context.Background(), *customerID, "verify@gmail.com")
+		if err != nil || !verified {
+			t.Fatalf("expected the email to be verified, got: %v, %v", verified, err)
+		}
+
+		c, err := customerRepo.GetCustomerByID(context.Background(), *customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.EmailVerified {
+			t.Fatal("expected the email of the customer to be verified")
+		}
+	})
+}
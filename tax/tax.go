@@ -0,0 +1,207 @@
+// Package tax computes the sales tax of orders from the destination they are shipped to.
+package tax
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ap-pauloafonso/bookstore/money"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	errInvalidCountry         = errors.New("invalid country: needs to be an ISO 3166-1 alpha-2 code, like US")
+	errInvalidRegion          = errors.New("invalid region: needs to have at most 64 characters")
+	errInvalidRate            = errors.New("invalid tax rate: needs to be between 0 and 100")
+	errDuplicateRule          = errors.New("duplicate tax rule")
+	errUnsupportedDestination = errors.New("the store doesn't ship to this destination")
+)
+
+var countryRegex = regexp.MustCompile(`^[A-Z]{2}$`)
+
+//go:embed rates.json
+var defaultRates []byte
+
+// Rate is a tax rate in hundredths of a percent, 7.25% is Rate(725)
+type Rate int64
+
+// Of returns the tax of amount, rounded like every amount derived from a rate
+func (r Rate) Of(amount money.Amount) money.Amount {
+	return amount.MulRate(int64(r), 100*money.MinorUnits)
+}
+
+// String formats the rate as a percentage with two decimal places, like 7.25
+func (r Rate) String() string {
+	return money.Amount(r).String()
+}
+
+// MarshalJSON encodes the rate as a percentage, like 7.25
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON reads a percentage with at most two decimal places, the same way amounts are read
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	var a money.Amount
+	if err := a.UnmarshalJSON(data); err != nil {
+		return fmt.Errorf("invalid tax rate: %w", err)
+	}
+	*r = Rate(a)
+	return nil
+}
+
+// Destination is where an order is shipped to, Region is a state or province and is optional
+type Destination struct {
+	Country string `json:"country"`
+	Region  string `json:"region,omitempty"`
+}
+
+// Normalize returns the destination with the uppercase codes rules are stored with
+func (d Destination) Normalize() Destination {
+	return Destination{Country: strings.ToUpper(strings.TrimSpace(d.Country)), Region: strings.ToUpper(strings.TrimSpace(d.Region))}
+}
+
+// Validate checks the format of the destination, not whether the store ships to it
+func (d Destination) Validate() error {
+	if !countryRegex.MatchString(d.Country) {
+		return errInvalidCountry
+	}
+	if len(d.Region) > 64 {
+		return errInvalidRegion
+	}
+	return nil
+}
+
+// Line is an item of an order, Amount is what is taxed: its price times its quantity minus its share of the discount
+type Line struct {
+	BookID int64
+	Amount money.Amount
+}
+
+// LineTax is the tax of a Line
+type LineTax struct {
+	Rate Rate
+	Tax  money.Amount
+}
+
+// Calculator computes the tax of the lines of an order shipped to destination, returning one LineTax per line in the
+// same order
+type Calculator interface {
+	Calculate(ctx context.Context, destination Destination, lines []Line) ([]LineTax, error)
+}
+
+// IsUnsupportedDestination reports whether err means that there isn't a tax rule for the destination
+func IsUnsupportedDestination(err error) bool {
+	return errors.Is(err, errUnsupportedDestination)
+}
+
+// Rule is the tax rate of a country, or of one of its regions when Region is set. BookRates are reduced rates of
+// specific books
+type Rule struct {
+	Country   string         `json:"country"`
+	Region    string         `json:"region,omitempty"`
+	Rate      Rate           `json:"rate"`
+	BookRates map[int64]Rate `json:"book_rates,omitempty"`
+}
+
+// Table is a Calculator that looks the rates up in a fixed set of rules. A region without a rule of its own uses
+// the rule of its country
+type Table struct {
+	rules map[Destination]Rule
+}
+
+func validRate(r Rate) bool {
+	return r >= 0 && r <= 100*money.MinorUnits
+}
+
+// NewTable validates the rules and builds a Table with them
+func NewTable(rules []Rule) (*Table, error) {
+	t := &Table{rules: make(map[Destination]Rule, len(rules))}
+	for _, v := range rules {
+		d := Destination{Country: v.Country, Region: v.Region}.Normalize()
+		if err := d.Validate(); err != nil {
+			return nil, err
+		}
+		if !validRate(v.Rate) {
+			return nil, fmt.Errorf("%w: %s", errInvalidRate, v.Rate)
+		}
+		for _, r := range v.BookRates {
+			if !validRate(r) {
+				return nil, fmt.Errorf("%w: %s", errInvalidRate, r)
+			}
+		}
+		if _, ok := t.rules[d]; ok {
+			return nil, fmt.Errorf("%w: %s %s", errDuplicateRule, d.Country, d.Region)
+		}
+		v.Country, v.Region = d.Country, d.Region
+		t.rules[d] = v
+	}
+
+	return t, nil
+}
+
+// ParseTable reads the rules of a Table from a JSON array
+func ParseTable(data []byte) (*Table, error) {
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid tax rules: %w", err)
+	}
+	return NewTable(rules)
+}
+
+// LoadTable reads the rules of a Table from a JSON file
+func LoadTable(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tax rules: %w", err)
+	}
+	return ParseTable(data)
+}
+
+// DefaultTable returns the Table with the rules shipped with the store
+func DefaultTable() *Table {
+	t, err := ParseTable(defaultRates)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// rule returns the most specific rule of the destination
+func (t *Table) rule(d Destination) (Rule, bool) {
+	if d.Region != "" {
+		if r, ok := t.rules[d]; ok {
+			return r, true
+		}
+	}
+	r, ok := t.rules[Destination{Country: d.Country}]
+	return r, ok
+}
+
+// Calculate taxes every line with the reduced rate of its book, or with the rate of the destination
+func (t *Table) Calculate(ctx context.Context, destination Destination, lines []Line) ([]LineTax, error) {
+	d := destination.Normalize()
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	rule, ok := t.rule(d)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedDestination, d.Country)
+	}
+
+	taxes := make([]LineTax, len(lines))
+	for i, v := range lines {
+		rate := rule.Rate
+		if r, ok := rule.BookRates[v.BookID]; ok {
+			rate = r
+		}
+		taxes[i] = LineTax{Rate: rate, Tax: rate.Of(v.Amount)}
+	}
+
+	return taxes, nil
+}
@@ -0,0 +1,126 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewTable(t *testing.T) {
+	tests := []struct {
+		name          string
+		rules         []Rule
+		expectedError error
+	}{
+		{name: "Valid", rules: []Rule{{Country: "us", Rate: 0}, {Country: "US", Region: "ca", Rate: 725, BookRates: map[int64]Rate{1: 0}}}},
+		{name: "InvalidCountry", rules: []Rule{{Country: "USA", Rate: 0}}, expectedError: errInvalidCountry},
+		{name: "InvalidRate", rules: []Rule{{Country: "US", Rate: 10001}}, expectedError: errInvalidRate},
+		{name: "NegativeRate", rules: []Rule{{Country: "US", Rate: -1}}, expectedError: errInvalidRate},
+		{name: "InvalidBookRate", rules: []Rule{{Country: "US", BookRates: map[int64]Rate{1: 10001}}}, expectedError: errInvalidRate},
+		{name: "Duplicate", rules: []Rule{{Country: "US", Region: "CA"}, {Country: "us", Region: "ca"}}, expectedError: errDuplicateRule},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTable(tt.rules)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestTable_Calculate(t *testing.T) {
+	table, err := NewTable([]Rule{
+		{Country: "US", Rate: 0},
+		{Country: "US", Region: "CA", Rate: 725, BookRates: map[int64]Rate{2: 100}},
+		{Country: "DE", Rate: 700},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []Line{{BookID: 1, Amount: 1000}, {BookID: 2, Amount: 1999}}
+
+	tests := []struct {
+		name          string
+		destination   Destination
+		expected      []LineTax
+		expectedError error
+	}{
+		{name: "Region", destination: Destination{Country: "us", Region: " ca "}, expected: []LineTax{{Rate: 725, Tax: 73}, {Rate: 100, Tax: 20}}},
+		{name: "RegionFallsBackToCountry", destination: Destination{Country: "US", Region: "OR"}, expected: []LineTax{{Rate: 0, Tax: 0}, {Rate: 0, Tax: 0}}},
+		{name: "Country", destination: Destination{Country: "DE"}, expected: []LineTax{{Rate: 700, Tax: 70}, {Rate: 700, Tax: 140}}},
+		{name: "Unsupported", destination: Destination{Country: "FR"}, expectedError: errUnsupportedDestination},
+		{name: "Invalid", destination: Destination{Country: "Germany"}, expectedError: errInvalidCountry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taxes, err := table.Calculate(context.Background(), tt.destination, lines)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected error: %v, got: %v", tt.expectedError, err)
+			}
+			if IsUnsupportedDestination(err) != (tt.expectedError == errUnsupportedDestination) {
+				t.Errorf("IsUnsupportedDestination mismatch for error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(taxes) != len(tt.expected) {
+				t.Fatalf("Expected %d taxes, got: %+v", len(tt.expected), taxes)
+			}
+			for i := range taxes {
+				if taxes[i] != tt.expected[i] {
+					t.Errorf("Expected tax: %+v, got: %+v", tt.expected[i], taxes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	if err := os.WriteFile(path, []byte(`[{"country": "PT", "rate": "6"}, {"country": "PT", "region": "20", "rate": 4, "book_rates": {"1": 0}}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := LoadTable(path)
+	if err != nil {
+		t.Fatalf("Expected the table to be loaded, got: %v", err)
+	}
+
+	taxes, err := table.Calculate(context.Background(), Destination{Country: "PT", Region: "20"}, []Line{{BookID: 1, Amount: 1000}, {BookID: 2, Amount: 1000}})
+	if err != nil || taxes[0].Rate != 0 || taxes[1].Rate != 400 || taxes[1].Tax != 40 {
+		t.Fatalf("Expected the rates of the file, got: %+v, %v", taxes, err)
+	}
+
+	if _, err := ParseTable([]byte(`[{"country": "PT", "rate": 6.125}]`)); err == nil {
+		t.Fatal("Expected an error for a rate with more than two decimal places")
+	}
+	if _, err := LoadTable(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
+
+func TestDefaultTable(t *testing.T) {
+	taxes, err := DefaultTable().Calculate(context.Background(), Destination{Country: "US", Region: "CA"}, []Line{{BookID: 1, Amount: 10000}})
+	if err != nil || taxes[0].Rate != 725 || taxes[0].Tax != 725 {
+		t.Fatalf("Expected the California rate, got: %+v, %v", taxes, err)
+	}
+}
+
+func TestRate_JSON(t *testing.T) {
+	data, err := json.Marshal(Rate(725))
+	if err != nil || string(data) != "7.25" {
+		t.Fatalf("Expected 7.25, got: %s, %v", data, err)
+	}
+
+	var r Rate
+	if err := json.Unmarshal([]byte(`"5.5"`), &r); err != nil || r != 550 {
+		t.Fatalf("Expected 550, got: %d, %v", r, err)
+	}
+}
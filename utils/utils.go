@@ -1,12 +1,21 @@
 package utils
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"github.com/jackc/pgconn"
 	"log/slog"
 	"os"
 )
 
+const (
+	// postgres error code raised when a row is still referenced by another table
+	foreignKeyViolationCode = "23503"
+	// postgres error code raised when a value is already taken by another row
+	uniqueViolationCode = "23505"
+)
+
 type ErrorMessage struct {
 	ErrorMessage string `json:"error_message"`
 }
@@ -27,3 +36,37 @@ func IsStorageRelatedError(err error) bool {
 	}
 	return false
 }
+
+func IsForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == foreignKeyViolationCode
+	}
+	return false
+}
+
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == uniqueViolationCode
+	}
+	return false
+}
+
+// EncodeCursor serializes a pagination position into an opaque string that is safe to use in a query string
+func EncodeCursor(position any) (string, error) {
+	b, err := json.Marshal(position)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeCursor reads a cursor created by EncodeCursor into position
+func DecodeCursor(cursor string, position any) error {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, position)
+}